@@ -0,0 +1,265 @@
+// Package activitypub holds the parts of ActivityPub the forum federates with: the objects
+// servers exchange, HTTP Signatures on their requests and WebFinger lookups.
+//
+// Requests are signed as in draft-cavage-http-signatures, with rsa-sha256 over
+// "(request-target) host date digest", which is what Mastodon and Lemmy expect.
+package activitypub
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ContentType is the media type of ActivityPub documents
+const ContentType = "application/activity+json"
+
+// Accept asks for ActivityPub documents under either of their media types
+const Accept = `application/activity+json, application/ld+json; profile="https://www.w3.org/ns/activitystreams"`
+
+// Public addresses an object to everyone
+const Public = "https://www.w3.org/ns/activitystreams#Public"
+
+// Context is the JSON-LD context of the documents the forum serves
+var Context = []string{"https://www.w3.org/ns/activitystreams", "https://w3id.org/security/v1"}
+
+// UserAgent is sent with every request
+var UserAgent = "Forum-ActivityPub/1.0"
+
+// MaxBody is the largest document read from a request or an answer
+const MaxBody = 1 << 20
+
+// ErrGone is returned when a fetched document no longer exists
+var ErrGone = errors.New("activitypub: gone")
+
+// Object is an actor, an activity or the content it is about. Only the properties the forum
+// uses are kept.
+type Object struct {
+	Context           interface{} `json:"@context,omitempty"`
+	ID                string      `json:"id,omitempty"`
+	Type              string      `json:"type,omitempty"`
+	Href              string      `json:"href,omitempty"` // of Link objects
+	Actor             *Link       `json:"actor,omitempty"`
+	Object            *Link       `json:"object,omitempty"`
+	Name              string      `json:"name,omitempty"`
+	PreferredUsername string      `json:"preferredUsername,omitempty"`
+	Summary           string      `json:"summary,omitempty"`
+	Content           string      `json:"content,omitempty"`
+	MediaType         string      `json:"mediaType,omitempty"`
+	Source            *Source     `json:"source,omitempty"`
+	URL               *Link       `json:"url,omitempty"`
+	AttributedTo      *Link       `json:"attributedTo,omitempty"`
+	InReplyTo         *Link       `json:"inReplyTo,omitempty"`
+	Audience          *Link       `json:"audience,omitempty"`
+	To                Strings     `json:"to,omitempty"`
+	CC                Strings     `json:"cc,omitempty"`
+	Published         *time.Time  `json:"published,omitempty"`
+	Inbox             string      `json:"inbox,omitempty"`
+	Outbox            string      `json:"outbox,omitempty"`
+	Followers         string      `json:"followers,omitempty"`
+	Endpoints         *Endpoints  `json:"endpoints,omitempty"`
+	PublicKey         *PublicKey  `json:"publicKey,omitempty"`
+	TotalItems        *int        `json:"totalItems,omitempty"`
+	OrderedItems      []Link      `json:"orderedItems,omitempty"`
+}
+
+// Source is the text an object's HTML content was made from
+type Source struct {
+	Content   string `json:"content"`
+	MediaType string `json:"mediaType"`
+}
+
+// Endpoints are the addresses an actor shares with the others of its server
+type Endpoints struct {
+	SharedInbox string `json:"sharedInbox,omitempty"`
+}
+
+// PublicKey is the key an actor signs its requests with
+type PublicKey struct {
+	ID           string `json:"id"`
+	Owner        string `json:"owner"`
+	PublicKeyPem string `json:"publicKeyPem"`
+}
+
+// Link is a property holding either the ID of an object or the object itself
+type Link struct {
+	ID     string
+	Object *Object // set when the object is embedded
+}
+
+// LinkTo links to an object by ID
+func LinkTo(id string) *Link {
+	return &Link{ID: id}
+}
+
+// Embed embeds an object
+func Embed(o *Object) *Link {
+	return &Link{ID: o.ID, Object: o}
+}
+
+// MarshalJSON writes the embedded object, or else the ID
+func (l Link) MarshalJSON() ([]byte, error) {
+	if l.Object != nil {
+		return json.Marshal(l.Object)
+	}
+	return json.Marshal(l.ID)
+}
+
+// UnmarshalJSON reads an ID, an object or a Link object. Of a list, the first entry is kept.
+func (l *Link) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch {
+	case len(data) == 0 || string(data) == "null":
+		return nil
+	case data[0] == '"':
+		return json.Unmarshal(data, &l.ID)
+	case data[0] == '[':
+		var list []Link
+		if err := json.Unmarshal(data, &list); err != nil {
+			return err
+		}
+		if len(list) > 0 {
+			*l = list[0]
+		}
+		return nil
+	}
+	var o Object
+	if err := json.Unmarshal(data, &o); err != nil {
+		return err
+	}
+	l.ID, l.Object = o.ID, &o
+	if o.Type == "Link" || o.ID == "" {
+		l.ID, l.Object = o.Href, nil
+	}
+	return nil
+}
+
+// IDOf returns the ID of a link, which may be nil
+func IDOf(l *Link) string {
+	if l == nil {
+		return ""
+	}
+	return l.ID
+}
+
+// Strings is a list of IDs that other servers may also send as a single string
+type Strings []string
+
+// UnmarshalJSON reads a string or a list of strings
+func (s *Strings) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*s = Strings{one}
+		return nil
+	}
+	var links []Link
+	if err := json.Unmarshal(data, &links); err != nil {
+		return err
+	}
+	*s = nil
+	for _, l := range links {
+		*s = append(*s, l.ID)
+	}
+	return nil
+}
+
+// Addresses lists everyone an object is addressed to
+func (o *Object) Addresses() []string {
+	list := append(append([]string{}, o.To...), o.CC...)
+	if id := IDOf(o.Audience); id != "" {
+		list = append(list, id)
+	}
+	return list
+}
+
+// IsPublic reports whether an object is addressed to everyone
+func (o *Object) IsPublic() bool {
+	for _, to := range o.Addresses() {
+		if to == Public || to == "as:Public" || to == "Public" {
+			return true
+		}
+	}
+	return false
+}
+
+// SameHost reports whether two IDs are on the same server
+func SameHost(a, b string) bool {
+	ua, errA := url.Parse(a)
+	ub, errB := url.Parse(b)
+	return errA == nil && errB == nil && ua.Host != "" && strings.EqualFold(ua.Host, ub.Host)
+}
+
+// Get fetches a document. Documents that are gone return ErrGone.
+func Get(client *http.Client, rawURL string, v interface{}) error {
+	if err := CheckURL(rawURL); err != nil {
+		return err
+	}
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", Accept)
+	req.Header.Set("User-Agent", UserAgent)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return ErrGone
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, MaxBody)).Decode(v)
+}
+
+// GetObject fetches an object and checks it is the one asked for, so a server cannot
+// pass off documents of another
+func GetObject(client *http.Client, id string) (*Object, error) {
+	var o Object
+	if err := Get(client, id, &o); err != nil {
+		return nil, err
+	}
+	if o.ID != id {
+		return nil, fmt.Errorf("activitypub: fetched %s but got %s", id, o.ID)
+	}
+	return &o, nil
+}
+
+// Post sends an activity to an inbox, signed with the sender's key. Answers other than 2xx
+// are returned with an error.
+func Post(client *http.Client, inbox string, key *Key, body []byte, now time.Time) error {
+	if err := CheckURL(inbox); err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", inbox, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", ContentType)
+	req.Header.Set("User-Agent", UserAgent)
+	if err := Sign(req, key, body, now); err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, MaxBody))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
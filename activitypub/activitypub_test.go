@@ -0,0 +1,224 @@
+package activitypub
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	privatePEM, publicPEM, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	private, err := ParsePrivateKey(privatePEM)
+	if err != nil {
+		t.Fatalf("Failed to parse private key: %v", err)
+	}
+	public, err := ParsePublicKey(publicPEM)
+	if err != nil {
+		t.Fatalf("Failed to parse public key: %v", err)
+	}
+	key := &Key{ID: "https://a.example/ap/category/1#main-key", Private: private}
+
+	now := time.Now()
+	body := []byte(`{"type":"Follow"}`)
+	var received *http.Request
+	var receivedBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		receivedBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+	defer func() { AllowLoopback = false }()
+	AllowLoopback = true
+
+	if err := Post(server.Client(), server.URL+"/ap/inbox?x=1", key, body, now); err != nil {
+		t.Fatalf("Failed to post: %v", err)
+	}
+	if received.Header.Get("Content-Type") != ContentType {
+		t.Errorf("Unexpected content type %q", received.Header.Get("Content-Type"))
+	}
+
+	signature, err := ParseSignature(received)
+	if err != nil {
+		t.Fatalf("Failed to parse signature: %v", err)
+	}
+	if signature.KeyID != key.ID || len(signature.Headers) != 4 {
+		t.Errorf("Unexpected signature %+v", signature)
+	}
+	if err := signature.Verify(received, receivedBody, public, time.Hour, now); err != nil {
+		t.Errorf("Expected a valid signature, got %v", err)
+	}
+
+	// A changed body, a replayed request or another key do not pass
+	if err := signature.Verify(received, []byte(`{"type":"Delete"}`), public, time.Hour, now); err == nil {
+		t.Error("Expected a changed body to be refused")
+	}
+	if err := signature.Verify(received, receivedBody, public, time.Hour, now.Add(2*time.Hour)); err == nil {
+		t.Error("Expected an old request to be refused")
+	}
+	_, otherPEM, _ := GenerateKey()
+	other, _ := ParsePublicKey(otherPEM)
+	if err := signature.Verify(received, receivedBody, other, time.Hour, now); err == nil {
+		t.Error("Expected another key to be refused")
+	}
+
+	// Signatures must cover the digest of a body
+	req := httptest.NewRequest("POST", "/ap/inbox", bytes.NewReader(body))
+	if err := Sign(req, key, nil, now); err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+	signature, _ = ParseSignature(req)
+	if err := signature.Verify(req, body, public, time.Hour, now); err == nil {
+		t.Error("Expected a body without signed digest to be refused")
+	}
+}
+
+func TestLinks(t *testing.T) {
+	var o Object
+	err := json.Unmarshal([]byte(`{
+		"id": "https://b.example/activities/1",
+		"type": "Announce",
+		"actor": "https://b.example/c/general",
+		"to": "https://www.w3.org/ns/activitystreams#Public",
+		"object": {
+			"id": "https://b.example/create/1",
+			"type": "Create",
+			"object": {"id": "https://b.example/post/1", "type": "Page", "attributedTo": ["https://b.example/u/alice"]}
+		},
+		"url": {"type": "Link", "href": "https://b.example/post/1"}
+	}`), &o)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	inner := o.Object.Object.Object.Object
+	if IDOf(o.Actor) != "https://b.example/c/general" || inner == nil || IDOf(inner.AttributedTo) != "https://b.example/u/alice" {
+		t.Errorf("Unexpected links %+v", o)
+	}
+	if !o.IsPublic() || IDOf(o.URL) != "https://b.example/post/1" {
+		t.Errorf("Expected a public announce with its URL, got %+v", o)
+	}
+
+	// Links are written as IDs unless the object is embedded
+	data, _ := json.Marshal(Object{Type: "Follow", Actor: LinkTo("https://a.example/1"), Object: Embed(&Object{ID: "https://b.example/2", Type: "Group"})})
+	if string(data) != `{"type":"Follow","actor":"https://a.example/1","object":{"id":"https://b.example/2","type":"Group"}}` {
+		t.Errorf("Unexpected JSON %s", data)
+	}
+
+	if !SameHost("https://b.example/a", "https://B.example/b") || SameHost("https://b.example/a", "https://c.example/a") {
+		t.Error("Unexpected host comparison")
+	}
+}
+
+func TestParseHandle(t *testing.T) {
+	for handle, want := range map[string][2]string{
+		"@general@forum.example":      {"general", "forum.example"},
+		"acct:general@localhost:3001": {"general", "localhost:3001"},
+		"general":                     {"", ""},
+		"general@host/path":           {"", ""},
+	} {
+		name, host, _ := ParseHandle(handle)
+		if name != want[0] || host != want[1] {
+			t.Errorf("%s: expected %v, got %s %s", handle, want, name, host)
+		}
+	}
+}
+
+func TestFinger(t *testing.T) {
+	var resource string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resource = r.URL.Query().Get("resource")
+		json.NewEncoder(w).Encode(WebFinger{Links: []WebFingerLink{{Rel: "self", Type: ContentType, Href: "http://" + r.Host + "/u/alice"}}})
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+	client := NewClient(time.Second)
+
+	// Instances on one machine find each other over HTTP when loopback is allowed
+	defer func() { AllowLoopback = false }()
+	AllowLoopback = true
+	uri, err := Finger(client, "alice@"+host)
+	if err != nil || uri != srv.URL+"/u/alice" || resource != "acct:alice@"+host {
+		t.Fatalf("Unexpected lookup %q %v for %q", uri, err, resource)
+	}
+	if Scheme("forum.example") != "https" || Scheme("localhost:3001") != "http" {
+		t.Error("Expected HTTP only for loopback hosts")
+	}
+	AllowLoopback = false
+	if Scheme("localhost:3001") != "https" {
+		t.Error("Expected HTTPS when loopback is not allowed")
+	}
+}
+
+func TestPlainText(t *testing.T) {
+	got := PlainText(`<p>Hello <span class="h-card"><a href="https://m.example/@bob">@<span>bob</span></a></span> &amp; all</p><p>See https://x.example<br>or <a href="https://y.example">https://y.example</a></p><script>x</script>`)
+	want := "Hello [@bob](https://m.example/@bob) & all\n\nSee https://x.example\nor https://y.example"
+	if got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestClient(t *testing.T) {
+	for _, tc := range []struct {
+		ip     string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"::1", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+		{"::ffff:10.0.0.1", false},
+	} {
+		if public := publicIP(net.ParseIP(tc.ip)); public != tc.public {
+			t.Errorf("Expected publicIP(%s) to be %v", tc.ip, tc.public)
+		}
+	}
+
+	for _, raw := range []string{"http://b.example/u/alice", "https:///u/alice", "/u/alice", "https://user@b.example/"} {
+		if err := CheckURL(raw); err == nil {
+			t.Errorf("Expected %s to be refused", raw)
+		}
+	}
+	if err := CheckURL("https://b.example/u/alice#main-key"); err != nil {
+		t.Errorf("Expected an https URL to be accepted, got %v", err)
+	}
+
+	// Loopback addresses are only reached when allowed
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(`{"id":"x"}`))
+	}))
+	defer srv.Close()
+	client := NewClient(time.Second)
+	var o Object
+
+	defer func() { AllowLoopback = false }()
+	AllowLoopback = true
+	if err := Get(client, srv.URL, &o); err != nil || requests != 1 {
+		t.Fatalf("Expected the fetch to work with loopback allowed, got %v", err)
+	}
+	AllowLoopback = false
+	if err := Get(client, strings.Replace(srv.URL, "http:", "https:", 1), &o); err == nil || !strings.Contains(err.Error(), "refusing to connect") {
+		t.Errorf("Expected the loopback address to be refused, got %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("Expected no request to reach the server, got %d", requests)
+	}
+}
@@ -0,0 +1,104 @@
+package activitypub
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// AllowLoopback lets clients made by NewClient reach loopback addresses over plain HTTP, so
+// instances on one machine can federate with each other. It must stay off on public servers.
+var AllowLoopback bool
+
+// maxRedirects is how many redirects a fetch follows
+const maxRedirects = 5
+
+// carrierNAT is the shared address space of RFC 6598, which net.IP does not count as private
+var carrierNAT = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// NewClient returns a client for other servers. Anyone can make the forum fetch a URL by sending
+// it an activity, so the client only connects to public addresses, checked after DNS
+// resolution, and only follows redirects to URLs CheckURL accepts.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, Control: checkDial}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			// No proxy from the environment: it would be dialed instead of the checked address
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   timeout,
+			ResponseHeaderTimeout: timeout,
+			MaxIdleConns:          20,
+			IdleConnTimeout:       90 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return errors.New("activitypub: too many redirects")
+			}
+			return CheckURL(req.URL.String())
+		},
+	}
+}
+
+// CheckURL returns an error for URLs the forum does not fetch: those without a host, and those
+// that are not https unless AllowLoopback is set
+func CheckURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Host == "" || u.User != nil {
+		return fmt.Errorf("activitypub: invalid URL %q", raw)
+	}
+	if u.Scheme != "https" && !(u.Scheme == "http" && AllowLoopback) {
+		return fmt.Errorf("activitypub: %q is not https", raw)
+	}
+	return nil
+}
+
+// Scheme is the scheme used to reach a host known only by name, such as the server of a
+// handle: http for loopback hosts when AllowLoopback is set, as CheckURL accepts, and https
+// otherwise
+func Scheme(host string) string {
+	name := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		name = h
+	}
+	if ip := net.ParseIP(name); AllowLoopback && (name == "localhost" || ip != nil && ip.IsLoopback()) {
+		return "http"
+	}
+	return "https"
+}
+
+// checkDial refuses connections to addresses that are not public
+func checkDial(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("activitypub: invalid address %q", address)
+	}
+	if !publicIP(ip) && !(AllowLoopback && ip.IsLoopback()) {
+		return fmt.Errorf("activitypub: refusing to connect to %s", ip)
+	}
+	return nil
+}
+
+// publicIP reports whether an address is reachable on the internet, rather than on the host,
+// the local network or a cloud provider's metadata service
+func publicIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		if ip4[0] == 0 || carrierNAT.Contains(ip4) {
+			return false
+		}
+	}
+	return ip.IsGlobalUnicast() && !ip.IsPrivate()
+}
@@ -0,0 +1,40 @@
+package activitypub
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	reDropped    = regexp.MustCompile(`(?is)<(script|style)[^>]*>.*?</(script|style)>`)
+	reBreak      = regexp.MustCompile(`(?i)<br\s*/?>`)
+	reBlockEnd   = regexp.MustCompile(`(?i)</(p|div|li|h[1-6]|blockquote|pre)>`)
+	reLink       = regexp.MustCompile(`(?is)<a\s[^>]*?href="([^"]*)"[^>]*>(.*?)</a>`)
+	reTag        = regexp.MustCompile(`(?s)<[^>]*>`)
+	reBlankLines = regexp.MustCompile(`\n{3,}`)
+)
+
+// PlainText turns the HTML content of an object from another server into text that can be
+// stored as Markdown. Paragraphs and line breaks are kept, and links keep their address.
+func PlainText(content string) string {
+	s := reDropped.ReplaceAllString(content, "")
+	s = reBreak.ReplaceAllString(s, "\n")
+	s = reBlockEnd.ReplaceAllString(s, "\n\n")
+	s = reLink.ReplaceAllStringFunc(s, func(link string) string {
+		m := reLink.FindStringSubmatch(link)
+		href := html.UnescapeString(m[1])
+		text := strings.TrimSpace(html.UnescapeString(reTag.ReplaceAllString(m[2], "")))
+		if text == "" || text == href || strings.HasSuffix(href, "//"+text) {
+			return href
+		}
+		return "[" + text + "](" + href + ")"
+	})
+	s = html.UnescapeString(reTag.ReplaceAllString(s, ""))
+
+	lines := strings.Split(s, "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSpace(lines[i])
+	}
+	return strings.TrimSpace(reBlankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
@@ -0,0 +1,208 @@
+package activitypub
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SignedHeaders are the headers a signature covers. Requests without a body leave out the digest.
+var SignedHeaders = []string{"(request-target)", "host", "date", "digest"}
+
+// Key is an actor's private key together with the ID others find its public half under
+type Key struct {
+	ID      string
+	Private *rsa.PrivateKey
+}
+
+// GenerateKey creates an RSA key pair and returns both halves PEM encoded
+func GenerateKey() (privatePEM, publicPEM string, err error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return "", "", err
+	}
+	public, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return "", "", err
+	}
+	privatePEM = string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+	publicPEM = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public}))
+	return privatePEM, publicPEM, nil
+}
+
+// ParsePrivateKey reads a PEM encoded private key made by GenerateKey
+func ParsePrivateKey(privatePEM string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(privatePEM))
+	if block == nil {
+		return nil, errors.New("activitypub: invalid private key")
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
+// ParsePublicKey reads the PEM encoded public key of an actor
+func ParsePublicKey(publicPEM string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(publicPEM))
+	if block == nil {
+		return nil, errors.New("activitypub: invalid public key")
+	}
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	public, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("activitypub: unsupported public key type")
+	}
+	return public, nil
+}
+
+// Digest returns the Digest header of a body
+func Digest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "SHA-256=" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Sign adds the Date, Digest and Signature headers to a request. The body is the one the
+// request sends, nil for requests without one.
+func Sign(req *http.Request, key *Key, body []byte, now time.Time) error {
+	headers := SignedHeaders
+	if body == nil {
+		headers = headers[:3]
+	} else {
+		req.Header.Set("Digest", Digest(body))
+	}
+	if req.Host == "" {
+		req.Host = req.URL.Host
+	}
+	req.Header.Set("Date", now.UTC().Format(http.TimeFormat))
+
+	hash := sha256.Sum256([]byte(signingString(req, headers)))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key.Private, crypto.SHA256, hash[:])
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Signature", fmt.Sprintf(
+		`keyId="%s",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		key.ID, strings.Join(headers, " "), base64.StdEncoding.EncodeToString(signature),
+	))
+	return nil
+}
+
+// signingString lists the signed headers of a request, one "name: value" line each
+func signingString(r *http.Request, headers []string) string {
+	lines := make([]string, 0, len(headers))
+	for _, name := range headers {
+		var value string
+		switch name {
+		case "(request-target)":
+			value = strings.ToLower(r.Method) + " " + r.URL.RequestURI()
+		case "host":
+			value = r.Host
+		default:
+			value = strings.Join(r.Header.Values(name), ", ")
+		}
+		lines = append(lines, name+": "+value)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Signature is the parsed Signature header of a request
+type Signature struct {
+	KeyID     string
+	Algorithm string
+	Headers   []string
+	Signature []byte
+}
+
+// ParseSignature reads the Signature header of a request
+func ParseSignature(r *http.Request) (*Signature, error) {
+	header := r.Header.Get("Signature")
+	if header == "" {
+		return nil, errors.New("activitypub: missing signature")
+	}
+
+	s := &Signature{Headers: []string{"date"}}
+	for _, part := range strings.Split(header, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(value, `"`)
+		switch name {
+		case "keyId":
+			s.KeyID = value
+		case "algorithm":
+			s.Algorithm = value
+		case "headers":
+			s.Headers = strings.Fields(strings.ToLower(value))
+		case "signature":
+			signature, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return nil, errors.New("activitypub: invalid signature")
+			}
+			s.Signature = signature
+		}
+	}
+	if s.KeyID == "" || s.Signature == nil {
+		return nil, errors.New("activitypub: incomplete signature")
+	}
+	return s, nil
+}
+
+// Verify checks the signature of a received request with the signer's public key. The
+// signature must cover the request target, the host and the date, which must be within
+// tolerance of now so captured requests cannot be replayed later, and for requests with
+// a body also the digest, which must match the body.
+func (s *Signature) Verify(r *http.Request, body []byte, public *rsa.PublicKey, tolerance time.Duration, now time.Time) error {
+	if s.Algorithm != "" && s.Algorithm != "rsa-sha256" && s.Algorithm != "hs2019" {
+		return fmt.Errorf("activitypub: unsupported algorithm %q", s.Algorithm)
+	}
+
+	required := SignedHeaders
+	if len(body) == 0 {
+		required = required[:3]
+	}
+	for _, name := range required {
+		if !s.covers(name) {
+			return fmt.Errorf("activitypub: %s is not signed", name)
+		}
+	}
+
+	date, err := http.ParseTime(r.Header.Get("Date"))
+	if err != nil {
+		return errors.New("activitypub: missing date")
+	}
+	if age := now.Sub(date); age > tolerance || age < -tolerance {
+		return errors.New("activitypub: date too far from now")
+	}
+	if len(body) > 0 && r.Header.Get("Digest") != Digest(body) {
+		return errors.New("activitypub: digest does not match the body")
+	}
+
+	hash := sha256.Sum256([]byte(signingString(r, s.Headers)))
+	if err := rsa.VerifyPKCS1v15(public, crypto.SHA256, hash[:], s.Signature); err != nil {
+		return errors.New("activitypub: invalid signature")
+	}
+	return nil
+}
+
+func (s *Signature) covers(name string) bool {
+	for _, h := range s.Headers {
+		if h == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package activitypub
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// WebFinger is the answer to a WebFinger lookup
+type WebFinger struct {
+	Subject string          `json:"subject"`
+	Aliases []string        `json:"aliases,omitempty"`
+	Links   []WebFingerLink `json:"links"`
+}
+
+// WebFingerLink is one of the addresses a WebFinger answer points to
+type WebFingerLink struct {
+	Rel  string `json:"rel"`
+	Type string `json:"type,omitempty"`
+	Href string `json:"href,omitempty"`
+}
+
+// ParseHandle splits a handle such as "@general@forum.example" into its name and host
+func ParseHandle(handle string) (name, host string, err error) {
+	handle = strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(handle), "acct:"), "@")
+	name, host, ok := strings.Cut(handle, "@")
+	if !ok || name == "" || host == "" || strings.ContainsAny(host, "@/?#") {
+		return "", "", errors.New("activitypub: invalid handle")
+	}
+	return name, host, nil
+}
+
+// Finger looks up the actor ID of a handle on its server, over HTTPS unless Scheme allows HTTP
+func Finger(client *http.Client, handle string) (string, error) {
+	name, host, err := ParseHandle(handle)
+	if err != nil {
+		return "", err
+	}
+
+	var answer WebFinger
+	lookup := fmt.Sprintf("%s://%s/.well-known/webfinger?resource=%s", Scheme(host), host, url.QueryEscape("acct:"+name+"@"+host))
+	if err := Get(client, lookup, &answer); err != nil {
+		return "", err
+	}
+	for _, link := range answer.Links {
+		if link.Rel == "self" && (link.Type == ContentType || strings.HasPrefix(link.Type, "application/ld+json")) {
+			return link.Href, nil
+		}
+	}
+	return "", errors.New("activitypub: no actor for " + handle)
+}
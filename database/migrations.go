@@ -120,6 +120,705 @@ func RunMigrations(db *sql.DB) error {
 		return err
 	}
 
+	// Track when a user last changed their username (for the rename cooldown)
+	err = addColumnIfNotExists(db, "users", "username_changed_at", "TIMESTAMP")
+	if err != nil {
+		return err
+	}
+
+	// Create email_changes table for pending, not yet verified email addresses
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS email_changes (
+			token TEXT PRIMARY KEY,
+			user_id INTEGER NOT NULL,
+			new_email TEXT NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create account_audit table recording changes made to user accounts
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS account_audit (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			action TEXT NOT NULL,
+			detail TEXT NOT NULL DEFAULT '',
+			ip_address TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Public profile fields
+	err = addColumnIfNotExists(db, "users", "bio", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	err = addColumnIfNotExists(db, "users", "hidden_profile_fields", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+
+	// Avatar version, bumped on every upload so avatar URLs can be cached forever (<= 0 = no avatar)
+	err = addColumnIfNotExists(db, "users", "avatar_version", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+
+	// Create attachments table for files uploaded with posts and comments.
+	// Uploads start without a post and are attached when the post or comment is saved.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS attachments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			token TEXT NOT NULL UNIQUE,
+			user_id INTEGER NOT NULL,
+			post_id INTEGER,
+			comment_id INTEGER,
+			filename TEXT NOT NULL,
+			content_type TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			width INTEGER NOT NULL DEFAULT 0,
+			height INTEGER NOT NULL DEFAULT 0,
+			has_thumbnail INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+			FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+			FOREIGN KEY (comment_id) REFERENCES comments(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_attachments_post ON attachments(post_id)")
+	if err != nil {
+		return err
+	}
+
+	// Create rendered_content table caching the Markdown HTML of posts and comments.
+	// The revision is a hash of the renderer version and the source text.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS rendered_content (
+			target_type TEXT NOT NULL,
+			target_id INTEGER NOT NULL,
+			revision TEXT NOT NULL,
+			html TEXT NOT NULL,
+			PRIMARY KEY (target_type, target_id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create drafts table for unpublished posts. A draft with publish_at set is
+	// published by the scheduler once that time has passed.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS drafts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			title TEXT NOT NULL DEFAULT '',
+			content TEXT NOT NULL DEFAULT '',
+			category_ids TEXT NOT NULL DEFAULT '',
+			publish_at TIMESTAMP,
+			last_error TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_drafts_publish_at ON drafts(publish_at)")
+	if err != nil {
+		return err
+	}
+
+	// Uploads made while writing a draft belong to it until it is published
+	err = addColumnIfNotExists(db, "attachments", "draft_id", "INTEGER")
+	if err != nil {
+		return err
+	}
+
+	// Roles: "user", "moderator" or "admin"
+	err = addColumnIfNotExists(db, "users", "role", "TEXT NOT NULL DEFAULT 'user'")
+	if err != nil {
+		return err
+	}
+
+	// Create polls table, at most one poll per post. A poll stops taking votes at
+	// closes_at, or earlier when closed_at is set by its creator or a moderator.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS polls (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			post_id INTEGER NOT NULL UNIQUE,
+			question TEXT NOT NULL DEFAULT '',
+			multiple_choice BOOLEAN NOT NULL DEFAULT 0,
+			results_before_vote BOOLEAN NOT NULL DEFAULT 0,
+			closes_at TIMESTAMP,
+			closed_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create poll_options table
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS poll_options (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			poll_id INTEGER NOT NULL,
+			position INTEGER NOT NULL,
+			text TEXT NOT NULL,
+			FOREIGN KEY (poll_id) REFERENCES polls(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create poll_votes table. A user's ballot is all their rows for a poll,
+	// one row per chosen option.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS poll_votes (
+			poll_id INTEGER NOT NULL,
+			option_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (poll_id, option_id, user_id),
+			FOREIGN KEY (poll_id) REFERENCES polls(id) ON DELETE CASCADE,
+			FOREIGN KEY (option_id) REFERENCES poll_options(id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Polls of drafts are kept as JSON until the draft is published
+	err = addColumnIfNotExists(db, "drafts", "poll", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+
+	// Create bookmark_folders table for organizing saved posts and comments
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS bookmark_folders (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(user_id, name),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create bookmarks table. Bookmarks are private; target_type is 'post' or 'comment'.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS bookmarks (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			target_type TEXT NOT NULL,
+			target_id INTEGER NOT NULL,
+			folder_id INTEGER,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(user_id, target_type, target_id),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+			FOREIGN KEY (folder_id) REFERENCES bookmark_folders(id) ON DELETE SET NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create follows table. target_type is 'post', 'category' or 'user'.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS follows (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			target_type TEXT NOT NULL,
+			target_id INTEGER NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(user_id, target_type, target_id),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_follows_target ON follows(target_type, target_id)")
+	if err != nil {
+		return err
+	}
+
+	// Create notifications table. comment_id and category_id are 0 when they do not apply.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS notifications (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			actor_id INTEGER NOT NULL,
+			type TEXT NOT NULL,
+			post_id INTEGER NOT NULL,
+			comment_id INTEGER NOT NULL DEFAULT 0,
+			category_id INTEGER NOT NULL DEFAULT 0,
+			reaction INTEGER NOT NULL DEFAULT 0,
+			read_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_notifications_user ON notifications(user_id, read_at)")
+	if err != nil {
+		return err
+	}
+
+	// Create mentions table. name is the username as it was written in the content.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS mentions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			target_type TEXT NOT NULL,
+			target_id INTEGER NOT NULL,
+			post_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			author_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(target_type, target_id, user_id),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_mentions_user ON mentions(user_id)")
+	if err != nil {
+		return err
+	}
+
+	// Create user_blocks table
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS user_blocks (
+			user_id INTEGER NOT NULL,
+			blocked_id INTEGER NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (user_id, blocked_id),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+			FOREIGN KEY (blocked_id) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	if err := addColumnIfNotExists(db, "users", "notify_mentions", "INTEGER NOT NULL DEFAULT 1"); err != nil {
+		return err
+	}
+
+	// Email preferences. email_digest is 'off', 'daily' or 'weekly'.
+	emailColumns := [][2]string{
+		{"email_replies", "INTEGER NOT NULL DEFAULT 1"},
+		{"email_mentions", "INTEGER NOT NULL DEFAULT 1"},
+		{"email_digest", "TEXT NOT NULL DEFAULT 'off'"},
+		{"last_digest_at", "TIMESTAMP"},
+		{"email_token", "TEXT"},
+	}
+	for _, column := range emailColumns {
+		if err := addColumnIfNotExists(db, "users", column[0], column[1]); err != nil {
+			return err
+		}
+	}
+
+	// Notifications not yet considered for an immediate email
+	if err := addColumnIfNotExists(db, "notifications", "email_pending", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
+	// Create email_outbox table. Emails are rendered when queued and sent by a background worker.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS email_outbox (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			to_address TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			text_body TEXT NOT NULL,
+			html_body TEXT NOT NULL,
+			unsubscribe_url TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT NOT NULL DEFAULT '',
+			next_attempt_at TIMESTAMP NOT NULL,
+			sent_at TIMESTAMP,
+			failed_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_email_outbox_due ON email_outbox(sent_at, failed_at, next_attempt_at)")
+	if err != nil {
+		return err
+	}
+
+	// Create chat_messages table for the chat room of each category. Deleted messages are kept
+	// with the moderator who removed them.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS chat_messages (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			category_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			content TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			deleted_at TIMESTAMP,
+			deleted_by INTEGER NOT NULL DEFAULT 0,
+			FOREIGN KEY (category_id) REFERENCES categories(id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_chat_messages_category ON chat_messages(category_id, id)")
+	if err != nil {
+		return err
+	}
+
+	// Create chat_mutes table for users who may not write in a chat room for a while
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS chat_mutes (
+			category_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			muted_by INTEGER NOT NULL,
+			muted_until TIMESTAMP NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (category_id, user_id),
+			FOREIGN KEY (category_id) REFERENCES categories(id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create conversations table for private messages between users
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS conversations (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			last_message_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create conversation_members table. Members who left keep their row so they
+	// can still report what they were sent.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS conversation_members (
+			conversation_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			last_read_id INTEGER NOT NULL DEFAULT 0,
+			joined_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			left_at TIMESTAMP,
+			PRIMARY KEY (conversation_id, user_id),
+			FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_conversation_members_user ON conversation_members(user_id)")
+	if err != nil {
+		return err
+	}
+
+	// Create private_messages table
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS private_messages (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			conversation_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			content TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_private_messages_conversation ON private_messages(conversation_id, id)")
+	if err != nil {
+		return err
+	}
+
+	// Create message_reports table. Moderators only ever see private messages that were reported.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS message_reports (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			message_id INTEGER NOT NULL,
+			reporter_id INTEGER NOT NULL,
+			reason TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			resolved_at TIMESTAMP,
+			resolved_by INTEGER NOT NULL DEFAULT 0,
+			UNIQUE (message_id, reporter_id),
+			FOREIGN KEY (message_id) REFERENCES private_messages(id) ON DELETE CASCADE,
+			FOREIGN KEY (reporter_id) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create webhooks table for integrations told about forum events. Events is a
+	// comma-separated list of event types.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS webhooks (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			url TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			events TEXT NOT NULL,
+			active INTEGER NOT NULL DEFAULT 1,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create webhook_deliveries table, the queue and log of webhook requests
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			webhook_id INTEGER NOT NULL,
+			event TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			status_code INTEGER NOT NULL DEFAULT 0,
+			response_body TEXT NOT NULL DEFAULT '',
+			last_error TEXT NOT NULL DEFAULT '',
+			next_attempt_at TIMESTAMP NOT NULL,
+			delivered_at TIMESTAMP,
+			failed_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (webhook_id) REFERENCES webhooks(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook ON webhook_deliveries(webhook_id, id)")
+	if err != nil {
+		return err
+	}
+
+	// Create api_tokens table. Only the SHA-256 of each token is kept.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS api_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			prefix TEXT NOT NULL,
+			scopes TEXT NOT NULL,
+			expires_at TIMESTAMP,
+			last_used_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_api_tokens_user ON api_tokens(user_id)")
+	if err != nil {
+		return err
+	}
+
+	// Create federation_keys table with the key pair of every category and user that
+	// federates. owner_type is 'category' or 'user'.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS federation_keys (
+			owner_type TEXT NOT NULL,
+			owner_id INTEGER NOT NULL,
+			private_key TEXT NOT NULL,
+			public_key TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (owner_type, owner_id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create remote_actors table, a cache of actors on other servers. Authors of remote
+	// posts and comments get a local account, user_id, that their content belongs to.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS remote_actors (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			uri TEXT NOT NULL UNIQUE,
+			type TEXT NOT NULL,
+			username TEXT NOT NULL,
+			host TEXT NOT NULL,
+			name TEXT NOT NULL DEFAULT '',
+			inbox TEXT NOT NULL,
+			shared_inbox TEXT NOT NULL DEFAULT '',
+			key_id TEXT NOT NULL DEFAULT '',
+			public_key TEXT NOT NULL DEFAULT '',
+			user_id INTEGER,
+			fetched_at TIMESTAMP NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_remote_actors_key ON remote_actors(key_id)")
+	if err != nil {
+		return err
+	}
+
+	// Create category_followers table, the remote actors following a category
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS category_followers (
+			category_id INTEGER NOT NULL,
+			actor_id INTEGER NOT NULL,
+			follow_uri TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (category_id, actor_id),
+			FOREIGN KEY (category_id) REFERENCES categories(id) ON DELETE CASCADE,
+			FOREIGN KEY (actor_id) REFERENCES remote_actors(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create category_subscriptions table, the remote groups a category follows. Posts the
+	// group shares are added to the category once the group accepted.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS category_subscriptions (
+			category_id INTEGER NOT NULL,
+			actor_id INTEGER NOT NULL,
+			accepted_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (category_id, actor_id),
+			FOREIGN KEY (category_id) REFERENCES categories(id) ON DELETE CASCADE,
+			FOREIGN KEY (actor_id) REFERENCES remote_actors(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create federated_objects table, which maps posts and comments received from other
+	// servers to their IDs there. Replies to them are sent to reply_inbox. group_id is the
+	// remote group they were shared through, if any.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS federated_objects (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			uri TEXT NOT NULL UNIQUE,
+			target_type TEXT NOT NULL,
+			target_id INTEGER NOT NULL,
+			actor_id INTEGER NOT NULL,
+			group_id INTEGER NOT NULL DEFAULT 0,
+			reply_inbox TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (target_type, target_id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create federation_deliveries table, the queue of activities sent to other servers.
+	// Each is signed with the key of its sender.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS federation_deliveries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			sender_type TEXT NOT NULL,
+			sender_id INTEGER NOT NULL,
+			inbox TEXT NOT NULL,
+			activity TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT NOT NULL DEFAULT '',
+			next_attempt_at TIMESTAMP NOT NULL,
+			delivered_at TIMESTAMP,
+			failed_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_federation_deliveries_due ON federation_deliveries(delivered_at, failed_at, next_attempt_at)")
+	if err != nil {
+		return err
+	}
+
 	log.Println("Database migrations completed")
 	return nil
 }
+
+// addColumnIfNotExists adds a column to an existing table unless it is already there.
+// SQLite has no "ADD COLUMN IF NOT EXISTS", so the table schema is inspected first.
+func addColumnIfNotExists(db *sql.DB, table, column, definition string) error {
+	rows, err := db.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
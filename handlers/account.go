@@ -0,0 +1,387 @@
+package handlers
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"forum/models"
+	"forum/utils"
+)
+
+// AccountSettingsHandler displays the account settings page and applies changes
+func AccountSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	db := getDB(r)
+	user := getUserFromContext(r)
+
+	if r.Method == "GET" {
+		renderAccountSettings(w, r, nil, r.URL.Query().Get("success"))
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse form
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	ip := clientIP(r)
+	var success string
+
+	switch r.FormValue("action") {
+	case "profile":
+		err = models.UpdateProfile(db, user.ID, r.FormValue("bio"), r.Form["hidden_fields"])
+		success = "Profile updated"
+
+	case "notifications":
+		err = models.SetMentionNotifications(db, user.ID, r.FormValue("notify_mentions") == "1")
+		if err == nil {
+			err = models.UpdateEmailPreferences(db, user.ID, models.EmailPreferences{
+				Replies:  r.FormValue("email_replies") == "1",
+				Mentions: r.FormValue("email_mentions") == "1",
+				Digest:   r.FormValue("email_digest"),
+			})
+		}
+		success = "Notification settings updated"
+
+	case "username":
+		err = models.ChangeUsername(db, user.ID, r.FormValue("username"), ip)
+		success = "Username updated"
+
+	case "email":
+		var token string
+		token, err = models.RequestEmailChange(db, user.ID, r.FormValue("email"), ip)
+		if err == nil {
+			sendEmailVerification(r, user, r.FormValue("email"), token)
+			success = "Check your new inbox for a verification link"
+		}
+
+	case "password":
+		newPassword := r.FormValue("new_password")
+		if newPassword != r.FormValue("confirm_password") {
+			renderAccountSettings(w, r, []string{"Passwords do not match"}, "")
+			return
+		}
+		err = models.ChangePassword(db, user.ID, r.FormValue("current_password"), newPassword, ip)
+		if err == nil {
+			revokeOtherSessions(r, user.ID, ip)
+			success = "Password updated and other sessions signed out"
+		}
+
+	default:
+		http.Error(w, "Unknown action", http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		renderAccountSettings(w, r, []string{err.Error()}, "")
+		return
+	}
+
+	http.Redirect(w, r, "/account/settings?success="+url.QueryEscape(success), http.StatusSeeOther)
+}
+
+// VerifyEmailHandler confirms a pending email change from a verification link
+func VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	db := getDB(r)
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		RenderErrorPage(w, http.StatusNotFound)
+		return
+	}
+
+	_, err := models.ConfirmEmailChange(db, token, clientIP(r))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		renderTemplate(w, "verify_email.html", map[string]interface{}{
+			"User":  getUserFromContext(r),
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	// Reload the user so the page shows the new address
+	user := getUserFromContext(r)
+	if user != nil {
+		if refreshed, err := models.GetUserByID(db, user.ID); err == nil {
+			user = refreshed
+		}
+	}
+
+	renderTemplate(w, "verify_email.html", map[string]interface{}{
+		"User": user,
+	})
+}
+
+// ExportDataHandler lets a user download everything they have created as JSON or ZIP
+func ExportDataHandler(w http.ResponseWriter, r *http.Request) {
+	db := getDB(r)
+	user := getUserFromContext(r)
+
+	export, err := models.ExportUserData(db, user.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to export data: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if err := models.RecordAccountEvent(db, user.ID, models.AuditDataExported, r.URL.Query().Get("format"), clientIP(r)); err != nil {
+		log.Printf("Failed to record account event: %v", err)
+	}
+
+	filename := fmt.Sprintf("forum-export-%s-%s", user.Username, time.Now().Format("20060102"))
+
+	if r.URL.Query().Get("format") != "zip" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".json"))
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(export); err != nil {
+			log.Printf("Failed to write export: %v", err)
+		}
+		return
+	}
+
+	// The archive holds one file per kind of data, plus the full export
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", export.Profile},
+		{"posts.json", export.Posts},
+		{"comments.json", export.Comments},
+		{"post_reactions.json", export.PostReactions},
+		{"comment_reactions.json", export.CommentReactions},
+		{"attachments.json", export.Attachments},
+		{"drafts.json", export.Drafts},
+		{"poll_votes.json", export.PollVotes},
+		{"bookmarks.json", export.Bookmarks},
+		{"follows.json", export.Follows},
+		{"notifications.json", export.Notifications},
+		{"blocks.json", export.Blocks},
+		{"chat_messages.json", export.ChatMessages},
+		{"private_messages.json", export.PrivateMessages},
+		{"account_events.json", export.AccountEvents},
+		{"export.json", export},
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".zip"))
+
+	archive := zip.NewWriter(w)
+	for _, file := range files {
+		f, err := archive.Create(file.name)
+		if err != nil {
+			log.Printf("Failed to write export: %v", err)
+			return
+		}
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.data); err != nil {
+			log.Printf("Failed to write export: %v", err)
+			return
+		}
+	}
+
+	// Uploaded files are included as they were stored
+	for _, attachment := range export.Attachments {
+		data, err := Store.Get(attachmentKey(attachment.Token))
+		if err != nil {
+			log.Printf("Attachment file missing for %s: %v", attachment.Token, err)
+			continue
+		}
+		f, err := archive.Create(path.Join("attachments", attachment.Token+"-"+attachment.Filename))
+		if err != nil {
+			log.Printf("Failed to write export: %v", err)
+			return
+		}
+		if _, err := f.Write(data); err != nil {
+			log.Printf("Failed to write export: %v", err)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		log.Printf("Failed to write export: %v", err)
+	}
+}
+
+// DeleteAccountHandler permanently deletes the logged-in user's account
+func DeleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse form
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	db := getDB(r)
+	user := getUserFromContext(r)
+
+	mode := r.FormValue("mode")
+	if mode != models.DeleteModeAnonymize && mode != models.DeleteModeEverything {
+		renderAccountSettings(w, r, []string{"Choose what should happen to your content"}, "")
+		return
+	}
+
+	// Require the password again before doing anything irreversible
+	if _, err := models.AuthenticateUser(db, user.Email, r.FormValue("password")); err != nil {
+		renderAccountSettings(w, r, []string{"Password is incorrect"}, "")
+		return
+	}
+
+	attachments, err := models.DeleteUser(db, user.ID, mode)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete account: %v", err), http.StatusInternalServerError)
+		return
+	}
+	removeAvatarFiles(user.ID, 0)
+	for _, attachment := range attachments {
+		removeAttachmentFiles(attachment)
+	}
+
+	// Clear session cookie
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session_id",
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// renderAccountSettings renders the settings page with fresh account data
+func renderAccountSettings(w http.ResponseWriter, r *http.Request, errors []string, success string) {
+	db := getDB(r)
+	user := getUserFromContext(r)
+
+	// Reload the user so a rename made in this request is shown
+	if refreshed, err := models.GetUserByID(db, user.ID); err == nil {
+		user = refreshed
+	}
+
+	pendingEmail, err := models.GetPendingEmailChange(db, user.ID)
+	if err != nil {
+		log.Printf("Failed to get pending email change: %v", err)
+	}
+
+	events, err := models.GetAccountEvents(db, user.ID, 20)
+	if err != nil {
+		log.Printf("Failed to get account events: %v", err)
+	}
+
+	bio, hiddenFields, err := models.GetProfileSettings(db, user.ID)
+	if err != nil {
+		log.Printf("Failed to get profile settings: %v", err)
+	}
+
+	avatarVersion, err := models.GetAvatarVersion(db, user.ID)
+	if err != nil {
+		log.Printf("Failed to get avatar version: %v", err)
+	}
+
+	notifyMentions, err := models.MentionNotificationsEnabled(db, user.ID)
+	if err != nil {
+		log.Printf("Failed to get notification settings: %v", err)
+	}
+
+	emailPrefs, err := models.GetEmailPreferences(db, user.ID)
+	if err != nil {
+		log.Printf("Failed to get email preferences: %v", err)
+		emailPrefs = &models.EmailPreferences{Digest: models.DigestOff}
+	}
+
+	blocked, err := models.GetBlockedUsers(db, user.ID)
+	if err != nil {
+		log.Printf("Failed to get blocked users: %v", err)
+	}
+
+	renderTemplate(w, "settings.html", map[string]interface{}{
+		"User":           user,
+		"Errors":         errors,
+		"Success":        success,
+		"PendingEmail":   pendingEmail,
+		"Events":         events,
+		"Bio":            bio,
+		"HiddenFields":   hiddenFields,
+		"ProfileFields":  models.ProfileFields,
+		"AvatarVersion":  avatarVersion,
+		"NotifyMentions": notifyMentions,
+		"EmailPrefs":     emailPrefs,
+		"BlockedUsers":   blocked,
+	})
+}
+
+// revokeOtherSessions signs the user out everywhere except the current browser
+func revokeOtherSessions(r *http.Request, userID int64, ip string) {
+	db := getDB(r)
+
+	var keep string
+	if cookie, err := r.Cookie("session_id"); err == nil {
+		keep = cookie.Value
+	}
+
+	revoked, err := utils.DeleteOtherSessions(db, userID, keep)
+	if err != nil {
+		log.Printf("Failed to revoke sessions: %v", err)
+		return
+	}
+	if revoked > 0 {
+		if err := models.RecordAccountEvent(db, userID, models.AuditSessionsRevoked, "", ip); err != nil {
+			log.Printf("Failed to record account event: %v", err)
+		}
+	}
+}
+
+// sendEmailVerification emails the link that confirms a new email address to that address.
+// The link uses BaseURL, since the Host header is whatever the requester sent.
+func sendEmailVerification(r *http.Request, user *models.User, email, token string) {
+	data := map[string]interface{}{
+		"Email": email,
+		"Link":  BaseURL + "/account/verify-email?token=" + url.QueryEscape(token),
+	}
+	err := queueEmailTo(getDB(r), user, email, models.EmailListAll, "Confirm your new email address", "verify_email", data)
+	if err != nil {
+		log.Printf("Failed to queue verification email for %s: %v", user.Username, err)
+	}
+}
+
+// TrustProxy makes the audit trail record the address a reverse proxy puts in X-Forwarded-For,
+// instead of the proxy's own. Only set it, with TRUST_PROXY, when the forum is reachable through
+// the proxy alone, since anyone can send the header.
+var TrustProxy bool
+
+// Helper to get the client IP address for the audit trail
+func clientIP(r *http.Request) string {
+	// The proxy appends the address it saw, so earlier entries are the client's own claims
+	if forwarded := r.Header.Get("X-Forwarded-For"); TrustProxy && forwarded != "" {
+		addresses := strings.Split(forwarded, ",")
+		return strings.TrimSpace(addresses[len(addresses)-1])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	req := httptest.NewRequest("GET", "/account/settings", nil)
+	req.RemoteAddr = "10.0.0.2:51234"
+	req.Header.Set("X-Forwarded-For", "1.2.3.4, 203.0.113.7")
+
+	// Clients cannot choose the address recorded for them
+	if ip := clientIP(req); ip != "10.0.0.2" {
+		t.Errorf("Expected the connection's address, got %q", ip)
+	}
+
+	// Behind a proxy, the address it added is used
+	defer func() { TrustProxy = false }()
+	TrustProxy = true
+	if ip := clientIP(req); ip != "203.0.113.7" {
+		t.Errorf("Expected the address the proxy saw, got %q", ip)
+	}
+}
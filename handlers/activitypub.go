@@ -0,0 +1,1275 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"forum/activitypub"
+	"forum/models"
+	"forum/utils"
+)
+
+// FederationClient fetches documents from other servers and sends them activities. It only
+// connects to public addresses.
+var FederationClient = activitypub.NewClient(10 * time.Second)
+
+// FederationWorkerInterval is how often pending activities are sent
+const FederationWorkerInterval = 10 * time.Second
+
+// ActivityPubPrefix is where actors, their collections, objects and the inbox are served
+const ActivityPubPrefix = "/ap/"
+
+// WebFingerPath is where other servers look up the actors of handles
+const WebFingerPath = "/.well-known/webfinger"
+
+// federationBatchSize is the largest number of activities sent in one pass
+const federationBatchSize = 50
+
+// federationRetention is how long sent and given up activities are kept
+const federationRetention = 7 * 24 * time.Hour
+
+// signatureTolerance is how far the Date of a signed request may be from now
+const signatureTolerance = 12 * time.Hour
+
+// actorRefresh is how long a fetched actor is used before it is fetched again
+const actorRefresh = 24 * time.Hour
+
+// outboxSize is the number of posts listed in a category's outbox
+const outboxSize = 20
+
+// maxRemoteTitle is the length of the titles made for remote posts that have none
+const maxRemoteTitle = 80
+
+// Limits on other servers: activities each server can send a minute, and posts and comments
+// each remote author and each server can add an hour. Activities over a limit are answered
+// 429 so the sender retries them later.
+var (
+	inboxHostLimiter   = utils.NewRateLimiter(300, time.Minute)
+	remoteActorLimiter = utils.NewRateLimiter(30, time.Hour)
+	remoteHostLimiter  = utils.NewRateLimiter(300, time.Hour)
+)
+
+// errRemoteLimit is returned for posts and comments over the limits of their author or server
+var errRemoteLimit = errors.New("too many posts and comments from this server or author")
+
+func categoryActorURI(categoryID int64) string {
+	return fmt.Sprintf("%s%scategory/%d", BaseURL, ActivityPubPrefix, categoryID)
+}
+
+func userActorURI(userID int64) string {
+	return fmt.Sprintf("%s%suser/%d", BaseURL, ActivityPubPrefix, userID)
+}
+
+func postObjectURI(postID int64) string {
+	return fmt.Sprintf("%s%spost/%d", BaseURL, ActivityPubPrefix, postID)
+}
+
+func commentObjectURI(commentID int64) string {
+	return fmt.Sprintf("%s%scomment/%d", BaseURL, ActivityPubPrefix, commentID)
+}
+
+// categoryFollowURI is the ID of a category's Follow of a remote group
+func categoryFollowURI(categoryID, actorID int64) string {
+	return fmt.Sprintf("%s/follow/%d", categoryActorURI(categoryID), actorID)
+}
+
+func inboxURI() string {
+	return BaseURL + ActivityPubPrefix + "inbox"
+}
+
+// siteHost is the host of handles on this site
+func siteHost() string {
+	u, err := url.Parse(BaseURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// parseLocalURI splits the ID of a local actor or object into its kind and number, and
+// what follows them. ok is false for IDs of other servers.
+func parseLocalURI(uri string) (kind string, id int64, rest string, ok bool) {
+	path := strings.TrimPrefix(uri, BaseURL+ActivityPubPrefix)
+	if path == uri {
+		return "", 0, "", false
+	}
+	parts := strings.SplitN(path, "/", 3)
+	if len(parts) < 2 {
+		return "", 0, "", false
+	}
+	id, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || id <= 0 {
+		return "", 0, "", false
+	}
+	if len(parts) == 3 {
+		rest = parts[2]
+	}
+	return parts[0], id, rest, true
+}
+
+// localCategory returns the category of a local group ID
+func localCategory(uri string) (int64, bool) {
+	kind, id, rest, ok := parseLocalURI(uri)
+	return id, ok && kind == "category" && rest == ""
+}
+
+// writeActivity answers with an ActivityPub document
+func writeActivity(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", activitypub.ContentType+"; charset=utf-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// wantsActivity reports whether a request asks for an ActivityPub document rather than a page
+func wantsActivity(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, activitypub.ContentType) || strings.Contains(accept, "application/ld+json")
+}
+
+// WebFingerHandler finds the group of a category, or the actor of a user, by handle
+func WebFingerHandler(w http.ResponseWriter, r *http.Request) {
+	db := getDB(r)
+	name, host, err := activitypub.ParseHandle(r.URL.Query().Get("resource"))
+	if err != nil {
+		http.Error(w, "Invalid resource", http.StatusBadRequest)
+		return
+	}
+	if !strings.EqualFold(host, siteHost()) {
+		http.NotFound(w, r)
+		return
+	}
+
+	var actor, page string
+	categories, err := models.GetAllCategories(db)
+	if err != nil {
+		http.Error(w, "Failed to get categories", http.StatusInternalServerError)
+		return
+	}
+	for _, c := range categories {
+		if models.CategorySlug(c.Name) == strings.ToLower(name) {
+			actor, page = categoryActorURI(c.ID), fmt.Sprintf("%s/posts/category/%d", BaseURL, c.ID)
+			break
+		}
+	}
+	if actor == "" {
+		user, err := localActorUser(db, name)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		actor, page = userActorURI(user.UserID), BaseURL+userURL(user.Username)
+	}
+
+	w.Header().Set("Content-Type", "application/jrd+json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(activitypub.WebFinger{
+		Subject: "acct:" + name + "@" + host,
+		Aliases: []string{actor, page},
+		Links: []activitypub.WebFingerLink{
+			{Rel: "self", Type: activitypub.ContentType, Href: actor},
+			{Rel: "http://webfinger.net/rel/profile-page", Type: "text/html", Href: page},
+		},
+	})
+}
+
+// localActorUser finds a local user that other servers can see as an actor. Accounts of
+// remote actors and the placeholder of deleted accounts are not.
+func localActorUser(db *sql.DB, username string) (*models.Profile, error) {
+	if username == models.DeletedUsername {
+		return nil, errors.New("user not found")
+	}
+	profile, err := models.GetProfileByUsername(db, username)
+	if err != nil {
+		return nil, err
+	}
+	if remote, err := models.IsRemoteUser(db, profile.UserID); err != nil || remote {
+		return nil, errors.New("user not found")
+	}
+	return profile, nil
+}
+
+// ActivityPubHandler serves the groups of categories, the actors of users, posts and
+// comments as ActivityPub documents, and receives activities in the shared inbox.
+// Browsers asking for an actor or object are sent to its page.
+func ActivityPubHandler(w http.ResponseWriter, r *http.Request) {
+	db := getDB(r)
+	path := strings.TrimPrefix(r.URL.Path, ActivityPubPrefix)
+	if path == "inbox" {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		InboxHandler(w, r)
+		return
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	kind, id, rest, ok := parseLocalURI(BaseURL + r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	var doc *activitypub.Object
+	var page string
+	var err error
+	switch {
+	case kind == "category" && rest == "":
+		doc, err = categoryActor(db, id)
+		page = fmt.Sprintf("/posts/category/%d", id)
+	case kind == "category" && rest == "outbox":
+		doc, err = categoryOutbox(db, id)
+	case kind == "category" && rest == "followers":
+		doc, err = categoryFollowers(db, id)
+	case kind == "user" && rest == "":
+		var user *models.User
+		user, err = models.GetUserByID(db, id)
+		if err == nil {
+			doc, err = userActor(db, user)
+			page = userURL(user.Username)
+		}
+	case kind == "post" && rest == "":
+		doc, err = localPostObject(db, id)
+		page = fmt.Sprintf("/post/%d", id)
+	case kind == "comment" && rest == "":
+		var postID int64
+		postID, err = models.GetCommentPostID(db, id)
+		if err == nil {
+			doc, err = localCommentObject(db, postID, id)
+			page = fmt.Sprintf("/post/%d#comment-%d", postID, id)
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		if err == sql.ErrNoRows || strings.HasSuffix(err.Error(), "not found") {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, fmt.Sprintf("Failed to get %s: %v", kind, err), http.StatusInternalServerError)
+		return
+	}
+
+	if page != "" && !wantsActivity(r) {
+		http.Redirect(w, r, page, http.StatusSeeOther)
+		return
+	}
+	w.Header().Add("Vary", "Accept")
+	doc.Context = activitypub.Context
+	writeActivity(w, http.StatusOK, doc)
+}
+
+// categoryActor is the Group a category federates as
+func categoryActor(db *sql.DB, categoryID int64) (*activitypub.Object, error) {
+	category, err := models.GetCategoryByID(db, categoryID)
+	if err != nil {
+		return nil, err
+	}
+	key, err := models.GetFederationKey(db, models.FederationCategory, categoryID)
+	if err != nil {
+		return nil, err
+	}
+	uri := categoryActorURI(categoryID)
+	return &activitypub.Object{
+		ID:                uri,
+		Type:              "Group",
+		PreferredUsername: models.CategorySlug(category.Name),
+		Name:              category.Name,
+		Summary:           fmt.Sprintf("<p>Posts in the %s category</p>", category.Name),
+		URL:               activitypub.LinkTo(fmt.Sprintf("%s/posts/category/%d", BaseURL, categoryID)),
+		Inbox:             inboxURI(),
+		Outbox:            uri + "/outbox",
+		Followers:         uri + "/followers",
+		Endpoints:         &activitypub.Endpoints{SharedInbox: inboxURI()},
+		PublicKey:         &activitypub.PublicKey{ID: uri + "#main-key", Owner: uri, PublicKeyPem: key.PublicKey},
+	}, nil
+}
+
+// userActor is the Person a local user federates as
+func userActor(db *sql.DB, user *models.User) (*activitypub.Object, error) {
+	if _, err := localActorUser(db, user.Username); err != nil {
+		return nil, err
+	}
+	key, err := models.GetFederationKey(db, models.FederationUser, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	uri := userActorURI(user.ID)
+	return &activitypub.Object{
+		ID:                uri,
+		Type:              "Person",
+		PreferredUsername: user.Username,
+		Name:              user.Username,
+		URL:               activitypub.LinkTo(BaseURL + userURL(user.Username)),
+		Inbox:             inboxURI(),
+		Endpoints:         &activitypub.Endpoints{SharedInbox: inboxURI()},
+		PublicKey:         &activitypub.PublicKey{ID: uri + "#main-key", Owner: uri, PublicKeyPem: key.PublicKey},
+	}, nil
+}
+
+// categoryOutbox lists the latest local posts of a category as Create activities
+func categoryOutbox(db *sql.DB, categoryID int64) (*activitypub.Object, error) {
+	if _, err := models.GetCategoryByID(db, categoryID); err != nil {
+		return nil, err
+	}
+	posts, total, err := models.GetPostPage(db, 0, models.PostFilter{CategoryID: categoryID}, outboxSize, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	outbox := &activitypub.Object{ID: categoryActorURI(categoryID) + "/outbox", Type: "OrderedCollection", TotalItems: &total}
+	for i := range posts {
+		if _, err := models.GetFederatedObjectFor(db, "post", posts[i].ID); err == nil {
+			continue
+		}
+		post, err := models.GetPostByID(db, posts[i].ID, 0)
+		if err != nil {
+			return nil, err
+		}
+		object, err := postObject(db, post)
+		if err != nil {
+			return nil, err
+		}
+		outbox.OrderedItems = append(outbox.OrderedItems, *activitypub.Embed(createActivity(object)))
+	}
+	return outbox, nil
+}
+
+// categoryFollowers tells how many remote actors follow a category, without listing them
+func categoryFollowers(db *sql.DB, categoryID int64) (*activitypub.Object, error) {
+	if _, err := models.GetCategoryByID(db, categoryID); err != nil {
+		return nil, err
+	}
+	counts, err := models.CountCategoryFollowers(db)
+	if err != nil {
+		return nil, err
+	}
+	total := counts[categoryID]
+	return &activitypub.Object{ID: categoryActorURI(categoryID) + "/followers", Type: "OrderedCollection", TotalItems: &total}, nil
+}
+
+// postGroups returns the IDs of the groups a post is shared in: those of its categories,
+// after the remote group it came through, if any
+func postGroups(db *sql.DB, post *models.Post, origin *models.FederatedObject) []string {
+	var groups []string
+	if origin != nil && origin.GroupID != 0 {
+		if group, err := models.GetRemoteActorByID(db, origin.GroupID); err == nil {
+			groups = append(groups, group.URI)
+		}
+	}
+	for _, c := range post.Categories {
+		groups = append(groups, categoryActorURI(c.ID))
+	}
+	return groups
+}
+
+// localPostObject is a local post as an Article. Posts received from other servers are theirs to serve.
+func localPostObject(db *sql.DB, postID int64) (*activitypub.Object, error) {
+	if _, err := models.GetFederatedObjectFor(db, "post", postID); err == nil {
+		return nil, errors.New("post not found")
+	}
+	post, err := models.GetPostByID(db, postID, 0)
+	if err != nil {
+		return nil, err
+	}
+	return postObject(db, post)
+}
+
+func postObject(db *sql.DB, post *models.Post) (*activitypub.Object, error) {
+	groups := postGroups(db, post, nil)
+	published := post.CreatedAt.UTC()
+	object := &activitypub.Object{
+		ID:           postObjectURI(post.ID),
+		Type:         "Article",
+		Name:         post.Title,
+		Content:      absoluteLinks(BaseURL, string(renderMarkdown(db, models.RenderedPost, post.ID, post.Content))),
+		MediaType:    "text/html",
+		Source:       &activitypub.Source{Content: post.Content, MediaType: "text/markdown"},
+		URL:          activitypub.LinkTo(fmt.Sprintf("%s/post/%d", BaseURL, post.ID)),
+		AttributedTo: activitypub.LinkTo(userActorURI(post.UserID)),
+		To:           activitypub.Strings{activitypub.Public},
+		CC:           groups,
+		Published:    &published,
+	}
+	if len(groups) > 0 {
+		object.Audience = activitypub.LinkTo(groups[0])
+	}
+	return object, nil
+}
+
+// localCommentObject is a local comment as a Note
+func localCommentObject(db *sql.DB, postID, commentID int64) (*activitypub.Object, error) {
+	if _, err := models.GetFederatedObjectFor(db, "comment", commentID); err == nil {
+		return nil, errors.New("comment not found")
+	}
+	post, err := models.GetPostByID(db, postID, 0)
+	if err != nil {
+		return nil, err
+	}
+	comment, err := models.GetCommentByID(db, commentID, 0)
+	if err != nil {
+		return nil, err
+	}
+	return commentObject(db, post, comment), nil
+}
+
+func commentObject(db *sql.DB, post *models.Post, comment *models.Comment) *activitypub.Object {
+	inReplyTo := postObjectURI(post.ID)
+	origin, err := models.GetFederatedObjectFor(db, "post", post.ID)
+	if err == nil {
+		inReplyTo = origin.URI
+	} else {
+		origin = nil
+	}
+	groups := postGroups(db, post, origin)
+
+	published := comment.CreatedAt.UTC()
+	object := &activitypub.Object{
+		ID:           commentObjectURI(comment.ID),
+		Type:         "Note",
+		Content:      absoluteLinks(BaseURL, string(renderMarkdown(db, models.RenderedComment, comment.ID, comment.Content))),
+		MediaType:    "text/html",
+		Source:       &activitypub.Source{Content: comment.Content, MediaType: "text/markdown"},
+		URL:          activitypub.LinkTo(fmt.Sprintf("%s/post/%d#comment-%d", BaseURL, post.ID, comment.ID)),
+		AttributedTo: activitypub.LinkTo(userActorURI(comment.UserID)),
+		InReplyTo:    activitypub.LinkTo(inReplyTo),
+		To:           activitypub.Strings{activitypub.Public},
+		CC:           groups,
+		Published:    &published,
+	}
+	if len(groups) > 0 {
+		object.Audience = activitypub.LinkTo(groups[0])
+	}
+	return object
+}
+
+// createActivity wraps a local object in the Create of its author
+func createActivity(object *activitypub.Object) *activitypub.Object {
+	return &activitypub.Object{
+		ID:        object.ID + "/create",
+		Type:      "Create",
+		Actor:     object.AttributedTo,
+		Object:    activitypub.Embed(object),
+		To:        object.To,
+		CC:        object.CC,
+		Audience:  object.Audience,
+		Published: object.Published,
+	}
+}
+
+// announceActivity is a category's group sharing an activity, or an object of another server, with its followers
+func announceActivity(categoryID int64, name string, object *activitypub.Link) *activitypub.Object {
+	group := categoryActorURI(categoryID)
+	return &activitypub.Object{
+		ID:       group + "/announce/" + name,
+		Type:     "Announce",
+		Actor:    activitypub.LinkTo(group),
+		Object:   object,
+		To:       activitypub.Strings{activitypub.Public},
+		CC:       activitypub.Strings{group + "/followers"},
+		Audience: activitypub.LinkTo(group),
+	}
+}
+
+// queueActivity queues an activity for the given inboxes, signed by a category or user.
+// Failures are logged since what the activity is about already happened.
+func queueActivity(db *sql.DB, senderType string, senderID int64, inboxes []string, activity *activitypub.Object) {
+	if len(inboxes) == 0 {
+		return
+	}
+	activity.Context = activitypub.Context
+	body, err := json.Marshal(activity)
+	if err == nil {
+		err = models.QueueFederationDelivery(db, senderType, senderID, inboxes, body)
+	}
+	if err != nil {
+		log.Printf("Failed to queue %s activity %s: %v", activity.Type, activity.ID, err)
+	}
+}
+
+// federatePost shares a new post with the followers of its categories' groups, and sends
+// local posts to the remote groups the categories follow. Posts that came through a
+// remote group were shared by it already.
+func federatePost(db *sql.DB, postID int64) {
+	post, err := models.GetPostByID(db, postID, 0)
+	if err != nil {
+		log.Printf("Failed to get post %d to federate: %v", postID, err)
+		return
+	}
+	origin, err := models.GetFederatedObjectFor(db, "post", postID)
+	if err == nil && origin.GroupID != 0 {
+		return
+	}
+
+	var shared *activitypub.Link
+	var remoteGroups []models.RemoteActor
+	if origin != nil {
+		shared = activitypub.LinkTo(origin.URI)
+	} else {
+		object, err := postObject(db, post)
+		if err != nil {
+			log.Printf("Failed to federate post %d: %v", postID, err)
+			return
+		}
+		shared = activitypub.Embed(createActivity(object))
+		remoteGroups = categoryGroups(db, post.Categories)
+	}
+
+	for _, c := range post.Categories {
+		inboxes, err := models.GetCategoryFollowerInboxes(db, c.ID)
+		if err != nil {
+			log.Printf("Failed to get followers of category %d: %v", c.ID, err)
+			continue
+		}
+		queueActivity(db, models.FederationCategory, c.ID, inboxes, announceActivity(c.ID, fmt.Sprintf("post/%d", postID), shared))
+	}
+	sendToGroups(db, post.UserID, shared.Object, remoteGroups)
+}
+
+// federateComment shares a new comment. Comments on posts of the categories' own groups are
+// shared with the followers of the groups; comments on posts that came through a remote
+// group are sent to that group, which shares them in turn. Local comments on local posts
+// also go to the remote groups the categories follow.
+func federateComment(db *sql.DB, postID, commentID int64) {
+	origin, err := models.GetFederatedObjectFor(db, "comment", commentID)
+	if err == nil && origin.GroupID != 0 {
+		return
+	}
+	post, err := models.GetPostByID(db, postID, 0)
+	if err != nil {
+		log.Printf("Failed to get post %d to federate comment %d: %v", postID, commentID, err)
+		return
+	}
+	postOrigin, err := models.GetFederatedObjectFor(db, "post", postID)
+	if err != nil {
+		postOrigin = nil
+	}
+
+	var shared *activitypub.Link
+	var authorID int64
+	if origin != nil {
+		shared = activitypub.LinkTo(origin.URI)
+	} else {
+		comment, err := models.GetCommentByID(db, commentID, 0)
+		if err != nil {
+			log.Printf("Failed to get comment %d to federate: %v", commentID, err)
+			return
+		}
+		shared = activitypub.Embed(createActivity(commentObject(db, post, comment)))
+		authorID = comment.UserID
+	}
+
+	if postOrigin != nil && postOrigin.GroupID != 0 {
+		if origin == nil {
+			queueActivity(db, models.FederationUser, authorID, []string{postOrigin.ReplyInbox}, shared.Object)
+		}
+		return
+	}
+
+	for _, c := range post.Categories {
+		inboxes, err := models.GetCategoryFollowerInboxes(db, c.ID)
+		if err != nil {
+			log.Printf("Failed to get followers of category %d: %v", c.ID, err)
+			continue
+		}
+		// Authors of posts sent to the group hear about replies even if they do not follow it
+		if postOrigin != nil {
+			inboxes = append(inboxes, postOrigin.ReplyInbox)
+		}
+		queueActivity(db, models.FederationCategory, c.ID, inboxes, announceActivity(c.ID, fmt.Sprintf("comment/%d", commentID), shared))
+	}
+	if postOrigin == nil && origin == nil {
+		sendToGroups(db, authorID, shared.Object, categoryGroups(db, post.Categories))
+	}
+}
+
+// categoryGroups returns the remote groups that any of the categories follows, each once
+func categoryGroups(db *sql.DB, categories []models.Category) []models.RemoteActor {
+	var groups []models.RemoteActor
+	seen := map[int64]bool{}
+	for _, c := range categories {
+		followed, err := models.GetCategoryGroups(db, c.ID)
+		if err != nil {
+			log.Printf("Failed to get groups of category %d: %v", c.ID, err)
+			continue
+		}
+		for _, g := range followed {
+			if !seen[g.ID] {
+				seen[g.ID] = true
+				groups = append(groups, g)
+			}
+		}
+	}
+	return groups
+}
+
+// sendToGroups sends a local user's Create to remote groups, addressing them so they add it
+func sendToGroups(db *sql.DB, userID int64, create *activitypub.Object, groups []models.RemoteActor) {
+	if len(groups) == 0 {
+		return
+	}
+	activity := *create
+	object := *create.Object.Object
+	var inboxes []string
+	for _, g := range groups {
+		object.CC = append(append(activitypub.Strings{}, object.CC...), g.URI)
+		inboxes = append(inboxes, g.Inbox)
+	}
+	activity.CC, activity.Object = object.CC, activitypub.Embed(&object)
+	queueActivity(db, models.FederationUser, userID, inboxes, &activity)
+}
+
+// InboxHandler receives activities from other servers. Each must be signed by the actor that
+// sent it. Activities the forum does not act on are accepted and dropped.
+func InboxHandler(w http.ResponseWriter, r *http.Request) {
+	db := getDB(r)
+	body, err := io.ReadAll(io.LimitReader(r.Body, activitypub.MaxBody+1))
+	if err != nil {
+		http.Error(w, "Failed to read body", http.StatusBadRequest)
+		return
+	}
+	if len(body) > activitypub.MaxBody {
+		http.Error(w, "Activity too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	var activity activitypub.Object
+	if err := json.Unmarshal(body, &activity); err != nil || activity.Type == "" {
+		http.Error(w, "Invalid activity", http.StatusBadRequest)
+		return
+	}
+	signature, err := activitypub.ParseSignature(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	actor, err := verifySigner(db, r, signature, body)
+	if err != nil {
+		// Servers announce deleted accounts to everyone, and their keys are gone with them
+		if activity.Type == "Delete" && errors.Is(err, activitypub.ErrGone) {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		log.Printf("Refused activity %s from %s: %v", activity.ID, signature.KeyID, err)
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+	if activitypub.IDOf(activity.Actor) != actor.URI {
+		http.Error(w, "Actor does not match the signature", http.StatusUnauthorized)
+		return
+	}
+	if !inboxHostLimiter.Allow(actor.Host) {
+		tooManyActivities(w)
+		return
+	}
+
+	if err := receiveActivity(db, actor, &activity); err != nil {
+		if errors.Is(err, errRemoteLimit) {
+			tooManyActivities(w)
+			return
+		}
+		log.Printf("Failed to handle %s %s from %s: %v", activity.Type, activity.ID, actor.URI, err)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// tooManyActivities asks the sending server to retry later
+func tooManyActivities(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", "600")
+	http.Error(w, "Too many activities, retry later", http.StatusTooManyRequests)
+}
+
+// verifySigner checks a request's signature and returns the actor that made it. A stored
+// key that does not match is fetched again in case the actor changed it.
+func verifySigner(db *sql.DB, r *http.Request, signature *activitypub.Signature, body []byte) (*models.RemoteActor, error) {
+	actor, err := models.GetRemoteActorByKey(db, signature.KeyID)
+	fresh := err == nil && time.Since(actor.FetchedAt) < actorRefresh
+	for {
+		if !fresh {
+			// The signature is not checked yet, so this fetch is on behalf of anyone
+			if err := activitypub.CheckURL(signature.KeyID); err != nil {
+				return nil, err
+			}
+			actorURI, _, _ := strings.Cut(signature.KeyID, "#")
+			actor, err = fetchActor(db, actorURI, true)
+			if err != nil {
+				return nil, err
+			}
+			if actor.KeyID != signature.KeyID {
+				return nil, errors.New("unknown key")
+			}
+		}
+		public, err := activitypub.ParsePublicKey(actor.PublicKey)
+		if err == nil {
+			err = signature.Verify(r, body, public, signatureTolerance, time.Now())
+		}
+		if err == nil || !fresh {
+			return actor, err
+		}
+		fresh = false
+	}
+}
+
+// fetchActor returns a remote actor, fetching it when it is not stored, the stored copy is
+// old, or refresh is set
+func fetchActor(db *sql.DB, uri string, refresh bool) (*models.RemoteActor, error) {
+	if !refresh {
+		actor, err := models.GetRemoteActor(db, uri)
+		if err == nil && time.Since(actor.FetchedAt) < actorRefresh {
+			return actor, nil
+		}
+	}
+	if _, _, _, local := parseLocalURI(uri); local {
+		return nil, errors.New("local actor")
+	}
+
+	doc, err := activitypub.GetObject(FederationClient, uri)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(doc.ID)
+	if err != nil || doc.Inbox == "" || doc.PreferredUsername == "" {
+		return nil, errors.New("not an actor")
+	}
+	actor := &models.RemoteActor{
+		URI:      doc.ID,
+		Type:     doc.Type,
+		Username: doc.PreferredUsername,
+		Host:     u.Host,
+		Name:     doc.Name,
+		Inbox:    doc.Inbox,
+	}
+	if doc.Endpoints != nil {
+		actor.SharedInbox = doc.Endpoints.SharedInbox
+	}
+	if doc.PublicKey != nil && doc.PublicKey.Owner == doc.ID {
+		actor.KeyID, actor.PublicKey = doc.PublicKey.ID, doc.PublicKey.PublicKeyPem
+	}
+	id, err := models.SaveRemoteActor(db, actor)
+	if err != nil {
+		return nil, err
+	}
+	return models.GetRemoteActorByID(db, id)
+}
+
+// resolveObject returns the object a link points to. Embedded objects are only trusted from
+// the server of the actor that sent them; others are fetched from their own server. Objects
+// of this site are nil.
+func resolveObject(link *activitypub.Link, actorURI string) (*activitypub.Object, error) {
+	if link == nil || link.ID == "" {
+		return nil, errors.New("missing object")
+	}
+	if _, _, _, local := parseLocalURI(link.ID); local {
+		return nil, nil
+	}
+	if link.Object != nil && activitypub.SameHost(link.ID, actorURI) {
+		return link.Object, nil
+	}
+	return activitypub.GetObject(FederationClient, link.ID)
+}
+
+// receiveActivity acts on an activity from a verified actor
+func receiveActivity(db *sql.DB, actor *models.RemoteActor, activity *activitypub.Object) error {
+	switch activity.Type {
+	case "Follow":
+		return receiveFollow(db, actor, activity)
+	case "Undo":
+		return receiveUndo(db, actor, activity)
+	case "Accept", "Reject":
+		return receiveFollowAnswer(db, actor, activity)
+	case "Create":
+		return receiveCreate(db, actor, activity)
+	case "Announce":
+		return receiveAnnounce(db, actor, activity)
+	case "Delete":
+		return receiveDelete(db, actor, activity)
+	}
+	return nil
+}
+
+// receiveFollow adds a follower to a category's group and accepts it
+func receiveFollow(db *sql.DB, actor *models.RemoteActor, follow *activitypub.Object) error {
+	categoryID, ok := localCategory(activitypub.IDOf(follow.Object))
+	if !ok {
+		return nil
+	}
+	if _, err := models.GetCategoryByID(db, categoryID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+	if err := models.AddCategoryFollower(db, categoryID, actor.ID, follow.ID); err != nil {
+		return err
+	}
+
+	group := categoryActorURI(categoryID)
+	queueActivity(db, models.FederationCategory, categoryID, []string{actor.Inbox}, &activitypub.Object{
+		ID:    fmt.Sprintf("%s/accept/%d/%d", group, actor.ID, time.Now().UnixNano()),
+		Type:  "Accept",
+		Actor: activitypub.LinkTo(group),
+		Object: activitypub.Embed(&activitypub.Object{
+			ID:     follow.ID,
+			Type:   "Follow",
+			Actor:  activitypub.LinkTo(actor.URI),
+			Object: activitypub.LinkTo(group),
+		}),
+	})
+	return nil
+}
+
+// receiveUndo removes a follower. Other activities undone, such as likes, are ignored.
+func receiveUndo(db *sql.DB, actor *models.RemoteActor, undo *activitypub.Object) error {
+	inner := undo.Object
+	if inner == nil {
+		return nil
+	}
+	if inner.Object == nil {
+		return models.RemoveCategoryFollow(db, actor.ID, inner.ID)
+	}
+	if inner.Object.Type != "Follow" || activitypub.IDOf(inner.Object.Actor) != actor.URI {
+		return nil
+	}
+	if categoryID, ok := localCategory(activitypub.IDOf(inner.Object.Object)); ok {
+		return models.RemoveCategoryFollower(db, categoryID, actor.ID)
+	}
+	return nil
+}
+
+// receiveFollowAnswer records whether a remote group accepted a category's follow
+func receiveFollowAnswer(db *sql.DB, actor *models.RemoteActor, answer *activitypub.Object) error {
+	kind, categoryID, rest, ok := parseLocalURI(activitypub.IDOf(answer.Object))
+	if !ok || kind != "category" || rest != fmt.Sprintf("follow/%d", actor.ID) {
+		return nil
+	}
+	var err error
+	if answer.Type == "Accept" {
+		err = models.AcceptCategorySubscription(db, categoryID, actor.ID)
+	} else {
+		err = models.RemoveCategorySubscription(db, categoryID, actor.ID)
+	}
+	if err != nil && err.Error() == "subscription not found" {
+		return nil
+	}
+	return err
+}
+
+// receiveCreate adds a reply to a local post or comment as a comment. Anything else is dropped:
+// posts only come from the remote groups that admins made categories follow, so nobody else
+// can post to the forum without an account.
+func receiveCreate(db *sql.DB, actor *models.RemoteActor, create *activitypub.Object) error {
+	object, err := resolveObject(create.Object, actor.URI)
+	if err != nil || object == nil {
+		return err
+	}
+	if activitypub.IDOf(object.AttributedTo) != actor.URI {
+		return errors.New("object not by the actor")
+	}
+	if activitypub.IDOf(object.InReplyTo) == "" {
+		return nil
+	}
+	return receiveObject(db, object, nil, nil)
+}
+
+// receiveAnnounce adds what a remote group followed by categories shares with its followers
+func receiveAnnounce(db *sql.DB, group *models.RemoteActor, announce *activitypub.Object) error {
+	categoryIDs, err := models.GetSubscribedCategories(db, group.ID)
+	if err != nil || len(categoryIDs) == 0 {
+		return err
+	}
+
+	inner, err := resolveObject(announce.Object, group.URI)
+	if err != nil || inner == nil {
+		return err
+	}
+	switch inner.Type {
+	case "Create":
+		object, err := resolveObject(inner.Object, group.URI)
+		if err != nil || object == nil {
+			return err
+		}
+		return receiveObject(db, object, group, categoryIDs)
+	case "Delete":
+		return receiveDelete(db, group, inner)
+	}
+	return receiveObject(db, inner, group, categoryIDs)
+}
+
+// receiveObject adds a public post or comment from another server. Comments must reply to
+// a post or comment the forum has; posts go to the given categories.
+func receiveObject(db *sql.DB, object *activitypub.Object, group *models.RemoteActor, categoryIDs []int64) error {
+	if object.Type != "Note" && object.Type != "Article" && object.Type != "Page" {
+		return nil
+	}
+	if !object.IsPublic() {
+		return nil
+	}
+	if _, err := models.GetFederatedObject(db, object.ID); err == nil {
+		return nil
+	}
+
+	var postID int64
+	if replyTo := activitypub.IDOf(object.InReplyTo); replyTo != "" {
+		var err error
+		if postID, err = repliedPost(db, replyTo); err != nil {
+			return nil
+		}
+	} else if len(categoryIDs) == 0 {
+		return nil
+	}
+
+	authorURI := activitypub.IDOf(object.AttributedTo)
+	if !allowRemoteObject(authorURI, group) {
+		return errRemoteLimit
+	}
+	author, err := fetchActor(db, authorURI, false)
+	if err != nil {
+		return err
+	}
+	userID, err := models.GetRemoteActorUser(db, author)
+	if err != nil {
+		return err
+	}
+
+	origin := &models.FederatedObject{URI: object.ID, ActorID: author.ID, ReplyInbox: author.DeliveryInbox()}
+	if group != nil {
+		origin.GroupID, origin.ReplyInbox = group.ID, group.Inbox
+	}
+	content := remoteContent(object)
+
+	if postID != 0 {
+		commentID, err := models.CreateFederatedComment(db, origin, content, userID, postID)
+		if err != nil {
+			return ignoreReceived(err)
+		}
+		notifyNewComment(db, postID, commentID, userID, content)
+		return nil
+	}
+
+	title := object.Name
+	if title == "" {
+		title = remoteTitle(content)
+	}
+	postID, err = models.CreateFederatedPost(db, origin, title, content, userID, categoryIDs)
+	if err != nil {
+		return ignoreReceived(err)
+	}
+	notifyNewPost(db, postID, userID, content)
+	return nil
+}
+
+// allowRemoteObject counts a new post or comment against the limits of its author, the author's
+// server, and the server of the group it came through
+func allowRemoteObject(authorURI string, group *models.RemoteActor) bool {
+	u, err := url.Parse(authorURI)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if !remoteActorLimiter.Allow(authorURI) || !remoteHostLimiter.Allow(u.Host) {
+		return false
+	}
+	return group == nil || group.Host == u.Host || remoteHostLimiter.Allow(group.Host)
+}
+
+// ignoreReceived drops the error of an object that arrived twice at the same time
+func ignoreReceived(err error) error {
+	if err.Error() == "object already received" {
+		return nil
+	}
+	return err
+}
+
+// repliedPost returns the post that a reply to a local or received post or comment belongs to
+func repliedPost(db *sql.DB, uri string) (int64, error) {
+	if kind, id, rest, ok := parseLocalURI(uri); ok && rest == "" {
+		switch kind {
+		case "post":
+			_, err := models.GetPostByID(db, id, 0)
+			return id, err
+		case "comment":
+			return models.GetCommentPostID(db, id)
+		}
+	}
+	object, err := models.GetFederatedObject(db, uri)
+	if err != nil {
+		return 0, err
+	}
+	if object.TargetType == "comment" {
+		return models.GetCommentPostID(db, object.TargetID)
+	}
+	return object.TargetID, nil
+}
+
+// remoteContent is the Markdown of a remote object, its source if it has one in Markdown
+func remoteContent(object *activitypub.Object) string {
+	content := activitypub.PlainText(object.Content)
+	if object.Source != nil && object.Source.MediaType == "text/markdown" {
+		content = object.Source.Content
+	}
+	if strings.TrimSpace(content) == "" {
+		content = activitypub.IDOf(object.URL)
+		if content == "" {
+			content = object.ID
+		}
+	}
+	return content
+}
+
+// remoteTitle makes a title from the first line of a post that has none
+func remoteTitle(content string) string {
+	title, _, _ := strings.Cut(strings.TrimSpace(content), "\n")
+	if utf8.RuneCountInString(title) > maxRemoteTitle {
+		title = string([]rune(title)[:maxRemoteTitle-1]) + "…"
+	}
+	return title
+}
+
+// receiveDelete removes a received post or comment when its author, its server or the
+// group it came through deletes it, and forgets actors that deleted their account
+func receiveDelete(db *sql.DB, actor *models.RemoteActor, del *activitypub.Object) error {
+	uri := activitypub.IDOf(del.Object)
+	if uri == actor.URI {
+		return models.ForgetRemoteActor(db, actor.ID)
+	}
+	object, err := models.GetFederatedObject(db, uri)
+	if err != nil {
+		return nil
+	}
+	if !activitypub.SameHost(uri, actor.URI) && object.GroupID != actor.ID {
+		return errors.New("not allowed to delete " + uri)
+	}
+	return models.DeleteFederatedObject(db, object)
+}
+
+// DeliverFederation sends the activities that are due, scheduling retries for the failed ones
+func DeliverFederation(db *sql.DB) {
+	deliveries, err := models.GetDueFederationDeliveries(db, time.Now(), federationBatchSize)
+	if err != nil {
+		log.Printf("Failed to get federation deliveries: %v", err)
+		return
+	}
+
+	keys := map[string]*activitypub.Key{}
+	for i := range deliveries {
+		d := &deliveries[i]
+		key, err := senderKey(db, keys, d.SenderType, d.SenderID)
+		if err == nil {
+			err = activitypub.Post(FederationClient, d.Inbox, key, []byte(d.Activity), time.Now())
+		}
+
+		if err != nil {
+			gaveUp, markErr := models.MarkFederationFailed(db, d, err, time.Now())
+			if markErr != nil {
+				log.Printf("Failed to update federation delivery %d: %v", d.ID, markErr)
+			} else if gaveUp {
+				log.Printf("Giving up on federation delivery %d to %s: %v", d.ID, d.Inbox, err)
+			} else {
+				log.Printf("Failed to deliver activity %d to %s, will retry: %v", d.ID, d.Inbox, err)
+			}
+			continue
+		}
+
+		if err := models.MarkFederationDelivered(db, d.ID, time.Now()); err != nil {
+			log.Printf("Failed to update federation delivery %d: %v", d.ID, err)
+		}
+	}
+}
+
+// senderKey returns the signing key of a category or user, remembering the ones already loaded
+func senderKey(db *sql.DB, keys map[string]*activitypub.Key, senderType string, senderID int64) (*activitypub.Key, error) {
+	name := fmt.Sprintf("%s/%d", senderType, senderID)
+	if key, ok := keys[name]; ok {
+		return key, nil
+	}
+	stored, err := models.GetFederationKey(db, senderType, senderID)
+	if err != nil {
+		return nil, err
+	}
+	private, err := activitypub.ParsePrivateKey(stored.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	actor := userActorURI(senderID)
+	if senderType == models.FederationCategory {
+		actor = categoryActorURI(senderID)
+	}
+	keys[name] = &activitypub.Key{ID: actor + "#main-key", Private: private}
+	return keys[name], nil
+}
+
+// CleanFederationDeliveries removes old sent and given up activities
+func CleanFederationDeliveries(db *sql.DB) {
+	if _, err := models.CleanFederationDeliveries(db, time.Now().Add(-federationRetention)); err != nil {
+		log.Printf("Failed to clean federation deliveries: %v", err)
+	}
+}
+
+// FederationHandler lists the remote groups categories follow and makes a category follow
+// another. Admins only.
+func FederationHandler(w http.ResponseWriter, r *http.Request) {
+	db := getDB(r)
+	user := getUserFromContext(r)
+	if !user.IsAdmin() {
+		RenderErrorPage(w, http.StatusNotFound)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Title":              "Federation",
+		"User":               user,
+		"SelectedCategoryID": int64(0),
+	}
+
+	if r.Method == "POST" {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Failed to parse form", http.StatusBadRequest)
+			return
+		}
+		categoryID, _ := strconv.ParseInt(r.FormValue("category_id"), 10, 64)
+		err := followGroup(db, categoryID, strings.TrimSpace(r.FormValue("group")))
+		if err == nil {
+			http.Redirect(w, r, "/admin/federation", http.StatusSeeOther)
+			return
+		}
+		switch err.Error() {
+		case "category not found", "remote group not found", "not a group", "already following":
+			data["ErrorMsg"] = err.Error()
+			data["Group"] = r.FormValue("group")
+			data["SelectedCategoryID"] = categoryID
+			w.WriteHeader(http.StatusBadRequest)
+		default:
+			http.Error(w, fmt.Sprintf("Failed to follow group: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	categories, err := models.GetAllCategories(db)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get categories: %v", err), http.StatusInternalServerError)
+		return
+	}
+	followers, err := models.CountCategoryFollowers(db)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to count followers: %v", err), http.StatusInternalServerError)
+		return
+	}
+	subscriptions, err := models.GetCategorySubscriptions(db)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get subscriptions: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	type federatedCategory struct {
+		models.Category
+		Handle    string
+		Followers int
+	}
+	var list []federatedCategory
+	for _, c := range categories {
+		list = append(list, federatedCategory{Category: c, Handle: models.CategorySlug(c.Name) + "@" + siteHost(), Followers: followers[c.ID]})
+	}
+	data["Categories"] = list
+	data["Subscriptions"] = subscriptions
+
+	renderTemplate(w, "federation.html", data)
+}
+
+// followGroup makes a category follow a remote group, given by handle or by actor ID
+func followGroup(db *sql.DB, categoryID int64, group string) error {
+	if _, err := models.GetCategoryByID(db, categoryID); err != nil {
+		if err == sql.ErrNoRows {
+			return errors.New("category not found")
+		}
+		return err
+	}
+
+	uri := group
+	if !strings.HasPrefix(group, "https://") && !strings.HasPrefix(group, "http://") {
+		var err error
+		if uri, err = activitypub.Finger(FederationClient, group); err != nil {
+			log.Printf("Failed to look up %s: %v", group, err)
+			return errors.New("remote group not found")
+		}
+	}
+	actor, err := fetchActor(db, uri, true)
+	if err != nil {
+		log.Printf("Failed to fetch %s: %v", uri, err)
+		return errors.New("remote group not found")
+	}
+	if actor.Type != "Group" {
+		return errors.New("not a group")
+	}
+	if err := models.AddCategorySubscription(db, categoryID, actor.ID); err != nil {
+		return err
+	}
+
+	queueActivity(db, models.FederationCategory, categoryID, []string{actor.Inbox}, &activitypub.Object{
+		ID:     categoryFollowURI(categoryID, actor.ID),
+		Type:   "Follow",
+		Actor:  activitypub.LinkTo(categoryActorURI(categoryID)),
+		Object: activitypub.LinkTo(actor.URI),
+	})
+	return nil
+}
+
+// UnfollowGroupHandler stops a category following a remote group. Admins only.
+func UnfollowGroupHandler(w http.ResponseWriter, r *http.Request) {
+	db := getDB(r)
+	user := getUserFromContext(r)
+	if !user.IsAdmin() {
+		RenderErrorPage(w, http.StatusNotFound)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	categoryID, _ := strconv.ParseInt(r.FormValue("category_id"), 10, 64)
+	actorID, _ := strconv.ParseInt(r.FormValue("actor_id"), 10, 64)
+	actor, err := models.GetRemoteActorByID(db, actorID)
+	if err == nil {
+		err = models.RemoveCategorySubscription(db, categoryID, actorID)
+	}
+	if err != nil {
+		if err.Error() == "actor not found" || err.Error() == "subscription not found" {
+			RenderErrorPage(w, http.StatusNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("Failed to unfollow group: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	group := categoryActorURI(categoryID)
+	follow := categoryFollowURI(categoryID, actorID)
+	queueActivity(db, models.FederationCategory, categoryID, []string{actor.Inbox}, &activitypub.Object{
+		ID:    follow + "/undo",
+		Type:  "Undo",
+		Actor: activitypub.LinkTo(group),
+		Object: activitypub.Embed(&activitypub.Object{
+			ID:     follow,
+			Type:   "Follow",
+			Actor:  activitypub.LinkTo(group),
+			Object: activitypub.LinkTo(actor.URI),
+		}),
+	})
+
+	http.Redirect(w, r, "/admin/federation", http.StatusSeeOther)
+}
@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"forum/activitypub"
+	"forum/models"
+	"forum/utils"
+)
+
+// remoteServer serves a Person on another server and records the activities sent to its inbox
+func remoteServer(t *testing.T) (*httptest.Server, *activitypub.Key, *[]activitypub.Object) {
+	privatePEM, publicPEM, err := activitypub.GenerateKey()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	private, _ := activitypub.ParsePrivateKey(privatePEM)
+
+	var received []activitypub.Object
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/u/alice":
+			actor := srv.URL + "/u/alice"
+			writeActivity(w, http.StatusOK, activitypub.Object{
+				Context:           activitypub.Context,
+				ID:                actor,
+				Type:              "Person",
+				PreferredUsername: "alice",
+				Inbox:             srv.URL + "/inbox",
+				PublicKey:         &activitypub.PublicKey{ID: actor + "#main-key", Owner: actor, PublicKeyPem: publicPEM},
+			})
+		case "/inbox":
+			var activity activitypub.Object
+			body, _ := io.ReadAll(r.Body)
+			json.Unmarshal(body, &activity)
+			received = append(received, activity)
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	// The test server listens on loopback, which the forum only reaches when allowed
+	activitypub.AllowLoopback = true
+	t.Cleanup(func() { activitypub.AllowLoopback = false })
+	return srv, &activitypub.Key{ID: srv.URL + "/u/alice#main-key", Private: private}, &received
+}
+
+// postToInbox sends an activity to the shared inbox, signed with key
+func postToInbox(t *testing.T, db *sql.DB, key *activitypub.Key, activity interface{}) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(activity)
+	req := createRequestWithDB("POST", inboxURI(), bytes.NewBuffer(body), db)
+	req.Header.Set("Content-Type", activitypub.ContentType)
+	if err := activitypub.Sign(req, key, body, time.Now()); err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+	rr := httptest.NewRecorder()
+	ActivityPubHandler(rr, req)
+	return rr
+}
+
+func TestActivityPubActors(t *testing.T) {
+	db, cleanup := setupPostTestDB(t)
+	defer cleanup()
+
+	userID := setupTestUser(t, db)
+	categoryIDs := setupTestCategories(t, db)
+	postID := setupTestPost(t, db, userID, categoryIDs)
+
+	// Categories are found by handle
+	req := createRequestWithDB("GET", WebFingerPath+"?resource=acct:technology@"+siteHost(), nil, db)
+	rr := httptest.NewRecorder()
+	WebFingerHandler(rr, req)
+	var finger activitypub.WebFinger
+	json.Unmarshal(rr.Body.Bytes(), &finger)
+	if rr.Code != http.StatusOK || len(finger.Links) == 0 || finger.Links[0].Href != categoryActorURI(categoryIDs[0]) {
+		t.Fatalf("Unexpected WebFinger answer %d %s", rr.Code, rr.Body.String())
+	}
+
+	get := func(path string, accept string) (*httptest.ResponseRecorder, activitypub.Object) {
+		req := createRequestWithDB("GET", path, nil, db)
+		req.Header.Set("Accept", accept)
+		rr := httptest.NewRecorder()
+		ActivityPubHandler(rr, req)
+		var doc activitypub.Object
+		json.Unmarshal(rr.Body.Bytes(), &doc)
+		return rr, doc
+	}
+
+	rr, group := get(fmt.Sprintf("/ap/category/%d", categoryIDs[0]), activitypub.ContentType)
+	if rr.Code != http.StatusOK || group.Type != "Group" || group.PreferredUsername != "technology" || group.PublicKey == nil {
+		t.Fatalf("Unexpected group %d %s", rr.Code, rr.Body.String())
+	}
+	if _, err := activitypub.ParsePublicKey(group.PublicKey.PublicKeyPem); err != nil {
+		t.Errorf("Failed to parse the group's key: %v", err)
+	}
+
+	_, outbox := get(fmt.Sprintf("/ap/category/%d/outbox", categoryIDs[0]), activitypub.ContentType)
+	if len(outbox.OrderedItems) != 1 || outbox.OrderedItems[0].Object == nil || activitypub.IDOf(outbox.OrderedItems[0].Object.Object) != postObjectURI(postID) {
+		t.Errorf("Unexpected outbox %+v", outbox)
+	}
+
+	_, article := get(fmt.Sprintf("/ap/post/%d", postID), activitypub.ContentType)
+	if article.Type != "Article" || activitypub.IDOf(article.AttributedTo) != userActorURI(userID) || !article.IsPublic() {
+		t.Errorf("Unexpected article %+v", article)
+	}
+
+	// Browsers get the page
+	if rr, _ := get(fmt.Sprintf("/ap/post/%d", postID), "text/html"); rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != fmt.Sprintf("/post/%d", postID) {
+		t.Errorf("Expected a redirect to the post, got %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+	if rr, _ := get("/ap/post/999", activitypub.ContentType); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing post, got %d", rr.Code)
+	}
+}
+
+func TestActivityPubInbox(t *testing.T) {
+	db, cleanup := setupPostTestDB(t)
+	defer cleanup()
+
+	userID := setupTestUser(t, db)
+	categoryIDs := setupTestCategories(t, db)
+	postID := setupTestPost(t, db, userID, categoryIDs[:1])
+	srv, key, received := remoteServer(t)
+	alice := srv.URL + "/u/alice"
+	group := categoryActorURI(categoryIDs[0])
+
+	// Unsigned and forged activities are refused
+	follow := map[string]interface{}{"id": srv.URL + "/follows/1", "type": "Follow", "actor": alice, "object": group}
+	req := createRequestWithDB("POST", inboxURI(), bytes.NewBufferString(`{"type":"Follow"}`), db)
+	rr := httptest.NewRecorder()
+	ActivityPubHandler(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for an unsigned activity, got %d", rr.Code)
+	}
+	// Without the loopback setting, keys on the local network are not fetched
+	activitypub.AllowLoopback = false
+	if rr := postToInbox(t, db, key, follow); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a key on a loopback address, got %d", rr.Code)
+	}
+	if _, err := models.GetRemoteActor(db, alice); err == nil {
+		t.Error("Expected the actor not to be fetched")
+	}
+	activitypub.AllowLoopback = true
+
+	forged := map[string]interface{}{"id": srv.URL + "/follows/2", "type": "Follow", "actor": srv.URL + "/u/bob", "object": group}
+	if rr := postToInbox(t, db, key, forged); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for another actor, got %d", rr.Code)
+	}
+
+	// Follows are accepted
+	if rr := postToInbox(t, db, key, follow); rr.Code != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %d %s", rr.Code, rr.Body.String())
+	}
+	if counts, _ := models.CountCategoryFollowers(db); counts[categoryIDs[0]] != 1 {
+		t.Fatalf("Expected a follower, got %v", counts)
+	}
+	DeliverFederation(db)
+	if len(*received) != 1 || (*received)[0].Type != "Accept" || (*received)[0].Object.ID != srv.URL+"/follows/1" {
+		t.Fatalf("Expected the follow to be accepted, got %+v", *received)
+	}
+
+	// Replies to posts become comments by the remote actor
+	note := map[string]interface{}{
+		"id":           srv.URL + "/notes/1",
+		"type":         "Note",
+		"attributedTo": alice,
+		"content":      "<p>Nice <b>post</b></p>",
+		"inReplyTo":    postObjectURI(postID),
+		"to":           []string{activitypub.Public},
+	}
+	create := map[string]interface{}{"id": srv.URL + "/notes/1/create", "type": "Create", "actor": alice, "object": note}
+	postToInbox(t, db, key, create)
+	comments, _ := models.GetCommentsByPostID(db, postID, 0)
+	if len(comments) != 1 || comments[0].Content != "Nice post" {
+		t.Fatalf("Expected the reply as a comment, got %+v", comments)
+	}
+	if user, _ := models.GetUserByID(db, comments[0].UserID); user == nil || user.Username != "alice@"+srv.Listener.Addr().String() {
+		t.Errorf("Unexpected author %+v", user)
+	}
+
+	// The comment is announced to the group's followers, linking to the original
+	DeliverFederation(db)
+	if len(*received) != 2 || (*received)[1].Type != "Announce" || activitypub.IDOf((*received)[1].Object) != srv.URL+"/notes/1" {
+		t.Fatalf("Expected the comment to be announced, got %+v", (*received)[1:])
+	}
+
+	// Posts cannot be sent to the group without the forum asking for them
+	article := map[string]interface{}{
+		"id":           srv.URL + "/articles/1",
+		"type":         "Article",
+		"attributedTo": alice,
+		"name":         "From afar",
+		"content":      "<p>Hello</p>",
+		"to":           []string{activitypub.Public, group},
+	}
+	if rr := postToInbox(t, db, key, map[string]interface{}{"id": srv.URL + "/articles/1/create", "type": "Create", "actor": alice, "object": article}); rr.Code != http.StatusAccepted {
+		t.Errorf("Expected 202, got %d", rr.Code)
+	}
+	if _, err := models.GetFederatedObject(db, srv.URL+"/articles/1"); err == nil {
+		t.Error("Expected the article to be dropped")
+	}
+	if posts, total, _ := models.GetPostPage(db, 0, models.PostFilter{CategoryID: categoryIDs[0]}, 10, 0); total != 1 || posts[0].ID != postID {
+		t.Errorf("Expected only the local post, got %d posts", total)
+	}
+
+	// Authors delete what they sent
+	postToInbox(t, db, key, map[string]interface{}{"id": srv.URL + "/notes/1/delete", "type": "Delete", "actor": alice, "object": srv.URL + "/notes/1"})
+	if comments, _ := models.GetCommentsByPostID(db, postID, 0); len(comments) != 0 {
+		t.Errorf("Expected the comment to be deleted, got %d", len(comments))
+	}
+
+	// Undoing the follow removes the follower
+	postToInbox(t, db, key, map[string]interface{}{"id": srv.URL + "/follows/1/undo", "type": "Undo", "actor": alice, "object": follow})
+	if counts, _ := models.CountCategoryFollowers(db); counts[categoryIDs[0]] != 0 {
+		t.Errorf("Expected no follower, got %v", counts)
+	}
+}
+
+func TestActivityPubLimits(t *testing.T) {
+	db, cleanup := setupPostTestDB(t)
+	defer cleanup()
+
+	userID := setupTestUser(t, db)
+	categoryIDs := setupTestCategories(t, db)
+	postID := setupTestPost(t, db, userID, categoryIDs)
+	srv, key, _ := remoteServer(t)
+	alice := srv.URL + "/u/alice"
+
+	defer func(inbox, actors, hosts *utils.RateLimiter) {
+		inboxHostLimiter, remoteActorLimiter, remoteHostLimiter = inbox, actors, hosts
+	}(inboxHostLimiter, remoteActorLimiter, remoteHostLimiter)
+	inboxHostLimiter = utils.NewRateLimiter(3, time.Minute)
+	remoteActorLimiter = utils.NewRateLimiter(1, time.Hour)
+	remoteHostLimiter = utils.NewRateLimiter(10, time.Hour)
+
+	reply := func(n int) *httptest.ResponseRecorder {
+		note := map[string]interface{}{
+			"id":           fmt.Sprintf("%s/notes/%d", srv.URL, n),
+			"type":         "Note",
+			"attributedTo": alice,
+			"content":      "<p>Hello</p>",
+			"inReplyTo":    postObjectURI(postID),
+			"to":           []string{activitypub.Public},
+		}
+		return postToInbox(t, db, key, map[string]interface{}{"id": fmt.Sprintf("%s/notes/%d/create", srv.URL, n), "type": "Create", "actor": alice, "object": note})
+	}
+
+	// Each remote author can only add so many comments, and is asked to retry later
+	if rr := reply(1); rr.Code != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := reply(2); rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected 429 over the author's limit, got %d", rr.Code)
+	}
+	if comments, _ := models.GetCommentsByPostID(db, postID, 0); len(comments) != 1 {
+		t.Errorf("Expected one comment, got %d", len(comments))
+	}
+
+	// Each server can only send so many activities
+	reply(1)
+	follow := map[string]interface{}{"id": srv.URL + "/follows/1", "type": "Follow", "actor": alice, "object": categoryActorURI(categoryIDs[0])}
+	if rr := postToInbox(t, db, key, follow); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 over the server's limit, got %d", rr.Code)
+	}
+	if counts, _ := models.CountCategoryFollowers(db); counts[categoryIDs[0]] != 0 {
+		t.Errorf("Expected the follow to be refused, got %v", counts)
+	}
+}
@@ -0,0 +1,869 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"forum/models"
+)
+
+// APIPrefix is the path every version 1 API route starts with
+const APIPrefix = "/api/v1/"
+
+// API list pages hold apiDefaultPageSize items unless per_page asks for up to apiMaxPageSize
+const (
+	apiDefaultPageSize = 20
+	apiMaxPageSize     = 100
+)
+
+// maxAPIBodyBytes is the largest JSON body the API reads
+const maxAPIBodyBytes = 1 << 20
+
+// apiError is the body of every API error response
+type apiError struct {
+	Status  int      `json:"status"`
+	Code    string   `json:"code"`
+	Message string   `json:"message"`
+	Details []string `json:"details,omitempty"`
+}
+
+// apiPagination describes the page of a list response
+type apiPagination struct {
+	Page    int `json:"page"`
+	PerPage int `json:"per_page"`
+	Total   int `json:"total"`
+	Pages   int `json:"pages"`
+}
+
+type apiUser struct {
+	ID        int64  `json:"id"`
+	Username  string `json:"username"`
+	URL       string `json:"url"`
+	AvatarURL string `json:"avatar_url"`
+}
+
+type apiCurrentUser struct {
+	apiUser
+	Email               string    `json:"email"`
+	Role                string    `json:"role"`
+	CreatedAt           time.Time `json:"created_at"`
+	UnreadNotifications int       `json:"unread_notifications"`
+	UnreadMessages      int       `json:"unread_messages"`
+}
+
+type apiCategory struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+type apiPost struct {
+	ID           int64         `json:"id"`
+	Title        string        `json:"title"`
+	Content      string        `json:"content"`
+	ContentHTML  string        `json:"content_html"`
+	URL          string        `json:"url"`
+	Author       apiUser       `json:"author"`
+	Categories   []apiCategory `json:"categories"`
+	Likes        int           `json:"likes"`
+	Dislikes     int           `json:"dislikes"`
+	UserReaction int           `json:"user_reaction"`
+	CreatedAt    time.Time     `json:"created_at"`
+}
+
+type apiComment struct {
+	ID           int64     `json:"id"`
+	PostID       int64     `json:"post_id"`
+	Content      string    `json:"content"`
+	ContentHTML  string    `json:"content_html"`
+	URL          string    `json:"url"`
+	Author       apiUser   `json:"author"`
+	Likes        int       `json:"likes"`
+	Dislikes     int       `json:"dislikes"`
+	UserReaction int       `json:"user_reaction"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// apiUserRole is a user with their role, as admins see them
+type apiUserRole struct {
+	apiUser
+	Role string `json:"role"`
+}
+
+type apiReaction struct {
+	Reaction int `json:"reaction"`
+	Likes    int `json:"likes"`
+	Dislikes int `json:"dislikes"`
+}
+
+// apiPostInput is the body for publishing a post
+type apiPostInput struct {
+	Title       string   `json:"title"`
+	Content     string   `json:"content"`
+	Categories  []int64  `json:"categories"`
+	Attachments []string `json:"attachments,omitempty"` // upload tokens
+}
+
+// apiCommentInput is the body for commenting on a post
+type apiCommentInput struct {
+	Content     string   `json:"content"`
+	Attachments []string `json:"attachments,omitempty"` // upload tokens
+}
+
+// apiReactionInput is the body for setting a reaction
+type apiReactionInput struct {
+	Reaction *int `json:"reaction"` // 1, -1 or 0
+}
+
+// apiCategoryInput is the body for adding a category
+type apiCategoryInput struct {
+	Name string `json:"name"`
+}
+
+// apiRoleInput is the body for changing a user's role
+type apiRoleInput struct {
+	Role string `json:"role"` // user, moderator or admin
+}
+
+// apiParam is a query parameter of an API route
+type apiParam struct {
+	Name        string
+	Type        string // string, integer or boolean
+	Enum        []string
+	Description string
+}
+
+// apiRoute is one API endpoint. APIHandler routes requests with these and checks the scope,
+// sign in and role they ask for before calling the handler. The OpenAPI document is built from
+// them too, so the two cannot drift apart.
+type apiRoute struct {
+	Method      string
+	Path        string // below APIPrefix, {id} stands for a numeric ID
+	OperationID string
+	Summary     string
+	Scope       string // the scope a token needs
+	SignedIn    bool   // anonymous requests are refused
+	Admin       bool   // only admins may use it, set with SignedIn
+	Params      []apiParam
+	Body        interface{} // request body type, nil without a body
+	Result      interface{} // type of data in the answer
+	List        bool        // data is a page of Result
+	Status      int         // status of a successful answer
+	handle      func(w http.ResponseWriter, r *http.Request, id int64)
+}
+
+// apiRoutes lists every API endpoint
+var apiRoutes = []apiRoute{
+	{
+		Method: "GET", Path: "me", OperationID: "getMe", Summary: "The signed in user",
+		Scope: models.ScopeRead, SignedIn: true, Result: apiCurrentUser{}, Status: http.StatusOK,
+		handle: func(w http.ResponseWriter, r *http.Request, _ int64) { apiMe(w, r) },
+	},
+	{
+		Method: "GET", Path: "categories", OperationID: "listCategories", Summary: "All categories",
+		Scope: models.ScopeRead, Result: []apiCategory{}, Status: http.StatusOK,
+		handle: func(w http.ResponseWriter, r *http.Request, _ int64) { apiCategories(w, r) },
+	},
+	{
+		Method: "GET", Path: "posts", OperationID: "listPosts", Summary: "Posts, newest first unless sorted otherwise",
+		Scope: models.ScopeRead, Result: apiPost{}, List: true, Status: http.StatusOK,
+		Params: []apiParam{
+			{Name: "category", Type: "integer", Description: "Only posts in this category"},
+			{Name: "author", Type: "string", Description: "Only posts by this username"},
+			{Name: "liked", Type: "boolean", Description: "Only posts the signed in user liked"},
+			{Name: "sort", Type: "string", Enum: []string{models.PostSortNew, models.PostSortOld, models.PostSortTop}, Description: "Order of the posts"},
+		},
+		handle: func(w http.ResponseWriter, r *http.Request, _ int64) { apiPosts(w, r) },
+	},
+	{
+		Method: "POST", Path: "posts", OperationID: "createPost", Summary: "Publish a post",
+		Scope: models.ScopeWritePosts, SignedIn: true, Body: apiPostInput{}, Result: apiPost{}, Status: http.StatusCreated,
+		handle: func(w http.ResponseWriter, r *http.Request, _ int64) { apiCreatePost(w, r) },
+	},
+	{
+		Method: "GET", Path: "posts/{id}", OperationID: "getPost", Summary: "One post",
+		Scope: models.ScopeRead, Result: apiPost{}, Status: http.StatusOK,
+		handle: apiPostByID,
+	},
+	{
+		Method: "GET", Path: "posts/{id}/comments", OperationID: "listComments", Summary: "The comments of a post, oldest first",
+		Scope: models.ScopeRead, Result: apiComment{}, List: true, Status: http.StatusOK,
+		Params: []apiParam{
+			{Name: "after", Type: "integer", Description: "Only comments after this comment ID, to page through new comments without skipping any"},
+		},
+		handle: apiComments,
+	},
+	{
+		Method: "POST", Path: "posts/{id}/comments", OperationID: "createComment", Summary: "Comment on a post",
+		Scope: models.ScopeWriteComments, SignedIn: true, Body: apiCommentInput{}, Result: apiComment{}, Status: http.StatusCreated,
+		handle: apiCreateComment,
+	},
+	{
+		Method: "PUT", Path: "posts/{id}/reaction", OperationID: "setPostReaction", Summary: "Set your reaction to a post",
+		Scope: models.ScopeReact, SignedIn: true, Body: apiReactionInput{}, Result: apiReaction{}, Status: http.StatusOK,
+		handle: func(w http.ResponseWriter, r *http.Request, id int64) { apiReact(w, r, "post", id) },
+	},
+	{
+		Method: "PUT", Path: "comments/{id}/reaction", OperationID: "setCommentReaction", Summary: "Set your reaction to a comment",
+		Scope: models.ScopeReact, SignedIn: true, Body: apiReactionInput{}, Result: apiReaction{}, Status: http.StatusOK,
+		handle: func(w http.ResponseWriter, r *http.Request, id int64) { apiReact(w, r, "comment", id) },
+	},
+	{
+		Method: "POST", Path: "categories", OperationID: "createCategory", Summary: "Add a category",
+		Scope: models.ScopeAdmin, SignedIn: true, Admin: true, Body: apiCategoryInput{}, Result: apiCategory{}, Status: http.StatusCreated,
+		handle: func(w http.ResponseWriter, r *http.Request, _ int64) { apiCreateCategory(w, r) },
+	},
+	{
+		Method: "PUT", Path: "users/{id}/role", OperationID: "setUserRole", Summary: "Change a user's role",
+		Scope: models.ScopeAdmin, SignedIn: true, Admin: true, Body: apiRoleInput{}, Result: apiUserRole{}, Status: http.StatusOK,
+		handle: apiSetUserRole,
+	},
+}
+
+// match reports whether a path below APIPrefix is this route's, and the ID in it
+func (route *apiRoute) match(parts []string) (int64, bool) {
+	pattern := strings.Split(route.Path, "/")
+	if len(pattern) != len(parts) {
+		return 0, false
+	}
+	var id int64
+	for i, part := range pattern {
+		if part == "{id}" {
+			n, err := strconv.ParseInt(parts[i], 10, 64)
+			if err != nil || n < 1 {
+				return 0, false
+			}
+			id = n
+		} else if part != parts[i] {
+			return 0, false
+		}
+	}
+	return id, true
+}
+
+// APIHandler serves the version 1 JSON API. It uses the same session as the web pages or a
+// personal access token, and answers every request, including errors, with JSON.
+func APIHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, APIPrefix), "/"), "/")
+
+	var allowed []string
+	for i := range apiRoutes {
+		route := &apiRoutes[i]
+		id, ok := route.match(parts)
+		if !ok {
+			continue
+		}
+		if route.Method == r.Method {
+			if route.authorize(w, r) {
+				route.handle(w, r, id)
+			}
+			return
+		}
+		allowed = append(allowed, route.Method)
+	}
+	if len(allowed) == 0 {
+		writeAPIError(w, http.StatusNotFound, "not_found", "No such API endpoint")
+		return
+	}
+	apiAllow(w, r, allowed...)
+}
+
+// authorize answers 401 or 403 unless the request may use the route: anonymous requests only
+// reach routes that are not SignedIn, tokens need the route's scope, and only admins reach
+// Admin routes. Handlers of SignedIn routes can rely on a signed in user.
+func (route *apiRoute) authorize(w http.ResponseWriter, r *http.Request) bool {
+	user := getUserFromContext(r)
+	if user == nil && route.SignedIn {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeAPIError(w, http.StatusUnauthorized, "unauthorized", "Sign in to use this endpoint")
+		return false
+	}
+	if !apiHasScope(r, route.Scope) {
+		writeAPIScopeError(w, route.Scope)
+		return false
+	}
+	if route.Admin && !user.IsAdmin() {
+		writeAPIError(w, http.StatusForbidden, "forbidden", "Only admins can use this endpoint")
+		return false
+	}
+	return true
+}
+
+// apiMe returns the signed in user
+func apiMe(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	profile, err := models.GetProfileByUsername(getDB(r), user.Username)
+	if err != nil {
+		writeAPIInternalError(w, "Failed to get profile", err)
+		return
+	}
+
+	writeAPIJSON(w, http.StatusOK, map[string]interface{}{"data": apiCurrentUser{
+		apiUser:             newAPIUser(user.ID, user.Username, profile.AvatarVersion),
+		Email:               user.Email,
+		Role:                user.Role,
+		CreatedAt:           user.CreatedAt,
+		UnreadNotifications: user.UnreadNotifications,
+		UnreadMessages:      user.UnreadMessages,
+	}})
+}
+
+// apiCategories lists every category
+func apiCategories(w http.ResponseWriter, r *http.Request) {
+	categories, err := models.GetAllCategories(getDB(r))
+	if err != nil {
+		writeAPIInternalError(w, "Failed to get categories", err)
+		return
+	}
+
+	data := make([]apiCategory, 0, len(categories))
+	for _, c := range categories {
+		data = append(data, newAPICategory(c))
+	}
+	writeAPIJSON(w, http.StatusOK, map[string]interface{}{"data": data})
+}
+
+// apiPosts lists posts. The category, author and liked parameters filter the list, sort
+// orders it (new, old or top), and page and per_page pick the page.
+func apiPosts(w http.ResponseWriter, r *http.Request) {
+	db := getDB(r)
+	user := getUserFromContext(r)
+	query := r.URL.Query()
+
+	var filter models.PostFilter
+	if value := query.Get("category"); value != "" {
+		categoryID, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "bad_request", "category must be a category ID")
+			return
+		}
+		filter.CategoryID = categoryID
+	}
+	if value := query.Get("author"); value != "" {
+		profile, err := models.GetProfileByUsername(db, value)
+		if err != nil {
+			if err.Error() == "user not found" {
+				writeAPIError(w, http.StatusNotFound, "not_found", "User not found")
+			} else {
+				writeAPIInternalError(w, "Failed to get user", err)
+			}
+			return
+		}
+		filter.AuthorID = profile.UserID
+	}
+	if query.Get("liked") == "true" {
+		if user == nil {
+			writeAPIError(w, http.StatusUnauthorized, "unauthorized", "Sign in to list liked posts")
+			return
+		}
+		filter.LikedBy = user.ID
+	}
+	filter.Sort = query.Get("sort")
+
+	page, perPage, ok := apiPage(w, r)
+	if !ok {
+		return
+	}
+
+	var userID int64
+	if user != nil {
+		userID = user.ID
+	}
+	posts, total, err := models.GetPostPage(db, userID, filter, perPage, (page-1)*perPage)
+	if err != nil {
+		if err.Error() == "unknown sort order" {
+			writeAPIError(w, http.StatusBadRequest, "bad_request", "sort must be new, old or top")
+		} else {
+			writeAPIInternalError(w, "Failed to get posts", err)
+		}
+		return
+	}
+
+	data := make([]apiPost, 0, len(posts))
+	for i := range posts {
+		data = append(data, newAPIPost(db, &posts[i]))
+	}
+	writeAPIList(w, data, page, perPage, total)
+}
+
+// apiPostByID returns one post
+func apiPostByID(w http.ResponseWriter, r *http.Request, postID int64) {
+	db := getDB(r)
+	post, ok := apiGetPost(w, r, postID)
+	if !ok {
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, map[string]interface{}{"data": newAPIPost(db, post)})
+}
+
+// apiCreatePost publishes a post from a JSON body with a title, content and category IDs.
+// Uploads referenced in the content or listed in attachments are attached to it.
+func apiCreatePost(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	var body apiPostInput
+	if !decodeAPIBody(w, r, &body) {
+		return
+	}
+
+	db := getDB(r)
+	draft := models.Draft{
+		UserID:      user.ID,
+		Title:       strings.TrimSpace(body.Title),
+		Content:     strings.TrimSpace(body.Content),
+		CategoryIDs: body.Categories,
+	}
+	problems := draft.Validate()
+	for _, categoryID := range draft.CategoryIDs {
+		if _, err := models.GetCategoryByID(db, categoryID); err != nil {
+			if err != sql.ErrNoRows {
+				writeAPIInternalError(w, "Failed to get category", err)
+				return
+			}
+			problems = append(problems, fmt.Sprintf("Category %d does not exist", categoryID))
+		}
+	}
+	tokens := apiAttachmentTokens(draft.Content, body.Attachments)
+	if len(tokens) > MaxAttachmentsPerItem {
+		problems = append(problems, fmt.Sprintf("At most %d attachments are allowed", MaxAttachmentsPerItem))
+	}
+	if len(problems) > 0 {
+		writeAPIValidationError(w, problems)
+		return
+	}
+
+	postID, err := models.CreatePost(db, draft.Title, draft.Content, user.ID, draft.CategoryIDs)
+	if err != nil {
+		writeAPIInternalError(w, "Failed to create post", err)
+		return
+	}
+	if _, err := models.AttachToPost(db, tokens, user.ID, postID); err != nil {
+		writeAPIInternalError(w, "Failed to save attachments", err)
+		return
+	}
+	notifyNewPost(db, postID, user.ID, draft.Content)
+
+	post, err := models.GetPostByID(db, postID, user.ID)
+	if err != nil {
+		writeAPIInternalError(w, "Failed to get post", err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("%sposts/%d", APIPrefix, postID))
+	writeAPIJSON(w, http.StatusCreated, map[string]interface{}{"data": newAPIPost(db, post)})
+}
+
+// apiComments lists the comments of a post, oldest first. The after parameter starts the list
+// after a comment, and page and per_page pick the page.
+func apiComments(w http.ResponseWriter, r *http.Request, postID int64) {
+	db := getDB(r)
+	if _, ok := apiGetPost(w, r, postID); !ok {
+		return
+	}
+	var afterID int64
+	if value := r.URL.Query().Get("after"); value != "" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 1 {
+			writeAPIError(w, http.StatusBadRequest, "bad_request", "after must be a comment ID")
+			return
+		}
+		afterID = n
+	}
+	page, perPage, ok := apiPage(w, r)
+	if !ok {
+		return
+	}
+
+	var userID int64
+	if user := getUserFromContext(r); user != nil {
+		userID = user.ID
+	}
+	comments, total, err := models.GetCommentPage(db, postID, userID, afterID, perPage, (page-1)*perPage)
+	if err != nil {
+		if err.Error() == "comment not found" {
+			writeAPIError(w, http.StatusBadRequest, "bad_request", "after must be a comment of this post")
+		} else {
+			writeAPIInternalError(w, "Failed to get comments", err)
+		}
+		return
+	}
+
+	data := make([]apiComment, 0, len(comments))
+	for i := range comments {
+		data = append(data, newAPIComment(db, &comments[i]))
+	}
+	writeAPIList(w, data, page, perPage, total)
+}
+
+// apiCreateComment adds a comment to a post from a JSON body with its content
+func apiCreateComment(w http.ResponseWriter, r *http.Request, postID int64) {
+	user := getUserFromContext(r)
+
+	var body apiCommentInput
+	if !decodeAPIBody(w, r, &body) {
+		return
+	}
+
+	db := getDB(r)
+	if _, ok := apiGetPost(w, r, postID); !ok {
+		return
+	}
+
+	content := strings.TrimSpace(body.Content)
+	var problems []string
+	if content == "" {
+		problems = append(problems, "Comment cannot be empty")
+	}
+	tokens := apiAttachmentTokens(content, body.Attachments)
+	if len(tokens) > MaxAttachmentsPerItem {
+		problems = append(problems, fmt.Sprintf("At most %d attachments are allowed", MaxAttachmentsPerItem))
+	}
+	if len(problems) > 0 {
+		writeAPIValidationError(w, problems)
+		return
+	}
+
+	commentID, err := models.CreateComment(db, content, user.ID, postID)
+	if err != nil {
+		writeAPIInternalError(w, "Failed to create comment", err)
+		return
+	}
+	if _, err := models.AttachToComment(db, tokens, user.ID, postID, commentID); err != nil {
+		writeAPIInternalError(w, "Failed to save attachments", err)
+		return
+	}
+	notifyNewComment(db, postID, commentID, user.ID, content)
+
+	comment, err := models.GetCommentByID(db, commentID, user.ID)
+	if err != nil {
+		writeAPIInternalError(w, "Failed to get comment", err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("%sposts/%d/comments", APIPrefix, postID))
+	writeAPIJSON(w, http.StatusCreated, map[string]interface{}{"data": newAPIComment(db, comment)})
+}
+
+// apiReact sets the signed in user's reaction to a post or comment: 1 to like, -1 to dislike
+// and 0 to take the reaction back. Setting the same reaction again changes nothing.
+func apiReact(w http.ResponseWriter, r *http.Request, targetType string, targetID int64) {
+	user := getUserFromContext(r)
+
+	var body apiReactionInput
+	if !decodeAPIBody(w, r, &body) {
+		return
+	}
+	if body.Reaction == nil || *body.Reaction < -1 || *body.Reaction > 1 {
+		writeAPIValidationError(w, []string{"reaction must be 1, -1 or 0"})
+		return
+	}
+	reaction := *body.Reaction
+
+	db := getDB(r)
+	var current int
+	var err error
+	if targetType == "comment" {
+		if _, err = models.GetCommentPostID(db, targetID); err != nil {
+			if err.Error() == "comment not found" {
+				writeAPIError(w, http.StatusNotFound, "not_found", "Comment not found")
+			} else {
+				writeAPIInternalError(w, "Failed to get comment", err)
+			}
+			return
+		}
+		current, err = models.GetUserCommentReaction(db, targetID, user.ID)
+	} else {
+		if _, ok := apiGetPost(w, r, targetID); !ok {
+			return
+		}
+		current, err = models.GetUserPostReaction(db, targetID, user.ID)
+	}
+	if err != nil {
+		writeAPIInternalError(w, "Failed to get reaction", err)
+		return
+	}
+
+	// Reacting again with the current reaction takes it back
+	if reaction != current {
+		toggle := reaction
+		if reaction == 0 {
+			toggle = current
+		}
+		if targetType == "comment" {
+			err = models.ReactToComment(db, targetID, user.ID, toggle)
+		} else {
+			err = models.ReactToPost(db, targetID, user.ID, toggle)
+		}
+		if err != nil {
+			writeAPIInternalError(w, "Failed to save reaction", err)
+			return
+		}
+		notifyReaction(db, user.ID, targetType, targetID)
+	}
+
+	result := apiReaction{Reaction: reaction}
+	if targetType == "comment" {
+		result.Likes, result.Dislikes, err = models.GetCommentReactionStats(db, targetID)
+	} else {
+		result.Likes, result.Dislikes, err = models.GetPostReactionStats(db, targetID)
+	}
+	if err != nil {
+		writeAPIInternalError(w, "Failed to count reactions", err)
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, map[string]interface{}{"data": result})
+}
+
+// apiCreateCategory adds a category from a JSON body with its name
+func apiCreateCategory(w http.ResponseWriter, r *http.Request) {
+	var body apiCategoryInput
+	if !decodeAPIBody(w, r, &body) {
+		return
+	}
+
+	db := getDB(r)
+	name := strings.TrimSpace(body.Name)
+	if name == "" {
+		writeAPIValidationError(w, []string{"Category name is required"})
+		return
+	}
+	exists, err := models.CategoryExists(db, name)
+	if err != nil {
+		writeAPIInternalError(w, "Failed to check category", err)
+		return
+	}
+	if exists {
+		writeAPIValidationError(w, []string{"Category already exists"})
+		return
+	}
+
+	categoryID, err := models.CreateCategory(db, name)
+	if err != nil {
+		writeAPIInternalError(w, "Failed to create category", err)
+		return
+	}
+	writeAPIJSON(w, http.StatusCreated, map[string]interface{}{"data": newAPICategory(models.Category{ID: categoryID, Name: name})})
+}
+
+// apiSetUserRole makes a user a moderator or admin, or back a regular user
+func apiSetUserRole(w http.ResponseWriter, r *http.Request, userID int64) {
+	var body apiRoleInput
+	if !decodeAPIBody(w, r, &body) {
+		return
+	}
+
+	db := getDB(r)
+	if err := models.SetUserRole(db, userID, body.Role); err != nil {
+		switch err.Error() {
+		case "invalid role":
+			writeAPIValidationError(w, []string{"role must be user, moderator or admin"})
+		case "user not found":
+			writeAPIError(w, http.StatusNotFound, "not_found", "User not found")
+		default:
+			writeAPIInternalError(w, "Failed to set role", err)
+		}
+		return
+	}
+
+	user, err := models.GetUserByID(db, userID)
+	if err != nil {
+		writeAPIInternalError(w, "Failed to get user", err)
+		return
+	}
+	profile, err := models.GetProfileByUsername(db, user.Username)
+	if err != nil {
+		writeAPIInternalError(w, "Failed to get profile", err)
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, map[string]interface{}{"data": apiUserRole{
+		apiUser: newAPIUser(user.ID, user.Username, profile.AvatarVersion),
+		Role:    user.Role,
+	}})
+}
+
+// apiGetPost loads a post for the current user, answering 404 when there is none
+func apiGetPost(w http.ResponseWriter, r *http.Request, postID int64) (*models.Post, bool) {
+	var userID int64
+	if user := getUserFromContext(r); user != nil {
+		userID = user.ID
+	}
+	post, err := models.GetPostByID(getDB(r), postID, userID)
+	if err != nil {
+		if err == sql.ErrNoRows || err.Error() == "post not found" {
+			writeAPIError(w, http.StatusNotFound, "not_found", "Post not found")
+		} else {
+			writeAPIInternalError(w, "Failed to get post", err)
+		}
+		return nil, false
+	}
+	return post, true
+}
+
+// apiAttachmentTokens returns the uploads referenced in content together with the listed ones
+func apiAttachmentTokens(content string, listed []string) []string {
+	return uniqueStrings(append(contentAttachmentTokens(content), listed...))
+}
+
+// API objects link to pages under BaseURL rather than the request's Host header, which the
+// client chooses
+func newAPIUser(id int64, username string, avatarVersion int) apiUser {
+	return apiUser{
+		ID:        id,
+		Username:  username,
+		URL:       BaseURL + userURL(username),
+		AvatarURL: BaseURL + avatarURL(id, avatarVersion, 128),
+	}
+}
+
+func newAPICategory(c models.Category) apiCategory {
+	return apiCategory{ID: c.ID, Name: c.Name, URL: fmt.Sprintf("%s/posts/category/%d", BaseURL, c.ID)}
+}
+
+func newAPIPost(db *sql.DB, post *models.Post) apiPost {
+	categories := make([]apiCategory, 0, len(post.Categories))
+	for _, c := range post.Categories {
+		categories = append(categories, newAPICategory(c))
+	}
+	return apiPost{
+		ID:           post.ID,
+		Title:        post.Title,
+		Content:      post.Content,
+		ContentHTML:  string(renderMarkdown(db, models.RenderedPost, post.ID, post.Content)),
+		URL:          fmt.Sprintf("%s/post/%d", BaseURL, post.ID),
+		Author:       newAPIUser(post.UserID, post.Username, post.AvatarVersion),
+		Categories:   categories,
+		Likes:        post.Likes,
+		Dislikes:     post.Dislikes,
+		UserReaction: post.UserReaction,
+		CreatedAt:    post.CreatedAt,
+	}
+}
+
+func newAPIComment(db *sql.DB, comment *models.Comment) apiComment {
+	return apiComment{
+		ID:           comment.ID,
+		PostID:       comment.PostID,
+		Content:      comment.Content,
+		ContentHTML:  string(renderMarkdown(db, models.RenderedComment, comment.ID, comment.Content)),
+		URL:          fmt.Sprintf("%s/post/%d#comment-%d", BaseURL, comment.PostID, comment.ID),
+		Author:       newAPIUser(comment.UserID, comment.Username, comment.AvatarVersion),
+		Likes:        comment.Likes,
+		Dislikes:     comment.Dislikes,
+		UserReaction: comment.UserReaction,
+		CreatedAt:    comment.CreatedAt,
+	}
+}
+
+// apiAllow answers 405 with the allowed methods unless the request uses one of them
+func apiAllow(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+	return false
+}
+
+// apiHasScope reports whether a request may use a scope. Session cookies may use them all.
+func apiHasScope(r *http.Request, scope string) bool {
+	token := getTokenFromContext(r)
+	return token == nil || token.HasScope(scope)
+}
+
+func writeAPIScopeError(w http.ResponseWriter, scope string) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, scope))
+	writeAPIError(w, http.StatusForbidden, "insufficient_scope", fmt.Sprintf("The access token needs the %s scope", scope))
+}
+
+// apiPage reads the page and per_page parameters
+func apiPage(w http.ResponseWriter, r *http.Request) (page, perPage int, ok bool) {
+	page, perPage = 1, apiDefaultPageSize
+	query := r.URL.Query()
+	if value := query.Get("page"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			writeAPIError(w, http.StatusBadRequest, "bad_request", "page must be a positive number")
+			return 0, 0, false
+		}
+		page = n
+	}
+	if value := query.Get("per_page"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > apiMaxPageSize {
+			writeAPIError(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("per_page must be between 1 and %d", apiMaxPageSize))
+			return 0, 0, false
+		}
+		perPage = n
+	}
+	return page, perPage, true
+}
+
+// decodeAPIBody reads a JSON request body, answering 415 or 400 when it cannot.
+// Requiring the JSON content type also keeps other sites' forms from posting here.
+func decodeAPIBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		writeAPIError(w, http.StatusUnsupportedMediaType, "unsupported_media_type", "Send the body as application/json")
+		return false
+	}
+
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeAPIError(w, http.StatusRequestEntityTooLarge, "too_large", "The body is too large")
+		} else {
+			writeAPIError(w, http.StatusBadRequest, "bad_request", "Invalid JSON: "+err.Error())
+		}
+		return false
+	}
+	return true
+}
+
+func writeAPIList(w http.ResponseWriter, data interface{}, page, perPage, total int) {
+	writeAPIJSON(w, http.StatusOK, map[string]interface{}{
+		"data": data,
+		"pagination": apiPagination{
+			Page:    page,
+			PerPage: perPage,
+			Total:   total,
+			Pages:   (total + perPage - 1) / perPage,
+		},
+	})
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write API response: %v", err)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	writeAPIJSON(w, status, map[string]apiError{"error": {Status: status, Code: code, Message: message}})
+}
+
+func writeAPIValidationError(w http.ResponseWriter, problems []string) {
+	writeAPIJSON(w, http.StatusUnprocessableEntity, map[string]apiError{"error": {
+		Status:  http.StatusUnprocessableEntity,
+		Code:    "validation_failed",
+		Message: "The request has invalid fields",
+		Details: problems,
+	}})
+}
+
+// writeAPIInternalError logs an unexpected error and answers 500 without its details
+func writeAPIInternalError(w http.ResponseWriter, message string, err error) {
+	log.Printf("API: %s: %v", message, err)
+	writeAPIError(w, http.StatusInternalServerError, "internal_error", message)
+}
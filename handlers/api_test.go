@@ -0,0 +1,393 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"forum/models"
+)
+
+// apiRequest sends a request to the API, signed in as userID unless it is 0, and decodes the answer
+func apiRequest(t *testing.T, db *sql.DB, userID int64, method, path, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	var buf *bytes.Buffer
+	if body != "" {
+		buf = bytes.NewBufferString(body)
+	}
+	var req *http.Request
+	if userID > 0 {
+		req = createAuthenticatedRequest(method, path, buf, db, userID)
+	} else {
+		req = createRequestWithDB(method, path, buf, db)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	rr := httptest.NewRecorder()
+	APIHandler(rr, req)
+
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("%s %s: expected a JSON answer, got %q", method, path, ct)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &decoded); err != nil {
+		t.Fatalf("%s %s: failed to decode %q: %v", method, path, rr.Body.String(), err)
+	}
+	return rr, decoded
+}
+
+func apiErrorCode(answer map[string]interface{}) string {
+	apiErr, _ := answer["error"].(map[string]interface{})
+	code, _ := apiErr["code"].(string)
+	return code
+}
+
+func TestAPIPosts(t *testing.T) {
+	db, cleanup := setupPostTestDB(t)
+	defer cleanup()
+
+	userID := setupTestUser(t, db)
+	categoryIDs := setupTestCategories(t, db)
+	postID := setupTestPost(t, db, userID, categoryIDs)
+	otherPostID := setupTestPost(t, db, userID, categoryIDs[1:])
+
+	// Anyone can list posts, newest first
+	rr, answer := apiRequest(t, db, 0, "GET", "/api/v1/posts?per_page=1", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	posts := answer["data"].([]interface{})
+	pagination := answer["pagination"].(map[string]interface{})
+	if len(posts) != 1 || posts[0].(map[string]interface{})["id"].(float64) != float64(otherPostID) {
+		t.Errorf("Unexpected posts %v", posts)
+	}
+	if pagination["total"].(float64) != 2 || pagination["pages"].(float64) != 2 {
+		t.Errorf("Unexpected pagination %v", pagination)
+	}
+
+	rr, answer = apiRequest(t, db, 0, "GET", fmt.Sprintf("/api/v1/posts?category=%d&author=testuser", categoryIDs[0]), "")
+	if posts := answer["data"].([]interface{}); rr.Code != http.StatusOK || len(posts) != 1 {
+		t.Errorf("Expected one post in the category, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	for path, code := range map[string]string{
+		"/api/v1/posts?sort=random":   "bad_request",
+		"/api/v1/posts?per_page=1000": "bad_request",
+		"/api/v1/posts?author=nobody": "not_found",
+		"/api/v1/posts?liked=true":    "unauthorized",
+		"/api/v1/posts/999":           "not_found",
+		"/api/v1/nothing":             "not_found",
+	} {
+		if _, answer := apiRequest(t, db, 0, "GET", path, ""); apiErrorCode(answer) != code {
+			t.Errorf("GET %s: expected %s, got %v", path, code, answer)
+		}
+	}
+
+	rr, answer = apiRequest(t, db, 0, "GET", fmt.Sprintf("/api/v1/posts/%d", postID), "")
+	post := answer["data"].(map[string]interface{})
+	if rr.Code != http.StatusOK || post["title"] != "Test Post Title" || post["content_html"] == "" {
+		t.Errorf("Unexpected post %v", post)
+	}
+
+	rr, _ = apiRequest(t, db, 0, "DELETE", fmt.Sprintf("/api/v1/posts/%d", postID), "")
+	if rr.Code != http.StatusMethodNotAllowed || rr.Header().Get("Allow") != "GET" {
+		t.Errorf("Expected 405 allowing GET, got %d %q", rr.Code, rr.Header().Get("Allow"))
+	}
+}
+
+func TestAPICreatePost(t *testing.T) {
+	db, cleanup := setupPostTestDB(t)
+	defer cleanup()
+
+	userID := setupTestUser(t, db)
+	categoryIDs := setupTestCategories(t, db)
+	body := fmt.Sprintf(`{"title": "From the API", "content": "Hello **there**", "categories": [%d]}`, categoryIDs[0])
+
+	if rr, answer := apiRequest(t, db, 0, "POST", "/api/v1/posts", body); rr.Code != http.StatusUnauthorized || apiErrorCode(answer) != "unauthorized" {
+		t.Errorf("Expected 401 when signed out, got %d", rr.Code)
+	}
+
+	req := createAuthenticatedRequest("POST", "/api/v1/posts", bytes.NewBufferString(body), db, userID)
+	rr := httptest.NewRecorder()
+	APIHandler(rr, req)
+	if rr.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected 415 for a form body, got %d", rr.Code)
+	}
+
+	rr, answer := apiRequest(t, db, userID, "POST", "/api/v1/posts", `{"title": " ", "categories": [999]}`)
+	details, _ := answer["error"].(map[string]interface{})["details"].([]interface{})
+	if rr.Code != http.StatusUnprocessableEntity || len(details) != 3 {
+		t.Errorf("Expected 422 with three problems, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if _, answer := apiRequest(t, db, userID, "POST", "/api/v1/posts", `{"title": "x", "extra": true}`); apiErrorCode(answer) != "bad_request" {
+		t.Errorf("Expected unknown fields to be refused, got %v", answer)
+	}
+
+	rr, answer = apiRequest(t, db, userID, "POST", "/api/v1/posts", body)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	post := answer["data"].(map[string]interface{})
+	if rr.Header().Get("Location") != fmt.Sprintf("/api/v1/posts/%v", post["id"]) || post["content_html"] != "<p>Hello <strong>there</strong></p>\n" {
+		t.Errorf("Unexpected created post %v (location %q)", post, rr.Header().Get("Location"))
+	}
+}
+
+func TestAPICommentsAndReactions(t *testing.T) {
+	db, cleanup := setupPostTestDB(t)
+	defer cleanup()
+
+	userID := setupTestUser(t, db)
+	postID := setupTestPost(t, db, userID, setupTestCategories(t, db))
+	commentsPath := fmt.Sprintf("/api/v1/posts/%d/comments", postID)
+
+	if rr, _ := apiRequest(t, db, userID, "POST", commentsPath, `{"content": "  "}`); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for an empty comment, got %d", rr.Code)
+	}
+	rr, answer := apiRequest(t, db, userID, "POST", commentsPath, `{"content": "First!"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	commentID := int64(answer["data"].(map[string]interface{})["id"].(float64))
+
+	rr, answer = apiRequest(t, db, 0, "GET", commentsPath, "")
+	if comments := answer["data"].([]interface{}); rr.Code != http.StatusOK || len(comments) != 1 {
+		t.Errorf("Expected one comment, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Pages are read from the database, and after continues from a comment
+	secondID, _ := models.CreateComment(db, "Second", userID, postID)
+	rr, answer = apiRequest(t, db, 0, "GET", commentsPath+"?per_page=1&page=2", "")
+	if comments := answer["data"].([]interface{}); rr.Code != http.StatusOK || len(comments) != 1 || comments[0].(map[string]interface{})["id"] != float64(secondID) {
+		t.Errorf("Expected the second comment, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr, answer = apiRequest(t, db, 0, "GET", fmt.Sprintf("%s?after=%d", commentsPath, commentID), "")
+	if comments := answer["data"].([]interface{}); len(comments) != 1 || answer["pagination"].(map[string]interface{})["total"] != float64(1) {
+		t.Errorf("Expected the comment after the first, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr, _ := apiRequest(t, db, 0, "GET", commentsPath+"?after=999", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a cursor from another post, got %d", rr.Code)
+	}
+
+	// Setting a reaction is idempotent, and 0 takes it back
+	reactionPath := fmt.Sprintf("/api/v1/posts/%d/reaction", postID)
+	for _, step := range []struct {
+		reaction       int
+		likes, dislike float64
+	}{{1, 1, 0}, {1, 1, 0}, {-1, 0, 1}, {0, 0, 0}} {
+		rr, answer := apiRequest(t, db, userID, "PUT", reactionPath, fmt.Sprintf(`{"reaction": %d}`, step.reaction))
+		data := answer["data"].(map[string]interface{})
+		if rr.Code != http.StatusOK || data["likes"] != step.likes || data["dislikes"] != step.dislike {
+			t.Errorf("Reaction %d: unexpected answer %d %v", step.reaction, rr.Code, data)
+		}
+	}
+	if reaction, _ := models.GetUserPostReaction(db, postID, userID); reaction != 0 {
+		t.Errorf("Expected the reaction to be taken back, got %d", reaction)
+	}
+
+	if rr, _ := apiRequest(t, db, userID, "PUT", reactionPath, `{"reaction": 2}`); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for an invalid reaction, got %d", rr.Code)
+	}
+	rr, answer = apiRequest(t, db, userID, "PUT", fmt.Sprintf("/api/v1/comments/%d/reaction", commentID), `{"reaction": -1}`)
+	if rr.Code != http.StatusOK || answer["data"].(map[string]interface{})["dislikes"] != float64(1) {
+		t.Errorf("Unexpected comment reaction %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr, _ := apiRequest(t, db, userID, "PUT", "/api/v1/comments/999/reaction", `{"reaction": 1}`); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing comment, got %d", rr.Code)
+	}
+}
+
+func TestAPIMe(t *testing.T) {
+	db, cleanup := setupPostTestDB(t)
+	defer cleanup()
+
+	userID := setupTestUser(t, db)
+	if rr, _ := apiRequest(t, db, 0, "GET", "/api/v1/me", ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 when signed out, got %d", rr.Code)
+	}
+	rr, answer := apiRequest(t, db, userID, "GET", "/api/v1/me", "")
+	me := answer["data"].(map[string]interface{})
+	if rr.Code != http.StatusOK || me["username"] != "testuser" || me["email"] != "test@example.com" || me["role"] != models.RoleUser {
+		t.Errorf("Unexpected user %d %v", rr.Code, me)
+	}
+
+	// Links use the site's address whatever Host the request names
+	defer func(base string) { BaseURL = base }(BaseURL)
+	BaseURL = "https://forum.example"
+	req := createAuthenticatedRequest("GET", "/api/v1/me", nil, db, userID)
+	req.Host = "attacker.example"
+	rr = httptest.NewRecorder()
+	APIHandler(rr, req)
+	if !strings.Contains(rr.Body.String(), `"url":"https://forum.example/user/testuser"`) || strings.Contains(rr.Body.String(), "attacker") {
+		t.Errorf("Expected links under the site's address, got %s", rr.Body.String())
+	}
+}
+
+// TestAPIRouteAuthorization checks that APIHandler enforces what each route declares, which is
+// also what the OpenAPI document tells clients
+func TestAPIRouteAuthorization(t *testing.T) {
+	db, cleanup := setupPostTestDB(t)
+	defer cleanup()
+
+	userID := setupTestUser(t, db)
+	user, _ := models.GetUserByID(db, userID)
+	handler := SessionMiddleware(db, http.HandlerFunc(APIHandler))
+
+	for _, route := range apiRoutes {
+		name := route.Method + " " + route.Path
+		if route.Scope == "" || route.Admin && !route.SignedIn {
+			t.Errorf("%s: routes need a scope, and admin routes need SignedIn", name)
+		}
+		path := APIPrefix + strings.ReplaceAll(route.Path, "{id}", "1")
+
+		if route.SignedIn {
+			if rr, answer := apiRequest(t, db, 0, route.Method, path, "{}"); rr.Code != http.StatusUnauthorized {
+				t.Errorf("%s: expected 401 when signed out, got %d %s", name, rr.Code, apiErrorCode(answer))
+			}
+		}
+
+		// A token with every scope but the route's
+		var scopes []string
+		for _, scope := range models.TokenScopes {
+			if scope != route.Scope && scope != models.ScopeAdmin {
+				scopes = append(scopes, scope)
+			}
+		}
+		token, err := models.CreateAPIToken(db, user, "all but "+route.OperationID, scopes, time.Time{})
+		if err != nil {
+			t.Fatalf("Failed to create token: %v", err)
+		}
+		req := createRequestWithDB(route.Method, path, bytes.NewBufferString("{}"), db)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), "insufficient_scope") {
+			t.Errorf("%s: expected 403 without the %s scope, got %d", name, route.Scope, rr.Code)
+		}
+	}
+}
+
+func TestAPIAdmin(t *testing.T) {
+	db, cleanup := setupPostTestDB(t)
+	defer cleanup()
+
+	userID := setupTestUser(t, db)
+	otherID, err := models.CreateUser(db, "other", "other@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	rolePath := fmt.Sprintf("/api/v1/users/%d/role", otherID)
+
+	// Only admins may use the admin endpoints
+	if rr, answer := apiRequest(t, db, userID, "PUT", rolePath, `{"role": "moderator"}`); rr.Code != http.StatusForbidden || apiErrorCode(answer) != "forbidden" {
+		t.Errorf("Expected 403 for a regular user, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if err := models.SetUserRole(db, userID, models.RoleAdmin); err != nil {
+		t.Fatalf("Failed to set role: %v", err)
+	}
+
+	rr, answer := apiRequest(t, db, userID, "PUT", rolePath, `{"role": "moderator"}`)
+	if rr.Code != http.StatusOK || answer["data"].(map[string]interface{})["role"] != models.RoleModerator {
+		t.Errorf("Unexpected answer %d: %s", rr.Code, rr.Body.String())
+	}
+	if other, _ := models.GetUserByID(db, otherID); other.Role != models.RoleModerator {
+		t.Errorf("Expected a moderator, got %s", other.Role)
+	}
+	if rr, _ := apiRequest(t, db, userID, "PUT", rolePath, `{"role": "owner"}`); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for an unknown role, got %d", rr.Code)
+	}
+	if rr, _ := apiRequest(t, db, userID, "PUT", "/api/v1/users/999/role", `{"role": "user"}`); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing user, got %d", rr.Code)
+	}
+
+	if rr, answer := apiRequest(t, db, userID, "POST", "/api/v1/categories", `{"name": "News"}`); rr.Code != http.StatusCreated || answer["data"].(map[string]interface{})["name"] != "News" {
+		t.Errorf("Unexpected answer %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr, _ := apiRequest(t, db, userID, "POST", "/api/v1/categories", `{"name": "News"}`); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for an existing category, got %d", rr.Code)
+	}
+}
+
+func TestAPITokens(t *testing.T) {
+	db, cleanup := setupPostTestDB(t)
+	defer cleanup()
+
+	userID := setupTestUser(t, db)
+	postID := setupTestPost(t, db, userID, setupTestCategories(t, db))
+	user, _ := models.GetUserByID(db, userID)
+	readToken, err := models.CreateAPIToken(db, user, "reader", []string{models.ScopeRead}, time.Time{})
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+	reactToken, err := models.CreateAPIToken(db, user, "reactor", []string{models.ScopeReact}, time.Time{})
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+
+	handler := SessionMiddleware(db, http.HandlerFunc(APIHandler))
+	send := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := createRequestWithDB(method, path, bytes.NewBufferString(body), db)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	reactionPath := fmt.Sprintf("/api/v1/posts/%d/reaction", postID)
+
+	if rr := send("GET", "/api/v1/me", readToken, ""); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"username":"testuser"`) {
+		t.Errorf("Expected the token's user, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := send("GET", "/api/v1/me", "forum_wrong", ""); rr.Code != http.StatusUnauthorized || !strings.Contains(rr.Header().Get("WWW-Authenticate"), "invalid_token") {
+		t.Errorf("Expected 401 for an unknown token, got %d", rr.Code)
+	}
+
+	// Scopes limit what a token can do
+	if rr := send("PUT", reactionPath, readToken, `{"reaction": 1}`); rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), "insufficient_scope") {
+		t.Errorf("Expected 403 without the react scope, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := send("GET", "/api/v1/posts", reactToken, ""); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 without the read scope, got %d", rr.Code)
+	}
+	if rr := send("PUT", reactionPath, reactToken, `{"reaction": 1}`); rr.Code != http.StatusOK {
+		t.Errorf("Expected the react scope to allow reacting, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Admin endpoints need an admin signed in with the admin scope
+	if err := models.SetUserRole(db, userID, models.RoleAdmin); err != nil {
+		t.Fatalf("Failed to set role: %v", err)
+	}
+	user, _ = models.GetUserByID(db, userID)
+	adminToken, err := models.CreateAPIToken(db, user, "admin", []string{models.ScopeAdmin}, time.Time{})
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+	if rr := send("POST", "/api/v1/categories", readToken, `{"name": "News"}`); rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), "insufficient_scope") {
+		t.Errorf("Expected 403 for an admin's token without the admin scope, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := send("POST", "/api/v1/categories", adminToken, `{"name": "News"}`); rr.Code != http.StatusCreated {
+		t.Errorf("Expected the admin scope to allow adding categories, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if exists, _ := models.CategoryExists(db, "News"); !exists {
+		t.Error("Expected the category to be added")
+	}
+
+	// Tokens only sign in API requests
+	req := createRequestWithDB("GET", "/account/settings", nil, db)
+	req.Header.Set("Authorization", "Bearer "+readToken)
+	var signedIn bool
+	SessionMiddleware(db, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signedIn = getUserFromContext(r) != nil
+	})).ServeHTTP(httptest.NewRecorder(), req)
+	if signedIn {
+		t.Error("Expected tokens not to sign in web pages")
+	}
+}
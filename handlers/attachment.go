@@ -0,0 +1,369 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"forum/models"
+	"forum/storage"
+	"forum/utils"
+
+	"github.com/google/uuid"
+)
+
+// Attachment limits, adjustable at startup
+var (
+	// MaxAttachmentBytes is the largest single file accepted
+	MaxAttachmentBytes int64 = 10 << 20
+	// MaxAttachmentsPerItem is the number of files that can be added to one post or comment
+	MaxAttachmentsPerItem = 5
+	// OrphanAttachmentAge is how long an upload may stay unattached before it is deleted
+	OrphanAttachmentAge = 24 * time.Hour
+	// AttachmentURLExpiry is how long the signed download links handed out for attachments work
+	AttachmentURLExpiry = time.Hour
+)
+
+// AllowedAttachmentTypes lists the sniffed MIME types accepted as attachments
+var AllowedAttachmentTypes = map[string]bool{
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+	"text/plain":      true,
+	"application/zip": true,
+}
+
+// thumbnailSize is the bounding box of generated image thumbnails
+const thumbnailSize = 320
+
+// attachmentRefPattern finds attachment links inside post and comment content
+var attachmentRefPattern = regexp.MustCompile(`/attachments/([0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})`)
+
+// UploadAttachmentHandler stores a single file while a post or comment is being written,
+// so it can be embedded in the content before submitting
+func UploadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	db := getDB(r)
+	user := getUserFromContext(r)
+
+	r.Body = http.MaxBytesReader(w, r.Body, MaxAttachmentBytes+1<<16)
+	if err := r.ParseMultipartForm(MaxAttachmentBytes); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("File must be smaller than %d MB", MaxAttachmentBytes>>20))
+		} else {
+			writeJSONError(w, http.StatusBadRequest, "Invalid upload")
+		}
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	files := r.MultipartForm.File["file"]
+	if len(files) != 1 {
+		writeJSONError(w, http.StatusBadRequest, "Upload exactly one file")
+		return
+	}
+
+	attachment, err := saveAttachment(db, user.ID, files[0])
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Images are embedded, other files are linked
+	snippet := fmt.Sprintf("[%s](%s)", attachment.Filename, attachment.URL())
+	if attachment.IsImage() {
+		snippet = "!" + snippet
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":    attachment.Token,
+		"url":      attachment.URL(),
+		"filename": attachment.Filename,
+		"is_image": attachment.IsImage(),
+		"snippet":  snippet,
+	})
+}
+
+// ServeAttachmentHandler serves an uploaded file or its thumbnail
+func ServeAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	// Extract token from URL path: /attachments/{token}[/thumb]
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/attachments/"), "/")
+	if len(parts) > 2 || (len(parts) == 2 && parts[1] != "thumb") {
+		RenderErrorPage(w, http.StatusNotFound)
+		return
+	}
+
+	db := getDB(r)
+
+	attachment, err := models.GetAttachmentByToken(db, parts[0])
+	if err != nil {
+		RenderErrorPage(w, http.StatusNotFound)
+		return
+	}
+
+	key := attachmentKey(attachment.Token)
+	opts := storage.URLOptions{
+		Expires:     AttachmentURLExpiry,
+		ContentType: attachment.ContentType,
+	}
+	if len(parts) == 2 {
+		if !attachment.HasThumbnail {
+			http.Redirect(w, r, attachment.URL(), http.StatusFound)
+			return
+		}
+		key = thumbnailKey(attachment.Token)
+		opts.ContentType = "image/png"
+	}
+
+	disposition := "attachment"
+	if attachment.IsImage() {
+		disposition = "inline"
+	}
+	opts.ContentDisposition = fmt.Sprintf("%s; filename=%q", disposition, attachment.Filename)
+
+	// Files are downloaded from the storage backend through a short-lived signed link
+	signedURL, err := Store.SignedURL(key, opts)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get attachment: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Browsers may reuse the redirect for a while, but not longer than the link works
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(AttachmentURLExpiry/time.Second)/2))
+	http.Redirect(w, r, signedURL, http.StatusFound)
+}
+
+// CleanOrphanAttachments removes uploads that were never attached, or whose post is gone
+func CleanOrphanAttachments(db *sql.DB) {
+	orphans, err := models.GetOrphanAttachments(db, time.Now().Add(-OrphanAttachmentAge))
+	if err != nil {
+		log.Printf("Failed to get orphan attachments: %v", err)
+		return
+	}
+
+	for _, attachment := range orphans {
+		removeAttachmentFiles(attachment)
+		if err := models.DeleteAttachment(db, attachment.ID); err != nil {
+			log.Printf("Failed to delete attachment %s: %v", attachment.Token, err)
+		}
+	}
+}
+
+// parseUploadForm parses a form that may carry file uploads
+func parseUploadForm(w http.ResponseWriter, r *http.Request) error {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		return r.ParseForm()
+	}
+
+	maxRequest := MaxAttachmentBytes*int64(MaxAttachmentsPerItem) + 1<<20
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequest)
+	return r.ParseMultipartForm(32 << 20)
+}
+
+// saveFormAttachments stores the files uploaded with a post or comment form and returns
+// the tokens of every upload the new content should own: these files, uploads embedded
+// while writing, and uploads listed in the attachment_tokens field.
+func saveFormAttachments(r *http.Request, userID int64, content string) ([]string, error) {
+	db := getDB(r)
+
+	tokens := append(contentAttachmentTokens(content), r.Form["attachment_tokens"]...)
+
+	var files []*multipart.FileHeader
+	if r.MultipartForm != nil {
+		for _, fh := range r.MultipartForm.File["attachments"] {
+			// Browsers send an empty part when no file is chosen
+			if fh.Filename != "" {
+				files = append(files, fh)
+			}
+		}
+	}
+
+	if len(files)+len(uniqueStrings(tokens)) > MaxAttachmentsPerItem {
+		return nil, fmt.Errorf("At most %d attachments are allowed", MaxAttachmentsPerItem)
+	}
+
+	for _, fh := range files {
+		attachment, err := saveAttachment(db, userID, fh)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", fh.Filename, err)
+		}
+		tokens = append(tokens, attachment.Token)
+	}
+
+	return uniqueStrings(tokens), nil
+}
+
+// saveAttachment validates an uploaded file, writes it (and a thumbnail for images)
+// to disk and records it as an unattached upload
+func saveAttachment(db *sql.DB, userID int64, fh *multipart.FileHeader) (*models.Attachment, error) {
+	if fh.Size > MaxAttachmentBytes {
+		return nil, fmt.Errorf("file must be smaller than %d MB", MaxAttachmentBytes>>20)
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, MaxAttachmentBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > MaxAttachmentBytes {
+		return nil, fmt.Errorf("file must be smaller than %d MB", MaxAttachmentBytes>>20)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("file is empty")
+	}
+
+	// Trust the content, not the name or the browser-supplied type
+	contentType := http.DetectContentType(data)
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	if !AllowedAttachmentTypes[contentType] {
+		return nil, fmt.Errorf("files of type %s are not allowed", contentType)
+	}
+
+	attachment := &models.Attachment{
+		Token:       uuid.New().String(),
+		UserID:      userID,
+		Filename:    cleanFilename(fh.Filename),
+		ContentType: contentType,
+		Size:        int64(len(data)),
+	}
+
+	if _, ok := utils.AllowedImageTypes[contentType]; ok {
+		img, _, err := utils.DecodeImage(data)
+		if err != nil {
+			return nil, err
+		}
+		attachment.Width = img.Bounds().Dx()
+		attachment.Height = img.Bounds().Dy()
+
+		if attachment.Width > thumbnailSize || attachment.Height > thumbnailSize {
+			var buf bytes.Buffer
+			if err := utils.EncodePNG(&buf, utils.Fit(img, thumbnailSize, thumbnailSize)); err != nil {
+				return nil, err
+			}
+			if err := Store.Put(thumbnailKey(attachment.Token), buf.Bytes(), "image/png"); err != nil {
+				return nil, err
+			}
+			attachment.HasThumbnail = true
+		}
+	}
+
+	if err := Store.Put(attachmentKey(attachment.Token), data, contentType); err != nil {
+		removeAttachmentFiles(*attachment)
+		return nil, err
+	}
+
+	if _, err := models.CreateAttachment(db, attachment); err != nil {
+		removeAttachmentFiles(*attachment)
+		return nil, err
+	}
+
+	return attachment, nil
+}
+
+func attachmentKey(token string) string {
+	return "attachments/" + token
+}
+
+func thumbnailKey(token string) string {
+	return "attachments/" + token + "_thumb.png"
+}
+
+func removeAttachmentFiles(attachment models.Attachment) {
+	for _, key := range []string{attachmentKey(attachment.Token), thumbnailKey(attachment.Token)} {
+		if err := Store.Delete(key); err != nil {
+			log.Printf("Failed to remove attachment file: %v", err)
+		}
+	}
+}
+
+// cleanFilename keeps only the base name of an uploaded file without control characters
+func cleanFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 32 || r == 127 || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "" || name == "." || name == "/" {
+		return "file"
+	}
+	if len(name) > 200 {
+		name = name[:200]
+	}
+	return name
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	var unique []string
+	for _, v := range values {
+		if v != "" && !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
+
+// Helper to write a JSON error response
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// formatFileSize formats a file size for display
+func formatFileSize(size int64) string {
+	switch {
+	case size >= 1<<20:
+		return strconv.FormatFloat(float64(size)/(1<<20), 'f', 1, 64) + " MB"
+	case size >= 1<<10:
+		return strconv.FormatInt(size>>10, 10) + " KB"
+	}
+	return strconv.FormatInt(size, 10) + " B"
+}
+
+// loadAttachments fills in the attachments of a post and its comments
+func loadAttachments(db *sql.DB, post *models.Post, comments []models.Comment) error {
+	var err error
+	post.Attachments, err = models.GetPostAttachments(db, post.ID)
+	if err != nil {
+		return err
+	}
+
+	byComment, err := models.GetCommentAttachments(db, post.ID)
+	if err != nil {
+		return err
+	}
+	for i := range comments {
+		comments[i].Attachments = byComment[comments[i].ID]
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUploadAttachmentErrors(t *testing.T) {
+	db, cleanup := setupPostTestDB(t)
+	defer cleanup()
+	userID := setupTestUser(t, db)
+
+	defer func(limit int64) { MaxAttachmentBytes = limit }(MaxAttachmentBytes)
+	MaxAttachmentBytes = 1 << 10
+
+	upload := func(contentType string, body *bytes.Buffer) *httptest.ResponseRecorder {
+		req := createAuthenticatedRequest("POST", "/attachments", body, db, userID)
+		req.Header.Set("Content-Type", contentType)
+		rr := httptest.NewRecorder()
+		UploadAttachmentHandler(rr, req)
+		return rr
+	}
+
+	// A body that is not valid multipart is a bad request
+	rr := upload("multipart/form-data; boundary=xyz", bytes.NewBufferString("not multipart at all"))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for a malformed body, got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	// A body over the limit is too large
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("file", "big.txt")
+	part.Write([]byte(strings.Repeat("x", 1<<17)))
+	form.Close()
+	rr = upload(form.FormDataContentType(), &body)
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status %d for a large body, got %d", http.StatusRequestEntityTooLarge, rr.Code)
+	}
+}
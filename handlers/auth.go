@@ -70,6 +70,7 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 			})
 			return
 		}
+		webhookUserRegistered(db, userID)
 
 		// Create session for the new user
 		sessionID, err := utils.CreateSession(db, userID)
@@ -204,7 +205,7 @@ func renderTemplate(w http.ResponseWriter, tmplFile string, data interface{}) {
 	tmplPath := filepath.Join("templates", tmplFile)
 	layoutPath := filepath.Join("templates", "layout.html")
 
-	tmpl, err := template.ParseFiles(layoutPath, tmplPath)
+	tmpl, err := template.New("layout").Funcs(templateFuncs).ParseFiles(layoutPath, tmplPath)
 	if err != nil {
 		log.Printf("Failed to parse template: %v", err)
 		RenderErrorPage(w, http.StatusInternalServerError)
@@ -232,7 +233,7 @@ func RenderErrorPage(w http.ResponseWriter, statusCode int) {
 	tmplPath := filepath.Join("templates", templateFile)
 	layoutPath := filepath.Join("templates", "layout.html")
 
-	tmpl, err := template.ParseFiles(layoutPath, tmplPath)
+	tmpl, err := template.New("layout").Funcs(templateFuncs).ParseFiles(layoutPath, tmplPath)
 	if err != nil {
 		// If error page template fails, fallback to basic text response
 		log.Printf("Failed to parse error template: %v", err)
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"forum/models"
+	"forum/utils"
+)
+
+// MaxAvatarBytes is the largest avatar file accepted for upload
+const MaxAvatarBytes = 5 << 20
+
+// AvatarSizes are the square thumbnail sizes generated for every avatar
+var AvatarSizes = []int{32, 64, 128, 256}
+
+// UploadAvatarHandler handles uploading and removing the logged-in user's avatar
+func UploadAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	db := getDB(r)
+	user := getUserFromContext(r)
+
+	// Parse form, leaving a little room for the other multipart fields
+	r.Body = http.MaxBytesReader(w, r.Body, MaxAvatarBytes+1<<16)
+	err := r.ParseMultipartForm(MaxAvatarBytes)
+	if err != nil {
+		renderAccountSettings(w, r, []string{"Avatar must be smaller than 5 MB"}, "")
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	if r.FormValue("action") == "remove" {
+		if err := models.RemoveAvatar(db, user.ID); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to remove avatar: %v", err), http.StatusInternalServerError)
+			return
+		}
+		removeAvatarFiles(user.ID, 0)
+		http.Redirect(w, r, "/account/settings?success=Avatar+removed", http.StatusSeeOther)
+		return
+	}
+
+	file, _, err := r.FormFile("avatar")
+	if err != nil {
+		renderAccountSettings(w, r, []string{"Choose an image to upload"}, "")
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		http.Error(w, "Failed to read upload", http.StatusBadRequest)
+		return
+	}
+
+	img, _, err := utils.DecodeImage(data)
+	if err != nil {
+		renderAccountSettings(w, r, []string{err.Error()}, "")
+		return
+	}
+	img = utils.CropSquare(img)
+
+	// Encode every size before touching the stored avatar
+	encoded := make(map[int][]byte, len(AvatarSizes))
+	for _, size := range AvatarSizes {
+		var buf bytes.Buffer
+		if err := utils.EncodePNG(&buf, utils.Resize(img, size, size)); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to process avatar: %v", err), http.StatusInternalServerError)
+			return
+		}
+		encoded[size] = buf.Bytes()
+	}
+
+	version, err := models.BumpAvatarVersion(db, user.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to save avatar: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	for size, data := range encoded {
+		if err := Store.Put(avatarKey(user.ID, version, size), data, "image/png"); err != nil {
+			log.Printf("Failed to write avatar: %v", err)
+			models.RemoveAvatar(db, user.ID)
+			http.Error(w, "Failed to save avatar", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	removeAvatarFiles(user.ID, version)
+
+	http.Redirect(w, r, "/account/settings?success=Avatar+updated", http.StatusSeeOther)
+}
+
+// AvatarHandler serves a user's avatar, or their identicon if they have not uploaded one
+func AvatarHandler(w http.ResponseWriter, r *http.Request) {
+	// Extract user ID and size from URL path: /avatar/{userID}/{size}
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) != 4 {
+		http.NotFound(w, r)
+		return
+	}
+
+	userID, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	size, err := strconv.Atoi(parts[3])
+	if err != nil || !validAvatarSize(size) {
+		http.NotFound(w, r)
+		return
+	}
+
+	db := getDB(r)
+
+	version, err := models.GetAvatarVersion(db, userID)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if version < 0 {
+		version = 0
+	}
+
+	// Versioned URLs never change content, anything else may change on the next upload
+	if r.URL.Query().Get("v") == strconv.Itoa(version) {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=300")
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	if version > 0 {
+		data, err := Store.Get(avatarKey(userID, version, size))
+		if err == nil {
+			w.Write(data)
+			return
+		}
+		log.Printf("Avatar file missing for user %d: %v", userID, err)
+	}
+
+	if err := utils.EncodePNG(w, utils.Identicon(userID, size)); err != nil {
+		log.Printf("Failed to write identicon: %v", err)
+	}
+}
+
+// avatarURL returns the cacheable URL of a user's avatar at the given size
+func avatarURL(userID int64, version int, size int) string {
+	if version < 0 {
+		version = 0
+	}
+	return fmt.Sprintf("/avatar/%d/%d?v=%d", userID, size, version)
+}
+
+func validAvatarSize(size int) bool {
+	for _, s := range AvatarSizes {
+		if s == size {
+			return true
+		}
+	}
+	return false
+}
+
+func avatarKey(userID int64, version, size int) string {
+	return fmt.Sprintf("avatars/%d-%d-%d.png", userID, version, size)
+}
+
+// removeAvatarFiles deletes all stored avatar images of a user except the kept version
+func removeAvatarFiles(userID int64, keepVersion int) {
+	keys, err := Store.List(fmt.Sprintf("avatars/%d-", userID))
+	if err != nil {
+		log.Printf("Failed to list avatar files: %v", err)
+		return
+	}
+	keepPrefix := fmt.Sprintf("avatars/%d-%d-", userID, keepVersion)
+	for _, key := range keys {
+		if keepVersion > 0 && strings.HasPrefix(key, keepPrefix) {
+			continue
+		}
+		if err := Store.Delete(key); err != nil {
+			log.Printf("Failed to remove avatar file: %v", err)
+		}
+	}
+}
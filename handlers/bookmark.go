@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"forum/models"
+)
+
+// savedPageSize is the number of bookmarks shown per page
+const savedPageSize = 20
+
+// SavedPostsHandler lists the posts and comments the logged-in user bookmarked
+func SavedPostsHandler(w http.ResponseWriter, r *http.Request) {
+	db := getDB(r)
+	user := getUserFromContext(r)
+
+	folders, err := models.GetBookmarkFolders(db, user.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get folders: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Optional folder filter
+	var folderID int64
+	var currentFolder *models.BookmarkFolder
+	if folderStr := r.URL.Query().Get("folder"); folderStr != "" {
+		folderID, err = strconv.ParseInt(folderStr, 10, 64)
+		if err != nil {
+			RenderErrorPage(w, http.StatusNotFound)
+			return
+		}
+		for i := range folders {
+			if folders[i].ID == folderID {
+				currentFolder = &folders[i]
+			}
+		}
+		if currentFolder == nil {
+			RenderErrorPage(w, http.StatusNotFound)
+			return
+		}
+	}
+
+	sort := r.URL.Query().Get("sort")
+	if sort != models.BookmarkSortOldest && sort != models.BookmarkSortPosted {
+		sort = models.BookmarkSortNewest
+	}
+	page := pageParam(r, "page")
+
+	bookmarks, total, err := models.GetBookmarks(db, user.ID, folderID, sort, savedPageSize, (page-1)*savedPageSize)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get bookmarks: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Bookmarks":     bookmarks,
+		"Folders":       folders,
+		"FolderID":      folderID,
+		"CurrentFolder": currentFolder,
+		"Sort":          sort,
+		"Page":          page,
+		"HasMore":       page*savedPageSize < total,
+		"Total":         total,
+		"User":          user,
+		"ErrorMsg":      r.URL.Query().Get("error"),
+	}
+
+	renderTemplate(w, "saved.html", data)
+}
+
+// ToggleBookmarkHandler saves a post or comment for later, or removes the bookmark
+func ToggleBookmarkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	db := getDB(r)
+	user := getUserFromContext(r)
+
+	targetType := r.FormValue("target_type")
+	targetID, err := strconv.ParseInt(r.FormValue("target_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid bookmark target", http.StatusBadRequest)
+		return
+	}
+	folderID, err := optionalID(r.FormValue("folder_id"))
+	if err != nil {
+		http.Error(w, "Invalid folder ID", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := models.ToggleBookmark(db, user.ID, targetType, targetID, folderID); err != nil {
+		switch err.Error() {
+		case "post not found", "comment not found", "folder not found":
+			http.NotFound(w, r)
+		case "invalid bookmark type":
+			http.Error(w, "Invalid bookmark target", http.StatusBadRequest)
+		default:
+			http.Error(w, fmt.Sprintf("Failed to save bookmark: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	redirectBack(w, r, "/posts/saved")
+}
+
+// MoveBookmarkHandler moves a bookmark into a folder, or out of its folder
+func MoveBookmarkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	db := getDB(r)
+	user := getUserFromContext(r)
+
+	bookmarkID, err := strconv.ParseInt(r.FormValue("bookmark_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid bookmark ID", http.StatusBadRequest)
+		return
+	}
+	folderID, err := optionalID(r.FormValue("folder_id"))
+	if err != nil {
+		http.Error(w, "Invalid folder ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := models.MoveBookmark(db, user.ID, bookmarkID, folderID); err != nil {
+		if err.Error() == "bookmark not found" || err.Error() == "folder not found" {
+			http.NotFound(w, r)
+		} else {
+			http.Error(w, fmt.Sprintf("Failed to move bookmark: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	redirectBack(w, r, "/posts/saved")
+}
+
+// CreateBookmarkFolderHandler adds a bookmark folder for the logged-in user
+func CreateBookmarkFolderHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	db := getDB(r)
+	user := getUserFromContext(r)
+
+	folderID, err := models.CreateBookmarkFolder(db, user.ID, r.FormValue("name"))
+	if err != nil {
+		switch err.Error() {
+		case "folder name is required", "folder name is too long", "folder already exists":
+			http.Redirect(w, r, "/posts/saved?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+		default:
+			http.Error(w, fmt.Sprintf("Failed to create folder: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/posts/saved?folder=%d", folderID), http.StatusSeeOther)
+}
+
+// DeleteBookmarkFolderHandler removes a bookmark folder, keeping its bookmarks
+func DeleteBookmarkFolderHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	db := getDB(r)
+	user := getUserFromContext(r)
+
+	folderID, err := strconv.ParseInt(r.FormValue("folder_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid folder ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := models.DeleteBookmarkFolder(db, user.ID, folderID); err != nil {
+		if err.Error() == "folder not found" {
+			http.NotFound(w, r)
+		} else {
+			http.Error(w, fmt.Sprintf("Failed to delete folder: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	http.Redirect(w, r, "/posts/saved", http.StatusSeeOther)
+}
+
+// loadBookmarks marks the post and comments the user has bookmarked
+func loadBookmarks(db *sql.DB, userID int64, post *models.Post, comments []models.Comment) error {
+	if userID == 0 {
+		return nil
+	}
+
+	saved, savedComments, err := models.GetPostBookmarks(db, userID, post.ID)
+	if err != nil {
+		return err
+	}
+	post.Bookmarked = saved
+	for i := range comments {
+		comments[i].Bookmarked = savedComments[comments[i].ID]
+	}
+	return nil
+}
+
+// redirectBack sends the user back to the page they came from, or to fallback
+func redirectBack(w http.ResponseWriter, r *http.Request, fallback string) {
+	redirectURL := fallback
+	if referer := r.Header.Get("Referer"); referer != "" {
+		redirectURL = referer
+	}
+	http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+}
+
+// optionalID parses an ID form value, where an empty value means none
+func optionalID(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
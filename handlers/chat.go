@@ -0,0 +1,390 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"forum/live"
+	"forum/models"
+	"forum/utils"
+	"forum/websocket"
+)
+
+// Chat connection limits
+const (
+	chatHistorySize    = 50
+	chatPingInterval   = 30 * time.Second
+	chatReadTimeout    = 75 * time.Second // two missed pings
+	chatWriteTimeout   = 10 * time.Second
+	chatMaxMessageSize = 8 << 10
+)
+
+// chatLimiter allows each user a few chat messages at a time, across all rooms
+var chatLimiter = utils.NewRateLimiter(5, 10*time.Second)
+
+// typingLimiter keeps typing indicators from flooding a room
+var typingLimiter = utils.NewRateLimiter(1, 2*time.Second)
+
+// chatRooms tracks who is connected to each chat room
+var chatRooms = &chatPresence{rooms: make(map[int64]map[int64]*chatMember)}
+
+// chatRequest is a message from a chat client
+type chatRequest struct {
+	Type    string `json:"type"`    // "message", "typing", "delete", "mute" or "unmute"
+	Content string `json:"content"` // message text
+	ID      int64  `json:"id"`      // message to delete
+	UserID  int64  `json:"user_id"` // user to mute or unmute
+	Minutes int    `json:"minutes"` // how long to mute for
+}
+
+// chatEvent is what chat clients receive
+type chatEvent struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+type chatMessagePayload struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	Username  string    `json:"username"`
+	AvatarURL string    `json:"avatar_url"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type chatUserPayload struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+}
+
+type chatMutePayload struct {
+	UserID   int64     `json:"user_id"`
+	Username string    `json:"username"`
+	Until    time.Time `json:"until,omitempty"`
+}
+
+// ChatPageHandler shows the chat room of a category
+func ChatPageHandler(w http.ResponseWriter, r *http.Request) {
+	db := getDB(r)
+	user := getUserFromContext(r)
+
+	categoryID, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/chat/"), 10, 64)
+	if err != nil {
+		RenderErrorPage(w, http.StatusNotFound)
+		return
+	}
+	category, err := models.GetCategoryByID(db, categoryID)
+	if err == sql.ErrNoRows {
+		RenderErrorPage(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get category: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	mutedUntil, muted, err := models.ChatMutedUntil(db, categoryID, user.ID, time.Now())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get chat status: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	renderTemplate(w, "chat.html", map[string]interface{}{
+		"Title":      category.Name + " Chat",
+		"Category":   category,
+		"Muted":      muted,
+		"MutedUntil": mutedUntil,
+		"MaxLength":  models.MaxChatMessageLength,
+		"User":       user,
+	})
+}
+
+// ChatSocketHandler connects a logged-in user to the chat room of ?category= over a WebSocket
+func ChatSocketHandler(w http.ResponseWriter, r *http.Request) {
+	db := getDB(r)
+
+	// Browsers cannot set headers on WebSocket requests, so the session cookie is checked here
+	cookie, err := r.Cookie("session_id")
+	if err != nil {
+		http.Error(w, "Login required", http.StatusUnauthorized)
+		return
+	}
+	userID, err := utils.ValidateSession(db, cookie.Value)
+	if err != nil {
+		http.Error(w, "Login required", http.StatusUnauthorized)
+		return
+	}
+	user, err := models.GetUserByID(db, userID)
+	if err != nil {
+		http.Error(w, "Login required", http.StatusUnauthorized)
+		return
+	}
+
+	categoryID, err := strconv.ParseInt(r.URL.Query().Get("category"), 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if _, err := models.GetCategoryByID(db, categoryID); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	history, err := models.GetChatHistory(db, categoryID, chatHistorySize)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get chat history: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.MaxMessageSize = chatMaxMessageSize
+	conn.ReadTimeout = chatReadTimeout
+
+	topic := chatTopic(categoryID)
+	subscriber := live.Default.Subscribe(topic)
+	defer live.Default.Unsubscribe(subscriber)
+
+	messages := make([]chatMessagePayload, 0, len(history))
+	for _, m := range history {
+		messages = append(messages, newChatMessagePayload(m))
+	}
+	if writeChat(conn, chatEvent{Type: "history", Data: messages}) != nil {
+		return
+	}
+
+	// Everyone in the room, the new user included, hears who is here now
+	publishChat(topic, "presence", chatRooms.join(categoryID, user))
+	defer func() {
+		publishChat(topic, "presence", chatRooms.leave(categoryID, user.ID))
+	}()
+
+	// Room events and pings are written by a second goroutine while this one reads
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ping := time.NewTicker(chatPingInterval)
+		defer ping.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case event, ok := <-subscriber.Events:
+				if !ok {
+					// Too far behind; closing makes the client reconnect and reload the history
+					conn.WriteClose(websocket.CloseGoingAway, "too slow")
+					conn.Close()
+					return
+				}
+				conn.SetWriteDeadline(time.Now().Add(chatWriteTimeout))
+				if err := conn.WriteJSON(chatEvent{Type: event.Name, Data: json.RawMessage(event.Data)}); err != nil {
+					conn.Close()
+					return
+				}
+			case <-ping.C:
+				conn.SetWriteDeadline(time.Now().Add(chatWriteTimeout))
+				if err := conn.WritePing(); err != nil {
+					conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var request chatRequest
+		if json.Unmarshal(data, &request) != nil {
+			err = errors.New("invalid request")
+		} else {
+			err = handleChatRequest(db, user, categoryID, &request)
+		}
+		if err != nil {
+			if writeChat(conn, chatEvent{Type: "error", Data: map[string]string{"error": err.Error()}}) != nil {
+				return
+			}
+		}
+	}
+}
+
+// handleChatRequest carries out what a chat client asked for. Errors are meant for the user.
+func handleChatRequest(db *sql.DB, user *models.User, categoryID int64, request *chatRequest) error {
+	topic := chatTopic(categoryID)
+
+	switch request.Type {
+	case "message":
+		if !chatLimiter.Allow(strconv.FormatInt(user.ID, 10)) {
+			return errors.New("you are sending messages too quickly")
+		}
+		message, err := models.CreateChatMessage(db, categoryID, user.ID, request.Content)
+		if err != nil {
+			return chatError(err)
+		}
+		publishChat(topic, "message", newChatMessagePayload(*message))
+
+	case "typing":
+		if !typingLimiter.Allow(strconv.FormatInt(user.ID, 10)) {
+			return nil
+		}
+		if _, muted, err := models.ChatMutedUntil(db, categoryID, user.ID, time.Now()); err != nil || muted {
+			return nil
+		}
+		publishChat(topic, "typing", chatUserPayload{ID: user.ID, Username: user.Username})
+
+	case "delete":
+		message, err := models.GetChatMessage(db, request.ID)
+		if err != nil {
+			return chatError(err)
+		}
+		if message.CategoryID != categoryID {
+			return errors.New("message not found")
+		}
+		if _, err := models.DeleteChatMessage(db, message.ID, user); err != nil {
+			return chatError(err)
+		}
+		publishChat(topic, "delete", map[string]int64{"id": message.ID})
+
+	case "mute":
+		if request.Minutes <= 0 {
+			return errors.New("choose how long to mute for")
+		}
+		until := time.Now().Add(time.Duration(request.Minutes) * time.Minute)
+		if err := models.MuteChatUser(db, categoryID, request.UserID, user, until); err != nil {
+			return chatError(err)
+		}
+		target, err := models.GetUserByID(db, request.UserID)
+		if err != nil {
+			return chatError(err)
+		}
+		publishChat(topic, "mute", chatMutePayload{UserID: target.ID, Username: target.Username, Until: until.UTC()})
+
+	case "unmute":
+		if err := models.UnmuteChatUser(db, categoryID, request.UserID, user); err != nil {
+			return chatError(err)
+		}
+		target, err := models.GetUserByID(db, request.UserID)
+		if err != nil {
+			return chatError(err)
+		}
+		publishChat(topic, "unmute", chatMutePayload{UserID: target.ID, Username: target.Username})
+
+	default:
+		return errors.New("unknown request")
+	}
+	return nil
+}
+
+// chatError hides unexpected errors from chat clients
+func chatError(err error) error {
+	switch err.Error() {
+	case "message is empty", "message is too long", "category not found", "you are muted in this chat",
+		"message not found", "you cannot delete this message", "only moderators can mute users",
+		"users can be muted for at most a week", "user not found", "moderators cannot be muted":
+		return err
+	}
+	log.Printf("Chat request failed: %v", err)
+	return errors.New("something went wrong, please try again")
+}
+
+// chatTopic is the live topic of a category's chat room
+func chatTopic(categoryID int64) string {
+	return fmt.Sprintf("chat:%d", categoryID)
+}
+
+func publishChat(topic, name string, data interface{}) {
+	if err := live.Default.Publish(topic, name, data); err != nil {
+		log.Printf("Failed to publish chat event: %v", err)
+	}
+}
+
+func writeChat(conn *websocket.Conn, event chatEvent) error {
+	conn.SetWriteDeadline(time.Now().Add(chatWriteTimeout))
+	return conn.WriteJSON(event)
+}
+
+func newChatMessagePayload(m models.ChatMessage) chatMessagePayload {
+	return chatMessagePayload{
+		ID:        m.ID,
+		UserID:    m.UserID,
+		Username:  m.Username,
+		AvatarURL: avatarURL(m.UserID, m.AvatarVersion, 32),
+		Content:   m.Content,
+		CreatedAt: m.CreatedAt,
+	}
+}
+
+// chatPresence counts the connections of each user in each chat room.
+// A user with several tabs open is listed once.
+type chatPresence struct {
+	mu    sync.Mutex
+	rooms map[int64]map[int64]*chatMember
+}
+
+type chatMember struct {
+	username    string
+	connections int
+}
+
+// join adds a connection of the user to a room and returns who is in it
+func (p *chatPresence) join(categoryID int64, user *models.User) []chatUserPayload {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	room := p.rooms[categoryID]
+	if room == nil {
+		room = make(map[int64]*chatMember)
+		p.rooms[categoryID] = room
+	}
+	member := room[user.ID]
+	if member == nil {
+		member = &chatMember{username: user.Username}
+		room[user.ID] = member
+	}
+	member.connections++
+	return p.members(categoryID)
+}
+
+// leave removes a connection of the user from a room and returns who is still in it
+func (p *chatPresence) leave(categoryID, userID int64) []chatUserPayload {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	room := p.rooms[categoryID]
+	if member := room[userID]; member != nil {
+		member.connections--
+		if member.connections <= 0 {
+			delete(room, userID)
+		}
+	}
+	if len(room) == 0 {
+		delete(p.rooms, categoryID)
+	}
+	return p.members(categoryID)
+}
+
+// members lists the users in a room by name. The caller holds p.mu.
+func (p *chatPresence) members(categoryID int64) []chatUserPayload {
+	users := []chatUserPayload{}
+	for id, member := range p.rooms[categoryID] {
+		users = append(users, chatUserPayload{ID: id, Username: member.username})
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return strings.ToLower(users[i].Username) < strings.ToLower(users[j].Username)
+	})
+	return users
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 
@@ -16,12 +17,15 @@ func CreateCommentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse form
-	err := r.ParseForm()
+	// Parse form, which may include attached files
+	err := parseUploadForm(w, r)
 	if err != nil {
 		http.Error(w, "Failed to parse form", http.StatusBadRequest)
 		return
 	}
+	if r.MultipartForm != nil {
+		defer r.MultipartForm.RemoveAll()
+	}
 
 	// Extract form values
 	postIDStr := r.FormValue("post_id")
@@ -43,13 +47,26 @@ func CreateCommentHandler(w http.ResponseWriter, r *http.Request) {
 	db := getDB(r)
 	user := getUserFromContext(r)
 
+	attachmentTokens, err := saveFormAttachments(r, user.ID, content)
+	if err != nil {
+		http.Redirect(w, r, fmt.Sprintf("/post/%d?error=%s", postID, url.QueryEscape(err.Error())), http.StatusSeeOther)
+		return
+	}
+
 	// Create the comment
-	_, err = models.CreateComment(db, content, user.ID, postID)
+	commentID, err := models.CreateComment(db, content, user.ID, postID)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to create comment: %v", err), http.StatusInternalServerError)
 		return
 	}
 
+	if _, err := models.AttachToComment(db, attachmentTokens, user.ID, postID, commentID); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to save attachments: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	notifyNewComment(db, postID, commentID, user.ID, content)
+
 	// Redirect back to the post
 	http.Redirect(w, r, fmt.Sprintf("/post/%d", postID), http.StatusSeeOther)
 }
@@ -100,6 +117,7 @@ func ReactCommentHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("Failed to save reaction: %v", err), http.StatusInternalServerError)
 		return
 	}
+	notifyReaction(db, user.ID, "comment", commentID)
 
 	// Redirect back to the post
 	http.Redirect(w, r, fmt.Sprintf("/post/%d", postID), http.StatusSeeOther)
@@ -0,0 +1,332 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"forum/models"
+)
+
+// DraftSchedulerInterval is how often scheduled drafts are checked for publishing
+var DraftSchedulerInterval = time.Minute
+
+// MaxAutosaveBytes is the largest autosave request accepted
+var MaxAutosaveBytes int64 = 1 << 20
+
+// DraftsHandler lists the drafts of the logged-in user
+func DraftsHandler(w http.ResponseWriter, r *http.Request) {
+	db := getDB(r)
+	user := getUserFromContext(r)
+
+	drafts, err := models.GetUserDrafts(db, user.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get drafts: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Drafts":   drafts,
+		"User":     user,
+		"ErrorMsg": r.URL.Query().Get("error"),
+	}
+
+	renderTemplate(w, "drafts.html", data)
+}
+
+// AutosaveDraftHandler saves the post editor contents as a draft and answers with JSON
+func AutosaveDraftHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	db := getDB(r)
+	user := getUserFromContext(r)
+
+	r.Body = http.MaxBytesReader(w, r.Body, MaxAutosaveBytes)
+	if err := r.ParseForm(); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Failed to parse form")
+		return
+	}
+
+	draft, err := draftFromForm(r, user.ID)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Nothing has been written yet
+	if draft.ID == 0 && draft.Title == "" && draft.Content == "" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": 0})
+		return
+	}
+
+	// Autosaving keeps the schedule of an existing draft
+	if draft.ID > 0 {
+		existing, err := models.GetDraft(db, draft.ID, user.ID)
+		if err != nil {
+			writeJSONError(w, http.StatusNotFound, "Draft not found")
+			return
+		}
+		draft.PublishAt = existing.PublishAt
+	}
+
+	if err := models.SaveDraft(db, draft); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to save draft")
+		return
+	}
+
+	// Keep images inserted while writing from being cleaned up as orphans
+	if _, err := models.AttachToDraft(db, contentAttachmentTokens(draft.Content), user.ID, draft.ID); err != nil {
+		log.Printf("Failed to keep uploads with draft %d: %v", draft.ID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":       draft.ID,
+		"saved_at": draft.UpdatedAt.Format(time.RFC3339),
+	})
+}
+
+// DeleteDraftHandler discards one of the user's drafts
+func DeleteDraftHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	db := getDB(r)
+	user := getUserFromContext(r)
+
+	draftID, err := strconv.ParseInt(r.FormValue("draft_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid draft ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := models.DeleteDraft(db, draftID, user.ID); err != nil {
+		if err.Error() == "draft not found" {
+			http.NotFound(w, r)
+		} else {
+			http.Error(w, fmt.Sprintf("Failed to delete draft: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	http.Redirect(w, r, "/drafts", http.StatusSeeOther)
+}
+
+// PublishDraftHandler publishes one of the user's drafts right away
+func PublishDraftHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	db := getDB(r)
+	user := getUserFromContext(r)
+
+	draftID, err := strconv.ParseInt(r.FormValue("draft_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid draft ID", http.StatusBadRequest)
+		return
+	}
+
+	draft, err := models.GetDraft(db, draftID, user.ID)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if problems := draft.Validate(); len(problems) > 0 {
+		http.Redirect(w, r, "/drafts?error="+url.QueryEscape(strings.Join(problems, ". ")), http.StatusSeeOther)
+		return
+	}
+
+	postID, err := models.PublishDraft(db, draft)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to publish draft: %v", err), http.StatusInternalServerError)
+		return
+	}
+	notifyNewPost(db, postID, user.ID, draft.Content)
+
+	http.Redirect(w, r, fmt.Sprintf("/post/%d", postID), http.StatusSeeOther)
+}
+
+// PublishDueDrafts publishes scheduled drafts whose time has come. Drafts that cannot be
+// published are unscheduled with the reason, so their authors can fix them.
+func PublishDueDrafts(db *sql.DB) {
+	drafts, err := models.GetDueDrafts(db, time.Now())
+	if err != nil {
+		log.Printf("Failed to get scheduled drafts: %v", err)
+		return
+	}
+
+	for i := range drafts {
+		draft := &drafts[i]
+		postID, err := models.PublishDraft(db, draft)
+		if err == nil {
+			log.Printf("Published scheduled draft %d as post %d", draft.ID, postID)
+			notifyNewPost(db, postID, draft.UserID, draft.Content)
+			continue
+		}
+
+		log.Printf("Failed to publish scheduled draft %d: %v", draft.ID, err)
+		draft.PublishAt = time.Time{}
+		draft.LastError = err.Error()
+		if err := models.SaveDraft(db, draft); err != nil {
+			log.Printf("Failed to unschedule draft %d: %v", draft.ID, err)
+		}
+	}
+}
+
+// saveDraftForm stores the post form as a draft, scheduled for publishing when action is "schedule"
+func saveDraftForm(w http.ResponseWriter, r *http.Request, user *models.User, action string) {
+	db := getDB(r)
+
+	draft, err := draftFromForm(r, user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var problems []string
+	if action == "schedule" {
+		problems = draft.Validate()
+		publishAt, err := parsePublishAt(r.FormValue("publish_at"), r.FormValue("timezone_offset"))
+		switch {
+		case err != nil:
+			problems = append(problems, "Choose a valid publishing time")
+		case !publishAt.After(time.Now()):
+			problems = append(problems, "The publishing time must be in the future")
+		case draft.Poll != nil && !draft.Poll.ClosesAt.IsZero() && !draft.Poll.ClosesAt.After(publishAt):
+			problems = append(problems, "The poll must close after the post is published")
+		default:
+			draft.PublishAt = publishAt
+		}
+	} else if draft.Title == "" && draft.Content == "" {
+		problems = append(problems, "Write a title or some content before saving a draft")
+	}
+
+	var attachmentTokens []string
+	if len(problems) == 0 {
+		attachmentTokens, err = saveFormAttachments(r, user.ID, draft.Content)
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	if len(problems) > 0 {
+		renderPostForm(w, db, user, draft, problems)
+		return
+	}
+
+	if err := models.SaveDraft(db, draft); err != nil {
+		if err.Error() == "draft not found" {
+			http.NotFound(w, r)
+		} else {
+			http.Error(w, fmt.Sprintf("Failed to save draft: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if _, err := models.AttachToDraft(db, attachmentTokens, user.ID, draft.ID); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to save attachments: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/drafts", http.StatusSeeOther)
+}
+
+// renderPostForm shows the post editor filled in from a draft
+func renderPostForm(w http.ResponseWriter, db *sql.DB, user *models.User, draft *models.Draft, errors []string) {
+	categories, err := models.GetAllCategories(db)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get categories: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var attachments []models.Attachment
+	if draft.ID > 0 {
+		attachments, err = models.GetDraftAttachments(db, draft.ID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get attachments: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	data := map[string]interface{}{
+		"Errors":           errors,
+		"Draft":            draft,
+		"DraftAttachments": attachments,
+		"Categories":       categories,
+		"User":             user,
+	}
+
+	renderTemplate(w, "create_post.html", data)
+}
+
+// draftFromForm reads the post editor fields
+func draftFromForm(r *http.Request, userID int64) (*models.Draft, error) {
+	draft := &models.Draft{
+		UserID:  userID,
+		Title:   strings.TrimSpace(r.FormValue("title")),
+		Content: strings.TrimSpace(r.FormValue("content")),
+	}
+
+	if idStr := r.FormValue("draft_id"); idStr != "" {
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid draft ID")
+		}
+		draft.ID = id
+	}
+
+	for _, idStr := range r.Form["categories"] {
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid category ID")
+		}
+		draft.CategoryIDs = append(draft.CategoryIDs, id)
+	}
+
+	poll, err := pollFromForm(r)
+	if err != nil {
+		return nil, err
+	}
+	draft.Poll = poll
+
+	return draft, nil
+}
+
+// parsePublishAt reads a datetime-local value. The browser sends its offset from UTC in
+// minutes, as returned by Date.getTimezoneOffset; without it the time is taken as UTC.
+func parsePublishAt(value, offset string) (time.Time, error) {
+	minutes := 0
+	if offset != "" {
+		var err error
+		minutes, err = strconv.Atoi(offset)
+		if err != nil || minutes < -14*60 || minutes > 14*60 {
+			return time.Time{}, fmt.Errorf("invalid timezone offset")
+		}
+	}
+	location := time.FixedZone("", -minutes*60)
+	return time.ParseInLocation("2006-01-02T15:04", value, location)
+}
+
+// contentAttachmentTokens returns the tokens of uploads referenced in post content
+func contentAttachmentTokens(content string) []string {
+	var tokens []string
+	for _, match := range attachmentRefPattern.FindAllStringSubmatch(content, -1) {
+		tokens = append(tokens, match[1])
+	}
+	return uniqueStrings(tokens)
+}
@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	texttemplate "text/template"
+	"time"
+
+	"forum/mail"
+	"forum/models"
+)
+
+// EmailSender delivers the emails in the outbox. It is set by main.
+var EmailSender mail.Sender = &mail.Log{}
+
+// BaseURL is the address of the site used for links in emails, without a trailing slash
+var BaseURL = "http://localhost:3000"
+
+// EmailWorkerInterval is how often notification emails, digests and the outbox are processed
+const EmailWorkerInterval = time.Minute
+
+// outboxBatchSize is the largest number of emails sent in one pass
+const outboxBatchSize = 50
+
+// outboxRetention is how long sent and failed emails are kept in the outbox
+const outboxRetention = 7 * 24 * time.Hour
+
+// emailListNames describes the email lists on the unsubscribe page
+var emailListNames = map[string]string{
+	models.EmailListReplies:  "reply emails",
+	models.EmailListMentions: "mention emails",
+	models.EmailListDigest:   "digest emails",
+	models.EmailListAll:      "all emails",
+}
+
+// UnsubscribeHandler turns off an email list from the link in an email. Mail clients
+// that support one-click unsubscribe POST to the same link.
+func UnsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	db := getDB(r)
+	list := r.FormValue("list")
+
+	_, err := models.Unsubscribe(db, r.FormValue("token"), list)
+	if err != nil {
+		switch err.Error() {
+		case "invalid unsubscribe link", "invalid email list":
+			w.WriteHeader(http.StatusBadRequest)
+			renderTemplate(w, "unsubscribed.html", map[string]interface{}{
+				"User":  getUserFromContext(r),
+				"Error": "This unsubscribe link is not valid",
+			})
+		default:
+			http.Error(w, fmt.Sprintf("Failed to unsubscribe: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	renderTemplate(w, "unsubscribed.html", map[string]interface{}{
+		"User":     getUserFromContext(r),
+		"List":     list,
+		"ListName": emailListNames[list],
+		"Token":    r.FormValue("token"),
+	})
+}
+
+// QueueNotificationEmails renders emails for new replies and mentions into the outbox
+func QueueNotificationEmails(db *sql.DB) {
+	notifications, upTo, err := models.GetPendingNotificationEmails(db)
+	if err != nil {
+		log.Printf("Failed to get notification emails: %v", err)
+		return
+	}
+
+	for _, n := range notifications {
+		list := models.EmailListReplies
+		if n.Type == models.NotifyMention {
+			list = models.EmailListMentions
+		}
+		data := map[string]interface{}{
+			"Notification": n,
+			"URL":          BaseURL + n.URL(),
+		}
+		if err := queueEmail(db, n.UserID, list, n.Message(), "notification", data); err != nil {
+			log.Printf("Failed to queue email for notification %d: %v", n.ID, err)
+		}
+	}
+
+	// Notifications that failed to render are not retried, so nobody gets an email twice
+	if upTo > 0 {
+		if err := models.ClearPendingNotificationEmails(db, upTo); err != nil {
+			log.Printf("Failed to clear notification emails: %v", err)
+		}
+	}
+}
+
+// SendEmailDigests queues the daily and weekly digests that are due. A digest
+// with no activity to report is skipped but still counts as sent.
+func SendEmailDigests(db *sql.DB, now time.Time) {
+	recipients, err := models.GetDueDigests(db, now)
+	if err != nil {
+		log.Printf("Failed to get due digests: %v", err)
+		return
+	}
+
+	for _, recipient := range recipients {
+		notifications, err := models.GetDigestNotifications(db, recipient.UserID, recipient.Since)
+		if err != nil {
+			log.Printf("Failed to get digest for user %d: %v", recipient.UserID, err)
+			continue
+		}
+
+		if len(notifications) > 0 {
+			subject := fmt.Sprintf("Your %s forum digest", recipient.Frequency)
+			data := map[string]interface{}{
+				"Frequency":     recipient.Frequency,
+				"Notifications": notifications,
+			}
+			if err := queueEmail(db, recipient.UserID, models.EmailListDigest, subject, "digest", data); err != nil {
+				log.Printf("Failed to queue digest for user %d: %v", recipient.UserID, err)
+				continue
+			}
+		}
+
+		if err := models.MarkDigestSent(db, recipient.UserID, now); err != nil {
+			log.Printf("Failed to update digest for user %d: %v", recipient.UserID, err)
+		}
+	}
+}
+
+// DeliverOutbox sends the outbox emails that are due, scheduling failed ones for a retry
+func DeliverOutbox(db *sql.DB) {
+	emails, err := models.GetDueEmails(db, time.Now(), outboxBatchSize)
+	if err != nil {
+		log.Printf("Failed to get outbox emails: %v", err)
+		return
+	}
+
+	for i := range emails {
+		email := &emails[i]
+		msg := &mail.Message{
+			To:      email.To,
+			Subject: email.Subject,
+			Text:    email.Text,
+			HTML:    email.HTML,
+			Headers: map[string]string{
+				"List-Unsubscribe":      "<" + email.UnsubscribeURL + ">",
+				"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+			},
+		}
+
+		if err := EmailSender.Send(msg); err != nil {
+			gaveUp, markErr := models.MarkEmailFailed(db, email, err, time.Now())
+			if markErr != nil {
+				log.Printf("Failed to update outbox email %d: %v", email.ID, markErr)
+			} else if gaveUp {
+				log.Printf("Giving up on email %d to %s: %v", email.ID, email.To, err)
+			} else {
+				log.Printf("Failed to send email %d to %s, will retry: %v", email.ID, email.To, err)
+			}
+			continue
+		}
+
+		if err := models.MarkEmailSent(db, email.ID, time.Now()); err != nil {
+			log.Printf("Failed to update outbox email %d: %v", email.ID, err)
+		}
+	}
+}
+
+// CleanOutbox removes old sent and failed emails from the outbox
+func CleanOutbox(db *sql.DB) {
+	if _, err := models.CleanOutbox(db, time.Now().Add(-outboxRetention)); err != nil {
+		log.Printf("Failed to clean outbox: %v", err)
+	}
+}
+
+// queueEmail renders an email for a user from templates/email/<name>.txt and .html and
+// adds it to the outbox. The footer links to unsubscribing from the given list.
+func queueEmail(db *sql.DB, userID int64, list, subject, name string, data map[string]interface{}) error {
+	user, err := models.GetUserByID(db, userID)
+	if err != nil {
+		return err
+	}
+	return queueEmailTo(db, user, user.Email, list, subject, name, data)
+}
+
+// queueEmailTo is queueEmail for an address other than the user's current one
+func queueEmailTo(db *sql.DB, user *models.User, to, list, subject, name string, data map[string]interface{}) error {
+	token, err := models.GetEmailToken(db, user.ID)
+	if err != nil {
+		return err
+	}
+
+	data["Username"] = user.Username
+	data["Subject"] = subject
+	data["SiteURL"] = BaseURL
+	data["SettingsURL"] = BaseURL + "/account/settings#notifications-form"
+	data["UnsubscribeURL"] = BaseURL + "/email/unsubscribe?token=" + url.QueryEscape(token) + "&list=" + url.QueryEscape(list)
+	data["ListName"] = emailListNames[list]
+
+	text, html, err := renderEmail(name, data)
+	if err != nil {
+		return err
+	}
+
+	return models.QueueEmail(db, &models.OutboxEmail{
+		UserID:         user.ID,
+		To:             to,
+		Subject:        subject,
+		Text:           text,
+		HTML:           html,
+		UnsubscribeURL: data["UnsubscribeURL"].(string),
+	})
+}
+
+// renderEmail renders the plain text and HTML versions of an email inside the email layout
+func renderEmail(name string, data interface{}) (string, string, error) {
+	dir := filepath.Join("templates", "email")
+
+	textTmpl, err := texttemplate.New("layout").ParseFiles(filepath.Join(dir, "layout.txt"), filepath.Join(dir, name+".txt"))
+	if err != nil {
+		return "", "", err
+	}
+	var text bytes.Buffer
+	if err := textTmpl.ExecuteTemplate(&text, "layout", data); err != nil {
+		return "", "", err
+	}
+
+	htmlTmpl, err := htmltemplate.New("layout").ParseFiles(filepath.Join(dir, "layout.html"), filepath.Join(dir, name+".html"))
+	if err != nil {
+		return "", "", err
+	}
+	var html bytes.Buffer
+	if err := htmlTmpl.ExecuteTemplate(&html, "layout", data); err != nil {
+		return "", "", err
+	}
+
+	return text.String(), html.String(), nil
+}
@@ -0,0 +1,398 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"forum/models"
+)
+
+// FeedPrefix is the path every feed starts with
+const FeedPrefix = "/feeds/"
+
+// feedSize is how many posts or comments a feed holds
+const feedSize = 20
+
+// Feed formats, picked by the extension of the feed's path
+const (
+	feedRSS  = ".rss"
+	feedAtom = ".atom"
+)
+
+// feed is a list of posts or comments, written out as RSS or Atom
+type feed struct {
+	Title       string
+	Description string
+	Link        string    // the page the feed follows
+	Self        string    // the feed itself
+	Updated     time.Time // when the newest item was published
+	Items       []feedItem
+}
+
+type feedItem struct {
+	Title      string
+	Link       string
+	Author     string
+	Categories []string
+	Content    string // HTML
+	Published  time.Time
+}
+
+// feedLink announces a feed in the head of a page
+type feedLink struct {
+	Title string `json:"title"`
+	Type  string `json:"type"`
+	URL   string `json:"url"`
+}
+
+// feedLinks returns the RSS and Atom versions of a feed, for path without its extension
+func feedLinks(title, path string) []feedLink {
+	return []feedLink{
+		{Title: title + " (RSS)", Type: "application/rss+xml", URL: path + feedRSS},
+		{Title: title + " (Atom)", Type: "application/atom+xml", URL: path + feedAtom},
+	}
+}
+
+// FeedHandler serves RSS and Atom feeds:
+//
+//	/feeds/posts.rss                 the latest posts
+//	/feeds/category/{id}.rss         the latest posts in a category
+//	/feeds/user/{username}.rss       the latest posts by a user
+//	/feeds/post/{id}/comments.rss    the latest comments on a post
+//
+// Each is also served as Atom with the .atom extension. Feeds answer conditional
+// requests with 304 Not Modified when nothing changed.
+func FeedHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, FeedPrefix)
+	var format string
+	for _, ext := range []string{feedRSS, feedAtom} {
+		if strings.HasSuffix(name, ext) {
+			format = ext
+			name = strings.TrimSuffix(name, ext)
+		}
+	}
+	if format == "" {
+		RenderErrorPage(w, http.StatusNotFound)
+		return
+	}
+	parts := strings.Split(name, "/")
+
+	db := getDB(r)
+	var f *feed
+	var err error
+	switch {
+	case name == "posts":
+		f, err = postsFeed(db, models.PostFilter{}, "Latest posts", "The latest posts on the forum", "/")
+	case len(parts) == 2 && parts[0] == "category":
+		f, err = categoryFeed(db, parts[1])
+	case len(parts) == 2 && parts[0] == "user":
+		f, err = userFeed(db, parts[1])
+	case len(parts) == 3 && parts[0] == "post" && parts[2] == "comments":
+		f, err = commentsFeed(db, parts[1])
+	default:
+		RenderErrorPage(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to build feed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if f == nil {
+		RenderErrorPage(w, http.StatusNotFound)
+		return
+	}
+	f.Self = BaseURL + r.URL.Path
+
+	var body []byte
+	contentType := "application/rss+xml; charset=utf-8"
+	if format == feedAtom {
+		body, err = f.atom()
+		contentType = "application/atom+xml; charset=utf-8"
+	} else {
+		body, err = f.rss()
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to write feed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// The ETag covers removed posts and renamed users too, which the date alone would miss.
+	// ServeContent answers If-None-Match and If-Modified-Since.
+	sum := sha256.Sum256(body)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	http.ServeContent(w, r, "", f.Updated, bytes.NewReader(body))
+}
+
+// postsFeed builds a feed of the latest posts matching a filter
+func postsFeed(db *sql.DB, filter models.PostFilter, title, description, page string) (*feed, error) {
+	posts, _, err := models.GetPostPage(db, 0, filter, feedSize, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &feed{Title: title, Description: description, Link: BaseURL + page}
+	for i := range posts {
+		post := &posts[i]
+		var categories []string
+		for _, c := range post.Categories {
+			categories = append(categories, c.Name)
+		}
+		f.add(feedItem{
+			Title:      post.Title,
+			Link:       fmt.Sprintf("/post/%d", post.ID),
+			Author:     post.Username,
+			Categories: categories,
+			Content:    string(renderMarkdown(db, models.RenderedPost, post.ID, post.Content)),
+			Published:  post.CreatedAt,
+		})
+	}
+	return f, nil
+}
+
+// categoryFeed builds the feed of a category, the same posts CategoryPostsHandler lists.
+// It returns nil when there is no such category.
+func categoryFeed(db *sql.DB, id string) (*feed, error) {
+	categoryID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, nil
+	}
+	category, err := models.GetCategoryByID(db, categoryID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return postsFeed(db, models.PostFilter{CategoryID: categoryID},
+		category.Name, "The latest posts in "+category.Name, fmt.Sprintf("/posts/category/%d", categoryID))
+}
+
+// userFeed builds the feed of a user's posts. It returns nil when there is no such user
+// or they hide their posts.
+func userFeed(db *sql.DB, username string) (*feed, error) {
+	if username == models.DeletedUsername {
+		return nil, nil
+	}
+	profile, err := models.GetProfileByUsername(db, username)
+	if err != nil {
+		if err.Error() == "user not found" {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if profile.Hidden[models.ProfileFieldPosts] {
+		return nil, nil
+	}
+	return postsFeed(db, models.PostFilter{AuthorID: profile.UserID},
+		"Posts by "+profile.Username, "The latest posts by "+profile.Username, userURL(profile.Username))
+}
+
+// commentsFeed builds the feed of a post's latest comments. It returns nil when there is
+// no such post.
+func commentsFeed(db *sql.DB, id string) (*feed, error) {
+	postID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, nil
+	}
+	post, err := models.GetPostByID(db, postID, 0)
+	if err != nil {
+		if err == sql.ErrNoRows || err.Error() == "post not found" {
+			return nil, nil
+		}
+		return nil, err
+	}
+	comments, err := models.GetCommentsByPostID(db, postID, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &feed{
+		Title:       "Comments on " + post.Title,
+		Description: "The latest comments on " + post.Title,
+		Link:        fmt.Sprintf("%s/post/%d", BaseURL, postID),
+	}
+	// Comments come oldest first, feeds list the newest first
+	for i := len(comments) - 1; i >= 0 && len(f.Items) < feedSize; i-- {
+		comment := &comments[i]
+		f.add(feedItem{
+			Title:     fmt.Sprintf("Comment by %s on %s", comment.Username, post.Title),
+			Link:      fmt.Sprintf("/post/%d#comment-%d", postID, comment.ID),
+			Author:    comment.Username,
+			Content:   string(renderMarkdown(db, models.RenderedComment, comment.ID, comment.Content)),
+			Published: comment.CreatedAt,
+		})
+	}
+	return f, nil
+}
+
+// add appends an item, making its links absolute so they work in feed readers. Feeds are cached
+// publicly, so the links use BaseURL rather than the Host header of whoever asked first.
+func (f *feed) add(item feedItem) {
+	item.Link = BaseURL + item.Link
+	item.Content = absoluteLinks(BaseURL, item.Content)
+	item.Published = item.Published.UTC()
+	if item.Published.After(f.Updated) {
+		f.Updated = item.Published
+	}
+	f.Items = append(f.Items, item)
+}
+
+// absoluteLinks makes the links and images of rendered content point to the site at base
+func absoluteLinks(base, content string) string {
+	return strings.NewReplacer(`href="/`, `href="`+base+`/`, `src="/`, `src="`+base+`/`).Replace(content)
+}
+
+type rssDocument struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	DCNS    string     `xml:"xmlns:dc,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	Self          atomLink  `xml:"atom:link"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	GUID        string   `xml:"guid"`
+	Creator     string   `xml:"dc:creator"`
+	Categories  []string `xml:"category"`
+	PubDate     string   `xml:"pubDate"`
+	Description string   `xml:"description"`
+}
+
+// rss writes the feed as RSS 2.0
+func (f *feed) rss() ([]byte, error) {
+	doc := rssDocument{
+		Version: "2.0",
+		AtomNS:  "http://www.w3.org/2005/Atom",
+		DCNS:    "http://purl.org/dc/elements/1.1/",
+		Channel: rssChannel{
+			Title:       f.Title,
+			Link:        f.Link,
+			Description: f.Description,
+			Self:        atomLink{Href: f.Self, Rel: "self", Type: "application/rss+xml"},
+		},
+	}
+	if !f.Updated.IsZero() {
+		doc.Channel.LastBuildDate = f.Updated.Format(time.RFC1123Z)
+	}
+	for _, item := range f.Items {
+		doc.Channel.Items = append(doc.Channel.Items, rssItem{
+			Title:       item.Title,
+			Link:        item.Link,
+			GUID:        item.Link,
+			Creator:     item.Author,
+			Categories:  item.Categories,
+			PubDate:     item.Published.Format(time.RFC1123Z),
+			Description: item.Content,
+		})
+	}
+	return marshalFeed(doc)
+}
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle"`
+	ID       string      `xml:"id"`
+	Updated  string      `xml:"updated"`
+	Links    []atomLink  `xml:"link"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Link       atomLink       `xml:"link"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Author     atomPerson     `xml:"author"`
+	Categories []atomCategory `xml:"category"`
+	Content    atomContent    `xml:"content"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// atom writes the feed as Atom 1.0
+func (f *feed) atom() ([]byte, error) {
+	// Posts are never edited, so an empty feed is the only one without a date
+	updated := f.Updated
+	if updated.IsZero() {
+		updated = time.Unix(0, 0).UTC()
+	}
+	doc := atomFeed{
+		Title:    f.Title,
+		Subtitle: f.Description,
+		ID:       f.Self,
+		Updated:  updated.Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: f.Self, Rel: "self", Type: "application/atom+xml"},
+			{Href: f.Link, Rel: "alternate", Type: "text/html"},
+		},
+	}
+	for _, item := range f.Items {
+		entry := atomEntry{
+			Title:     item.Title,
+			ID:        item.Link,
+			Link:      atomLink{Href: item.Link, Rel: "alternate", Type: "text/html"},
+			Published: item.Published.Format(time.RFC3339),
+			Updated:   item.Published.Format(time.RFC3339),
+			Author:    atomPerson{Name: item.Author},
+			Content:   atomContent{Type: "html", Body: item.Content},
+		}
+		for _, c := range item.Categories {
+			entry.Categories = append(entry.Categories, atomCategory{Term: c})
+		}
+		doc.Entries = append(doc.Entries, entry)
+	}
+	return marshalFeed(doc)
+}
+
+func marshalFeed(doc interface{}) ([]byte, error) {
+	body, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
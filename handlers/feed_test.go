@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"forum/models"
+)
+
+func TestFeeds(t *testing.T) {
+	db, cleanup := setupPostTestDB(t)
+	defer cleanup()
+
+	userID := setupTestUser(t, db)
+	categoryIDs := setupTestCategories(t, db)
+	postID := setupTestPost(t, db, userID, categoryIDs)
+	otherPostID := setupTestPost(t, db, userID, categoryIDs[1:])
+	if _, err := models.CreateComment(db, "See [the docs](/post/1)", userID, postID); err != nil {
+		t.Fatalf("Failed to create comment: %v", err)
+	}
+
+	defer func(base string) { BaseURL = base }(BaseURL)
+	BaseURL = "https://forum.example"
+
+	// Feeds are cached, so their links ignore the Host header
+	fetch := func(path string) *httptest.ResponseRecorder {
+		req := createRequestWithDB("GET", path, nil, db)
+		req.Host = "attacker.example"
+		rr := httptest.NewRecorder()
+		FeedHandler(rr, req)
+		return rr
+	}
+
+	// RSS feeds list their items newest first
+	rr := fetch("/feeds/posts.rss")
+	if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Header().Get("Content-Type"), "application/rss+xml") {
+		t.Fatalf("Expected an RSS feed, got %d %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	var rss struct {
+		Items []struct {
+			Link     string   `xml:"link"`
+			Category []string `xml:"category"`
+		} `xml:"channel>item"`
+	}
+	if err := xml.Unmarshal(rr.Body.Bytes(), &rss); err != nil {
+		t.Fatalf("Failed to decode RSS: %v", err)
+	}
+	if len(rss.Items) != 2 || rss.Items[0].Link != fmt.Sprintf("https://forum.example/post/%d", otherPostID) {
+		t.Errorf("Unexpected items %+v", rss.Items)
+	}
+
+	// Atom feeds of a category only hold its posts
+	rr = fetch(fmt.Sprintf("/feeds/category/%d.atom", categoryIDs[0]))
+	var atom struct {
+		Title   string `xml:"title"`
+		Entries []struct {
+			ID      string `xml:"id"`
+			Content string `xml:"content"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal(rr.Body.Bytes(), &atom); err != nil {
+		t.Fatalf("Failed to decode Atom: %v", err)
+	}
+	if rr.Code != http.StatusOK || len(atom.Entries) != 1 || !strings.HasSuffix(atom.Entries[0].ID, fmt.Sprintf("/post/%d", postID)) {
+		t.Errorf("Expected the category's post, got %d %+v", rr.Code, atom)
+	}
+
+	if rr := fetch("/feeds/user/testuser.rss"); rr.Code != http.StatusOK || strings.Count(rr.Body.String(), "<item>") != 2 {
+		t.Errorf("Expected the user's posts, got %d", rr.Code)
+	}
+
+	// Links in comments point back to the forum
+	rr = fetch(fmt.Sprintf("/feeds/post/%d/comments.atom", postID))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "href=&#34;https://forum.example/post/1&#34;") {
+		t.Errorf("Expected the comment with absolute links, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	for _, path := range []string{
+		"/feeds/posts.json",
+		"/feeds/category/999.rss",
+		"/feeds/user/nobody.rss",
+		"/feeds/post/999/comments.rss",
+		"/feeds/post/1.rss",
+	} {
+		if rr := fetch(path); rr.Code != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d", path, rr.Code)
+		}
+	}
+
+	// Users who hide their posts have no feed
+	if err := models.UpdateProfile(db, userID, "", []string{models.ProfileFieldPosts}); err != nil {
+		t.Fatalf("Failed to update profile: %v", err)
+	}
+	if rr := fetch("/feeds/user/testuser.rss"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for hidden posts, got %d", rr.Code)
+	}
+}
+
+func TestFeedConditionalGet(t *testing.T) {
+	db, cleanup := setupPostTestDB(t)
+	defer cleanup()
+
+	userID := setupTestUser(t, db)
+	categoryIDs := setupTestCategories(t, db)
+	setupTestPost(t, db, userID, categoryIDs)
+
+	fetch := func(header, value string) *httptest.ResponseRecorder {
+		req := createRequestWithDB("GET", "/feeds/posts.atom", nil, db)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		rr := httptest.NewRecorder()
+		FeedHandler(rr, req)
+		return rr
+	}
+
+	rr := fetch("", "")
+	etag, modified := rr.Header().Get("ETag"), rr.Header().Get("Last-Modified")
+	if rr.Code != http.StatusOK || etag == "" || modified == "" {
+		t.Fatalf("Expected an ETag and Last-Modified, got %d %q %q", rr.Code, etag, modified)
+	}
+
+	if rr := fetch("If-None-Match", etag); rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+		t.Errorf("Expected 304 for the same ETag, got %d", rr.Code)
+	}
+	if rr := fetch("If-Modified-Since", modified); rr.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 when not modified since, got %d", rr.Code)
+	}
+
+	// A new post changes the ETag
+	setupTestPost(t, db, userID, categoryIDs)
+	if rr := fetch("If-None-Match", etag); rr.Code != http.StatusOK || rr.Header().Get("ETag") == etag {
+		t.Errorf("Expected the changed feed, got %d", rr.Code)
+	}
+}
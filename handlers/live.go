@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"forum/live"
+)
+
+// liveHeartbeat is how often an idle event stream sends a comment to keep the connection open
+const liveHeartbeat = 25 * time.Second
+
+// liveWriteTimeout bounds each write to an event stream, so a stalled client is dropped
+const liveWriteTimeout = 10 * time.Second
+
+// EventsHandler streams live updates as Server-Sent Events: new comments and reaction
+// counts for ?post=, new posts for ?category=, or new posts anywhere without either
+func EventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	topic := live.PostsTopic
+	query := r.URL.Query()
+	if value := query.Get("post"); value != "" {
+		postID, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid post ID", http.StatusBadRequest)
+			return
+		}
+		topic = live.PostTopic(postID)
+	} else if value := query.Get("category"); value != "" {
+		categoryID, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid category ID", http.StatusBadRequest)
+			return
+		}
+		topic = live.CategoryTopic(categoryID)
+	}
+
+	subscriber := live.Default.Subscribe(topic)
+	defer live.Default.Unsubscribe(subscriber)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // stop proxies from buffering the stream
+	w.WriteHeader(http.StatusOK)
+
+	// The server's write timeout would end the stream, so each write gets its own deadline
+	rc := http.NewResponseController(w)
+	send := func(format string, args ...interface{}) bool {
+		if err := rc.SetWriteDeadline(time.Now().Add(liveWriteTimeout)); err != nil {
+			return false
+		}
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+
+	if !send("retry: 5000\n\n") {
+		return
+	}
+
+	heartbeat := time.NewTicker(liveHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-subscriber.Events:
+			if !ok {
+				// Dropped for falling behind; the browser reconnects
+				return
+			}
+			if !send("id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Name, event.Data) {
+				return
+			}
+		case <-heartbeat.C:
+			if !send(": ping\n\n") {
+				return
+			}
+		}
+	}
+}
+
+// liveURL is the event stream announcing new posts in a category, or in any category for 0
+func liveURL(categoryID int64) string {
+	if categoryID == 0 {
+		return "/events"
+	}
+	return fmt.Sprintf("/events?category=%d", categoryID)
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+
+	"forum/markdown"
+	"forum/models"
+)
+
+// MaxPreviewBytes is the largest text accepted by the preview endpoint
+var MaxPreviewBytes int64 = 64 << 10
+
+// renderMarkdown returns the HTML of a post or comment, using the cached copy when the source is unchanged.
+// Mentions link to the users stored when the content was saved.
+func renderMarkdown(db *sql.DB, targetType string, targetID int64, content string) template.HTML {
+	revision := models.ContentRevision(markdown.Version, content)
+	cached, found, err := models.GetRenderedContent(db, targetType, targetID, revision)
+	if err != nil {
+		log.Printf("Failed to read rendered %s %d: %v", targetType, targetID, err)
+	}
+	if found {
+		return template.HTML(cached)
+	}
+
+	names, err := models.GetMentionNames(db, targetType, targetID)
+	if err != nil {
+		log.Printf("Failed to get mentions of %s %d: %v", targetType, targetID, err)
+	}
+	rendered := markdown.RenderWithMentions(content, func(name string) (string, string, bool) {
+		username, ok := names[name]
+		return userURL(username), username, ok
+	})
+	if err := models.SaveRenderedContent(db, targetType, targetID, revision, rendered); err != nil {
+		log.Printf("Failed to cache rendered %s %d: %v", targetType, targetID, err)
+	}
+	return template.HTML(rendered)
+}
+
+// renderPostContent fills in the rendered HTML of a post and its comments
+func renderPostContent(db *sql.DB, post *models.Post, comments []models.Comment) {
+	post.ContentHTML = renderMarkdown(db, models.RenderedPost, post.ID, post.Content)
+	for i := range comments {
+		comments[i].ContentHTML = renderMarkdown(db, models.RenderedComment, comments[i].ID, comments[i].Content)
+	}
+}
+
+// PreviewMarkdownHandler renders posted text exactly as it will appear once saved
+func PreviewMarkdownHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, MaxPreviewBytes+1024)
+	if err := r.ParseForm(); err != nil {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, "Text is too long to preview")
+		return
+	}
+	content := r.FormValue("content")
+	if int64(len(content)) > MaxPreviewBytes {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, "Text is too long to preview")
+		return
+	}
+
+	users, err := models.FindUsernames(getDB(r), markdown.Mentions(content))
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to look up mentions")
+		return
+	}
+	rendered := markdown.RenderWithMentions(content, func(name string) (string, string, bool) {
+		_, ok := users[name]
+		return userURL(name), name, ok
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]string{"html": rendered})
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"forum/models"
+)
+
+// maxUserSuggestions is the number of usernames offered by mention autocomplete
+const maxUserSuggestions = 8
+
+// UserSearchHandler suggests usernames starting with the q parameter, for mention autocomplete
+func UserSearchHandler(w http.ResponseWriter, r *http.Request) {
+	prefix := strings.TrimPrefix(strings.TrimSpace(r.URL.Query().Get("q")), "@")
+
+	type suggestion struct {
+		Username   string `json:"username"`
+		AvatarURL  string `json:"avatar_url"`
+		ProfileURL string `json:"profile_url"`
+	}
+	suggestions := []suggestion{}
+
+	if prefix != "" {
+		users, err := models.SearchUsernames(getDB(r), prefix, maxUserSuggestions)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to search users")
+			return
+		}
+		for _, u := range users {
+			suggestions = append(suggestions, suggestion{
+				Username:   u.Username,
+				AvatarURL:  avatarURL(u.ID, u.AvatarVersion, 32),
+				ProfileURL: userURL(u.Username),
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{"users": suggestions})
+}
+
+// saveMentions stores who a post or comment mentions and notifies the newly mentioned users.
+// Call it again whenever the content changes.
+func saveMentions(db *sql.DB, targetType string, targetID, postID, authorID int64, content string) {
+	mentioned, err := models.SaveMentions(db, targetType, targetID, postID, authorID, content)
+	if err != nil {
+		log.Printf("Failed to save mentions of %s %d: %v", targetType, targetID, err)
+		return
+	}
+
+	var commentID int64
+	if targetType == models.RenderedComment {
+		commentID = targetID
+	}
+	if err := models.NotifyMentions(db, postID, commentID, authorID, mentioned); err != nil {
+		log.Printf("Failed to send mention notifications for %s %d: %v", targetType, targetID, err)
+	}
+}
@@ -0,0 +1,302 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"forum/models"
+	"forum/utils"
+)
+
+// Page sizes for the inbox, conversations and the report queue
+const (
+	conversationsPageSize  = 20
+	messagesPageSize       = 30
+	messageReportsPageSize = 20
+)
+
+// messageLimiter keeps users from flooding others with private messages
+var messageLimiter = utils.NewRateLimiter(10, time.Minute)
+
+// MessagesHandler shows the logged-in user's conversations
+func MessagesHandler(w http.ResponseWriter, r *http.Request) {
+	db := getDB(r)
+	user := getUserFromContext(r)
+	page := pageParam(r, "page")
+
+	conversations, total, err := models.GetConversations(db, user.ID, conversationsPageSize, (page-1)*conversationsPageSize)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get conversations: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	renderTemplate(w, "messages.html", map[string]interface{}{
+		"Title":         "Messages",
+		"Conversations": conversations,
+		"Page":          page,
+		"HasMore":       page*conversationsPageSize < total,
+		"User":          user,
+	})
+}
+
+// NewMessageHandler starts a conversation with one or more users
+func NewMessageHandler(w http.ResponseWriter, r *http.Request) {
+	db := getDB(r)
+	user := getUserFromContext(r)
+
+	data := map[string]interface{}{
+		"Title":      "New Message",
+		"To":         r.URL.Query().Get("to"),
+		"MaxMembers": models.MaxConversationMembers - 1,
+		"MaxLength":  models.MaxMessageLength,
+		"User":       user,
+	}
+
+	if r.Method != "POST" {
+		renderTemplate(w, "new_message.html", data)
+		return
+	}
+
+	to := r.FormValue("to")
+	content := r.FormValue("message")
+	data["To"] = to
+	data["Message"] = content
+
+	if !messageLimiter.Allow(strconv.FormatInt(user.ID, 10)) {
+		data["ErrorMsg"] = "You are sending messages too quickly. Please wait a moment."
+		w.WriteHeader(http.StatusTooManyRequests)
+		renderTemplate(w, "new_message.html", data)
+		return
+	}
+
+	conversationID, err := models.StartConversation(db, user.ID, strings.Split(to, ","), content)
+	if err != nil {
+		switch err.Error() {
+		case "message is empty", "message is too long", "user not found", "you cannot message yourself",
+			"add at least one recipient", "too many recipients", "you cannot message users you blocked or who blocked you":
+			data["ErrorMsg"] = err.Error()
+			w.WriteHeader(http.StatusBadRequest)
+			renderTemplate(w, "new_message.html", data)
+		default:
+			http.Error(w, fmt.Sprintf("Failed to send message: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/messages/%d", conversationID), http.StatusSeeOther)
+}
+
+// ConversationHandler shows a page of a conversation and marks it read. Only its members
+// can open it.
+func ConversationHandler(w http.ResponseWriter, r *http.Request) {
+	db := getDB(r)
+	user := getUserFromContext(r)
+
+	conversationID, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/messages/"), 10, 64)
+	if err != nil {
+		RenderErrorPage(w, http.StatusNotFound)
+		return
+	}
+
+	conversation, err := models.GetConversation(db, conversationID, user.ID)
+	if err != nil {
+		if err.Error() == "conversation not found" {
+			RenderErrorPage(w, http.StatusNotFound)
+		} else {
+			http.Error(w, fmt.Sprintf("Failed to get conversation: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	page := pageParam(r, "page")
+	messages, total, err := models.GetConversationMessages(db, conversationID, messagesPageSize, (page-1)*messagesPageSize)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get messages: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if err := models.MarkConversationRead(db, conversationID, user.ID); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to update conversation: %v", err), http.StatusInternalServerError)
+		return
+	}
+	// The badge in the header counts what is now read
+	user.UnreadMessages -= conversation.Unread
+	if user.UnreadMessages < 0 {
+		user.UnreadMessages = 0
+	}
+
+	renderTemplate(w, "conversation.html", map[string]interface{}{
+		"Title":        conversation.Names(),
+		"Conversation": conversation,
+		"Messages":     messages,
+		"Page":         page,
+		"HasOlder":     page*messagesPageSize < total,
+		"MaxLength":    models.MaxMessageLength,
+		"ErrorMsg":     r.URL.Query().Get("error"),
+		"Notice":       r.URL.Query().Get("notice"),
+		"User":         user,
+	})
+}
+
+// SendMessageHandler adds a message to a conversation
+func SendMessageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	db := getDB(r)
+	user := getUserFromContext(r)
+
+	conversationID, err := strconv.ParseInt(r.FormValue("conversation_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid conversation ID", http.StatusBadRequest)
+		return
+	}
+	conversationURL := fmt.Sprintf("/messages/%d", conversationID)
+
+	if !messageLimiter.Allow(strconv.FormatInt(user.ID, 10)) {
+		http.Redirect(w, r, conversationURL+"?error="+url.QueryEscape("You are sending messages too quickly. Please wait a moment."), http.StatusSeeOther)
+		return
+	}
+
+	if _, err := models.SendMessage(db, conversationID, user.ID, r.FormValue("message")); err != nil {
+		switch err.Error() {
+		case "conversation not found":
+			RenderErrorPage(w, http.StatusNotFound)
+		case "message is empty", "message is too long", "everyone else left this conversation",
+			"you cannot message users you blocked or who blocked you":
+			http.Redirect(w, r, conversationURL+"?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+		default:
+			http.Error(w, fmt.Sprintf("Failed to send message: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	http.Redirect(w, r, conversationURL, http.StatusSeeOther)
+}
+
+// LeaveConversationHandler takes the logged-in user out of a conversation
+func LeaveConversationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	db := getDB(r)
+	user := getUserFromContext(r)
+
+	conversationID, err := strconv.ParseInt(r.FormValue("conversation_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid conversation ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := models.LeaveConversation(db, conversationID, user.ID); err != nil {
+		if err.Error() == "conversation not found" {
+			RenderErrorPage(w, http.StatusNotFound)
+		} else {
+			http.Error(w, fmt.Sprintf("Failed to leave conversation: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	http.Redirect(w, r, "/messages", http.StatusSeeOther)
+}
+
+// ReportMessageHandler sends a private message the user received to the moderators
+func ReportMessageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	db := getDB(r)
+	user := getUserFromContext(r)
+
+	messageID, err := strconv.ParseInt(r.FormValue("message_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+
+	back := "/messages"
+	if conversationID, err := strconv.ParseInt(r.FormValue("conversation_id"), 10, 64); err == nil {
+		back = fmt.Sprintf("/messages/%d", conversationID)
+	}
+
+	if err := models.ReportMessage(db, messageID, user.ID, r.FormValue("reason")); err != nil {
+		switch err.Error() {
+		case "message not found":
+			http.NotFound(w, r)
+		case "reason is too long", "you cannot report your own message", "you already reported this message":
+			http.Redirect(w, r, back+"?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+		default:
+			http.Error(w, fmt.Sprintf("Failed to report message: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	http.Redirect(w, r, back+"?notice="+url.QueryEscape("Thanks, the moderators will look at this message."), http.StatusSeeOther)
+}
+
+// MessageReportsHandler lists reported private messages for moderators
+func MessageReportsHandler(w http.ResponseWriter, r *http.Request) {
+	db := getDB(r)
+	user := getUserFromContext(r)
+	if !user.IsModerator() {
+		RenderErrorPage(w, http.StatusNotFound)
+		return
+	}
+
+	page := pageParam(r, "page")
+	reports, total, err := models.GetMessageReports(db, user, messageReportsPageSize, (page-1)*messageReportsPageSize)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get reports: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	renderTemplate(w, "message_reports.html", map[string]interface{}{
+		"Title":   "Reported Messages",
+		"Reports": reports,
+		"Page":    page,
+		"HasMore": page*messageReportsPageSize < total,
+		"User":    user,
+	})
+}
+
+// ResolveMessageReportHandler closes a report once a moderator dealt with it
+func ResolveMessageReportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	db := getDB(r)
+	user := getUserFromContext(r)
+	if !user.IsModerator() {
+		RenderErrorPage(w, http.StatusNotFound)
+		return
+	}
+
+	reportID, err := strconv.ParseInt(r.FormValue("report_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid report ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := models.ResolveMessageReport(db, reportID, user); err != nil {
+		if err.Error() == "report not found" {
+			http.NotFound(w, r)
+		} else {
+			http.Error(w, fmt.Sprintf("Failed to resolve report: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	redirectBack(w, r, "/moderation/messages")
+}
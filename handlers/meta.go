@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"html/template"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"forum/models"
+)
+
+// SiteName names the forum in link previews
+const SiteName = "Forum"
+
+// metaDescriptionLength is the longest description given to link previews and search engines
+const metaDescriptionLength = 200
+
+var (
+	metaTagPattern   = regexp.MustCompile(`(?s)<[^>]*>`)
+	metaSpacePattern = regexp.MustCompile(`\s+`)
+)
+
+// pageMeta describes a page for link previews (OpenGraph and Twitter cards) and search engines
+// (JSON-LD). The layout writes it into the head of pages that have one.
+type pageMeta struct {
+	SiteName    string
+	Title       string
+	Description string
+	URL         string // canonical address of the page
+	Type        string // og:type
+	Image       string
+	Published   string // RFC 3339
+	Author      string
+	Tags        []string
+	JSONLD      template.JS
+}
+
+// TwitterCard is the kind of card to show, large when there is an image
+func (m *pageMeta) TwitterCard() string {
+	if m.Image != "" {
+		return "summary_large_image"
+	}
+	return "summary"
+}
+
+// discussionForumPosting is a post as described by schema.org
+type discussionForumPosting struct {
+	Context              string                   `json:"@context"`
+	Type                 string                   `json:"@type"`
+	URL                  string                   `json:"url"`
+	Headline             string                   `json:"headline"`
+	Text                 string                   `json:"text"`
+	DatePublished        string                   `json:"datePublished"`
+	Author               schemaPerson             `json:"author"`
+	Image                string                   `json:"image,omitempty"`
+	ArticleSection       []string                 `json:"articleSection,omitempty"`
+	CommentCount         int                      `json:"commentCount"`
+	InteractionStatistic []schemaInteractionCount `json:"interactionStatistic"`
+}
+
+type schemaPerson struct {
+	Type string `json:"@type"`
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+type schemaInteractionCount struct {
+	Type                 string `json:"@type"`
+	InteractionType      string `json:"interactionType"`
+	UserInteractionCount int    `json:"userInteractionCount"`
+}
+
+// postMeta describes a post page from the post as GetPostByID returns it, with its rendered
+// content and attachments loaded. The first image attached to the post is its picture. Addresses
+// use BaseURL, as pages are cached and crawled under their canonical address.
+func postMeta(post *models.Post, commentCount int) *pageMeta {
+	text := metaText(string(post.ContentHTML))
+	meta := &pageMeta{
+		SiteName:    SiteName,
+		Title:       post.Title,
+		Description: truncateText(text, metaDescriptionLength),
+		URL:         fmt.Sprintf("%s/post/%d", BaseURL, post.ID),
+		Type:        "article",
+		Published:   post.CreatedAt.UTC().Format(time.RFC3339),
+		Author:      post.Username,
+	}
+	for _, a := range post.Attachments {
+		if a.IsImage() {
+			meta.Image = BaseURL + a.URL()
+			break
+		}
+	}
+	for _, c := range post.Categories {
+		meta.Tags = append(meta.Tags, c.Name)
+	}
+
+	posting := discussionForumPosting{
+		Context:       "https://schema.org",
+		Type:          "DiscussionForumPosting",
+		URL:           meta.URL,
+		Headline:      post.Title,
+		Text:          text,
+		DatePublished: meta.Published,
+		Author: schemaPerson{
+			Type: "Person",
+			Name: post.Username,
+			URL:  BaseURL + userURL(post.Username),
+		},
+		Image:          meta.Image,
+		ArticleSection: meta.Tags,
+		CommentCount:   commentCount,
+		InteractionStatistic: []schemaInteractionCount{
+			{Type: "InteractionCounter", InteractionType: "https://schema.org/CommentAction", UserInteractionCount: commentCount},
+			{Type: "InteractionCounter", InteractionType: "https://schema.org/LikeAction", UserInteractionCount: post.Likes},
+		},
+	}
+	// json.Marshal escapes <, > and &, so the script element cannot be closed from inside
+	body, err := json.Marshal(posting)
+	if err != nil {
+		log.Printf("Failed to describe post %d: %v", post.ID, err)
+	} else {
+		meta.JSONLD = template.JS(body)
+	}
+	return meta
+}
+
+// metaText is the text of rendered HTML on one line
+func metaText(content string) string {
+	text := html.UnescapeString(metaTagPattern.ReplaceAllString(content, " "))
+	return strings.TrimSpace(metaSpacePattern.ReplaceAllString(text, " "))
+}
+
+// truncateText shortens text to at most max runes, cutting at a space when it can
+func truncateText(text string, max int) string {
+	if utf8.RuneCountInString(text) <= max {
+		return text
+	}
+	cut := string([]rune(text)[:max-1])
+	if i := strings.LastIndex(cut, " "); i > len(cut)/2 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " .,;:") + "…"
+}
@@ -3,7 +3,10 @@ package handlers
 import (
 	"context"
 	"database/sql"
+	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	"forum/models"
 	"forum/utils"
@@ -13,8 +16,9 @@ import (
 type contextKey string
 
 const (
-	dbContextKey   contextKey = "db"
-	userContextKey contextKey = "user"
+	dbContextKey    contextKey = "db"
+	userContextKey  contextKey = "user"
+	tokenContextKey contextKey = "token"
 )
 
 // GetDBContextKey returns the database context key
@@ -35,9 +39,15 @@ func DBMiddleware(db *sql.DB, next http.Handler) http.Handler {
 	})
 }
 
-// SessionMiddleware checks for a valid session and adds the user to context
+// SessionMiddleware checks for a valid session and adds the user to context.
+// API requests can sign in with a personal access token instead.
 func SessionMiddleware(db *sql.DB, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token, ok := bearerToken(r); ok && strings.HasPrefix(r.URL.Path, APIPrefix) {
+			tokenMiddleware(db, token, next, w, r)
+			return
+		}
+
 		// Get session cookie
 		cookie, err := r.Cookie("session_id")
 		if err != nil {
@@ -64,7 +74,7 @@ func SessionMiddleware(db *sql.DB, next http.Handler) http.Handler {
 		}
 
 		// Get user information
-		user, err := models.GetUserByID(db, userID)
+		user, err := loadSessionUser(db, userID)
 		if err != nil {
 			// User not found, clear cookie and continue without user
 			http.SetCookie(w, &http.Cookie{
@@ -86,11 +96,71 @@ func SessionMiddleware(db *sql.DB, next http.Handler) http.Handler {
 	})
 }
 
+// tokenMiddleware signs an API request in with a personal access token, adding the user and
+// the token, whose scopes limit what the request may do, to the context
+func tokenMiddleware(db *sql.DB, token string, next http.Handler, w http.ResponseWriter, r *http.Request) {
+	apiToken, err := models.AuthenticateAPIToken(db, token, time.Now())
+	if err != nil {
+		switch err.Error() {
+		case "invalid token", "token expired":
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			writeAPIError(w, http.StatusUnauthorized, "invalid_token", "The access token is invalid or expired")
+		default:
+			writeAPIInternalError(w, "Failed to check access token", err)
+		}
+		return
+	}
+
+	user, err := loadSessionUser(db, apiToken.UserID)
+	if err != nil {
+		writeAPIInternalError(w, "Failed to get user", err)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	ctx = context.WithValue(ctx, tokenContextKey, apiToken)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// loadSessionUser retrieves a signed in user with the unread counts shown in the header
+func loadSessionUser(db *sql.DB, userID int64) (*models.User, error) {
+	user, err := models.GetUserByID(db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unread count for the notification badge
+	user.UnreadNotifications, err = models.CountUnreadNotifications(db, user.ID)
+	if err != nil {
+		log.Printf("Failed to count notifications for user %d: %v", user.ID, err)
+	}
+	user.UnreadMessages, err = models.CountUnreadMessages(db, user.ID)
+	if err != nil {
+		log.Printf("Failed to count messages for user %d: %v", user.ID, err)
+	}
+	return user, nil
+}
+
 // AuthMiddleware protects routes that require authentication
 func AuthMiddleware(handler http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user := getUserFromContext(r)
 		if user == nil {
+			// Scripts asking for JSON cannot follow a redirect to the login form
+			if wantsJSON(r) {
+				writeAPIError(w, http.StatusUnauthorized, "unauthorized", "Sign in to see this page")
+				return
+			}
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
@@ -106,3 +176,10 @@ func getUserFromContext(r *http.Request) *models.User {
 	}
 	return user
 }
+
+// getTokenFromContext returns the access token a request signed in with, or nil for the
+// session cookie, which is not limited by scopes
+func getTokenFromContext(r *http.Request) *models.APIToken {
+	token, _ := r.Context().Value(tokenContextKey).(*models.APIToken)
+	return token
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"forum/models"
+)
+
+// pageJSONFields names the template data that pages also answer with as JSON. Data without
+// a name here only matters to the template and is left out.
+var pageJSONFields = map[string]string{
+	"Title":              "title",
+	"User":               "user",
+	"Post":               "post",
+	"Posts":              "posts",
+	"Comments":           "comments",
+	"Categories":         "categories",
+	"CurrentCategory":    "category",
+	"SelectedCategoryID": "selected_category_id",
+	"Following":          "following",
+	"ErrorMsg":           "error",
+	"PollError":          "poll_error",
+	"LiveURL":            "live_url",
+	"Feeds":              "feeds",
+}
+
+// wantsJSON reports whether a request prefers JSON to HTML. Only an explicit
+// application/json counts, so browsers sending */* still get pages.
+func wantsJSON(r *http.Request) bool {
+	var jsonQ, htmlQ float64
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		switch mediaType {
+		case "application/json":
+			jsonQ = q
+		case "text/html":
+			htmlQ = q
+		}
+	}
+	return jsonQ > 0 && jsonQ > htmlQ
+}
+
+// renderPage renders a page, or answers with its data as JSON when the request asks for it.
+// The JSON uses the API's types, so its fields have the same names there.
+func renderPage(w http.ResponseWriter, r *http.Request, tmplFile string, data map[string]interface{}) {
+	w.Header().Add("Vary", "Accept")
+	if !wantsJSON(r) {
+		renderTemplate(w, tmplFile, data)
+		return
+	}
+
+	answer := map[string]interface{}{}
+	for key, value := range data {
+		if name, ok := pageJSONFields[key]; ok {
+			answer[name] = pageJSONValue(r, value)
+		}
+	}
+	writeAPIJSON(w, http.StatusOK, answer)
+}
+
+// renderNotFound answers 404 as a page or as JSON
+func renderNotFound(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept")
+	if wantsJSON(r) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "Page not found")
+		return
+	}
+	RenderErrorPage(w, http.StatusNotFound)
+}
+
+// pageJSONValue converts template data to the type the API uses for it
+func pageJSONValue(r *http.Request, value interface{}) interface{} {
+	db := getDB(r)
+	switch v := value.(type) {
+	case *models.User:
+		if v == nil {
+			return nil
+		}
+		version, err := models.GetAvatarVersion(db, v.ID)
+		if err != nil {
+			version = 0
+		}
+		return apiCurrentUser{
+			apiUser:             newAPIUser(v.ID, v.Username, version),
+			Email:               v.Email,
+			Role:                v.Role,
+			CreatedAt:           v.CreatedAt,
+			UnreadNotifications: v.UnreadNotifications,
+			UnreadMessages:      v.UnreadMessages,
+		}
+	case *models.Post:
+		return newAPIPost(db, v)
+	case []models.Post:
+		posts := make([]apiPost, 0, len(v))
+		for i := range v {
+			posts = append(posts, newAPIPost(db, &v[i]))
+		}
+		return posts
+	case []models.Comment:
+		comments := make([]apiComment, 0, len(v))
+		for i := range v {
+			comments = append(comments, newAPIComment(db, &v[i]))
+		}
+		return comments
+	case *models.Category:
+		return newAPICategory(*v)
+	case []models.Category:
+		categories := make([]apiCategory, 0, len(v))
+		for _, c := range v {
+			categories = append(categories, newAPICategory(c))
+		}
+		return categories
+	}
+	return value
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"forum/models"
+)
+
+func TestWantsJSON(t *testing.T) {
+	for accept, want := range map[string]bool{
+		"":    false,
+		"*/*": false,
+		"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8": false,
+		"application/json":                  true,
+		"application/json, */*":             true,
+		"text/html;q=0.5, application/json": true,
+		"text/html, application/json":       false,
+		"application/json;q=0":              false,
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept", accept)
+		if got := wantsJSON(req); got != want {
+			t.Errorf("Accept %q: expected %v, got %v", accept, want, got)
+		}
+	}
+}
+
+func TestPagesAnswerJSON(t *testing.T) {
+	db, cleanup := setupPostTestDB(t)
+	defer cleanup()
+
+	userID := setupTestUser(t, db)
+	categoryIDs := setupTestCategories(t, db)
+	postID := setupTestPost(t, db, userID, categoryIDs)
+	if _, err := models.CreateComment(db, "Nice post", userID, postID); err != nil {
+		t.Fatalf("Failed to create comment: %v", err)
+	}
+
+	get := func(handler http.HandlerFunc, path string, userID int64) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req := createAuthenticatedRequest("GET", path, nil, db, userID)
+		req.Header.Set("Accept", "application/json")
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+
+		var answer map[string]interface{}
+		if err := json.Unmarshal(rr.Body.Bytes(), &answer); err != nil {
+			t.Fatalf("GET %s: failed to decode %q: %v", path, rr.Body.String(), err)
+		}
+		return rr, answer
+	}
+
+	rr, answer := get(HomeHandler, "/", 0)
+	posts, _ := answer["posts"].([]interface{})
+	if rr.Code != http.StatusOK || rr.Header().Get("Vary") != "Accept" || len(posts) != 1 || answer["user"] != nil {
+		t.Errorf("Unexpected home page %d: %v", rr.Code, answer)
+	}
+	if posts[0].(map[string]interface{})["title"] != "Test Post Title" {
+		t.Errorf("Expected posts to use the API's fields, got %v", posts[0])
+	}
+
+	rr, answer = get(ViewPostHandler, fmt.Sprintf("/post/%d", postID), userID)
+	comments, _ := answer["comments"].([]interface{})
+	user, _ := answer["user"].(map[string]interface{})
+	if rr.Code != http.StatusOK || answer["post"] == nil || len(comments) != 1 || user["username"] != "testuser" {
+		t.Errorf("Unexpected post page %d: %v", rr.Code, answer)
+	}
+	if _, ok := answer["ShowComments"]; ok {
+		t.Error("Expected template-only data to be left out")
+	}
+
+	rr, answer = get(CategoryPostsHandler, fmt.Sprintf("/posts/category/%d", categoryIDs[1]), 0)
+	category, _ := answer["category"].(map[string]interface{})
+	if rr.Code != http.StatusOK || category["id"] != float64(categoryIDs[1]) || len(answer["feeds"].([]interface{})) != 2 {
+		t.Errorf("Unexpected category page %d: %v", rr.Code, answer)
+	}
+
+	for path, handler := range map[string]http.HandlerFunc{
+		"/posts/category/999": CategoryPostsHandler,
+		"/post/999":           ViewPostHandler,
+	} {
+		if rr, answer := get(handler, path, 0); rr.Code != http.StatusNotFound || apiErrorCode(answer) != "not_found" {
+			t.Errorf("%s: expected a JSON 404, got %d: %v", path, rr.Code, answer)
+		}
+	}
+	if rr, answer := get(AuthMiddleware(MyPostsHandler), "/posts/my", 0); rr.Code != http.StatusUnauthorized || apiErrorCode(answer) != "unauthorized" {
+		t.Errorf("Expected a JSON 401 instead of a redirect, got %d: %v", rr.Code, answer)
+	}
+	if rr, answer := get(AuthMiddleware(LikedPostsHandler), "/posts/liked", userID); rr.Code != http.StatusOK || answer["title"] != "Posts I Liked" {
+		t.Errorf("Unexpected liked posts page %d: %v", rr.Code, answer)
+	}
+}
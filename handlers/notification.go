@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"forum/models"
+)
+
+// notificationsPageSize is the number of notifications shown per page
+const notificationsPageSize = 30
+
+// NotificationsHandler shows the logged-in user's notifications and what they follow
+func NotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	db := getDB(r)
+	user := getUserFromContext(r)
+	page := pageParam(r, "page")
+
+	notifications, total, err := models.GetNotifications(db, user.ID, notificationsPageSize, (page-1)*notificationsPageSize)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get notifications: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	follows, err := models.GetUserFollows(db, user.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get follows: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Title":         "Notifications",
+		"Notifications": notifications,
+		"Follows":       follows,
+		"Page":          page,
+		"HasMore":       page*notificationsPageSize < total,
+		"User":          user,
+	}
+
+	renderTemplate(w, "notifications.html", data)
+}
+
+// OpenNotificationHandler marks a notification as read and goes to what it is about
+func OpenNotificationHandler(w http.ResponseWriter, r *http.Request) {
+	db := getDB(r)
+	user := getUserFromContext(r)
+
+	notificationID, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		RenderErrorPage(w, http.StatusNotFound)
+		return
+	}
+
+	notification, err := models.GetNotification(db, user.ID, notificationID)
+	if err != nil {
+		if err.Error() == "notification not found" {
+			RenderErrorPage(w, http.StatusNotFound)
+		} else {
+			http.Error(w, fmt.Sprintf("Failed to get notification: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if err := models.MarkNotificationRead(db, user.ID, notificationID); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to update notification: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, notification.URL(), http.StatusSeeOther)
+}
+
+// MarkAllNotificationsReadHandler marks all of the logged-in user's notifications as read
+func MarkAllNotificationsReadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	db := getDB(r)
+	user := getUserFromContext(r)
+
+	if err := models.MarkAllNotificationsRead(db, user.ID); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to update notifications: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/notifications", http.StatusSeeOther)
+}
+
+// ToggleFollowHandler follows a post, category or user, or unfollows it
+func ToggleFollowHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	db := getDB(r)
+	user := getUserFromContext(r)
+
+	targetType := r.FormValue("target_type")
+	targetID, err := strconv.ParseInt(r.FormValue("target_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid follow target", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := models.ToggleFollow(db, user.ID, targetType, targetID); err != nil {
+		switch err.Error() {
+		case "post not found", "category not found", "user not found":
+			http.NotFound(w, r)
+		case "invalid follow type", "you cannot follow yourself":
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, fmt.Sprintf("Failed to update follow: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	redirectBack(w, r, "/notifications")
+}
+
+// notifyNewPost subscribes the author to a new post and tells their followers, the users it
+// mentions, the webhooks and other servers about it. Failures are logged since the post itself
+// was created.
+func notifyNewPost(db *sql.DB, postID, authorID int64, content string) {
+	if err := models.FollowTarget(db, authorID, models.FollowPost, postID); err != nil {
+		log.Printf("Failed to follow post %d for user %d: %v", postID, authorID, err)
+	}
+	if err := models.NotifyNewPost(db, postID, authorID); err != nil {
+		log.Printf("Failed to send notifications for post %d: %v", postID, err)
+	}
+	saveMentions(db, models.RenderedPost, postID, postID, authorID, content)
+	webhookPostCreated(db, postID)
+	federatePost(db, postID)
+}
+
+// notifyNewComment subscribes the commenter to the post and tells its followers, the users
+// it mentions, the webhooks and other servers about the comment
+func notifyNewComment(db *sql.DB, postID, commentID, authorID int64, content string) {
+	if err := models.FollowTarget(db, authorID, models.FollowPost, postID); err != nil {
+		log.Printf("Failed to follow post %d for user %d: %v", postID, authorID, err)
+	}
+	if err := models.NotifyNewComment(db, postID, commentID, authorID); err != nil {
+		log.Printf("Failed to send notifications for comment %d: %v", commentID, err)
+	}
+	saveMentions(db, models.RenderedComment, commentID, postID, authorID, content)
+	webhookCommentCreated(db, postID, commentID, authorID, content)
+	federateComment(db, postID, commentID)
+}
+
+// notifyReaction tells the author of a post or comment and the webhooks about a reaction to it
+func notifyReaction(db *sql.DB, userID int64, targetType string, targetID int64) {
+	if err := models.NotifyNewReaction(db, userID, targetType, targetID); err != nil {
+		log.Printf("Failed to send reaction notification for %s %d: %v", targetType, targetID, err)
+	}
+	webhookReactionChanged(db, userID, targetType, targetID)
+}
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"forum/models"
+)
+
+// OpenAPIPath is where the OpenAPI document of the JSON API is served
+const OpenAPIPath = "/api/openapi.json"
+
+// apiVersion is the version of the API the document describes
+const apiVersion = "1.0.0"
+
+// OpenAPIHandler serves an OpenAPI 3 document describing the JSON API. It is built from
+// apiRoutes and the Go types the handlers read and write.
+func OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	writeAPIJSON(w, http.StatusOK, openAPIDocument(BaseURL+strings.TrimSuffix(APIPrefix, "/")))
+}
+
+// openAPISpec collects the schemas of the Go types used by the API while the document is built
+type openAPISpec struct {
+	schemas map[string]interface{}
+}
+
+// openAPIDocument builds the document for an API served at serverURL
+func openAPIDocument(serverURL string) map[string]interface{} {
+	spec := &openAPISpec{schemas: map[string]interface{}{}}
+	errorRef := spec.schema(reflect.TypeOf(apiError{}))
+	spec.schemas["ErrorResponse"] = object(map[string]interface{}{"error": errorRef}, "error")
+	spec.schema(reflect.TypeOf(apiPagination{}))
+
+	paths := map[string]interface{}{}
+	for i := range apiRoutes {
+		route := &apiRoutes[i]
+		path := "/" + route.Path
+		item, ok := paths[path].(map[string]interface{})
+		if !ok {
+			item = map[string]interface{}{}
+			paths[path] = item
+		}
+		item[strings.ToLower(route.Method)] = spec.operation(route)
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.1.0",
+		"info": map[string]interface{}{
+			"title":       "Forum API",
+			"version":     apiVersion,
+			"description": "Posts, comments, reactions and categories. Sign in with the session cookie or a personal access token.",
+		},
+		"servers": []interface{}{map[string]interface{}{"url": serverURL}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": spec.schemas,
+			"securitySchemes": map[string]interface{}{
+				"token": map[string]interface{}{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "A personal access token from /account/tokens. Its scopes: " + strings.Join(models.TokenScopes, ", "),
+				},
+				"session": map[string]interface{}{"type": "apiKey", "in": "cookie", "name": "session_id"},
+			},
+		},
+	}
+}
+
+// operation describes one route
+func (spec *openAPISpec) operation(route *apiRoute) map[string]interface{} {
+	op := map[string]interface{}{
+		"operationId": route.OperationID,
+		"summary":     route.Summary,
+		"tags":        []string{strings.Split(route.Path, "/")[0]},
+	}
+	if route.Admin {
+		op["description"] = "Only admins can use this endpoint, and tokens need the admin scope."
+	}
+
+	// Anonymous requests may read, tokens need the route's scope
+	security := []interface{}{
+		map[string]interface{}{"token": []string{route.Scope}},
+		map[string]interface{}{"session": []string{}},
+	}
+	if !route.SignedIn {
+		security = append(security, map[string]interface{}{})
+	}
+	op["security"] = security
+
+	var params []interface{}
+	if strings.Contains(route.Path, "{id}") {
+		params = append(params, map[string]interface{}{
+			"name": "id", "in": "path", "required": true,
+			"schema": map[string]interface{}{"type": "integer", "format": "int64", "minimum": 1},
+		})
+	}
+	for _, p := range route.Params {
+		schema := map[string]interface{}{"type": p.Type}
+		if len(p.Enum) > 0 {
+			schema["enum"] = p.Enum
+		}
+		params = append(params, map[string]interface{}{"name": p.Name, "in": "query", "description": p.Description, "schema": schema})
+	}
+	if route.List {
+		params = append(params,
+			map[string]interface{}{"name": "page", "in": "query", "schema": map[string]interface{}{"type": "integer", "minimum": 1, "default": 1}},
+			map[string]interface{}{"name": "per_page", "in": "query", "schema": map[string]interface{}{
+				"type": "integer", "minimum": 1, "maximum": apiMaxPageSize, "default": apiDefaultPageSize,
+			}},
+		)
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+
+	if route.Body != nil {
+		op["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  jsonContent(spec.schema(reflect.TypeOf(route.Body))),
+		}
+	}
+
+	result := spec.schema(reflect.TypeOf(route.Result))
+	var success map[string]interface{}
+	if route.List {
+		success = object(map[string]interface{}{
+			"data":       map[string]interface{}{"type": "array", "items": result},
+			"pagination": ref("Pagination"),
+		}, "data", "pagination")
+	} else {
+		success = object(map[string]interface{}{"data": result}, "data")
+	}
+	responses := map[string]interface{}{
+		fmt.Sprint(route.Status): map[string]interface{}{"description": http.StatusText(route.Status), "content": jsonContent(success)},
+		"default":                errorResponse("Error"),
+	}
+	for _, status := range route.errorStatuses() {
+		responses[fmt.Sprint(status)] = errorResponse(http.StatusText(status))
+	}
+	op["responses"] = responses
+	return op
+}
+
+// errorStatuses are the error answers a route gives besides the generic ones
+func (route *apiRoute) errorStatuses() []int {
+	statuses := []int{http.StatusForbidden}
+	if route.SignedIn {
+		statuses = append(statuses, http.StatusUnauthorized)
+	}
+	if route.List || len(route.Params) > 0 {
+		statuses = append(statuses, http.StatusBadRequest)
+	}
+	if strings.Contains(route.Path, "{id}") {
+		statuses = append(statuses, http.StatusNotFound)
+	}
+	if route.Body != nil {
+		statuses = append(statuses, http.StatusBadRequest, http.StatusRequestEntityTooLarge,
+			http.StatusUnsupportedMediaType, http.StatusUnprocessableEntity)
+	}
+	sort.Ints(statuses)
+	return statuses
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schema returns the schema of a Go type, adding structs to the components
+func (spec *openAPISpec) schema(t reflect.Type) map[string]interface{} {
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Ptr:
+		return spec.schema(t.Elem())
+	case t.Kind() == reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": spec.schema(t.Elem())}
+	case t.Kind() == reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case t.Kind() == reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case t.Kind() == reflect.Int64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case t.Kind() == reflect.Int:
+		return map[string]interface{}{"type": "integer"}
+	case t.Kind() == reflect.Struct:
+		name := schemaName(t)
+		if _, ok := spec.schemas[name]; !ok {
+			spec.schemas[name] = nil // guards against recursion
+			properties := map[string]interface{}{}
+			var required []string
+			spec.addFields(t, properties, &required)
+			spec.schemas[name] = object(properties, required...)
+		}
+		return ref(name)
+	}
+	panic(fmt.Sprintf("openapi: no schema for %s", t))
+}
+
+// addFields adds the JSON fields of a struct, including those of embedded structs
+func (spec *openAPISpec) addFields(t reflect.Type, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous {
+			spec.addFields(field.Type, properties, required)
+			continue
+		}
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = spec.schema(field.Type)
+		if !strings.Contains(options, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// schemaName names the schema of an API type: apiCurrentUser becomes CurrentUser
+func schemaName(t reflect.Type) string {
+	name := strings.TrimPrefix(t.Name(), "api")
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+func object(properties map[string]interface{}, required ...string) map[string]interface{} {
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+	return schema
+}
+
+func ref(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+func errorResponse(description string) map[string]interface{} {
+	return map[string]interface{}{"description": description, "content": jsonContent(ref("ErrorResponse"))}
+}
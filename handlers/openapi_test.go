@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"forum/models"
+)
+
+func getOpenAPIDocument(t *testing.T) map[string]interface{} {
+	req := httptest.NewRequest("GET", OpenAPIPath, nil)
+	rr := httptest.NewRecorder()
+	OpenAPIHandler(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rr.Code)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("Failed to decode the document: %v", err)
+	}
+	return doc
+}
+
+// findRefs collects every $ref in a part of the document
+func findRefs(v interface{}, refs map[string]bool) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if ref, ok := value.(string); ok && key == "$ref" {
+				refs[ref] = true
+			}
+			findRefs(value, refs)
+		}
+	case []interface{}:
+		for _, value := range v {
+			findRefs(value, refs)
+		}
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	doc := getOpenAPIDocument(t)
+	if doc["openapi"] != "3.1.0" {
+		t.Errorf("Unexpected version %v", doc["openapi"])
+	}
+
+	paths := doc["paths"].(map[string]interface{})
+	operationIDs := map[string]bool{}
+	for _, route := range apiRoutes {
+		item, _ := paths["/"+route.Path].(map[string]interface{})
+		op, _ := item[strings.ToLower(route.Method)].(map[string]interface{})
+		if op == nil {
+			t.Errorf("%s %s is missing from the document", route.Method, route.Path)
+			continue
+		}
+		id, _ := op["operationId"].(string)
+		if id == "" || operationIDs[id] || op["summary"] == "" {
+			t.Errorf("%s %s needs a unique operation ID and a summary, got %q", route.Method, route.Path, id)
+		}
+		operationIDs[id] = true
+	}
+
+	schemas := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	refs := map[string]bool{}
+	findRefs(doc, refs)
+	for ref := range refs {
+		if schemas[strings.TrimPrefix(ref, "#/components/schemas/")] == nil {
+			t.Errorf("%s does not exist", ref)
+		}
+	}
+}
+
+// TestOpenAPICoversRoutes calls every documented path with every method, so a route the
+// document does not describe, or a described one that does not answer, fails the test.
+// Answers must also match their schemas.
+func TestOpenAPICoversRoutes(t *testing.T) {
+	db, cleanup := setupPostTestDB(t)
+	defer cleanup()
+
+	userID := setupTestUser(t, db)
+	postID := setupTestPost(t, db, userID, setupTestCategories(t, db))
+	commentID, err := models.CreateComment(db, "A comment", userID, postID)
+	if err != nil {
+		t.Fatalf("Failed to create comment: %v", err)
+	}
+
+	doc := getOpenAPIDocument(t)
+	schemas := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	for path, item := range doc["paths"].(map[string]interface{}) {
+		id := postID
+		if strings.HasPrefix(path, "/comments/") {
+			id = commentID
+		}
+		url := strings.TrimSuffix(APIPrefix, "/") + strings.Replace(path, "{id}", fmt.Sprint(id), 1)
+
+		for _, method := range []string{"GET", "POST", "PUT", "PATCH", "DELETE"} {
+			op, documented := item.(map[string]interface{})[strings.ToLower(method)].(map[string]interface{})
+			body := ""
+			if method != "GET" {
+				body = "{}"
+			}
+			rr, answer := apiRequest(t, db, userID, method, url, body)
+			code := apiErrorCode(answer)
+
+			if !documented {
+				if rr.Code != http.StatusMethodNotAllowed {
+					t.Errorf("%s %s answers %d but is not documented", method, path, rr.Code)
+				}
+				continue
+			}
+			if code == "not_found" && rr.Code == http.StatusNotFound || code == "method_not_allowed" {
+				t.Errorf("%s %s is documented but not served: %s", method, path, rr.Body.String())
+				continue
+			}
+			if _, ok := op["responses"].(map[string]interface{})[fmt.Sprint(rr.Code)]; !ok {
+				t.Errorf("%s %s answered %d, which is not documented", method, path, rr.Code)
+			}
+			if method == "GET" {
+				checkSchema(t, method+" "+path, answer, op, schemas)
+			}
+		}
+	}
+}
+
+// checkSchema compares the fields of an answer's data with the documented ones
+func checkSchema(t *testing.T, name string, answer, op, schemas map[string]interface{}) {
+	success := op["responses"].(map[string]interface{})["200"].(map[string]interface{})
+	schema := success["content"].(map[string]interface{})["application/json"].(map[string]interface{})["schema"].(map[string]interface{})
+	schema = schema["properties"].(map[string]interface{})["data"].(map[string]interface{})
+
+	data := answer["data"]
+	if items, ok := data.([]interface{}); ok {
+		if len(items) == 0 {
+			t.Fatalf("%s: expected data to compare", name)
+		}
+		data = items[0]
+		schema = schema["items"].(map[string]interface{})
+	}
+	ref, _ := schema["$ref"].(string)
+	schema = schemas[strings.TrimPrefix(ref, "#/components/schemas/")].(map[string]interface{})
+	properties := schema["properties"].(map[string]interface{})
+
+	fields := data.(map[string]interface{})
+	for field := range fields {
+		if properties[field] == nil {
+			t.Errorf("%s: %s is not documented", name, field)
+		}
+	}
+	for _, field := range schema["required"].([]interface{}) {
+		if _, ok := fields[field.(string)]; !ok {
+			t.Errorf("%s: documented field %s is missing", name, field)
+		}
+	}
+}
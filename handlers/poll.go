@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"forum/models"
+)
+
+// VotePollHandler records the logged-in user's vote on a poll
+func VotePollHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	db := getDB(r)
+	user := getUserFromContext(r)
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	pollID, err := strconv.ParseInt(r.FormValue("poll_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid poll ID", http.StatusBadRequest)
+		return
+	}
+
+	var optionIDs []int64
+	for _, idStr := range r.Form["option_ids"] {
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid option ID", http.StatusBadRequest)
+			return
+		}
+		optionIDs = append(optionIDs, id)
+	}
+
+	poll, err := models.GetPollByID(db, pollID, user.ID)
+	if err != nil {
+		if err.Error() == "poll not found" {
+			http.NotFound(w, r)
+		} else {
+			http.Error(w, fmt.Sprintf("Failed to get poll: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if err := models.VotePoll(db, pollID, user.ID, optionIDs); err != nil {
+		switch err.Error() {
+		case "the poll is closed", "choose an option to vote", "choose only one option", "invalid poll option":
+			redirectToPoll(w, r, poll.PostID, err.Error())
+		default:
+			http.Error(w, fmt.Sprintf("Failed to vote: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	redirectToPoll(w, r, poll.PostID, "")
+}
+
+// ClosePollHandler closes a poll before its end date. Only the poll creator and moderators can close it.
+func ClosePollHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	db := getDB(r)
+	user := getUserFromContext(r)
+
+	pollID, err := strconv.ParseInt(r.FormValue("poll_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid poll ID", http.StatusBadRequest)
+		return
+	}
+
+	poll, err := models.GetPollByID(db, pollID, user.ID)
+	if err != nil {
+		if err.Error() == "poll not found" {
+			http.NotFound(w, r)
+		} else {
+			http.Error(w, fmt.Sprintf("Failed to get poll: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if user.ID != poll.CreatorID && !user.IsModerator() {
+		http.Error(w, "Only the poll creator or a moderator can close this poll", http.StatusForbidden)
+		return
+	}
+
+	if err := models.ClosePoll(db, pollID); err != nil && err.Error() != "the poll is already closed" {
+		http.Error(w, fmt.Sprintf("Failed to close poll: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	redirectToPoll(w, r, poll.PostID, "")
+}
+
+// redirectToPoll sends the user back to the poll, with an optional message
+func redirectToPoll(w http.ResponseWriter, r *http.Request, postID int64, message string) {
+	target := fmt.Sprintf("/post/%d", postID)
+	if message != "" {
+		target += "?poll_error=" + url.QueryEscape(message)
+	}
+	http.Redirect(w, r, target+"#poll", http.StatusSeeOther)
+}
+
+// pollFromForm reads the poll fields of the post editor. It returns nil when no poll was filled in.
+func pollFromForm(r *http.Request) (*models.Poll, error) {
+	question := strings.TrimSpace(r.FormValue("poll_question"))
+	var options []models.PollOption
+	for _, text := range r.Form["poll_options"] {
+		if text = strings.TrimSpace(text); text != "" {
+			options = append(options, models.PollOption{Text: text})
+		}
+	}
+	if question == "" && len(options) == 0 {
+		return nil, nil
+	}
+
+	poll := &models.Poll{
+		Question:          question,
+		Options:           options,
+		MultipleChoice:    r.FormValue("poll_multiple") == "1",
+		ResultsBeforeVote: r.FormValue("poll_results") == "1",
+	}
+
+	if value := r.FormValue("poll_closes_at"); value != "" {
+		closesAt, err := parsePublishAt(value, r.FormValue("timezone_offset"))
+		if err != nil {
+			return nil, fmt.Errorf("Choose a valid poll closing time")
+		}
+		poll.ClosesAt = closesAt
+	}
+
+	return poll, nil
+}
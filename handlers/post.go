@@ -1,7 +1,9 @@
 package handlers
 
 import (
+	"database/sql"
 	"fmt"
+	"log"
 	"net/http"
 	"strconv"
 	"strings"
@@ -50,15 +52,22 @@ func HomeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	feeds := feedLinks("Latest posts", FeedPrefix+"posts")
+	if categoryID > 0 {
+		feeds = feedLinks("Category posts", fmt.Sprintf("%scategory/%d", FeedPrefix, categoryID))
+	}
+
 	// Prepare data for template
 	data := map[string]interface{}{
 		"Posts":              posts,
 		"Categories":         categories,
 		"User":               user,
 		"SelectedCategoryID": categoryID,
+		"LiveURL":            liveURL(categoryID),
+		"Feeds":              feeds,
 	}
 
-	renderTemplate(w, "home.html", data)
+	renderPage(w, r, "home.html", data)
 }
 
 // ViewPostHandler displays a single post with its comments
@@ -89,8 +98,8 @@ func ViewPostHandler(w http.ResponseWriter, r *http.Request) {
 	post, err := models.GetPostByID(db, postID, userID)
 	if err != nil {
 		// If post not found, return 404 instead of internal server error
-		if err.Error() == "post not found" {
-			RenderErrorPage(w, http.StatusNotFound)
+		if err == sql.ErrNoRows || err.Error() == "post not found" {
+			renderNotFound(w, r)
 		} else {
 			http.Error(w, fmt.Sprintf("Failed to get post: %v", err), http.StatusInternalServerError)
 		}
@@ -104,19 +113,49 @@ func ViewPostHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := loadAttachments(db, post, comments); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get attachments: %v", err), http.StatusInternalServerError)
+		return
+	}
+	renderPostContent(db, post, comments)
+
+	post.Poll, err = models.GetPostPoll(db, postID, userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get poll: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if err := loadBookmarks(db, userID, post, comments); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get bookmarks: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var following bool
+	if user != nil {
+		following, err = models.IsFollowing(db, userID, models.FollowPost, postID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get follows: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
 	// Check for error messages from the query parameters
 	errorMsg := r.URL.Query().Get("error")
 
 	// Prepare data for template
 	data := map[string]interface{}{
 		"Post":         post,
+		"Following":    following,
 		"Comments":     comments,
 		"User":         user,
 		"ErrorMsg":     errorMsg,
+		"PollError":    r.URL.Query().Get("poll_error"),
 		"ShowComments": strings.Contains(r.URL.Fragment, "comments"),
+		"Feeds":        feedLinks("Comments on "+post.Title, fmt.Sprintf("%spost/%d/comments", FeedPrefix, post.ID)),
+		"Meta":         postMeta(post, len(comments)),
 	}
 
-	renderTemplate(w, "post.html", data)
+	renderPage(w, r, "post.html", data)
 }
 
 // GetPostHandler retrieves a post by ID from the request context
@@ -161,6 +200,23 @@ func GetPostHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := loadAttachments(db, post, comments); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get attachments: %v", err), http.StatusInternalServerError)
+		return
+	}
+	renderPostContent(db, post, comments)
+
+	post.Poll, err = models.GetPostPoll(db, postID, userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get poll: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if err := loadBookmarks(db, userID, post, comments); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get bookmarks: %v", err), http.StatusInternalServerError)
+		return
+	}
+
 	// Prepare data for template
 	data := map[string]interface{}{
 		"Post":     post,
@@ -171,91 +227,96 @@ func GetPostHandler(w http.ResponseWriter, r *http.Request) {
 	renderTemplate(w, "post.html", data)
 }
 
-// CreatePostHandler handles creation of new posts
+// CreatePostHandler handles creation of new posts, and saving and scheduling drafts
 func CreatePostHandler(w http.ResponseWriter, r *http.Request) {
 	db := getDB(r)
 	user := getUserFromContext(r)
 
 	if r.Method == "GET" {
-		// Get all categories for the form
-		categories, err := models.GetAllCategories(db)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to get categories: %v", err), http.StatusInternalServerError)
-			return
-		}
-
-		data := map[string]interface{}{
-			"Categories": categories,
-			"User":       user,
+		// Continue writing a saved draft
+		draft := &models.Draft{}
+		if idStr := r.URL.Query().Get("draft"); idStr != "" {
+			draftID, err := strconv.ParseInt(idStr, 10, 64)
+			if err != nil {
+				http.NotFound(w, r)
+				return
+			}
+			draft, err = models.GetDraft(db, draftID, user.ID)
+			if err != nil {
+				if err.Error() == "draft not found" {
+					http.NotFound(w, r)
+				} else {
+					http.Error(w, fmt.Sprintf("Failed to get draft: %v", err), http.StatusInternalServerError)
+				}
+				return
+			}
 		}
 
-		renderTemplate(w, "create_post.html", data)
+		renderPostForm(w, db, user, draft, nil)
 		return
 	}
 
 	if r.Method == "POST" {
-		// Parse form
-		err := r.ParseForm()
+		// Parse form, which may include attached files
+		err := parseUploadForm(w, r)
 		if err != nil {
 			http.Error(w, "Failed to parse form", http.StatusBadRequest)
 			return
 		}
+		if r.MultipartForm != nil {
+			defer r.MultipartForm.RemoveAll()
+		}
+
+		// Saving or scheduling a draft does not publish anything yet
+		if action := r.FormValue("action"); action == "draft" || action == "schedule" {
+			saveDraftForm(w, r, user, action)
+			return
+		}
 
 		// Extract form values
-		title := strings.TrimSpace(r.FormValue("title"))
-		content := strings.TrimSpace(r.FormValue("content"))
-		categoryIDs := r.Form["categories"]
+		draft, err := draftFromForm(r, user.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
 		// Basic validation
-		var errors []string
-		if title == "" {
-			errors = append(errors, "Title is required")
-		}
-		if content == "" {
-			errors = append(errors, "Content is required")
-		}
-		if len(categoryIDs) == 0 {
-			errors = append(errors, "At least one category is required")
-		}
+		errors := draft.Validate()
 
-		if len(errors) > 0 {
-			// Get all categories for the form
-			categories, err := models.GetAllCategories(db)
+		// Store attached files once the rest of the form is valid
+		var attachmentTokens []string
+		if len(errors) == 0 {
+			attachmentTokens, err = saveFormAttachments(r, user.ID, draft.Content)
 			if err != nil {
-				http.Error(w, fmt.Sprintf("Failed to get categories: %v", err), http.StatusInternalServerError)
-				return
+				errors = append(errors, err.Error())
 			}
-
-			data := map[string]interface{}{
-				"Errors":     errors,
-				"Title":      title,
-				"Content":    content,
-				"Categories": categories,
-				"User":       user,
-			}
-
-			renderTemplate(w, "create_post.html", data)
-			return
 		}
 
-		// Convert category IDs to int64
-		var categoryIDsInt []int64
-		for _, idStr := range categoryIDs {
-			id, err := strconv.ParseInt(idStr, 10, 64)
-			if err != nil {
-				http.Error(w, "Invalid category ID", http.StatusBadRequest)
-				return
-			}
-			categoryIDsInt = append(categoryIDsInt, id)
+		if len(errors) > 0 {
+			renderPostForm(w, db, user, draft, errors)
+			return
 		}
 
 		// Create the post
-		postID, err := models.CreatePost(db, title, content, user.ID, categoryIDsInt)
+		postID, err := models.CreatePostWithPoll(db, draft.Title, draft.Content, user.ID, draft.CategoryIDs, draft.Poll)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Failed to create post: %v", err), http.StatusInternalServerError)
 			return
 		}
 
+		if _, err := models.AttachToPost(db, attachmentTokens, user.ID, postID); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to save attachments: %v", err), http.StatusInternalServerError)
+			return
+		}
+		notifyNewPost(db, postID, user.ID, draft.Content)
+
+		// The draft the post was written from is no longer needed
+		if draft.ID > 0 {
+			if err := models.DeleteDraft(db, draft.ID, user.ID); err != nil && err.Error() != "draft not found" {
+				log.Printf("Failed to delete published draft %d: %v", draft.ID, err)
+			}
+		}
+
 		// Redirect to the new post
 		http.Redirect(w, r, fmt.Sprintf("/post/%d", postID), http.StatusSeeOther)
 	}
@@ -287,6 +348,10 @@ func CategoryPostsHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Get the category
 	category, err := models.GetCategoryByID(db, categoryID)
+	if err == sql.ErrNoRows {
+		renderNotFound(w, r)
+		return
+	}
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to get category: %v", err), http.StatusInternalServerError)
 		return
@@ -306,16 +371,28 @@ func CategoryPostsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var following bool
+	if user != nil {
+		following, err = models.IsFollowing(db, userID, models.FollowCategory, categoryID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get follows: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
 	// Prepare data for template
 	data := map[string]interface{}{
 		"Posts":              posts,
 		"Categories":         categories,
 		"User":               user,
+		"Following":          following,
 		"CurrentCategory":    category,
 		"SelectedCategoryID": categoryID,
+		"LiveURL":            liveURL(categoryID),
+		"Feeds":              feedLinks(category.Name, fmt.Sprintf("%scategory/%d", FeedPrefix, categoryID)),
 	}
 
-	renderTemplate(w, "home.html", data)
+	renderPage(w, r, "home.html", data)
 }
 
 // MyPostsHandler displays posts created by the logged-in user
@@ -345,7 +422,7 @@ func MyPostsHandler(w http.ResponseWriter, r *http.Request) {
 		"Title":      "My Posts",
 	}
 
-	renderTemplate(w, "home.html", data)
+	renderPage(w, r, "home.html", data)
 }
 
 // LikedPostsHandler displays posts liked by the logged-in user
@@ -375,7 +452,7 @@ func LikedPostsHandler(w http.ResponseWriter, r *http.Request) {
 		"Title":      "Posts I Liked",
 	}
 
-	renderTemplate(w, "home.html", data)
+	renderPage(w, r, "home.html", data)
 }
 
 // ReactPostHandler handles liking/disliking posts
@@ -417,6 +494,7 @@ func ReactPostHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("Failed to save reaction: %v", err), http.StatusInternalServerError)
 		return
 	}
+	notifyReaction(db, user.ID, "post", postID)
 
 	// Redirect back to the post or referer
 	redirectURL := fmt.Sprintf("/post/%d", postID)
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"forum/models"
+)
+
+// profilePageSize is the number of posts and comments shown per profile page
+const profilePageSize = 10
+
+// UserProfileHandler displays a user's public profile
+func UserProfileHandler(w http.ResponseWriter, r *http.Request) {
+	// Extract username from URL path
+	username := strings.TrimPrefix(r.URL.Path, "/user/")
+	if username == "" || strings.Contains(username, "/") || username == models.DeletedUsername {
+		RenderErrorPage(w, http.StatusNotFound)
+		return
+	}
+
+	db := getDB(r)
+	user := getUserFromContext(r)
+
+	profile, err := models.GetProfileByUsername(db, username)
+	if err != nil {
+		if err.Error() == "user not found" {
+			RenderErrorPage(w, http.StatusNotFound)
+		} else {
+			http.Error(w, fmt.Sprintf("Failed to get profile: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Feeds are public, so they follow the profile's settings even for its owner
+	var feeds []feedLink
+	if !profile.Hidden[models.ProfileFieldPosts] {
+		feeds = feedLinks("Posts by "+profile.Username, FeedPrefix+"user/"+url.PathEscape(profile.Username))
+	}
+
+	// Owners always see their full profile
+	isOwner := user != nil && user.ID == profile.UserID
+	if isOwner {
+		profile.Hidden = map[string]bool{}
+	}
+
+	postsPage := pageParam(r, "posts_page")
+	commentsPage := pageParam(r, "comments_page")
+
+	var posts []models.ProfilePost
+	if !profile.Hidden[models.ProfileFieldPosts] {
+		posts, err = models.GetProfilePosts(db, profile.UserID, profilePageSize, (postsPage-1)*profilePageSize)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get posts: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	var comments []models.ProfileComment
+	if !profile.Hidden[models.ProfileFieldComments] {
+		comments, err = models.GetProfileComments(db, profile.UserID, profilePageSize, (commentsPage-1)*profilePageSize)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get comments: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	var following, blocked bool
+	if user != nil && !isOwner {
+		following, err = models.IsFollowing(db, user.ID, models.FollowUser, profile.UserID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get follows: %v", err), http.StatusInternalServerError)
+			return
+		}
+		blocked, err = models.IsBlocked(db, user.ID, profile.UserID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get blocks: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Prepare data for template
+	data := map[string]interface{}{
+		"Title":           profile.Username,
+		"User":            user,
+		"Profile":         profile,
+		"IsOwner":         isOwner,
+		"Following":       following,
+		"Blocked":         blocked,
+		"Posts":           posts,
+		"Comments":        comments,
+		"PostsPage":       postsPage,
+		"CommentsPage":    commentsPage,
+		"HasMorePosts":    postsPage*profilePageSize < profile.PostCount,
+		"HasMoreComments": commentsPage*profilePageSize < profile.CommentCount,
+		"Feeds":           feeds,
+	}
+
+	renderTemplate(w, "profile.html", data)
+}
+
+// ToggleBlockHandler blocks a user, or unblocks them
+func ToggleBlockHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	db := getDB(r)
+	user := getUserFromContext(r)
+
+	blockedID, err := strconv.ParseInt(r.FormValue("user_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := models.ToggleBlock(db, user.ID, blockedID); err != nil {
+		switch err.Error() {
+		case "user not found":
+			http.NotFound(w, r)
+		case "you cannot block yourself":
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, fmt.Sprintf("Failed to update block: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	redirectBack(w, r, "/account/settings")
+}
+
+// Helper to read a 1-based page number from the query string
+func pageParam(r *http.Request, name string) int {
+	page, err := strconv.Atoi(r.URL.Query().Get(name))
+	if err != nil || page < 1 {
+		return 1
+	}
+	return page
+}
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"forum/models"
+)
+
+// SitemapPath is the sitemap index search engines start from
+const SitemapPath = "/sitemap.xml"
+
+// SitemapPrefix is the path the sitemaps listed in the index start with
+const SitemapPrefix = "/sitemaps/"
+
+// RobotsPath is where crawlers look for the rules they should follow
+const RobotsPath = "/robots.txt"
+
+// SitemapSize is the largest number of posts listed in one sitemap. The protocol allows 50,000.
+var SitemapSize = 10000
+
+// RobotsTxt replaces the default robots.txt when set, from the file named by ROBOTS_FILE
+var RobotsTxt string
+
+// robotsDisallow are the pages crawlers are asked to skip: forms, pages of the signed in
+// user and endpoints that are not pages
+var robotsDisallow = []string{
+	"/account/", "/admin/", "/api/", "/attachments/upload", "/bookmarks/", "/chat/",
+	"/drafts", "/events", "/login", "/logout", "/messages", "/moderation/", "/notifications",
+	"/post/create", "/posts/liked", "/posts/my", "/posts/saved", "/register", "/users/",
+}
+
+const sitemapNamespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+type sitemapIndex struct {
+	XMLName  xml.Name       `xml:"sitemapindex"`
+	Xmlns    string         `xml:"xmlns,attr"`
+	Sitemaps []sitemapEntry `xml:"sitemap"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name       `xml:"urlset"`
+	Xmlns   string         `xml:"xmlns,attr"`
+	URLs    []sitemapEntry `xml:"url"`
+}
+
+type sitemapEntry struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+func sitemapTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// SitemapHandler serves the sitemap index and the sitemaps it lists:
+//
+//	/sitemap.xml               the index
+//	/sitemaps/pages.xml        the home page and each category
+//	/sitemaps/posts-{n}.xml    the posts, SitemapSize to a sitemap
+//
+// Shared caches keep them, so their addresses come from BaseURL rather than the Host header.
+func SitemapHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	db := getDB(r)
+	var doc interface{}
+	var updated time.Time
+	var err error
+	name := strings.TrimPrefix(r.URL.Path, SitemapPrefix)
+	switch {
+	case r.URL.Path == SitemapPath:
+		doc, err = sitemapIndexDoc(db)
+	case name == "pages.xml":
+		doc, err = sitemapPagesDoc(db)
+	case strings.HasPrefix(name, "posts-") && strings.HasSuffix(name, ".xml"):
+		page, convErr := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, "posts-"), ".xml"))
+		if convErr != nil || page < 1 {
+			RenderErrorPage(w, http.StatusNotFound)
+			return
+		}
+		var set *sitemapURLSet
+		set, updated, err = sitemapPostsDoc(db, page)
+		if set != nil {
+			doc = set
+		}
+	default:
+		RenderErrorPage(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to build sitemap: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if doc == nil {
+		RenderErrorPage(w, http.StatusNotFound)
+		return
+	}
+
+	body, err := marshalFeed(doc)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to write sitemap: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	http.ServeContent(w, r, "", updated, bytes.NewReader(body))
+}
+
+// sitemapPages is the number of sitemaps the posts take, at least one so the index is never empty
+func sitemapPages(db *sql.DB) (int, error) {
+	count, err := models.CountPosts(db)
+	if err != nil {
+		return 0, err
+	}
+	if count == 0 {
+		return 1, nil
+	}
+	return (count + SitemapSize - 1) / SitemapSize, nil
+}
+
+func sitemapIndexDoc(db *sql.DB) (*sitemapIndex, error) {
+	pages, err := sitemapPages(db)
+	if err != nil {
+		return nil, err
+	}
+	index := &sitemapIndex{Xmlns: sitemapNamespace}
+	index.Sitemaps = append(index.Sitemaps, sitemapEntry{Loc: BaseURL + SitemapPrefix + "pages.xml"})
+	for page := 1; page <= pages; page++ {
+		index.Sitemaps = append(index.Sitemaps, sitemapEntry{Loc: fmt.Sprintf("%s%sposts-%d.xml", BaseURL, SitemapPrefix, page)})
+	}
+	return index, nil
+}
+
+func sitemapPagesDoc(db *sql.DB) (*sitemapURLSet, error) {
+	categories, err := models.GetAllCategories(db)
+	if err != nil {
+		return nil, err
+	}
+	set := &sitemapURLSet{Xmlns: sitemapNamespace}
+	set.URLs = append(set.URLs, sitemapEntry{Loc: BaseURL + "/"})
+	for _, c := range categories {
+		set.URLs = append(set.URLs, sitemapEntry{Loc: fmt.Sprintf("%s/posts/category/%d", BaseURL, c.ID)})
+	}
+	return set, nil
+}
+
+// sitemapPostsDoc lists a page of posts, and returns when the latest of them changed.
+// Pages past the last are nil, except the first, which is empty on a new forum.
+func sitemapPostsDoc(db *sql.DB, page int) (*sitemapURLSet, time.Time, error) {
+	var updated time.Time
+	posts, err := models.GetSitemapPosts(db, SitemapSize, (page-1)*SitemapSize)
+	if err != nil {
+		return nil, updated, err
+	}
+	if len(posts) == 0 && page > 1 {
+		return nil, updated, nil
+	}
+
+	set := &sitemapURLSet{Xmlns: sitemapNamespace}
+	for _, p := range posts {
+		set.URLs = append(set.URLs, sitemapEntry{
+			Loc:     fmt.Sprintf("%s/post/%d", BaseURL, p.ID),
+			LastMod: sitemapTime(p.LastModified),
+		})
+		if p.LastModified.After(updated) {
+			updated = p.LastModified
+		}
+	}
+	return set, updated, nil
+}
+
+// RobotsHandler serves robots.txt: RobotsTxt when it is set, otherwise rules that keep crawlers
+// to the public pages and point them to the sitemap
+func RobotsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if RobotsTxt != "" {
+		fmt.Fprint(w, RobotsTxt)
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString("User-agent: *\n")
+	for _, path := range robotsDisallow {
+		fmt.Fprintf(&b, "Disallow: %s\n", path)
+	}
+	fmt.Fprintf(&b, "\nSitemap: %s\n", BaseURL+SitemapPath)
+	fmt.Fprint(w, b.String())
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"forum/models"
+)
+
+func TestSitemap(t *testing.T) {
+	db, cleanup := setupPostTestDB(t)
+	defer cleanup()
+
+	userID := setupTestUser(t, db)
+	categoryIDs := setupTestCategories(t, db)
+	var postIDs []int64
+	for i := 0; i < 3; i++ {
+		postIDs = append(postIDs, setupTestPost(t, db, userID, categoryIDs))
+	}
+	if _, err := models.CreateComment(db, "A reply", userID, postIDs[0]); err != nil {
+		t.Fatalf("Failed to create comment: %v", err)
+	}
+
+	defer func(size int) { SitemapSize = size }(SitemapSize)
+	SitemapSize = 2
+	defer func(base string) { BaseURL = base }(BaseURL)
+	BaseURL = "https://forum.example"
+
+	// The Host header does not change the addresses that caches keep
+	fetch := func(path string) *httptest.ResponseRecorder {
+		req := createRequestWithDB("GET", path, nil, db)
+		req.Host = "attacker.example"
+		rr := httptest.NewRecorder()
+		SitemapHandler(rr, req)
+		return rr
+	}
+	type entries struct {
+		Entries []sitemapEntry `xml:",any"`
+	}
+
+	// The index lists the pages and enough post sitemaps for every post
+	rr := fetch(SitemapPath)
+	var index entries
+	if err := xml.Unmarshal(rr.Body.Bytes(), &index); err != nil {
+		t.Fatalf("Failed to decode index: %v", err)
+	}
+	if rr.Code != http.StatusOK || len(index.Entries) != 3 || index.Entries[2].Loc != "https://forum.example/sitemaps/posts-2.xml" {
+		t.Fatalf("Unexpected index %d %s", rr.Code, rr.Body.String())
+	}
+
+	var pages entries
+	xml.Unmarshal(fetch("/sitemaps/pages.xml").Body.Bytes(), &pages)
+	if len(pages.Entries) != 3 || pages.Entries[0].Loc != "https://forum.example/" {
+		t.Errorf("Expected the home page and 2 categories, got %+v", pages.Entries)
+	}
+
+	var posts entries
+	xml.Unmarshal(fetch("/sitemaps/posts-1.xml").Body.Bytes(), &posts)
+	if len(posts.Entries) != 2 || posts.Entries[0].Loc != fmt.Sprintf("https://forum.example/post/%d", postIDs[0]) || posts.Entries[0].LastMod == "" {
+		t.Errorf("Unexpected posts %+v", posts.Entries)
+	}
+	posts = entries{}
+	xml.Unmarshal(fetch("/sitemaps/posts-2.xml").Body.Bytes(), &posts)
+	if len(posts.Entries) != 1 || posts.Entries[0].Loc != fmt.Sprintf("https://forum.example/post/%d", postIDs[2]) {
+		t.Errorf("Unexpected posts %+v", posts.Entries)
+	}
+
+	for _, path := range []string{"/sitemaps/posts-3.xml", "/sitemaps/posts-0.xml", "/sitemaps/users.xml"} {
+		if rr := fetch(path); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 for %s, got %d", path, rr.Code)
+		}
+	}
+}
+
+func TestRobots(t *testing.T) {
+	defer func(base string) { BaseURL = base }(BaseURL)
+	BaseURL = "https://forum.example"
+
+	fetch := func() string {
+		req := httptest.NewRequest("GET", RobotsPath, nil)
+		req.Host = "attacker.example"
+		rr := httptest.NewRecorder()
+		RobotsHandler(rr, req)
+		return rr.Body.String()
+	}
+
+	body := fetch()
+	if !strings.Contains(body, "Disallow: /account/\n") || !strings.Contains(body, "Sitemap: https://forum.example/sitemap.xml\n") {
+		t.Errorf("Unexpected robots.txt %q", body)
+	}
+
+	defer func() { RobotsTxt = "" }()
+	RobotsTxt = "User-agent: *\nDisallow: /\n"
+	if body := fetch(); body != RobotsTxt {
+		t.Errorf("Expected the configured robots.txt, got %q", body)
+	}
+}
+
+func TestPostMeta(t *testing.T) {
+	db, cleanup := setupPostTestDB(t)
+	defer cleanup()
+
+	userID := setupTestUser(t, db)
+	categoryIDs := setupTestCategories(t, db)
+	postID, err := models.CreatePost(db, "Meta </script> title", "Some *text* & "+strings.Repeat("more words ", 40), userID, categoryIDs)
+	if err != nil {
+		t.Fatalf("Failed to create post: %v", err)
+	}
+	post, _ := models.GetPostByID(db, postID, 0)
+	renderPostContent(db, post, nil)
+
+	defer func(base string) { BaseURL = base }(BaseURL)
+	BaseURL = "https://forum.example"
+	meta := postMeta(post, 4)
+
+	if meta.URL != fmt.Sprintf("https://forum.example/post/%d", postID) || meta.TwitterCard() != "summary" || len(meta.Tags) != 2 {
+		t.Errorf("Unexpected meta %+v", meta)
+	}
+	if !strings.HasPrefix(meta.Description, "Some text & more words") || len([]rune(meta.Description)) > metaDescriptionLength {
+		t.Errorf("Unexpected description %q", meta.Description)
+	}
+
+	// The JSON-LD cannot end its script element early
+	if strings.Contains(string(meta.JSONLD), "</script>") {
+		t.Errorf("Expected the title to be escaped, got %s", meta.JSONLD)
+	}
+	var posting map[string]interface{}
+	if err := json.Unmarshal([]byte(meta.JSONLD), &posting); err != nil {
+		t.Fatalf("Failed to decode JSON-LD: %v", err)
+	}
+	if posting["@type"] != "DiscussionForumPosting" || posting["headline"] != "Meta </script> title" || posting["commentCount"] != float64(4) {
+		t.Errorf("Unexpected JSON-LD %v", posting)
+	}
+}
@@ -0,0 +1,6 @@
+package handlers
+
+import "forum/storage"
+
+// Store holds uploaded files such as avatars and attachments. It is set up by main.
+var Store storage.Storage
@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"html/template"
+	"net/url"
+
+	"forum/models"
+)
+
+// templateFuncs are the helper functions available in every template
+var templateFuncs = template.FuncMap{
+	"userURL":   userURL,
+	"avatarURL": avatarURL,
+	"isDeletedUser": func(username string) bool {
+		return username == models.DeletedUsername
+	},
+	"fileSize": formatFileSize,
+	"add":      func(a, b int) int { return a + b },
+	"sub":      func(a, b int) int { return a - b },
+}
+
+// userURL returns the profile page path for a username
+func userURL(username string) string {
+	return "/user/" + url.PathEscape(username)
+}
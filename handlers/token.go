@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"forum/models"
+)
+
+// tokenExpiryDays are the lifetimes offered for new tokens, in days. 0 never expires.
+var tokenExpiryDays = []int{30, 90, 365, 0}
+
+// APITokensHandler lists the user's personal access tokens and creates new ones.
+// A new token is shown once, on the page answering the form.
+func APITokensHandler(w http.ResponseWriter, r *http.Request) {
+	db := getDB(r)
+	user := getUserFromContext(r)
+
+	data := map[string]interface{}{
+		"Title":       "API tokens",
+		"Scopes":      models.TokenScopes,
+		"ExpiryDays":  tokenExpiryDays,
+		"MaxName":     models.MaxTokenNameLength,
+		"Success":     r.URL.Query().Get("success"),
+		"DefaultDays": tokenExpiryDays[0],
+		"Chosen":      &models.APIToken{Scopes: []string{models.ScopeRead}},
+		"User":        user,
+	}
+
+	if r.Method == "POST" {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Failed to parse form", http.StatusBadRequest)
+			return
+		}
+
+		var expiresAt time.Time
+		days, err := strconv.Atoi(r.FormValue("expires_in"))
+		if err != nil || days < 0 {
+			http.Error(w, "Invalid expiry", http.StatusBadRequest)
+			return
+		}
+		if days > 0 {
+			expiresAt = time.Now().AddDate(0, 0, days)
+		}
+
+		name := strings.TrimSpace(r.FormValue("name"))
+		token, err := models.CreateAPIToken(db, user, name, r.Form["scopes"], expiresAt)
+		if err != nil {
+			switch err.Error() {
+			case "token name is required", "token name is too long", "choose at least one scope",
+				"unknown scope", "only admins can create admin tokens", "expiry must be in the future",
+				"you have too many tokens, revoke one first":
+				data["ErrorMsg"] = err.Error()
+				data["Name"] = name
+				data["DefaultDays"] = days
+				data["Chosen"] = &models.APIToken{Scopes: r.Form["scopes"]}
+				w.WriteHeader(http.StatusBadRequest)
+			default:
+				http.Error(w, fmt.Sprintf("Failed to create token: %v", err), http.StatusInternalServerError)
+				return
+			}
+		} else {
+			if err := models.RecordAccountEvent(db, user.ID, models.AuditTokenCreated, name, clientIP(r)); err != nil {
+				log.Printf("Failed to record account event: %v", err)
+			}
+			data["NewToken"] = token
+			w.Header().Set("Cache-Control", "no-store")
+		}
+	} else if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tokens, err := models.GetAPITokens(db, user.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get tokens: %v", err), http.StatusInternalServerError)
+		return
+	}
+	data["Tokens"] = tokens
+	data["Now"] = time.Now()
+
+	renderTemplate(w, "tokens.html", data)
+}
+
+// RevokeAPITokenHandler deletes one of the user's tokens
+func RevokeAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	db := getDB(r)
+	user := getUserFromContext(r)
+
+	tokenID, err := strconv.ParseInt(r.FormValue("token_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid token ID", http.StatusBadRequest)
+		return
+	}
+
+	name, err := models.RevokeAPIToken(db, tokenID, user.ID)
+	if err != nil {
+		if err.Error() == "token not found" {
+			http.NotFound(w, r)
+		} else {
+			http.Error(w, fmt.Sprintf("Failed to revoke token: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+	if err := models.RecordAccountEvent(db, user.ID, models.AuditTokenRevoked, name, clientIP(r)); err != nil {
+		log.Printf("Failed to record account event: %v", err)
+	}
+
+	http.Redirect(w, r, "/account/tokens?success="+url.QueryEscape("Token revoked"), http.StatusSeeOther)
+}
@@ -0,0 +1,495 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"forum/models"
+	"forum/webhook"
+)
+
+// WebhookClient sends webhook deliveries
+var WebhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// WebhookWorkerInterval is how often pending webhook deliveries are sent
+const WebhookWorkerInterval = 10 * time.Second
+
+// webhookBatchSize is the largest number of deliveries sent in one pass
+const webhookBatchSize = 50
+
+// webhookRetention is how long delivered and failed deliveries stay in the log
+const webhookRetention = 30 * 24 * time.Hour
+
+// webhookDeliveriesPageSize is the number of deliveries shown per page of the log
+const webhookDeliveriesPageSize = 30
+
+// webhookPayload is the JSON body of every webhook request
+type webhookPayload struct {
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+type webhookUser struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+	URL      string `json:"url"`
+}
+
+type webhookPost struct {
+	ID         int64       `json:"id"`
+	Title      string      `json:"title"`
+	Content    string      `json:"content"`
+	Categories []string    `json:"categories"`
+	URL        string      `json:"url"`
+	Author     webhookUser `json:"author"`
+	CreatedAt  time.Time   `json:"created_at"`
+}
+
+type webhookComment struct {
+	ID        int64       `json:"id"`
+	PostID    int64       `json:"post_id"`
+	PostTitle string      `json:"post_title"`
+	Content   string      `json:"content"`
+	URL       string      `json:"url"`
+	Author    webhookUser `json:"author"`
+}
+
+type webhookReaction struct {
+	TargetType string      `json:"target_type"`
+	TargetID   int64       `json:"target_id"`
+	PostID     int64       `json:"post_id"`
+	URL        string      `json:"url"`
+	User       webhookUser `json:"user"`
+	Reaction   int         `json:"reaction"` // 1 for like, -1 for dislike, 0 when taken back
+	Likes      int         `json:"likes"`
+	Dislikes   int         `json:"dislikes"`
+}
+
+// WebhooksHandler lists the webhooks and adds new ones. Admins only.
+func WebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	db := getDB(r)
+	user := getUserFromContext(r)
+	if !user.IsAdmin() {
+		RenderErrorPage(w, http.StatusNotFound)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Title":  "Webhooks",
+		"Events": models.WebhookEvents,
+		"New":    &models.Webhook{Events: models.WebhookEvents},
+		"User":   user,
+	}
+
+	if r.Method == "POST" {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Failed to parse form", http.StatusBadRequest)
+			return
+		}
+		webhookID, err := models.CreateWebhook(db, r.FormValue("url"), r.Form["events"])
+		if err == nil {
+			http.Redirect(w, r, fmt.Sprintf("/admin/webhooks/%d", webhookID), http.StatusSeeOther)
+			return
+		}
+		switch err.Error() {
+		case "invalid webhook URL", "choose at least one event", "unknown event":
+			data["ErrorMsg"] = err.Error()
+			data["New"] = &models.Webhook{URL: r.FormValue("url"), Events: r.Form["events"]}
+			w.WriteHeader(http.StatusBadRequest)
+		default:
+			http.Error(w, fmt.Sprintf("Failed to create webhook: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	webhooks, err := models.GetWebhooks(db)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get webhooks: %v", err), http.StatusInternalServerError)
+		return
+	}
+	data["Webhooks"] = webhooks
+
+	renderTemplate(w, "webhooks.html", data)
+}
+
+// WebhookHandler shows a webhook with its settings and delivery log. Admins only.
+func WebhookHandler(w http.ResponseWriter, r *http.Request) {
+	db := getDB(r)
+	user := getUserFromContext(r)
+	if !user.IsAdmin() {
+		RenderErrorPage(w, http.StatusNotFound)
+		return
+	}
+
+	webhookID, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/admin/webhooks/"), 10, 64)
+	if err != nil {
+		RenderErrorPage(w, http.StatusNotFound)
+		return
+	}
+	hook, err := models.GetWebhook(db, webhookID)
+	if err != nil {
+		if err.Error() == "webhook not found" {
+			RenderErrorPage(w, http.StatusNotFound)
+		} else {
+			http.Error(w, fmt.Sprintf("Failed to get webhook: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	page := pageParam(r, "page")
+	deliveries, total, err := models.GetWebhookDeliveries(db, webhookID, webhookDeliveriesPageSize, (page-1)*webhookDeliveriesPageSize)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get deliveries: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	renderTemplate(w, "webhook.html", map[string]interface{}{
+		"Title":      "Webhook",
+		"Webhook":    hook,
+		"Events":     models.WebhookEvents,
+		"Deliveries": deliveries,
+		"Page":       page,
+		"HasMore":    page*webhookDeliveriesPageSize < total,
+		"ErrorMsg":   r.URL.Query().Get("error"),
+		"User":       user,
+	})
+}
+
+// UpdateWebhookHandler changes a webhook's address and events, or turns it on or off
+func UpdateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	db := getDB(r)
+	user := getUserFromContext(r)
+	if !user.IsAdmin() {
+		RenderErrorPage(w, http.StatusNotFound)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+	webhookID, err := strconv.ParseInt(r.FormValue("webhook_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+		return
+	}
+	webhookURL := fmt.Sprintf("/admin/webhooks/%d", webhookID)
+
+	err = models.UpdateWebhook(db, webhookID, r.FormValue("url"), r.Form["events"], r.FormValue("active") == "on")
+	if err != nil {
+		switch err.Error() {
+		case "webhook not found":
+			http.NotFound(w, r)
+		case "invalid webhook URL", "choose at least one event", "unknown event":
+			http.Redirect(w, r, webhookURL+"?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+		default:
+			http.Error(w, fmt.Sprintf("Failed to update webhook: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	http.Redirect(w, r, webhookURL, http.StatusSeeOther)
+}
+
+// DeleteWebhookHandler removes a webhook and its delivery log
+func DeleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	db := getDB(r)
+	user := getUserFromContext(r)
+	if !user.IsAdmin() {
+		RenderErrorPage(w, http.StatusNotFound)
+		return
+	}
+
+	webhookID, err := strconv.ParseInt(r.FormValue("webhook_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := models.DeleteWebhook(db, webhookID); err != nil {
+		if err.Error() == "webhook not found" {
+			http.NotFound(w, r)
+		} else {
+			http.Error(w, fmt.Sprintf("Failed to delete webhook: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	http.Redirect(w, r, "/admin/webhooks", http.StatusSeeOther)
+}
+
+// PingWebhookHandler queues a ping event, to check that a webhook is reachable
+func PingWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	db := getDB(r)
+	user := getUserFromContext(r)
+	if !user.IsAdmin() {
+		RenderErrorPage(w, http.StatusNotFound)
+		return
+	}
+
+	webhookID, err := strconv.ParseInt(r.FormValue("webhook_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+		return
+	}
+	if _, err := models.GetWebhook(db, webhookID); err != nil {
+		if err.Error() == "webhook not found" {
+			http.NotFound(w, r)
+		} else {
+			http.Error(w, fmt.Sprintf("Failed to get webhook: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	payload, err := json.Marshal(webhookPayload{
+		Event:     models.WebhookPing,
+		CreatedAt: time.Now().UTC(),
+		Data:      map[string]string{"message": "Webhook is set up"},
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode ping: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if _, err := models.QueueWebhookDelivery(db, webhookID, models.WebhookPing, payload); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to queue ping: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/admin/webhooks/%d", webhookID), http.StatusSeeOther)
+}
+
+// RedeliverWebhookHandler sends the payload of an earlier delivery again
+func RedeliverWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	db := getDB(r)
+	user := getUserFromContext(r)
+	if !user.IsAdmin() {
+		RenderErrorPage(w, http.StatusNotFound)
+		return
+	}
+
+	deliveryID, err := strconv.ParseInt(r.FormValue("delivery_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid delivery ID", http.StatusBadRequest)
+		return
+	}
+
+	webhookID, err := models.RedeliverWebhook(db, deliveryID)
+	if err != nil {
+		if err.Error() == "delivery not found" {
+			http.NotFound(w, r)
+		} else {
+			http.Error(w, fmt.Sprintf("Failed to redeliver: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/admin/webhooks/%d", webhookID), http.StatusSeeOther)
+}
+
+// DeliverWebhooks sends the webhook deliveries that are due, scheduling retries for the failed ones
+func DeliverWebhooks(db *sql.DB) {
+	deliveries, err := models.GetDueWebhookDeliveries(db, time.Now(), webhookBatchSize)
+	if err != nil {
+		log.Printf("Failed to get webhook deliveries: %v", err)
+		return
+	}
+	if len(deliveries) == 0 {
+		return
+	}
+
+	webhooks, err := models.GetWebhooks(db)
+	if err != nil {
+		log.Printf("Failed to get webhooks: %v", err)
+		return
+	}
+	byID := make(map[int64]*models.Webhook, len(webhooks))
+	for i := range webhooks {
+		byID[webhooks[i].ID] = &webhooks[i]
+	}
+
+	for i := range deliveries {
+		d := &deliveries[i]
+		hook := byID[d.WebhookID]
+		if hook == nil {
+			continue
+		}
+
+		result, err := webhook.Send(WebhookClient, &webhook.Delivery{
+			ID:     d.ID,
+			URL:    hook.URL,
+			Secret: hook.Secret,
+			Event:  d.Event,
+			Body:   []byte(d.Payload),
+		}, time.Now())
+
+		var statusCode int
+		var responseBody string
+		if result != nil {
+			statusCode, responseBody = result.StatusCode, result.Body
+		}
+
+		if err != nil {
+			gaveUp, markErr := models.MarkWebhookFailed(db, d, statusCode, responseBody, err, time.Now())
+			if markErr != nil {
+				log.Printf("Failed to update webhook delivery %d: %v", d.ID, markErr)
+			} else if gaveUp {
+				log.Printf("Giving up on webhook delivery %d to %s: %v", d.ID, hook.URL, err)
+			} else {
+				log.Printf("Failed to deliver webhook %d to %s, will retry: %v", d.ID, hook.URL, err)
+			}
+			continue
+		}
+
+		if err := models.MarkWebhookDelivered(db, d.ID, statusCode, responseBody, time.Now()); err != nil {
+			log.Printf("Failed to update webhook delivery %d: %v", d.ID, err)
+		}
+	}
+}
+
+// CleanWebhookDeliveries removes old delivered and failed deliveries from the log
+func CleanWebhookDeliveries(db *sql.DB) {
+	if _, err := models.CleanWebhookDeliveries(db, time.Now().Add(-webhookRetention)); err != nil {
+		log.Printf("Failed to clean webhook deliveries: %v", err)
+	}
+}
+
+// queueWebhookEvent sends an event to the webhooks subscribed to it. Failures are logged
+// since what the event is about already happened.
+func queueWebhookEvent(db *sql.DB, event string, data interface{}) {
+	payload, err := json.Marshal(webhookPayload{Event: event, CreatedAt: time.Now().UTC(), Data: data})
+	if err == nil {
+		err = models.QueueWebhookEvent(db, event, payload)
+	}
+	if err != nil {
+		log.Printf("Failed to queue %s webhooks: %v", event, err)
+	}
+}
+
+func newWebhookUser(db *sql.DB, userID int64) (webhookUser, error) {
+	user, err := models.GetUserByID(db, userID)
+	if err != nil {
+		return webhookUser{}, err
+	}
+	return webhookUser{ID: user.ID, Username: user.Username, URL: BaseURL + userURL(user.Username)}, nil
+}
+
+// webhookPostCreated tells webhooks about a new post
+func webhookPostCreated(db *sql.DB, postID int64) {
+	post, err := models.GetPostByID(db, postID, 0)
+	if err != nil {
+		log.Printf("Failed to get post %d for webhooks: %v", postID, err)
+		return
+	}
+	author, err := newWebhookUser(db, post.UserID)
+	if err != nil {
+		log.Printf("Failed to get author of post %d for webhooks: %v", postID, err)
+		return
+	}
+
+	categories := []string{}
+	for _, c := range post.Categories {
+		categories = append(categories, c.Name)
+	}
+	queueWebhookEvent(db, models.WebhookPostCreated, webhookPost{
+		ID:         post.ID,
+		Title:      post.Title,
+		Content:    post.Content,
+		Categories: categories,
+		URL:        fmt.Sprintf("%s/post/%d", BaseURL, post.ID),
+		Author:     author,
+		CreatedAt:  post.CreatedAt,
+	})
+}
+
+// webhookCommentCreated tells webhooks about a new comment
+func webhookCommentCreated(db *sql.DB, postID, commentID, authorID int64, content string) {
+	post, err := models.GetPostByID(db, postID, 0)
+	if err != nil {
+		log.Printf("Failed to get post %d for webhooks: %v", postID, err)
+		return
+	}
+	author, err := newWebhookUser(db, authorID)
+	if err != nil {
+		log.Printf("Failed to get author of comment %d for webhooks: %v", commentID, err)
+		return
+	}
+
+	queueWebhookEvent(db, models.WebhookCommentCreated, webhookComment{
+		ID:        commentID,
+		PostID:    postID,
+		PostTitle: post.Title,
+		Content:   content,
+		URL:       fmt.Sprintf("%s/post/%d#comment-%d", BaseURL, postID, commentID),
+		Author:    author,
+	})
+}
+
+// webhookReactionChanged tells webhooks that a user liked, disliked or stopped reacting to a post or comment
+func webhookReactionChanged(db *sql.DB, userID int64, targetType string, targetID int64) {
+	data := webhookReaction{TargetType: targetType, TargetID: targetID}
+	var err error
+	if targetType == "comment" {
+		data.PostID, err = models.GetCommentPostID(db, targetID)
+		if err == nil {
+			data.Reaction, err = models.GetUserCommentReaction(db, targetID, userID)
+		}
+		if err == nil {
+			data.Likes, data.Dislikes, err = models.GetCommentReactionStats(db, targetID)
+		}
+		data.URL = fmt.Sprintf("%s/post/%d#comment-%d", BaseURL, data.PostID, targetID)
+	} else {
+		data.PostID = targetID
+		data.Reaction, err = models.GetUserPostReaction(db, targetID, userID)
+		if err == nil {
+			data.Likes, data.Dislikes, err = models.GetPostReactionStats(db, targetID)
+		}
+		data.URL = fmt.Sprintf("%s/post/%d", BaseURL, targetID)
+	}
+	if err == nil {
+		data.User, err = newWebhookUser(db, userID)
+	}
+	if err != nil {
+		log.Printf("Failed to get reaction on %s %d for webhooks: %v", targetType, targetID, err)
+		return
+	}
+
+	queueWebhookEvent(db, models.WebhookReaction, data)
+}
+
+// webhookUserRegistered tells webhooks about a new account
+func webhookUserRegistered(db *sql.DB, userID int64) {
+	user, err := newWebhookUser(db, userID)
+	if err != nil {
+		log.Printf("Failed to get user %d for webhooks: %v", userID, err)
+		return
+	}
+	queueWebhookEvent(db, models.WebhookUserRegistered, user)
+}
@@ -0,0 +1,110 @@
+package highlight
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// hint is a pattern suggesting a language, counted once per matching line
+type hint struct {
+	lang    string
+	pattern *regexp.Regexp
+	weight  int
+}
+
+var hints = []hint{
+	{"go", regexp.MustCompile(`^package \w+$`), 5},
+	{"go", regexp.MustCompile(`^\s*func\s+(\(\w+ \*?\w+\)\s*)?\w+\(`), 3},
+	{"go", regexp.MustCompile(`\w+\s*:=`), 2},
+	{"go", regexp.MustCompile(`\b(fmt|errors|strings|http)\.[A-Z]`), 2},
+	{"go", regexp.MustCompile(`\bif err != nil\b`), 4},
+
+	{"python", regexp.MustCompile(`^\s*def \w+\(.*\)\s*(->\s*[\w\[\], ]+)?:\s*$`), 4},
+	{"python", regexp.MustCompile(`^\s*(from [\w.]+ )?import [\w.]+( as \w+)?$`), 2},
+	{"python", regexp.MustCompile(`^\s*(if|elif|for|while|with|try|except|class)\b[^{;]*:\s*$`), 2},
+	{"python", regexp.MustCompile(`\bself\.\w+`), 2},
+	{"python", regexp.MustCompile(`\b(None|True|False)\b`), 1},
+
+	{"javascript", regexp.MustCompile(`\b(const|let|var)\s+\w+\s*=`), 2},
+	{"javascript", regexp.MustCompile(`=>`), 2},
+	{"javascript", regexp.MustCompile(`\bfunction\s*\w*\s*\(`), 2},
+	{"javascript", regexp.MustCompile(`\b(console\.log|document\.|window\.|addEventListener)`), 3},
+	{"javascript", regexp.MustCompile(`\bimport .* from ['"]|\brequire\(['"]`), 3},
+
+	{"java", regexp.MustCompile(`\bpublic\s+(static\s+)?(final\s+)?(class|void|interface)\b`), 4},
+	{"java", regexp.MustCompile(`System\.out\.print`), 5},
+	{"java", regexp.MustCompile(`^\s*@Override\b`), 3},
+	{"java", regexp.MustCompile(`\b(private|protected)\s+\w+(<[\w, ]+>)?\s+\w+`), 2},
+
+	{"c", regexp.MustCompile(`^#include\s*[<"]`), 5},
+	{"c", regexp.MustCompile(`\bint\s+main\s*\(`), 4},
+	{"c", regexp.MustCompile(`\b(printf|malloc|sizeof)\s*\(`), 2},
+	{"c", regexp.MustCompile(`\bstd::|\bcout\s*<<`), 4},
+
+	{"rust", regexp.MustCompile(`^\s*(pub\s+)?fn\s+\w+`), 4},
+	{"rust", regexp.MustCompile(`\blet\s+mut\b`), 4},
+	{"rust", regexp.MustCompile(`\b(println|vec|format)!`), 4},
+	{"rust", regexp.MustCompile(`^\s*(impl|use|mod)\b.*[;{]\s*$`), 2},
+
+	{"sql", regexp.MustCompile(`(?i)\bselect\b.+\bfrom\b`), 4},
+	{"sql", regexp.MustCompile(`(?i)^\s*(insert\s+into|create\s+(table|index)|update\s+\w+\s+set|delete\s+from|alter\s+table)\b`), 5},
+	{"sql", regexp.MustCompile(`(?i)^\s*(where|from|join|group by|order by|limit)\b`), 2},
+
+	{"bash", regexp.MustCompile(`^#!.*\b(ba|z)?sh\b`), 10},
+	{"bash", regexp.MustCompile(`^\s*\$ \w`), 4},
+	{"bash", regexp.MustCompile(`^\s*(sudo|apt|apt-get|brew|npm|yarn|pip|go|git|docker|curl|cd|mkdir|export|echo)\s`), 3},
+	{"bash", regexp.MustCompile(`^\s*(fi|done|esac)\s*$`), 4},
+
+	{"html", regexp.MustCompile(`(?i)<(!doctype|html|head|body|div|span|p|a|ul|li|script|form|input|template)\b[^>]*>`), 3},
+	{"html", regexp.MustCompile(`</\w+>`), 2},
+
+	{"css", regexp.MustCompile(`^\s*[.#]?[\w-]+(\s*[,>+~]?\s*[.#:]?[\w-]+)*\s*\{\s*$`), 2},
+	{"css", regexp.MustCompile(`^\s*[\w-]+\s*:\s*[^;{}]+;\s*$`), 2},
+
+	{"yaml", regexp.MustCompile(`^\s*[\w-]+:(\s+[^\s{(;]+.*)?$`), 1},
+	{"yaml", regexp.MustCompile(`^\s*- [\w-]+:`), 3},
+	{"yaml", regexp.MustCompile(`^---\s*$`), 2},
+
+	{"diff", regexp.MustCompile(`^@@ .* @@`), 6},
+	{"diff", regexp.MustCompile(`^diff --git `), 6},
+	{"diff", regexp.MustCompile(`^(\+\+\+|---) \S`), 3},
+}
+
+// minDetectScore is the score a language needs before it is used for highlighting
+const minDetectScore = 4
+
+// Detect guesses the language of a code sample, returning "" when unsure
+func Detect(code string) string {
+	trimmed := strings.TrimSpace(code)
+	if trimmed == "" {
+		return ""
+	}
+	if (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid([]byte(trimmed)) {
+		return "json"
+	}
+
+	scores := map[string]int{}
+	lines := strings.Split(code, "\n")
+	if len(lines) > 200 {
+		lines = lines[:200]
+	}
+	for _, line := range lines {
+		for _, h := range hints {
+			if h.pattern.MatchString(line) {
+				scores[h.lang] += h.weight
+			}
+		}
+	}
+
+	best, bestScore := "", 0
+	for _, lang := range languages {
+		if scores[lang.name] > bestScore {
+			best, bestScore = lang.name, scores[lang.name]
+		}
+	}
+	if bestScore < minDetectScore {
+		return ""
+	}
+	return best
+}
@@ -0,0 +1,64 @@
+// Package highlight renders code blocks as HTML with syntax highlighting.
+// Tokens are marked with "hl-" CSS classes so colors come from the stylesheet.
+package highlight
+
+import (
+	"html"
+	"strings"
+)
+
+// MaxBytes is the largest code block that is highlighted; bigger blocks are shown as plain text
+var MaxBytes = 64 << 10
+
+// Render returns the HTML of a code block. When lang is empty or unknown the
+// language is detected from the code. Each line is wrapped in a span so the
+// stylesheet can number lines without the numbers being copied.
+func Render(code, lang string) string {
+	code = strings.TrimSuffix(code, "\n")
+
+	language := lookup(lang)
+	if language == nil {
+		language = lookup(Detect(code))
+	}
+
+	var tokens []token
+	if language != nil && len(code) <= MaxBytes {
+		tokens = tokenize(code, language)
+	} else {
+		tokens = []token{{classNone, code}}
+	}
+
+	var b strings.Builder
+	b.WriteString(`<div class="code-block"><pre><code`)
+	switch {
+	case language != nil:
+		b.WriteString(` class="language-` + language.name + `"`)
+	case lang != "":
+		b.WriteString(` class="language-` + html.EscapeString(lang) + `"`)
+	}
+	b.WriteString(">")
+	writeLines(&b, tokens)
+	b.WriteString("</code></pre></div>\n")
+	return b.String()
+}
+
+// writeLines writes the tokens line by line, reopening token spans that cross line breaks
+func writeLines(b *strings.Builder, tokens []token) {
+	b.WriteString(`<span class="line">`)
+	for _, t := range tokens {
+		for i, part := range strings.Split(t.text, "\n") {
+			if i > 0 {
+				b.WriteString("</span>\n" + `<span class="line">`)
+			}
+			if part == "" {
+				continue
+			}
+			if t.class == classNone {
+				b.WriteString(html.EscapeString(part))
+				continue
+			}
+			b.WriteString(`<span class="hl-` + t.class + `">` + html.EscapeString(part) + "</span>")
+		}
+	}
+	b.WriteString("</span>\n")
+}
@@ -0,0 +1,78 @@
+package highlight
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     string
+		expected string
+	}{
+		{"go", "package main\n\nfunc main() {\n\tx := 1\n}", "go"},
+		{"python", "import os\n\ndef main():\n    print(os.getcwd())", "python"},
+		{"javascript", "const items = list.map((x) => x * 2);\nconsole.log(items);", "javascript"},
+		{"sql", "SELECT id FROM users\nWHERE name = 'alice';", "sql"},
+		{"bash", "#!/bin/sh\necho hi", "bash"},
+		{"json", `{"name": "forum", "tags": [1, 2]}`, "json"},
+		{"html", "<div class=\"box\">\n  <p>Hello</p>\n</div>", "html"},
+		{"diff", "--- a/x.go\n+++ b/x.go\n@@ -1,2 +1,2 @@\n-old\n+new", "diff"},
+		{"prose", "Just a sentence about something.", ""},
+		{"empty", "  \n", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Detect(tt.code); got != tt.expected {
+				t.Errorf("Detect(%q) = %q, expected %q", tt.code, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     string
+		lang     string
+		contains []string
+	}{
+		{"keywords and strings", "func f() string { return \"<b>\" }", "go", []string{
+			`<code class="language-go">`,
+			`<span class="hl-keyword">func</span>`,
+			`<span class="hl-function">f</span>`,
+			`<span class="hl-type">string</span>`,
+			`<span class="hl-string">&#34;&lt;b&gt;&#34;</span>`,
+		}},
+		{"alias", "x = None", "py", []string{`class="language-python"`, `<span class="hl-literal">None</span>`}},
+		{"case insensitive keywords", "select 1", "sql", []string{`<span class="hl-keyword">select</span>`}},
+		{"comment spanning lines", "/* a\nb */ x", "c", []string{
+			`<span class="line"><span class="hl-comment">/* a</span></span>`,
+			`<span class="line"><span class="hl-comment">b */</span> x</span>`,
+		}},
+		{"json keys", `{"a": 1}`, "json", []string{`<span class="hl-property">&#34;a&#34;</span>`}},
+		{"markup", `<a href="/x">y</a>`, "html", []string{
+			`<span class="hl-tag">&lt;a</span>`,
+			`<span class="hl-attr">href</span>`,
+			`<span class="hl-string">&#34;/x&#34;</span>`,
+		}},
+		{"unknown language", "<script>", "brainfuck", []string{`class="language-brainfuck"`, "&lt;script&gt;"}},
+		{"line per source line", "a\nb\n", "text", []string{`<span class="line">a</span>` + "\n" + `<span class="line">b</span>` + "\n</code>"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Render(tt.code, tt.lang)
+			if !strings.HasPrefix(got, `<div class="code-block"><pre><code`) {
+				t.Errorf("Render(%q) = %q, expected a code block", tt.code, got)
+			}
+			for _, want := range tt.contains {
+				if !strings.Contains(got, want) {
+					t.Errorf("Render(%q, %q) = %q, expected it to contain %q", tt.code, tt.lang, got, want)
+				}
+			}
+		})
+	}
+}
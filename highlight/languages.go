@@ -0,0 +1,200 @@
+package highlight
+
+import "strings"
+
+// language describes how to tokenize the source of one language
+type language struct {
+	name            string
+	aliases         []string
+	keywords        []string
+	types           []string
+	literals        []string
+	builtins        []string
+	lineComments    []string
+	blockComments   [][2]string
+	quotes          string // string delimiters with backslash escapes
+	rawQuotes       string // string delimiters without escapes
+	multilineQuotes string // delimiters whose strings may span lines
+	tripleQuotes    bool   // Python style """ and ''' strings
+	caseInsensitive bool   // keywords match in any case, as in SQL
+	variables       bool   // $name and ${name} are variables
+	preprocessor    bool   // lines starting with # are directives
+	annotations     bool   // @name is an annotation or decorator
+	keys            bool   // names and strings before a colon are keys
+	markup          bool   // HTML and XML
+	diff            bool   // unified diffs
+}
+
+var languages = []*language{
+	{
+		name: "go",
+		keywords: words("break case chan const continue default defer else fallthrough for func go goto if " +
+			"import interface map package range return select struct switch type var"),
+		types: words("bool byte complex64 complex128 error float32 float64 int int8 int16 int32 int64 rune " +
+			"string uint uint8 uint16 uint32 uint64 uintptr any comparable"),
+		literals:        words("true false nil iota"),
+		builtins:        words("append cap close complex copy delete imag len make new panic print println real recover min max clear"),
+		lineComments:    []string{"//"},
+		blockComments:   [][2]string{{"/*", "*/"}},
+		quotes:          `"'`,
+		rawQuotes:       "`",
+		multilineQuotes: "`",
+	},
+	{
+		name:    "javascript",
+		aliases: []string{"js", "jsx", "mjs", "typescript", "ts", "tsx"},
+		keywords: words("async await break case catch class const continue debugger default delete do else export " +
+			"extends finally for from function if import in instanceof let new of return static super switch this " +
+			"throw try typeof var void while with yield interface type implements enum as readonly"),
+		types:           words("string number boolean any unknown never object Array Promise Map Set Date Error"),
+		literals:        words("true false null undefined NaN Infinity"),
+		builtins:        words("console document window JSON Math Object parseInt parseFloat require module exports fetch"),
+		lineComments:    []string{"//"},
+		blockComments:   [][2]string{{"/*", "*/"}},
+		quotes:          "\"'`",
+		multilineQuotes: "`",
+	},
+	{
+		name:    "python",
+		aliases: []string{"py", "python3"},
+		keywords: words("and as assert async await break class continue def del elif else except finally for from " +
+			"global if import in is lambda nonlocal not or pass raise return try while with yield match case"),
+		types:        words("int float str bool list dict set tuple bytes object type"),
+		literals:     words("True False None self cls"),
+		builtins:     words("print len range open enumerate zip map filter sorted isinstance super abs min max sum any all input"),
+		lineComments: []string{"#"},
+		quotes:       `"'`,
+		tripleQuotes: true,
+		annotations:  true,
+	},
+	{
+		name:    "java",
+		aliases: []string{"kotlin", "kt", "scala"},
+		keywords: words("abstract assert break case catch class continue default do else enum extends final finally " +
+			"for if implements import instanceof interface native new package private protected public return static " +
+			"super switch synchronized this throw throws transient try volatile while var val fun"),
+		types:         words("boolean byte char double float int long short void String Object Integer List Map"),
+		literals:      words("true false null"),
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        `"'`,
+		annotations:   true,
+	},
+	{
+		name:    "c",
+		aliases: []string{"h", "cpp", "c++", "cc", "hpp", "cxx", "csharp", "cs", "c#"},
+		keywords: words("auto break case catch class const constexpr continue default delete do else enum explicit " +
+			"extern for friend goto if inline namespace new operator private protected public register return sizeof " +
+			"static struct switch template this throw try typedef typename union using virtual volatile while"),
+		types: words("bool char double float int long short signed unsigned void size_t int8_t int16_t int32_t " +
+			"int64_t uint8_t uint16_t uint32_t uint64_t string vector std"),
+		literals:      words("true false NULL nullptr"),
+		builtins:      words("printf scanf malloc free memcpy strlen cout cin endl"),
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        `"'`,
+		preprocessor:  true,
+	},
+	{
+		name:    "rust",
+		aliases: []string{"rs"},
+		keywords: words("as async await break const continue crate dyn else enum extern fn for if impl in let loop " +
+			"match mod move mut pub ref return self Self static struct super trait type unsafe use where while"),
+		types: words("bool char f32 f64 i8 i16 i32 i64 i128 isize str u8 u16 u32 u64 u128 usize String Vec " +
+			"Option Result Box"),
+		literals:      words("true false None Some Ok Err"),
+		builtins:      words("println print format vec panic assert assert_eq"),
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        `"`,
+	},
+	{
+		name:    "sql",
+		aliases: []string{"sqlite", "mysql", "postgresql", "postgres", "psql"},
+		keywords: words("add all alter and as asc begin between by case check column commit constraint create " +
+			"default delete desc distinct drop else end exists foreign from group having if in index inner insert " +
+			"into is join key left like limit not null offset on or order outer primary references returning right " +
+			"rollback select set table then transaction union unique update values view when where with conflict do"),
+		types:           words("integer int text varchar char real float double boolean date timestamp blob numeric"),
+		literals:        words("true false null"),
+		builtins:        words("count sum avg min max coalesce length lower upper substr datetime now"),
+		lineComments:    []string{"--"},
+		blockComments:   [][2]string{{"/*", "*/"}},
+		quotes:          `'"`,
+		caseInsensitive: true,
+	},
+	{
+		name:    "bash",
+		aliases: []string{"sh", "shell", "zsh", "console", "shell-session"},
+		keywords: words("if then else elif fi for while until do done case esac in function return local export " +
+			"readonly select"),
+		builtins: words("echo cd ls cat grep sed awk rm cp mv mkdir chmod sudo git go npm docker curl make " +
+			"source set unset exit printf read test"),
+		lineComments: []string{"#"},
+		quotes:       `"`,
+		rawQuotes:    `'`,
+		variables:    true,
+	},
+	{
+		name:     "json",
+		literals: words("true false null"),
+		quotes:   `"`,
+		keys:     true,
+	},
+	{
+		name:         "yaml",
+		aliases:      []string{"yml"},
+		literals:     words("true false null yes no on off"),
+		lineComments: []string{"#"},
+		quotes:       `"`,
+		rawQuotes:    `'`,
+		keys:         true,
+	},
+	{
+		name:          "css",
+		aliases:       []string{"scss", "less"},
+		keywords:      words("important media import from to"),
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        `"'`,
+		keys:          true,
+	},
+	{
+		name:    "html",
+		aliases: []string{"xml", "svg", "xhtml", "vue"},
+		markup:  true,
+	},
+	{
+		name:    "diff",
+		aliases: []string{"patch"},
+		diff:    true,
+	},
+}
+
+// lookup finds a language by name or alias
+func lookup(name string) *language {
+	name = strings.ToLower(name)
+	for _, lang := range languages {
+		if lang.name == name {
+			return lang
+		}
+		for _, alias := range lang.aliases {
+			if alias == name {
+				return lang
+			}
+		}
+	}
+	return nil
+}
+
+func words(s string) []string {
+	return strings.Fields(s)
+}
+
+func contains(list []string, word string, caseInsensitive bool) bool {
+	for _, w := range list {
+		if w == word || (caseInsensitive && strings.EqualFold(w, word)) {
+			return true
+		}
+	}
+	return false
+}
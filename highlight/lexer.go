@@ -0,0 +1,349 @@
+package highlight
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Token classes, used as CSS classes prefixed with "hl-"
+const (
+	classNone     = ""
+	classKeyword  = "keyword"
+	classType     = "type"
+	classLiteral  = "literal"
+	classBuiltin  = "builtin"
+	classString   = "string"
+	classNumber   = "number"
+	classComment  = "comment"
+	classFunction = "function"
+	classOperator = "operator"
+	classVariable = "variable"
+	classMeta     = "meta"
+	classProperty = "property"
+	classTag      = "tag"
+	classAttr     = "attr"
+	classAddition = "addition"
+	classDeletion = "deletion"
+)
+
+type token struct {
+	class string
+	text  string
+}
+
+var (
+	reNumber     = regexp.MustCompile(`^(0[xX][0-9a-fA-F_]+|0[bBoO][0-7_]+|[0-9][0-9_]*(\.[0-9_]+)?([eE][+-]?[0-9]+)?|\.[0-9]+([eE][+-]?[0-9]+)?)[A-Za-z%]*`)
+	reIdentifier = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*`)
+	reCSSWord    = regexp.MustCompile(`^-?[A-Za-z_][A-Za-z0-9_-]*`)
+	reVariable   = regexp.MustCompile(`^\$(\{[^}\n]*\}|[A-Za-z_][A-Za-z0-9_]*|[0-9@#?*$!-])`)
+	reAnnotation = regexp.MustCompile(`^@[A-Za-z_][A-Za-z0-9_.]*`)
+	reHexColor   = regexp.MustCompile(`^#[0-9a-fA-F]{3,8}\b`)
+	reTagOpen    = regexp.MustCompile(`^</?[A-Za-z][A-Za-z0-9:_.-]*`)
+	reAttrName   = regexp.MustCompile(`^[^\s"'<>/=]+`)
+)
+
+const operatorChars = "+-*/%=<>!&|^~?:"
+
+// tokenize splits code into classified tokens
+func tokenize(code string, lang *language) []token {
+	switch {
+	case lang.markup:
+		return tokenizeMarkup(code)
+	case lang.diff:
+		return tokenizeDiff(code)
+	}
+
+	var tokens []token
+	emit := func(class, text string) {
+		// Merge runs of the same class to keep the output small
+		if n := len(tokens); n > 0 && tokens[n-1].class == class {
+			tokens[n-1].text += text
+			return
+		}
+		tokens = append(tokens, token{class, text})
+	}
+
+	lineStart := true
+	for pos := 0; pos < len(code); {
+		rest := code[pos:]
+		c := rest[0]
+
+		if c == ' ' || c == '\t' || c == '\r' || c == '\n' {
+			if c == '\n' {
+				lineStart = true
+			}
+			emit(classNone, rest[:1])
+			pos++
+			continue
+		}
+		atLineStart := lineStart
+		lineStart = false
+
+		if lang.preprocessor && c == '#' && atLineStart {
+			end := lineEnd(rest)
+			emit(classMeta, rest[:end])
+			pos += end
+			continue
+		}
+
+		if prefix := hasAnyPrefix(rest, lang.lineComments); prefix != "" {
+			end := lineEnd(rest)
+			emit(classComment, rest[:end])
+			pos += end
+			continue
+		}
+
+		if n := blockComment(rest, lang.blockComments); n > 0 {
+			emit(classComment, rest[:n])
+			pos += n
+			continue
+		}
+
+		if lang.tripleQuotes && (strings.HasPrefix(rest, `"""`) || strings.HasPrefix(rest, "'''")) {
+			end := strings.Index(rest[3:], rest[:3])
+			n := len(rest)
+			if end >= 0 {
+				n = 3 + end + 3
+			}
+			emit(classString, rest[:n])
+			pos += n
+			continue
+		}
+
+		if strings.IndexByte(lang.quotes, c) >= 0 || strings.IndexByte(lang.rawQuotes, c) >= 0 {
+			n := quotedString(rest, strings.IndexByte(lang.rawQuotes, c) < 0, strings.IndexByte(lang.multilineQuotes, c) >= 0)
+			class := classString
+			if lang.keys && followedByColon(rest[n:]) {
+				class = classProperty
+			}
+			emit(class, rest[:n])
+			pos += n
+			continue
+		}
+
+		if lang.variables {
+			if m := reVariable.FindString(rest); m != "" {
+				emit(classVariable, m)
+				pos += len(m)
+				continue
+			}
+		}
+
+		if lang.annotations && c == '@' {
+			if m := reAnnotation.FindString(rest); m != "" {
+				emit(classMeta, m)
+				pos += len(m)
+				continue
+			}
+		}
+
+		if lang.name == "css" && c == '#' {
+			if m := reHexColor.FindString(rest); m != "" {
+				emit(classNumber, m)
+				pos += len(m)
+				continue
+			}
+		}
+
+		if isDigit(c) || (c == '.' && len(rest) > 1 && isDigit(rest[1])) {
+			// Digits inside identifiers were consumed with the identifier
+			m := reNumber.FindString(rest)
+			emit(classNumber, m)
+			pos += len(m)
+			continue
+		}
+
+		identifier := reIdentifier
+		if lang.name == "css" {
+			identifier = reCSSWord
+		}
+		if m := identifier.FindString(rest); m != "" {
+			// Rust macros end in an exclamation mark
+			if lang.name == "rust" && strings.HasPrefix(rest[len(m):], "!") && !strings.HasPrefix(rest[len(m):], "!=") {
+				emit(classFunction, m+"!")
+				pos += len(m) + 1
+				continue
+			}
+			emit(classifyWord(lang, m, rest[len(m):]), m)
+			pos += len(m)
+			continue
+		}
+
+		if strings.IndexByte(operatorChars, c) >= 0 {
+			emit(classOperator, rest[:1])
+		} else {
+			emit(classNone, rest[:1])
+		}
+		pos++
+	}
+	return tokens
+}
+
+// classifyWord picks the class of an identifier from its spelling and what follows it
+func classifyWord(lang *language, name, after string) string {
+	switch {
+	case lang.keys && followedByColon(after) && !contains(lang.keywords, name, lang.caseInsensitive):
+		return classProperty
+	case contains(lang.keywords, name, lang.caseInsensitive):
+		return classKeyword
+	case contains(lang.types, name, lang.caseInsensitive):
+		return classType
+	case contains(lang.literals, name, lang.caseInsensitive):
+		return classLiteral
+	case contains(lang.builtins, name, lang.caseInsensitive):
+		return classBuiltin
+	case strings.HasPrefix(strings.TrimLeft(after, " \t"), "("):
+		return classFunction
+	}
+	return classNone
+}
+
+// tokenizeMarkup highlights tags, attributes and comments of HTML or XML
+func tokenizeMarkup(code string) []token {
+	var tokens []token
+	for pos := 0; pos < len(code); {
+		rest := code[pos:]
+
+		if strings.HasPrefix(rest, "<!--") {
+			end := strings.Index(rest, "-->")
+			n := len(rest)
+			if end >= 0 {
+				n = end + 3
+			}
+			tokens = append(tokens, token{classComment, rest[:n]})
+			pos += n
+			continue
+		}
+
+		if strings.HasPrefix(rest, "<!") || strings.HasPrefix(rest, "<?") {
+			n := strings.IndexByte(rest, '>') + 1
+			if n == 0 {
+				n = len(rest)
+			}
+			tokens = append(tokens, token{classMeta, rest[:n]})
+			pos += n
+			continue
+		}
+
+		tag := reTagOpen.FindString(rest)
+		if tag == "" {
+			// Text runs up to the next tag
+			n := strings.IndexByte(rest[1:], '<') + 1
+			if n == 0 {
+				n = len(rest)
+			}
+			tokens = append(tokens, token{classNone, rest[:n]})
+			pos += n
+			continue
+		}
+
+		tokens = append(tokens, token{classTag, tag})
+		pos += len(tag)
+		for pos < len(code) {
+			rest = code[pos:]
+			c := rest[0]
+			switch {
+			case c == '>' || strings.HasPrefix(rest, "/>"):
+				n := 1
+				if c == '/' {
+					n = 2
+				}
+				tokens = append(tokens, token{classTag, rest[:n]})
+				pos += n
+			case c == '"' || c == '\'':
+				n := quotedString(rest, false, true)
+				tokens = append(tokens, token{classString, rest[:n]})
+				pos += n
+				continue
+			case c == '=' || c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '/':
+				tokens = append(tokens, token{classNone, rest[:1]})
+				pos++
+				continue
+			default:
+				name := reAttrName.FindString(rest)
+				if name == "" {
+					name = rest[:1]
+				}
+				tokens = append(tokens, token{classAttr, name})
+				pos += len(name)
+				continue
+			}
+			break
+		}
+	}
+	return tokens
+}
+
+// tokenizeDiff highlights added, removed and header lines of a unified diff
+func tokenizeDiff(code string) []token {
+	var tokens []token
+	for _, line := range strings.SplitAfter(code, "\n") {
+		class := classNone
+		switch {
+		case strings.HasPrefix(line, "+++") || strings.HasPrefix(line, "---") || strings.HasPrefix(line, "@@") ||
+			strings.HasPrefix(line, "diff ") || strings.HasPrefix(line, "index "):
+			class = classMeta
+		case strings.HasPrefix(line, "+"):
+			class = classAddition
+		case strings.HasPrefix(line, "-"):
+			class = classDeletion
+		}
+		tokens = append(tokens, token{class, line})
+	}
+	return tokens
+}
+
+// quotedString returns the length of the string literal at the start of s
+func quotedString(s string, escapes, multiline bool) int {
+	quote := s[0]
+	for i := 1; i < len(s); i++ {
+		switch {
+		case escapes && s[i] == '\\':
+			i++
+		case s[i] == quote:
+			return i + 1
+		case s[i] == '\n' && !multiline:
+			return i
+		}
+	}
+	return len(s)
+}
+
+// blockComment returns the length of the block comment at the start of s, or 0
+func blockComment(s string, delimiters [][2]string) int {
+	for _, d := range delimiters {
+		if strings.HasPrefix(s, d[0]) {
+			end := strings.Index(s[len(d[0]):], d[1])
+			if end < 0 {
+				return len(s)
+			}
+			return len(d[0]) + end + len(d[1])
+		}
+	}
+	return 0
+}
+
+func hasAnyPrefix(s string, prefixes []string) string {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return p
+		}
+	}
+	return ""
+}
+
+func followedByColon(s string) bool {
+	s = strings.TrimLeft(s, " \t")
+	return strings.HasPrefix(s, ":") && !strings.HasPrefix(s, "::")
+}
+
+func lineEnd(s string) int {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return i
+	}
+	return len(s)
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
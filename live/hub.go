@@ -0,0 +1,127 @@
+// Package live delivers events about new content to open pages.
+package live
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// subscriberBuffer is how many events a subscriber may fall behind before it is dropped
+const subscriberBuffer = 32
+
+// Event is something that happened on a topic, such as a new comment on a post
+type Event struct {
+	ID    uint64 // increases with every event published on the hub
+	Topic string
+	Name  string // event type, e.g. "comment"
+	Data  []byte // JSON payload
+}
+
+// Subscriber receives the events of the topics it subscribed to. Events is closed when
+// the subscriber is unsubscribed or dropped for falling behind.
+type Subscriber struct {
+	Events <-chan Event
+	events chan Event
+	topics []string
+}
+
+// Hub passes events from publishers to the subscribers of their topic. Publishing never
+// blocks: a subscriber that does not keep up is dropped and has to subscribe again.
+type Hub struct {
+	mu     sync.Mutex
+	topics map[string]map[*Subscriber]struct{}
+	lastID uint64
+}
+
+// NewHub creates an empty hub
+func NewHub() *Hub {
+	return &Hub{topics: make(map[string]map[*Subscriber]struct{})}
+}
+
+// Default is the hub the models publish to
+var Default = NewHub()
+
+// Subscribe starts receiving the events of the given topics
+func (h *Hub) Subscribe(topics ...string) *Subscriber {
+	events := make(chan Event, subscriberBuffer)
+	s := &Subscriber{Events: events, events: events, topics: topics}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, topic := range topics {
+		subscribers := h.topics[topic]
+		if subscribers == nil {
+			subscribers = make(map[*Subscriber]struct{})
+			h.topics[topic] = subscribers
+		}
+		subscribers[s] = struct{}{}
+	}
+	return s
+}
+
+// Unsubscribe stops a subscriber. It is safe to call more than once.
+func (h *Hub) Unsubscribe(s *Subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(s)
+}
+
+// remove takes a subscriber off its topics and closes it. The caller holds h.mu.
+func (h *Hub) remove(s *Subscriber) {
+	removed := false
+	for _, topic := range s.topics {
+		subscribers := h.topics[topic]
+		if _, ok := subscribers[s]; ok {
+			delete(subscribers, s)
+			removed = true
+		}
+		if len(subscribers) == 0 {
+			delete(h.topics, topic)
+		}
+	}
+	if removed {
+		close(s.events)
+	}
+}
+
+// Publish sends an event with the JSON encoding of data to the subscribers of a topic
+func (h *Hub) Publish(topic, name string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("encode %s event: %w", name, err)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastID++
+	event := Event{ID: h.lastID, Topic: topic, Name: name, Data: payload}
+	for s := range h.topics[topic] {
+		select {
+		case s.events <- event:
+		default:
+			h.remove(s)
+		}
+	}
+	return nil
+}
+
+// Subscribers counts the subscribers of a topic
+func (h *Hub) Subscribers(topic string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.topics[topic])
+}
+
+// PostTopic is the topic of new comments and reactions on a post
+func PostTopic(postID int64) string {
+	return fmt.Sprintf("post:%d", postID)
+}
+
+// CategoryTopic is the topic of new posts in a category
+func CategoryTopic(categoryID int64) string {
+	return fmt.Sprintf("category:%d", categoryID)
+}
+
+// PostsTopic is the topic of all new posts
+const PostsTopic = "posts"
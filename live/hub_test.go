@@ -0,0 +1,75 @@
+package live
+
+import (
+	"testing"
+)
+
+func TestPublishSubscribe(t *testing.T) {
+	hub := NewHub()
+	post := hub.Subscribe(PostTopic(1))
+	other := hub.Subscribe(PostTopic(2))
+	defer hub.Unsubscribe(post)
+	defer hub.Unsubscribe(other)
+
+	if err := hub.Publish(PostTopic(1), "comment", map[string]int{"id": 7}); err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+
+	select {
+	case event := <-post.Events:
+		if event.Name != "comment" || string(event.Data) != `{"id":7}` || event.ID == 0 {
+			t.Errorf("Unexpected event %+v", event)
+		}
+	default:
+		t.Fatal("Expected an event for the subscriber of the topic")
+	}
+
+	select {
+	case event := <-other.Events:
+		t.Errorf("Expected no event on another topic, got %+v", event)
+	default:
+	}
+
+	if n := hub.Subscribers(PostTopic(1)); n != 1 {
+		t.Errorf("Expected 1 subscriber, got %d", n)
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	hub := NewHub()
+	s := hub.Subscribe(PostsTopic, CategoryTopic(3))
+
+	hub.Unsubscribe(s)
+	hub.Unsubscribe(s)
+
+	if _, ok := <-s.Events; ok {
+		t.Error("Expected the events channel to be closed")
+	}
+	if n := hub.Subscribers(PostsTopic) + hub.Subscribers(CategoryTopic(3)); n != 0 {
+		t.Errorf("Expected no subscribers, got %d", n)
+	}
+	if err := hub.Publish(PostsTopic, "post", nil); err != nil {
+		t.Errorf("Failed to publish without subscribers: %v", err)
+	}
+}
+
+func TestSlowSubscriberDropped(t *testing.T) {
+	hub := NewHub()
+	slow := hub.Subscribe(PostsTopic)
+
+	// Publishing never blocks, even when nobody reads the events
+	for i := 0; i <= subscriberBuffer; i++ {
+		hub.Publish(PostsTopic, "post", i)
+	}
+
+	if n := hub.Subscribers(PostsTopic); n != 0 {
+		t.Fatalf("Expected the slow subscriber to be dropped, got %d subscribers", n)
+	}
+	received := 0
+	for range slow.Events {
+		received++
+	}
+	if received != subscriberBuffer {
+		t.Errorf("Expected %d buffered events before the channel closed, got %d", subscriberBuffer, received)
+	}
+}
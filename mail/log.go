@@ -0,0 +1,11 @@
+package mail
+
+import "log"
+
+// Log writes messages to the server log instead of sending them, for development
+type Log struct{}
+
+func (l *Log) Send(msg *Message) error {
+	log.Printf("Email to %s: %s\n%s", msg.To, msg.Subject, msg.Text)
+	return nil
+}
@@ -0,0 +1,125 @@
+package mail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Message is an email with a plain text and an HTML version
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+	Headers map[string]string // extra headers such as List-Unsubscribe
+}
+
+// Sender delivers email messages
+type Sender interface {
+	Send(msg *Message) error
+}
+
+// FromEnv creates the sender configured by the environment. With SMTP_HOST set, mail is
+// sent through that server (SMTP_PORT, default 587, with SMTP_USERNAME and SMTP_PASSWORD
+// when it requires a login) from MAIL_FROM. Otherwise messages are written to the log.
+func FromEnv() (Sender, error) {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return &Log{}, nil
+	}
+
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		return nil, errors.New("MAIL_FROM is required when SMTP_HOST is set")
+	}
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	return &SMTP{
+		Addr:     host + ":" + port,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     from,
+	}, nil
+}
+
+// Bytes encodes the message as a MIME multipart/alternative email sent from the given address
+func (m *Message) Bytes(from string, date time.Time) ([]byte, error) {
+	for _, value := range []string{from, m.To, m.Subject} {
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, errors.New("header values cannot contain line breaks")
+		}
+	}
+
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", m.Text},
+		{"text/html; charset=utf-8", m.HTML},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 {
+		domain = strings.Trim(from[at+1:], "> ")
+	}
+
+	headers := map[string]string{
+		"From":         from,
+		"To":           m.To,
+		"Subject":      mime.QEncoding.Encode("utf-8", m.Subject),
+		"Date":         date.Format(time.RFC1123Z),
+		"Message-ID":   fmt.Sprintf("<%s@%s>", uuid.New().String(), domain),
+		"MIME-Version": "1.0",
+		"Content-Type": "multipart/alternative; boundary=" + parts.Boundary(),
+	}
+	for name, value := range m.Headers {
+		if strings.ContainsAny(name+value, "\r\n") {
+			return nil, errors.New("header values cannot contain line breaks")
+		}
+		headers[name] = value
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var msg bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&msg, "%s: %s\r\n", name, headers[name])
+	}
+	msg.WriteString("\r\n")
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
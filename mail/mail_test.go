@@ -0,0 +1,66 @@
+package mail
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMessageBytes(t *testing.T) {
+	msg := &Message{
+		To:      "alice@example.com",
+		Subject: "Héllo",
+		Text:    "Plain text",
+		HTML:    "<p>HTML</p>",
+		Headers: map[string]string{"List-Unsubscribe": "<http://localhost/unsubscribe>"},
+	}
+
+	data, err := msg.Bytes("forum@example.com", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Failed to encode message: %v", err)
+	}
+
+	parsed, err := mail.ReadMessage(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatalf("Failed to parse message: %v", err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil || subject != "Héllo" {
+		t.Errorf("Expected subject %q, got %q (%v)", "Héllo", subject, err)
+	}
+	if parsed.Header.Get("List-Unsubscribe") != "<http://localhost/unsubscribe>" {
+		t.Errorf("Missing List-Unsubscribe header")
+	}
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Expected multipart/alternative, got %q (%v)", mediaType, err)
+	}
+	reader := multipart.NewReader(parsed.Body, params["boundary"])
+	var bodies []string
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read part: %v", err)
+		}
+		body, _ := io.ReadAll(part)
+		bodies = append(bodies, part.Header.Get("Content-Type")+" "+string(body))
+	}
+	if len(bodies) != 2 || bodies[0] != "text/plain; charset=utf-8 Plain text" || bodies[1] != "text/html; charset=utf-8 <p>HTML</p>" {
+		t.Errorf("Unexpected parts: %q", bodies)
+	}
+}
+
+func TestMessageHeaderInjection(t *testing.T) {
+	msg := &Message{To: "alice@example.com\r\nBcc: eve@example.com", Subject: "Hi"}
+	if _, err := msg.Bytes("forum@example.com", time.Now()); err == nil {
+		t.Error("Expected an error for a line break in a header")
+	}
+}
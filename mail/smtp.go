@@ -0,0 +1,32 @@
+package mail
+
+import (
+	"net"
+	"net/smtp"
+	"time"
+)
+
+// SMTP sends mail through an SMTP server. STARTTLS is used when the server offers it.
+type SMTP struct {
+	Addr     string // host:port
+	Username string // no login when empty
+	Password string
+	From     string
+}
+
+func (s *SMTP) Send(msg *Message) error {
+	data, err := msg.Bytes(s.From, time.Now())
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	return smtp.SendMail(s.Addr, auth, s.From, []string{msg.To}, data)
+}
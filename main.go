@@ -2,19 +2,39 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
+	"forum/activitypub"
 	"forum/database"
 	"forum/handlers"
+	"forum/mail"
 	"forum/models"
+	"forum/storage"
 	"forum/utils"
+	"forum/webhook"
 )
 
 func main() {
+	// Maintenance commands
+	if len(os.Args) > 1 && os.Args[1] == "migrate-storage" {
+		migrateStorage(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "set-role" {
+		setRole(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "webhook-receiver" {
+		webhookReceiver(os.Args[2:])
+		return
+	}
+
 	// Database initialization
 	db, err := database.InitDB("./forum.db")
 	if err != nil {
@@ -53,9 +73,42 @@ func main() {
 		}
 	}
 
+	// Storage for uploaded files, selected with STORAGE_BACKEND
+	store, err := storage.FromEnv()
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+	handlers.Store = store
+
+	// Email delivery, configured with SMTP_HOST and related variables
+	sender, err := mail.FromEnv()
+	if err != nil {
+		log.Fatalf("Failed to initialize email: %v", err)
+	}
+	handlers.EmailSender = sender
+	if siteURL := os.Getenv("SITE_URL"); siteURL != "" {
+		handlers.BaseURL = strings.TrimRight(siteURL, "/")
+	}
+	handlers.TrustProxy = os.Getenv("TRUST_PROXY") == "true"
+	activitypub.AllowLoopback = os.Getenv("FEDERATION_ALLOW_LOOPBACK") == "true"
+
+	// Rules for crawlers, replacing the default robots.txt
+	if robotsFile := os.Getenv("ROBOTS_FILE"); robotsFile != "" {
+		robots, err := os.ReadFile(robotsFile)
+		if err != nil {
+			log.Fatalf("Failed to read robots file: %v", err)
+		}
+		handlers.RobotsTxt = string(robots)
+	}
+
 	// Apply middleware to all handlers
 	mux := http.NewServeMux()
 
+	// Signed download links of the local storage backend
+	if local, ok := store.(*storage.Local); ok {
+		mux.Handle(storage.LocalURLPrefix, local)
+	}
+
 	// Static file server
 	fs := http.FileServer(http.Dir("./static"))
 
@@ -98,6 +151,20 @@ func main() {
 	mux.HandleFunc("/login", withMiddleware(handlers.LoginHandler))
 	mux.HandleFunc("/logout", withMiddleware(handlers.LogoutHandler))
 
+	// Account routes
+	mux.HandleFunc("/account/settings", withMiddleware(handlers.AuthMiddleware(handlers.AccountSettingsHandler)))
+	mux.HandleFunc("/account/verify-email", withMiddleware(handlers.VerifyEmailHandler))
+	mux.HandleFunc("/account/export", withMiddleware(handlers.AuthMiddleware(handlers.ExportDataHandler)))
+	mux.HandleFunc("/account/delete", withMiddleware(handlers.AuthMiddleware(handlers.DeleteAccountHandler)))
+	mux.HandleFunc("/account/tokens", withMiddleware(handlers.AuthMiddleware(handlers.APITokensHandler)))
+	mux.HandleFunc("/account/tokens/revoke", withMiddleware(handlers.AuthMiddleware(handlers.RevokeAPITokenHandler)))
+	mux.HandleFunc("/account/avatar", withMiddleware(handlers.AuthMiddleware(handlers.UploadAvatarHandler)))
+	mux.HandleFunc("/email/unsubscribe", withMiddleware(handlers.UnsubscribeHandler))
+
+	// User profile routes
+	mux.HandleFunc("/user/", withMiddleware(handlers.UserProfileHandler))
+	mux.HandleFunc("/avatar/", withMiddleware(handlers.AvatarHandler))
+
 	// Post routes
 	// mux.HandleFunc("/", withMiddleware(handlers.HomeHandler))
 	mux.HandleFunc("/post/create", withMiddleware(handlers.AuthMiddleware(handlers.CreatePostHandler)))
@@ -105,14 +172,91 @@ func main() {
 	mux.HandleFunc("/posts/category/", withMiddleware(handlers.CategoryPostsHandler))
 	mux.HandleFunc("/posts/my", withMiddleware(handlers.AuthMiddleware(handlers.MyPostsHandler)))
 	mux.HandleFunc("/posts/liked", withMiddleware(handlers.AuthMiddleware(handlers.LikedPostsHandler)))
+	mux.HandleFunc("/posts/saved", withMiddleware(handlers.AuthMiddleware(handlers.SavedPostsHandler)))
 
 	// Comment routes
 	mux.HandleFunc("/comment/create", withMiddleware(handlers.AuthMiddleware(handlers.CreateCommentHandler)))
 
+	// Attachment routes
+	mux.HandleFunc("/attachments/upload", withMiddleware(handlers.AuthMiddleware(handlers.UploadAttachmentHandler)))
+	mux.HandleFunc("/attachments/", withMiddleware(handlers.ServeAttachmentHandler))
+
+	// Markdown preview
+	mux.HandleFunc("/markdown/preview", withMiddleware(handlers.AuthMiddleware(handlers.PreviewMarkdownHandler)))
+
+	// Draft routes
+	mux.HandleFunc("/drafts", withMiddleware(handlers.AuthMiddleware(handlers.DraftsHandler)))
+	mux.HandleFunc("/drafts/autosave", withMiddleware(handlers.AuthMiddleware(handlers.AutosaveDraftHandler)))
+	mux.HandleFunc("/drafts/delete", withMiddleware(handlers.AuthMiddleware(handlers.DeleteDraftHandler)))
+	mux.HandleFunc("/drafts/publish", withMiddleware(handlers.AuthMiddleware(handlers.PublishDraftHandler)))
+
+	// Bookmark routes
+	mux.HandleFunc("/bookmarks/toggle", withMiddleware(handlers.AuthMiddleware(handlers.ToggleBookmarkHandler)))
+	mux.HandleFunc("/bookmarks/move", withMiddleware(handlers.AuthMiddleware(handlers.MoveBookmarkHandler)))
+	mux.HandleFunc("/bookmarks/folders/create", withMiddleware(handlers.AuthMiddleware(handlers.CreateBookmarkFolderHandler)))
+	mux.HandleFunc("/bookmarks/folders/delete", withMiddleware(handlers.AuthMiddleware(handlers.DeleteBookmarkFolderHandler)))
+
+	// Follow and notification routes
+	mux.HandleFunc("/follow/toggle", withMiddleware(handlers.AuthMiddleware(handlers.ToggleFollowHandler)))
+	mux.HandleFunc("/notifications", withMiddleware(handlers.AuthMiddleware(handlers.NotificationsHandler)))
+	mux.HandleFunc("/notifications/open", withMiddleware(handlers.AuthMiddleware(handlers.OpenNotificationHandler)))
+	mux.HandleFunc("/notifications/read-all", withMiddleware(handlers.AuthMiddleware(handlers.MarkAllNotificationsReadHandler)))
+
+	// Mention and block routes
+	mux.HandleFunc("/users/search", withMiddleware(handlers.AuthMiddleware(handlers.UserSearchHandler)))
+	mux.HandleFunc("/users/block", withMiddleware(handlers.AuthMiddleware(handlers.ToggleBlockHandler)))
+
+	// Poll routes
+	mux.HandleFunc("/poll/vote", withMiddleware(handlers.AuthMiddleware(handlers.VotePollHandler)))
+	mux.HandleFunc("/poll/close", withMiddleware(handlers.AuthMiddleware(handlers.ClosePollHandler)))
+
 	// Reaction routes (like/dislike)
 	mux.HandleFunc("/post/react", withMiddleware(handlers.AuthMiddleware(handlers.ReactPostHandler)))
 	mux.HandleFunc("/comment/react", withMiddleware(handlers.AuthMiddleware(handlers.ReactCommentHandler)))
 
+	// Chat rooms
+	mux.HandleFunc("/chat/", withMiddleware(handlers.AuthMiddleware(handlers.ChatPageHandler)))
+	mux.HandleFunc("/chat/ws", withMiddleware(handlers.ChatSocketHandler))
+
+	// Private messages
+	mux.HandleFunc("/messages", withMiddleware(handlers.AuthMiddleware(handlers.MessagesHandler)))
+	mux.HandleFunc("/messages/new", withMiddleware(handlers.AuthMiddleware(handlers.NewMessageHandler)))
+	mux.HandleFunc("/messages/send", withMiddleware(handlers.AuthMiddleware(handlers.SendMessageHandler)))
+	mux.HandleFunc("/messages/leave", withMiddleware(handlers.AuthMiddleware(handlers.LeaveConversationHandler)))
+	mux.HandleFunc("/messages/report", withMiddleware(handlers.AuthMiddleware(handlers.ReportMessageHandler)))
+	mux.HandleFunc("/messages/", withMiddleware(handlers.AuthMiddleware(handlers.ConversationHandler)))
+	mux.HandleFunc("/moderation/messages", withMiddleware(handlers.AuthMiddleware(handlers.MessageReportsHandler)))
+	mux.HandleFunc("/moderation/messages/resolve", withMiddleware(handlers.AuthMiddleware(handlers.ResolveMessageReportHandler)))
+
+	// Webhooks
+	mux.HandleFunc("/admin/webhooks", withMiddleware(handlers.AuthMiddleware(handlers.WebhooksHandler)))
+	mux.HandleFunc("/admin/webhooks/update", withMiddleware(handlers.AuthMiddleware(handlers.UpdateWebhookHandler)))
+	mux.HandleFunc("/admin/webhooks/delete", withMiddleware(handlers.AuthMiddleware(handlers.DeleteWebhookHandler)))
+	mux.HandleFunc("/admin/webhooks/ping", withMiddleware(handlers.AuthMiddleware(handlers.PingWebhookHandler)))
+	mux.HandleFunc("/admin/webhooks/redeliver", withMiddleware(handlers.AuthMiddleware(handlers.RedeliverWebhookHandler)))
+	mux.HandleFunc("/admin/webhooks/", withMiddleware(handlers.AuthMiddleware(handlers.WebhookHandler)))
+
+	// JSON API
+	mux.HandleFunc(handlers.APIPrefix, withMiddleware(handlers.APIHandler))
+	mux.HandleFunc(handlers.OpenAPIPath, withMiddleware(handlers.OpenAPIHandler))
+
+	// Feeds
+	mux.HandleFunc(handlers.FeedPrefix, withMiddleware(handlers.FeedHandler))
+
+	// Live updates
+	mux.HandleFunc("/events", withMiddleware(handlers.EventsHandler))
+
+	// Search engines
+	mux.HandleFunc(handlers.SitemapPath, withMiddleware(handlers.SitemapHandler))
+	mux.HandleFunc(handlers.SitemapPrefix, withMiddleware(handlers.SitemapHandler))
+	mux.HandleFunc(handlers.RobotsPath, withMiddleware(handlers.RobotsHandler))
+
+	// Federation
+	mux.HandleFunc(handlers.WebFingerPath, withMiddleware(handlers.WebFingerHandler))
+	mux.HandleFunc(handlers.ActivityPubPrefix, withMiddleware(handlers.ActivityPubHandler))
+	mux.HandleFunc("/admin/federation", withMiddleware(handlers.AuthMiddleware(handlers.FederationHandler)))
+	mux.HandleFunc("/admin/federation/unfollow", withMiddleware(handlers.AuthMiddleware(handlers.UnfollowGroupHandler)))
+
 	// Error pages
 	mux.HandleFunc("/error", withMiddleware(handlers.NoPageHandler))
 	mux.HandleFunc("/crashed", withMiddleware(handlers.ServerProblemHandler))
@@ -126,16 +270,57 @@ func main() {
 		handlers.HomeHandler(w, r)
 	}))
 
-	// Clean expired sessions periodically
+	// Clean expired sessions and orphaned uploads periodically
 	go func() {
 		for {
 			utils.CleanExpiredSessions(db)
+			handlers.CleanOrphanAttachments(db)
+			handlers.CleanOutbox(db)
+			handlers.CleanWebhookDeliveries(db)
+			handlers.CleanFederationDeliveries(db)
 			time.Sleep(time.Hour) // Run every hour
 		}
 	}()
 
+	// Publish scheduled drafts as their time comes
+	go func() {
+		for {
+			handlers.PublishDueDrafts(db)
+			time.Sleep(handlers.DraftSchedulerInterval)
+		}
+	}()
+
+	// Send notification emails and digests through the outbox
+	go func() {
+		for {
+			handlers.QueueNotificationEmails(db)
+			handlers.SendEmailDigests(db, time.Now())
+			handlers.DeliverOutbox(db)
+			time.Sleep(handlers.EmailWorkerInterval)
+		}
+	}()
+
+	// Send webhook deliveries and retry the failed ones
+	go func() {
+		for {
+			handlers.DeliverWebhooks(db)
+			time.Sleep(handlers.WebhookWorkerInterval)
+		}
+	}()
+
+	// Send activities to other servers and retry the failed ones
+	go func() {
+		for {
+			handlers.DeliverFederation(db)
+			time.Sleep(handlers.FederationWorkerInterval)
+		}
+	}()
+
 	// Start server
 	port := "3000" // Changed to use port 5000
+	if p := os.Getenv("PORT"); p != "" {
+		port = p
+	}
 	server := &http.Server{
 		Addr:         "0.0.0.0:" + port,
 		Handler:      mux,
@@ -147,3 +332,90 @@ func main() {
 	fmt.Printf("Starting server at http://0.0.0.0:%s\n", port)
 	log.Fatal(server.ListenAndServe())
 }
+
+// migrateStorage copies uploaded files from one storage backend to another.
+// Both backends are configured from the same environment variables as the server.
+func migrateStorage(args []string) {
+	flags := flag.NewFlagSet("migrate-storage", flag.ExitOnError)
+	from := flags.String("from", "local", "backend to copy from (local or s3)")
+	to := flags.String("to", "s3", "backend to copy to (local or s3)")
+	prefix := flags.String("prefix", "", "only copy keys starting with this prefix, e.g. avatars/")
+	deleteSource := flags.Bool("delete", false, "delete each file from the source once it is copied")
+	flags.Parse(args)
+
+	if *from == *to {
+		log.Fatal("Source and destination backends must differ")
+	}
+
+	source, err := storage.New(*from)
+	if err != nil {
+		log.Fatalf("Failed to initialize %s storage: %v", *from, err)
+	}
+	destination, err := storage.New(*to)
+	if err != nil {
+		log.Fatalf("Failed to initialize %s storage: %v", *to, err)
+	}
+
+	copied, err := storage.Migrate(source, destination, *prefix, *deleteSource, func(key string) {
+		fmt.Println(key)
+	})
+	if err != nil {
+		log.Fatalf("Migration stopped after %d files: %v", copied, err)
+	}
+	fmt.Printf("Copied %d files from %s to %s\n", copied, *from, *to)
+}
+
+// setRole makes a user a moderator or admin, or back a regular user
+func setRole(args []string) {
+	flags := flag.NewFlagSet("set-role", flag.ExitOnError)
+	username := flags.String("user", "", "username of the account to change")
+	role := flags.String("role", models.RoleModerator, "user, moderator or admin")
+	flags.Parse(args)
+
+	db, err := database.InitDB("./forum.db")
+	if err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	if err := database.RunMigrations(db); err != nil {
+		log.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	profile, err := models.GetProfileByUsername(db, *username)
+	if err != nil {
+		log.Fatalf("Failed to find user %q: %v", *username, err)
+	}
+	if err := models.SetUserRole(db, profile.UserID, *role); err != nil {
+		log.Fatalf("Failed to set role: %v", err)
+	}
+	fmt.Printf("%s is now a %s\n", *username, *role)
+}
+
+// webhookReceiver listens for webhook requests and prints the ones with a valid signature.
+// It is meant for trying webhooks out locally.
+func webhookReceiver(args []string) {
+	flags := flag.NewFlagSet("webhook-receiver", flag.ExitOnError)
+	addr := flags.String("addr", "127.0.0.1:9000", "address to listen on")
+	secret := flags.String("secret", "", "secret shown on the webhook's page")
+	status := flags.Int("status", http.StatusOK, "status code to answer with, to try out retries")
+	flags.Parse(args)
+
+	if *secret == "" {
+		log.Fatal("The -secret flag is required")
+	}
+
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		body, err := webhook.Verify(r, *secret, 5*time.Minute, time.Now())
+		if err != nil {
+			log.Printf("Refused request: %v", err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		log.Printf("%s (delivery %s): %s", r.Header.Get(webhook.EventHeader), r.Header.Get(webhook.DeliveryHeader), body)
+		w.WriteHeader(*status)
+	})
+
+	fmt.Printf("Listening for webhooks at http://%s\n", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
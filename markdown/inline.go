@@ -0,0 +1,709 @@
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type inlineKind int
+
+const (
+	inlineRoot inlineKind = iota
+	inlineText
+	inlineCode
+	inlineEmph
+	inlineStrong
+	inlineDel
+	inlineLink
+	inlineImage
+	inlineSoftBreak
+	inlineHardBreak
+)
+
+// inline is a node of the inline tree. Children form a doubly linked list,
+// which keeps wrapping a range of nodes in emphasis cheap.
+type inline struct {
+	kind        inlineKind
+	text        string
+	dest, title string
+	mention     bool // link to a mentioned user
+
+	parent      *inline
+	first, last *inline
+	prev, next  *inline
+}
+
+func (n *inline) appendChild(child *inline) {
+	child.parent = n
+	child.prev = n.last
+	child.next = nil
+	if n.last != nil {
+		n.last.next = child
+	} else {
+		n.first = child
+	}
+	n.last = child
+}
+
+func (n *inline) insertAfter(sibling *inline) {
+	sibling.parent = n.parent
+	sibling.prev = n
+	sibling.next = n.next
+	if n.next != nil {
+		n.next.prev = sibling
+	} else if n.parent != nil {
+		n.parent.last = sibling
+	}
+	n.next = sibling
+}
+
+func (n *inline) unlink() {
+	if n.prev != nil {
+		n.prev.next = n.next
+	} else if n.parent != nil {
+		n.parent.first = n.next
+	}
+	if n.next != nil {
+		n.next.prev = n.prev
+	} else if n.parent != nil {
+		n.parent.last = n.prev
+	}
+	n.parent, n.prev, n.next = nil, nil, nil
+}
+
+// delimiter is a run of *, _ or ~ that may open or close emphasis
+type delimiter struct {
+	node      *inline
+	char      byte
+	count     int
+	origCount int
+	canOpen   bool
+	canClose  bool
+	prev      *delimiter
+	next      *delimiter
+}
+
+// bracket is an opening [ or ![ that may start a link or image
+type bracket struct {
+	node      *inline
+	image     bool
+	active    bool
+	pos       int // position after the bracket
+	prevDelim *delimiter
+	prev      *bracket
+}
+
+type inlineParser struct {
+	src      string
+	pos      int
+	refs     map[string]linkRef
+	root     *inline
+	delims   *delimiter // top of the delimiter stack
+	brackets *bracket   // top of the bracket stack
+}
+
+var (
+	reEntity        = regexp.MustCompile(`^&(?:#[xX][0-9a-fA-F]{1,6}|#[0-9]{1,7}|[A-Za-z][A-Za-z0-9]{1,31});`)
+	reAutolinkURI   = regexp.MustCompile(`^<([A-Za-z][A-Za-z0-9.+-]{1,31}:[^<>\x00-\x20]*)>`)
+	reAutolinkEmail = regexp.MustCompile(`^<([a-zA-Z0-9.!#$%&'*+/=?^_` + "`" + `{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*)>`)
+	reBareURL       = regexp.MustCompile(`(?:https?://|www\.)[^\s<]+`)
+	reMention       = regexp.MustCompile(`@([A-Za-z0-9_](?:[A-Za-z0-9_.-]*[A-Za-z0-9_])?)`)
+)
+
+const asciiPunctuation = "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
+
+// parseInline parses the inline content of a paragraph, heading or table cell
+func parseInline(src string, refs map[string]linkRef) *inline {
+	p := &inlineParser{src: src, refs: refs, root: &inline{kind: inlineRoot}}
+	for p.pos < len(p.src) {
+		p.parseNext()
+	}
+	p.processEmphasis(nil)
+	linkifyText(p.root)
+	return p.root
+}
+
+func (p *inlineParser) parseNext() {
+	c := p.src[p.pos]
+	switch c {
+	case '\n':
+		p.parseNewline()
+	case '\\':
+		p.parseBackslash()
+	case '`':
+		p.parseCodeSpan()
+	case '*', '_', '~':
+		p.parseDelimiterRun(c)
+	case '[':
+		p.pos++
+		p.pushBracket(p.text("["), false)
+	case '!':
+		if p.pos+1 < len(p.src) && p.src[p.pos+1] == '[' {
+			p.pos += 2
+			p.pushBracket(p.text("!["), true)
+		} else {
+			p.pos++
+			p.text("!")
+		}
+	case ']':
+		p.parseCloseBracket()
+	case '<':
+		p.parseAutolink()
+	case '&':
+		p.parseEntity()
+	default:
+		end := p.pos + 1
+		for end < len(p.src) && !strings.ContainsRune("\n\\`*_~[!]<&", rune(p.src[end])) {
+			end++
+		}
+		p.text(p.src[p.pos:end])
+		p.pos = end
+	}
+}
+
+// text appends a text node
+func (p *inlineParser) text(s string) *inline {
+	n := &inline{kind: inlineText, text: s}
+	p.root.appendChild(n)
+	return n
+}
+
+func (p *inlineParser) parseNewline() {
+	p.pos++
+	hard := false
+	if last := p.root.last; last != nil && last.kind == inlineText {
+		trimmed := strings.TrimRight(last.text, " ")
+		hard = len(last.text)-len(trimmed) >= 2
+		last.text = trimmed
+	}
+	if hard {
+		p.root.appendChild(&inline{kind: inlineHardBreak})
+	} else {
+		p.root.appendChild(&inline{kind: inlineSoftBreak})
+	}
+	// Leading spaces of the next line are ignored
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *inlineParser) parseBackslash() {
+	p.pos++
+	if p.pos < len(p.src) && p.src[p.pos] == '\n' {
+		p.pos++
+		p.root.appendChild(&inline{kind: inlineHardBreak})
+		for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+			p.pos++
+		}
+		return
+	}
+	if p.pos < len(p.src) && strings.IndexByte(asciiPunctuation, p.src[p.pos]) >= 0 {
+		p.text(p.src[p.pos : p.pos+1])
+		p.pos++
+		return
+	}
+	p.text("\\")
+}
+
+func (p *inlineParser) parseCodeSpan() {
+	start := p.pos
+	for p.pos < len(p.src) && p.src[p.pos] == '`' {
+		p.pos++
+	}
+	ticks := p.src[start:p.pos]
+
+	// Look for a closing run of exactly the same length
+	for search := p.pos; search < len(p.src); {
+		i := strings.IndexByte(p.src[search:], '`')
+		if i < 0 {
+			break
+		}
+		runStart := search + i
+		runEnd := runStart
+		for runEnd < len(p.src) && p.src[runEnd] == '`' {
+			runEnd++
+		}
+		if runEnd-runStart == len(ticks) {
+			content := strings.ReplaceAll(p.src[p.pos:runStart], "\n", " ")
+			if len(content) >= 2 && content[0] == ' ' && content[len(content)-1] == ' ' && strings.Trim(content, " ") != "" {
+				content = content[1 : len(content)-1]
+			}
+			p.root.appendChild(&inline{kind: inlineCode, text: content})
+			p.pos = runEnd
+			return
+		}
+		search = runEnd
+	}
+
+	p.text(ticks)
+}
+
+func (p *inlineParser) parseDelimiterRun(c byte) {
+	start := p.pos
+	for p.pos < len(p.src) && p.src[p.pos] == c {
+		p.pos++
+	}
+	count := p.pos - start
+
+	before, after := ' ', ' '
+	if start > 0 {
+		before, _ = utf8.DecodeLastRuneInString(p.src[:start])
+	}
+	if p.pos < len(p.src) {
+		after, _ = utf8.DecodeRuneInString(p.src[p.pos:])
+	}
+
+	beforeSpace, afterSpace := unicode.IsSpace(before), unicode.IsSpace(after)
+	beforePunct, afterPunct := isPunctuation(before), isPunctuation(after)
+	leftFlanking := !afterSpace && (!afterPunct || beforeSpace || beforePunct)
+	rightFlanking := !beforeSpace && (!beforePunct || afterSpace || afterPunct)
+
+	var canOpen, canClose bool
+	switch c {
+	case '_':
+		canOpen = leftFlanking && (!rightFlanking || beforePunct)
+		canClose = rightFlanking && (!leftFlanking || afterPunct)
+	case '~':
+		// Only ~~ and ~ are strikethrough
+		canOpen = leftFlanking && count <= 2
+		canClose = rightFlanking && count <= 2
+	default:
+		canOpen = leftFlanking
+		canClose = rightFlanking
+	}
+
+	node := p.text(p.src[start:p.pos])
+	if canOpen || canClose {
+		d := &delimiter{node: node, char: c, count: count, origCount: count, canOpen: canOpen, canClose: canClose, prev: p.delims}
+		if p.delims != nil {
+			p.delims.next = d
+		}
+		p.delims = d
+	}
+}
+
+func (p *inlineParser) pushBracket(node *inline, image bool) {
+	p.brackets = &bracket{node: node, image: image, active: true, pos: p.pos, prevDelim: p.delims, prev: p.brackets}
+}
+
+func (p *inlineParser) parseCloseBracket() {
+	closePos := p.pos
+	p.pos++
+
+	opener := p.brackets
+	if opener == nil {
+		p.text("]")
+		return
+	}
+	if !opener.active {
+		p.brackets = opener.prev
+		p.text("]")
+		return
+	}
+
+	dest, title, matched := "", "", false
+
+	// Inline link: [text](destination "title")
+	if p.pos < len(p.src) && p.src[p.pos] == '(' {
+		if d, t, end, ok := parseLinkTail(p.src, p.pos+1); ok {
+			dest, title, matched = d, t, true
+			p.pos = end
+		}
+	}
+
+	// Reference links: [text][label], [label][] and [label]
+	if !matched {
+		label := ""
+		afterLabel := p.pos
+		if p.pos < len(p.src) && p.src[p.pos] == '[' {
+			if end := strings.IndexByte(p.src[p.pos+1:], ']'); end >= 0 {
+				label = p.src[p.pos+1 : p.pos+1+end]
+				afterLabel = p.pos + end + 2
+			}
+		}
+		if label == "" {
+			label = p.src[opener.pos:closePos]
+		}
+		if ref, ok := p.refs[normalizeLabel(label)]; ok {
+			dest, title, matched = ref.dest, ref.title, true
+			p.pos = afterLabel
+		}
+	}
+
+	if !matched {
+		p.brackets = opener.prev
+		p.text("]")
+		return
+	}
+
+	kind := inlineLink
+	if opener.image {
+		kind = inlineImage
+	}
+	link := &inline{kind: kind, dest: dest, title: title}
+
+	// Everything after the opening bracket becomes the link text
+	for n := opener.node.next; n != nil; {
+		next := n.next
+		n.unlink()
+		link.appendChild(n)
+		n = next
+	}
+	p.processEmphasis(opener.prevDelim)
+	opener.node.unlink()
+	p.root.appendChild(link)
+	p.brackets = opener.prev
+
+	// Links may not contain other links
+	if !opener.image {
+		for b := p.brackets; b != nil; b = b.prev {
+			if !b.image {
+				b.active = false
+			}
+		}
+	}
+}
+
+// parseLinkTail parses "destination "title")" starting after the opening parenthesis
+func parseLinkTail(src string, pos int) (dest, title string, end int, ok bool) {
+	skipSpace := func() {
+		for pos < len(src) && (src[pos] == ' ' || src[pos] == '\t' || src[pos] == '\n') {
+			pos++
+		}
+	}
+	skipSpace()
+
+	// Destination
+	if pos < len(src) && src[pos] == '<' {
+		close := strings.IndexAny(src[pos+1:], ">\n<")
+		if close < 0 || src[pos+1+close] != '>' {
+			return "", "", 0, false
+		}
+		dest = src[pos+1 : pos+1+close]
+		pos += close + 2
+	} else {
+		start, depth := pos, 0
+		for pos < len(src) {
+			c := src[pos]
+			if c == '\\' && pos+1 < len(src) && strings.IndexByte(asciiPunctuation, src[pos+1]) >= 0 {
+				pos += 2
+				continue
+			}
+			if c <= ' ' {
+				break
+			}
+			if c == '(' {
+				depth++
+			} else if c == ')' {
+				if depth == 0 {
+					break
+				}
+				depth--
+			}
+			pos++
+		}
+		if depth != 0 {
+			return "", "", 0, false
+		}
+		dest = src[start:pos]
+	}
+
+	// Optional title, separated by whitespace
+	beforeSpace := pos
+	skipSpace()
+	if pos < len(src) && pos > beforeSpace && (src[pos] == '"' || src[pos] == '\'' || src[pos] == '(') {
+		closing := src[pos]
+		if closing == '(' {
+			closing = ')'
+		}
+		i := pos + 1
+		for i < len(src) && src[i] != closing {
+			if src[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i >= len(src) {
+			return "", "", 0, false
+		}
+		title = src[pos+1 : i]
+		pos = i + 1
+		skipSpace()
+	}
+
+	if pos >= len(src) || src[pos] != ')' {
+		return "", "", 0, false
+	}
+
+	dest = html.UnescapeString(unescapeBackslashes(dest))
+	title = html.UnescapeString(unescapeBackslashes(title))
+	return dest, title, pos + 1, true
+}
+
+func (p *inlineParser) parseAutolink() {
+	rest := p.src[p.pos:]
+	if m := reAutolinkURI.FindStringSubmatch(rest); m != nil {
+		link := &inline{kind: inlineLink, dest: m[1]}
+		link.appendChild(&inline{kind: inlineText, text: m[1]})
+		p.root.appendChild(link)
+		p.pos += len(m[0])
+		return
+	}
+	if m := reAutolinkEmail.FindStringSubmatch(rest); m != nil {
+		link := &inline{kind: inlineLink, dest: "mailto:" + m[1]}
+		link.appendChild(&inline{kind: inlineText, text: m[1]})
+		p.root.appendChild(link)
+		p.pos += len(m[0])
+		return
+	}
+	p.pos++
+	p.text("<")
+}
+
+func (p *inlineParser) parseEntity() {
+	if m := reEntity.FindString(p.src[p.pos:]); m != "" {
+		if decoded := html.UnescapeString(m); decoded != m {
+			p.text(decoded)
+			p.pos += len(m)
+			return
+		}
+	}
+	p.pos++
+	p.text("&")
+}
+
+type openerKey struct {
+	char    byte
+	mod     int
+	canOpen bool
+}
+
+// processEmphasis matches delimiter runs above the stack bottom into emphasis,
+// strong emphasis and strikethrough, following the CommonMark algorithm
+func (p *inlineParser) processEmphasis(stackBottom *delimiter) {
+	bottoms := map[openerKey]*delimiter{}
+
+	// Start with the first delimiter above the bottom
+	var closer *delimiter
+	for d := p.delims; d != nil && d != stackBottom; d = d.prev {
+		closer = d
+	}
+
+	for closer != nil {
+		if !closer.canClose {
+			closer = closer.next
+			continue
+		}
+
+		key := openerKey{closer.char, closer.origCount % 3, closer.canOpen}
+		opener := closer.prev
+		found := false
+		for opener != nil && opener != stackBottom && opener != bottoms[key] {
+			if opener.char == closer.char && opener.canOpen {
+				if closer.char == '~' {
+					found = opener.count == closer.count
+				} else {
+					oddMatch := (closer.canOpen || opener.canClose) &&
+						closer.origCount%3 != 0 && (opener.origCount+closer.origCount)%3 == 0
+					found = !oddMatch
+				}
+				if found {
+					break
+				}
+			}
+			opener = opener.prev
+		}
+
+		if !found {
+			next := closer.next
+			bottoms[key] = closer.prev
+			if !closer.canOpen {
+				p.removeDelimiter(closer)
+			}
+			closer = next
+			continue
+		}
+
+		kind, use := inlineEmph, 1
+		switch {
+		case closer.char == '~':
+			kind, use = inlineDel, closer.count
+		case closer.count >= 2 && opener.count >= 2:
+			kind, use = inlineStrong, 2
+		}
+
+		opener.count -= use
+		closer.count -= use
+		opener.node.text = opener.node.text[:len(opener.node.text)-use]
+		closer.node.text = closer.node.text[:len(closer.node.text)-use]
+
+		// Wrap everything between the runs
+		emph := &inline{kind: kind}
+		for n := opener.node.next; n != nil && n != closer.node; {
+			next := n.next
+			n.unlink()
+			emph.appendChild(n)
+			n = next
+		}
+		opener.node.insertAfter(emph)
+
+		// Delimiters between the runs can no longer match
+		for d := closer.prev; d != nil && d != opener; {
+			prev := d.prev
+			p.removeDelimiter(d)
+			d = prev
+		}
+
+		if opener.count == 0 {
+			opener.node.unlink()
+			p.removeDelimiter(opener)
+		}
+		if closer.count == 0 {
+			next := closer.next
+			closer.node.unlink()
+			p.removeDelimiter(closer)
+			closer = next
+		}
+	}
+
+	// Unmatched delimiters stay as text
+	for p.delims != nil && p.delims != stackBottom {
+		p.removeDelimiter(p.delims)
+	}
+}
+
+func (p *inlineParser) removeDelimiter(d *delimiter) {
+	if d.prev != nil {
+		d.prev.next = d.next
+	}
+	if d.next != nil {
+		d.next.prev = d.prev
+	} else {
+		p.delims = d.prev
+	}
+}
+
+// linkifyText turns bare URLs in text outside of links into links
+func linkifyText(parent *inline) {
+	for n := parent.first; n != nil; n = n.next {
+		switch n.kind {
+		case inlineLink, inlineImage:
+			continue
+		case inlineText:
+		default:
+			linkifyText(n)
+			continue
+		}
+
+		// Delimiters that did not become emphasis are left as separate text nodes
+		for n.next != nil && n.next.kind == inlineText {
+			n.text += n.next.text
+			n.next.unlink()
+		}
+
+		loc := reBareURL.FindStringIndex(n.text)
+		if loc == nil {
+			continue
+		}
+		// Only at the start of a word
+		if loc[0] > 0 {
+			r, _ := utf8.DecodeLastRuneInString(n.text[:loc[0]])
+			if !unicode.IsSpace(r) && !strings.ContainsRune("*_~(", r) {
+				continue
+			}
+		}
+
+		url := trimURLPunctuation(n.text[loc[0]:loc[1]])
+		if strings.HasPrefix(url, "www.") && !strings.Contains(url[4:], ".") {
+			continue
+		}
+		dest := url
+		if strings.HasPrefix(url, "www.") {
+			dest = "http://" + url
+		}
+
+		link := &inline{kind: inlineLink, dest: dest}
+		link.appendChild(&inline{kind: inlineText, text: url})
+		rest := &inline{kind: inlineText, text: n.text[loc[0]+len(url):]}
+		n.text = n.text[:loc[0]]
+		n.insertAfter(link)
+		link.insertAfter(rest)
+		n = link
+	}
+}
+
+// linkifyMentions turns @name in text outside of links into links to the mentioned users
+func linkifyMentions(parent *inline, resolve MentionResolver) {
+	for n := parent.first; n != nil; n = n.next {
+		switch n.kind {
+		case inlineLink, inlineImage:
+			continue
+		case inlineText:
+		default:
+			linkifyMentions(n, resolve)
+			continue
+		}
+
+		for _, loc := range reMention.FindAllStringSubmatchIndex(n.text, -1) {
+			// Only at the start of a word, so email addresses are left alone
+			if loc[0] > 0 {
+				r, _ := utf8.DecodeLastRuneInString(n.text[:loc[0]])
+				if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_.@/", r) {
+					continue
+				}
+			}
+
+			href, display, ok := resolve(n.text[loc[2]:loc[3]])
+			if !ok {
+				continue
+			}
+
+			link := &inline{kind: inlineLink, dest: href, mention: true}
+			link.appendChild(&inline{kind: inlineText, text: "@" + display})
+			rest := &inline{kind: inlineText, text: n.text[loc[1]:]}
+			n.text = n.text[:loc[0]]
+			n.insertAfter(link)
+			link.insertAfter(rest)
+			n = link
+			break
+		}
+	}
+}
+
+// trimURLPunctuation drops trailing punctuation and unbalanced closing parentheses
+func trimURLPunctuation(url string) string {
+	for len(url) > 0 {
+		last := url[len(url)-1]
+		switch {
+		case strings.IndexByte("?!.,:*_~'\"", last) >= 0:
+			url = url[:len(url)-1]
+		case last == ')' && strings.Count(url, ")") > strings.Count(url, "("):
+			url = url[:len(url)-1]
+		default:
+			return url
+		}
+	}
+	return url
+}
+
+// unescapeBackslashes removes backslashes before ASCII punctuation
+func unescapeBackslashes(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(asciiPunctuation, s[i+1]) >= 0 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func isPunctuation(r rune) bool {
+	return r < 128 && strings.ContainsRune(asciiPunctuation, r) || unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
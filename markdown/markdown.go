@@ -0,0 +1,595 @@
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Version identifies the renderer output. Bump it whenever the generated HTML changes,
+// so that cached renderings are refreshed.
+const Version = "3"
+
+// Render converts Markdown (CommonMark with GitHub-style tables, task lists,
+// strikethrough and autolinks) to sanitized HTML. Raw HTML in the source is escaped.
+func Render(source string) string {
+	return render(source, nil)
+}
+
+// MentionResolver returns the profile link and the name to show for a username mentioned
+// as @name. Names that do not belong to anyone report false and stay plain text.
+type MentionResolver func(name string) (href, display string, ok bool)
+
+// RenderWithMentions is Render with @name mentions turned into profile links
+func RenderWithMentions(source string, resolve MentionResolver) string {
+	return render(source, resolve)
+}
+
+// Mentions returns the names mentioned as @name in the source, in order of appearance and
+// without duplicates. Mentions in code and inside links do not count.
+func Mentions(source string) []string {
+	var names []string
+	seen := map[string]bool{}
+	render(source, func(name string) (string, string, bool) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+		return "", "", false
+	})
+	return names
+}
+
+func render(source string, resolve MentionResolver) string {
+	p := &parser{refs: map[string]linkRef{}}
+	source = strings.ReplaceAll(source, "\r\n", "\n")
+	source = strings.ReplaceAll(source, "\r", "\n")
+	source = strings.ReplaceAll(source, "\x00", "�")
+	blocks := p.parseBlocks(strings.Split(source, "\n"))
+
+	var b strings.Builder
+	r := &renderer{refs: p.refs, mention: resolve}
+	r.renderBlocks(&b, blocks, false)
+	return Sanitize(b.String())
+}
+
+type blockKind int
+
+const (
+	blockParagraph blockKind = iota
+	blockHeading
+	blockThematicBreak
+	blockCode
+	blockQuote
+	blockList
+	blockItem
+	blockTable
+)
+
+type block struct {
+	kind     blockKind
+	level    int    // heading level
+	text     string // raw inline text of paragraphs and headings, content of code blocks
+	info     string // language of fenced code blocks
+	children []*block
+
+	// Lists and list items
+	ordered bool
+	start   int
+	tight   bool
+	task    int // 0 for a normal item, 1 for an open task, 2 for a done task
+
+	// Tables
+	align []string
+	rows  [][]string // the first row is the header
+}
+
+type linkRef struct {
+	dest  string
+	title string
+}
+
+type parser struct {
+	refs map[string]linkRef
+}
+
+var (
+	reATXHeading     = regexp.MustCompile(`^ {0,3}(#{1,6})([ \t].*)?$`)
+	reClosingHashes  = regexp.MustCompile(`(^|[ \t]+)#+[ \t]*$`)
+	reThematicBreak  = regexp.MustCompile(`^ {0,3}((\*[ \t]*){3,}|(-[ \t]*){3,}|(_[ \t]*){3,})$`)
+	reSetextLine     = regexp.MustCompile(`^ {0,3}(=+|-+)[ \t]*$`)
+	reFenceOpen      = regexp.MustCompile("^( {0,3})(`{3,}|~{3,})(.*)$")
+	reBulletItem     = regexp.MustCompile(`^( {0,3})([-+*])([ \t]+|$)`)
+	reOrderedItem    = regexp.MustCompile(`^( {0,3})(\d{1,9})([.)])([ \t]+|$)`)
+	reBlockQuote     = regexp.MustCompile(`^ {0,3}>`)
+	reTableDelimiter = regexp.MustCompile(`^ {0,3}\|?[ \t]*:?-+:?[ \t]*(\|[ \t]*:?-+:?[ \t]*)*\|?[ \t]*$`)
+	reLinkRefDef     = regexp.MustCompile(`^ {0,3}\[((?:[^\]\\]|\\.){1,999})\]:[ \t]*(<[^<>\n]*>|\S+)(?:[ \t]+("(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'|\((?:[^()\\]|\\.)*\)))?[ \t]*$`)
+)
+
+// parseBlocks splits lines into a tree of blocks
+func (p *parser) parseBlocks(lines []string) []*block {
+	var blocks []*block
+	var para []string
+
+	closePara := func() {
+		if para == nil {
+			return
+		}
+		if b := p.paragraph(para); b != nil {
+			blocks = append(blocks, b)
+		}
+		para = nil
+	}
+
+	for i := 0; i < len(lines); {
+		line := lines[i]
+
+		if isBlank(line) {
+			closePara()
+			i++
+			continue
+		}
+
+		// Indented code cannot interrupt a paragraph
+		if para == nil && indentWidth(line) >= 4 {
+			var code []string
+			for i < len(lines) && (isBlank(lines[i]) || indentWidth(lines[i]) >= 4) {
+				code = append(code, stripIndent(lines[i], 4))
+				i++
+			}
+			for len(code) > 0 && isBlank(code[len(code)-1]) {
+				code = code[:len(code)-1]
+			}
+			blocks = append(blocks, &block{kind: blockCode, text: strings.Join(code, "\n") + "\n"})
+			continue
+		}
+
+		if m := reFenceOpen.FindStringSubmatch(line); m != nil && !(m[2][0] == '`' && strings.Contains(m[3], "`")) {
+			closePara()
+			indent, fence := len(m[1]), m[2]
+			info := strings.Fields(html.UnescapeString(unescapeBackslashes(strings.TrimSpace(m[3]))))
+			var code []string
+			closed := false
+			i++
+			for i < len(lines) {
+				if isClosingFence(lines[i], fence) {
+					closed = true
+					i++
+					break
+				}
+				code = append(code, stripIndent(lines[i], indent))
+				i++
+			}
+			// An unclosed fence runs to the end, but the newline ending the source is not a line
+			if !closed && len(code) > 0 && lines[len(lines)-1] == "" {
+				code = code[:len(code)-1]
+			}
+			b := &block{kind: blockCode}
+			if len(code) > 0 {
+				b.text = strings.Join(code, "\n") + "\n"
+			}
+			if len(info) > 0 {
+				b.info = info[0]
+			}
+			blocks = append(blocks, b)
+			continue
+		}
+
+		if m := reATXHeading.FindStringSubmatch(line); m != nil {
+			closePara()
+			text := strings.TrimSpace(reClosingHashes.ReplaceAllString(strings.TrimSpace(m[2]), ""))
+			blocks = append(blocks, &block{kind: blockHeading, level: len(m[1]), text: text})
+			i++
+			continue
+		}
+
+		// A line of = or - under a paragraph turns it into a heading
+		if para != nil && reSetextLine.MatchString(line) {
+			level := 2
+			if strings.TrimSpace(line)[0] == '=' {
+				level = 1
+			}
+			b := p.paragraph(para)
+			para = nil
+			if b != nil {
+				blocks = append(blocks, &block{kind: blockHeading, level: level, text: b.text})
+				i++
+				continue
+			}
+			// The paragraph only held link reference definitions
+			if level == 1 {
+				para = []string{line}
+				i++
+				continue
+			}
+		}
+
+		if reThematicBreak.MatchString(line) {
+			closePara()
+			blocks = append(blocks, &block{kind: blockThematicBreak})
+			i++
+			continue
+		}
+
+		if reBlockQuote.MatchString(line) {
+			closePara()
+			var quoted []string
+			lazy := false
+			for i < len(lines) {
+				l := lines[i]
+				if reBlockQuote.MatchString(l) {
+					content := strings.TrimLeft(l, " ")[1:]
+					content = stripIndent(content, 1)
+					quoted = append(quoted, content)
+					lazy = !isBlank(content) && !startsBlock(content)
+					i++
+					continue
+				}
+				// Paragraph text may continue without the > marker
+				if lazy && !isBlank(l) && !startsBlock(l) {
+					quoted = append(quoted, l)
+					i++
+					continue
+				}
+				break
+			}
+			blocks = append(blocks, &block{kind: blockQuote, children: p.parseBlocks(quoted)})
+			continue
+		}
+
+		if item, ok := parseListMarker(line); ok && (para == nil || item.canInterrupt()) {
+			closePara()
+			var list *block
+			list, i = p.parseList(lines, i)
+			blocks = append(blocks, list)
+			continue
+		}
+
+		// A paragraph of a single line followed by a delimiter row starts a table
+		if len(para) == 1 && reTableDelimiter.MatchString(line) {
+			if table := p.parseTable(para[0], lines, &i); table != nil {
+				para = nil
+				blocks = append(blocks, table)
+				continue
+			}
+		}
+
+		para = append(para, line)
+		i++
+	}
+
+	closePara()
+	return blocks
+}
+
+// paragraph builds a paragraph from its lines, extracting link reference definitions at its start
+func (p *parser) paragraph(lines []string) *block {
+	for len(lines) > 0 {
+		m := reLinkRefDef.FindStringSubmatch(lines[0])
+		if m == nil {
+			break
+		}
+		label := normalizeLabel(m[1])
+		if label != "" {
+			if _, exists := p.refs[label]; !exists {
+				dest := m[2]
+				if strings.HasPrefix(dest, "<") {
+					dest = dest[1 : len(dest)-1]
+				}
+				title := ""
+				if len(m[3]) >= 2 {
+					title = m[3][1 : len(m[3])-1]
+				}
+				p.refs[label] = linkRef{
+					dest:  html.UnescapeString(unescapeBackslashes(dest)),
+					title: html.UnescapeString(unescapeBackslashes(title)),
+				}
+			}
+		}
+		lines = lines[1:]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	for i := range lines {
+		lines[i] = strings.TrimLeft(lines[i], " \t")
+	}
+	text := strings.Join(lines, "\n")
+	return &block{kind: blockParagraph, text: strings.TrimRight(text, " \t")}
+}
+
+type listMarker struct {
+	ordered bool
+	char    byte // bullet character, or the delimiter of ordered items
+	start   int
+	prefix  int // length of the indent and marker
+	width   int // columns before the item content
+	empty   bool
+}
+
+// canInterrupt reports whether the item may start while a paragraph is open
+func (m listMarker) canInterrupt() bool {
+	return !m.empty && (!m.ordered || m.start == 1)
+}
+
+func (m listMarker) sameList(other listMarker) bool {
+	return m.ordered == other.ordered && m.char == other.char
+}
+
+func parseListMarker(line string) (listMarker, bool) {
+	if reThematicBreak.MatchString(line) {
+		return listMarker{}, false
+	}
+
+	var marker listMarker
+	var prefix, spaces string
+	if m := reBulletItem.FindStringSubmatch(line); m != nil {
+		marker.char = m[2][0]
+		prefix, spaces = m[1]+m[2], m[3]
+	} else if m := reOrderedItem.FindStringSubmatch(line); m != nil {
+		marker.ordered = true
+		marker.char = m[3][0]
+		marker.start, _ = strconv.Atoi(m[2])
+		prefix, spaces = m[1]+m[2]+m[3], m[4]
+	} else {
+		return marker, false
+	}
+
+	marker.prefix = len(prefix)
+	rest := line[len(prefix)+len(spaces):]
+	marker.empty = isBlank(rest)
+	spaceWidth := indentWidth(spaces)
+	switch {
+	case marker.empty:
+		marker.width = len(prefix) + 1
+	case spaceWidth > 4:
+		// The content is indented code, which starts one space after the marker
+		marker.width = len(prefix) + 1
+	default:
+		marker.width = len(prefix) + spaceWidth
+	}
+	return marker, true
+}
+
+// parseList reads a list starting at line i and returns it with the index of the next line
+func (p *parser) parseList(lines []string, i int) (*block, int) {
+	first, _ := parseListMarker(lines[i])
+	list := &block{kind: blockList, ordered: first.ordered, start: first.start, tight: true}
+
+	for i < len(lines) {
+		marker, ok := parseListMarker(lines[i])
+		if !ok || !marker.sameList(first) {
+			break
+		}
+
+		// The marker counts as indentation of the first line
+		itemLines := []string{stripIndent(strings.Repeat(" ", marker.prefix)+lines[i][marker.prefix:], marker.width)}
+		i++
+		if marker.empty && i < len(lines) && isBlank(lines[i]) {
+			// An item can begin with at most one blank line
+			list.children = append(list.children, &block{kind: blockItem})
+			continue
+		}
+
+		inFence := reFenceOpen.MatchString(itemLines[0])
+		for i < len(lines) {
+			l := lines[i]
+			if isBlank(l) {
+				itemLines = append(itemLines, "")
+				i++
+				continue
+			}
+			if indentWidth(l) >= marker.width {
+				content := stripIndent(l, marker.width)
+				if reFenceOpen.MatchString(content) {
+					inFence = !inFence
+				}
+				itemLines = append(itemLines, content)
+				i++
+				continue
+			}
+			// Lazy continuation of a paragraph, unless the line starts another item
+			if _, isItem := parseListMarker(l); isItem {
+				break
+			}
+			last := itemLines[len(itemLines)-1]
+			if !inFence && !isBlank(last) && !startsBlock(l) && indentWidth(last) < 4 {
+				itemLines = append(itemLines, l)
+				i++
+				continue
+			}
+			break
+		}
+
+		// Blank lines at the end belong between items
+		trailingBlank := false
+		for len(itemLines) > 1 && isBlank(itemLines[len(itemLines)-1]) {
+			itemLines = itemLines[:len(itemLines)-1]
+			trailingBlank = true
+		}
+
+		item := &block{kind: blockItem, children: p.parseBlocks(itemLines)}
+		if len(item.children) > 1 && hasInnerBlank(itemLines) {
+			list.tight = false
+		}
+		if trailingBlank && i < len(lines) {
+			if next, ok := parseListMarker(lines[i]); ok && next.sameList(first) {
+				list.tight = false
+			}
+		}
+
+		// Task list items start with [ ] or [x]
+		if len(item.children) > 0 && item.children[0].kind == blockParagraph {
+			text := item.children[0].text
+			if len(text) >= 3 && text[0] == '[' && text[2] == ']' && (len(text) == 3 || text[3] == ' ' || text[3] == '\n') {
+				switch text[1] {
+				case ' ':
+					item.task = 1
+				case 'x', 'X':
+					item.task = 2
+				}
+				if item.task != 0 {
+					item.children[0].text = strings.TrimLeft(text[3:], " ")
+				}
+			}
+		}
+
+		list.children = append(list.children, item)
+	}
+
+	return list, i
+}
+
+// parseTable reads a table whose header row has been collected as a paragraph line
+func (p *parser) parseTable(header string, lines []string, i *int) *block {
+	headerCells := splitTableRow(header)
+	delimiterCells := splitTableRow(lines[*i])
+	if len(headerCells) != len(delimiterCells) {
+		return nil
+	}
+	if !strings.Contains(header, "|") && !strings.Contains(lines[*i], "|") {
+		return nil
+	}
+
+	table := &block{kind: blockTable, rows: [][]string{headerCells}}
+	for _, cell := range delimiterCells {
+		left := strings.HasPrefix(cell, ":")
+		right := strings.HasSuffix(cell, ":")
+		switch {
+		case left && right:
+			table.align = append(table.align, "center")
+		case right:
+			table.align = append(table.align, "right")
+		case left:
+			table.align = append(table.align, "left")
+		default:
+			table.align = append(table.align, "")
+		}
+	}
+
+	*i++
+	for *i < len(lines) && !isBlank(lines[*i]) && !startsBlock(lines[*i]) {
+		cells := splitTableRow(lines[*i])
+		// Rows are padded or cut to the header width
+		row := make([]string, len(headerCells))
+		copy(row, cells)
+		table.rows = append(table.rows, row)
+		*i++
+	}
+	return table
+}
+
+// splitTableRow splits a table row on unescaped pipes
+func splitTableRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, "\\|") {
+		line = line[:len(line)-1]
+	}
+
+	var cells []string
+	var cell strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line) && line[i+1] == '|':
+			cell.WriteByte('|')
+			i++
+		case line[i] == '|':
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		default:
+			cell.WriteByte(line[i])
+		}
+	}
+	return append(cells, strings.TrimSpace(cell.String()))
+}
+
+// startsBlock reports whether a line begins a block that interrupts a paragraph
+func startsBlock(line string) bool {
+	if reATXHeading.MatchString(line) || reThematicBreak.MatchString(line) || reBlockQuote.MatchString(line) {
+		return true
+	}
+	if m := reFenceOpen.FindStringSubmatch(line); m != nil && !(m[2][0] == '`' && strings.Contains(m[3], "`")) {
+		return true
+	}
+	if marker, ok := parseListMarker(line); ok && marker.canInterrupt() {
+		return true
+	}
+	return false
+}
+
+func isClosingFence(line, fence string) bool {
+	if indentWidth(line) >= 4 {
+		return false
+	}
+	trimmed := strings.TrimSpace(line)
+	if len(trimmed) < len(fence) {
+		return false
+	}
+	for i := 0; i < len(trimmed); i++ {
+		if trimmed[i] != fence[0] {
+			return false
+		}
+	}
+	return true
+}
+
+func hasInnerBlank(lines []string) bool {
+	for _, line := range lines[1:] {
+		if isBlank(line) {
+			return true
+		}
+	}
+	return false
+}
+
+func isBlank(line string) bool {
+	return strings.TrimLeft(line, " \t") == ""
+}
+
+// indentWidth counts the columns of leading whitespace, with tab stops every 4 columns
+func indentWidth(line string) int {
+	width := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case ' ':
+			width++
+		case '\t':
+			width += 4 - width%4
+		default:
+			return width
+		}
+	}
+	return width
+}
+
+// stripIndent removes up to n columns of leading whitespace
+func stripIndent(line string, n int) string {
+	width := 0
+	for i := 0; i < len(line); i++ {
+		if width >= n {
+			return line[i:]
+		}
+		switch line[i] {
+		case ' ':
+			width++
+		case '\t':
+			tab := 4 - width%4
+			if width+tab > n {
+				// Keep the part of the tab beyond the indent as spaces
+				return strings.Repeat(" ", width+tab-n) + line[i+1:]
+			}
+			width += tab
+		default:
+			return line[i:]
+		}
+	}
+	return ""
+}
+
+// normalizeLabel makes link labels case- and whitespace-insensitive
+func normalizeLabel(label string) string {
+	return strings.ToLower(strings.Join(strings.Fields(label), " "))
+}
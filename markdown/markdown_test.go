@@ -0,0 +1,99 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"emphasis", "*a* **b** ***c*** ~~d~~", "<p><em>a</em> <strong>b</strong> <em><strong>c</strong></em> <del>d</del></p>\n"},
+		{"intraword underscore", "snake_case_name", "<p>snake_case_name</p>\n"},
+		{"code span", "use `<b>` here", "<p>use <code>&lt;b&gt;</code> here</p>\n"},
+		{"heading", "## Title ##", "<h2>Title</h2>\n"},
+		{"setext heading", "Title\n===", "<h1>Title</h1>\n"},
+		{"hard break", "a  \nb", "<p>a<br>\nb</p>\n"},
+		{"escapes", `\*not emphasis\*`, "<p>*not emphasis*</p>\n"},
+		{"entities", "&copy; &bogus;", "<p>© &amp;bogus;</p>\n"},
+		{"link", `[site](https://example.com "Home")`, `<p><a href="https://example.com" title="Home" rel="nofollow ugc noopener">site</a></p>` + "\n"},
+		{"reference link", "[site]\n\n[site]: /about", `<p><a href="/about" rel="nofollow ugc noopener">site</a></p>` + "\n"},
+		{"image", "![cat](/attachments/abc)", `<p><img src="/attachments/abc" alt="cat"></p>` + "\n"},
+		{"autolink", "see <https://example.com>", `<p>see <a href="https://example.com" rel="nofollow ugc noopener">https://example.com</a></p>` + "\n"},
+		{"bare url", "at www.example.com.", `<p>at <a href="http://www.example.com" rel="nofollow ugc noopener">www.example.com</a>.</p>` + "\n"},
+		{"fenced code", "```text\nx := 1 < 2\n```", `<div class="code-block"><pre><code class="language-text"><span class="line">x := 1 &lt; 2</span>` + "\n</code></pre></div>\n"},
+		{"highlighted code", "```go\nreturn nil\n```", `<div class="code-block"><pre><code class="language-go"><span class="line">` +
+			`<span class="hl-keyword">return</span> <span class="hl-literal">nil</span></span>` + "\n</code></pre></div>\n"},
+		{"unclosed fence", "```\nx\n\ny\n", `<div class="code-block"><pre><code><span class="line">x</span>` + "\n" +
+			`<span class="line"></span>` + "\n" + `<span class="line">y</span>` + "\n</code></pre></div>\n"},
+		{"indented code", "    code", `<div class="code-block"><pre><code><span class="line">code</span>` + "\n</code></pre></div>\n"},
+		{"blockquote", "> quoted\nlazy", "<blockquote>\n<p>quoted\nlazy</p>\n</blockquote>\n"},
+		{"tight list", "- a\n- b", "<ul>\n<li>a</li>\n<li>b</li>\n</ul>\n"},
+		{"loose list", "- a\n\n- b", "<ul>\n<li>\n<p>a</p>\n</li>\n<li>\n<p>b</p>\n</li>\n</ul>\n"},
+		{"ordered list", "3. a\n4. b", "<ol start=\"3\">\n<li>a</li>\n<li>b</li>\n</ol>\n"},
+		{"nested list", "- a\n  - b", "<ul>\n<li>a\n<ul>\n<li>b</li>\n</ul>\n</li>\n</ul>\n"},
+		{"task list", "- [x] done\n- [ ] todo", "<ul>\n<li class=\"task-list-item\"><input type=\"checkbox\" disabled checked> done</li>\n" +
+			"<li class=\"task-list-item\"><input type=\"checkbox\" disabled> todo</li>\n</ul>\n"},
+		{"table", "| a | b |\n|:-:|---|\n| 1 | 2 |", "<table>\n<thead>\n<tr>\n<th class=\"align-center\">a</th>\n<th>b</th>\n</tr>\n</thead>\n" +
+			"<tbody>\n<tr>\n<td class=\"align-center\">1</td>\n<td>2</td>\n</tr>\n</tbody>\n</table>\n"},
+		{"thematic break", "***", "<hr>\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Render(tt.input); got != tt.expected {
+				t.Errorf("Render(%q) = %q, expected %q", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRenderUnsafeInput(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		forbidden string
+	}{
+		{"raw html", "<script>alert(1)</script>", "<script"},
+		{"inline html", "hi <img src=x onerror=alert(1)>", "<img"},
+		{"javascript link", "[x](javascript:alert(1))", "javascript:"},
+		{"encoded javascript link", "[x](jav&#x61;script:alert(1))", "script:"},
+		{"data image", "![x](data:text/html;base64,AAAA)", "data:"},
+		{"javascript autolink", "<javascript:alert(1)>", "href"},
+		{"quote in url", `[x](/a"onmouseover="alert(1))`, `"onmouseover`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Render(tt.input); strings.Contains(got, tt.forbidden) {
+				t.Errorf("Render(%q) = %q, should not contain %q", tt.input, got, tt.forbidden)
+			}
+		})
+	}
+}
+
+func TestMentions(t *testing.T) {
+	source := "Hi @alice and @bob.\n\n`@code` [@linked](/x) mail@example.com @alice again\n\n    @indented"
+	got := strings.Join(Mentions(source), ",")
+	if got != "alice,bob" {
+		t.Errorf("Mentions = %q, expected %q", got, "alice,bob")
+	}
+}
+
+func TestRenderWithMentions(t *testing.T) {
+	resolve := func(name string) (string, string, bool) {
+		if name == "old_name" {
+			return "/user/new_name", "new_name", true
+		}
+		return "", "", false
+	}
+
+	got := RenderWithMentions("cc @old_name, @nobody", resolve)
+	expected := `<p>cc <a href="/user/new_name" class="mention" rel="nofollow ugc noopener">@new_name</a>, @nobody</p>` + "\n"
+	if got != expected {
+		t.Errorf("RenderWithMentions = %q, expected %q", got, expected)
+	}
+}
@@ -0,0 +1,233 @@
+package markdown
+
+import (
+	"html"
+	"strconv"
+	"strings"
+
+	"forum/highlight"
+)
+
+type renderer struct {
+	refs    map[string]linkRef
+	mention MentionResolver
+}
+
+func (r *renderer) renderBlocks(b *strings.Builder, blocks []*block, tight bool) {
+	for _, bl := range blocks {
+		r.renderBlock(b, bl, tight)
+	}
+}
+
+func (r *renderer) renderBlock(b *strings.Builder, bl *block, tight bool) {
+	switch bl.kind {
+	case blockParagraph:
+		if tight {
+			r.renderInline(b, bl.text)
+			return
+		}
+		b.WriteString("<p>")
+		r.renderInline(b, bl.text)
+		b.WriteString("</p>\n")
+
+	case blockHeading:
+		level := strconv.Itoa(bl.level)
+		b.WriteString("<h" + level + ">")
+		r.renderInline(b, bl.text)
+		b.WriteString("</h" + level + ">\n")
+
+	case blockThematicBreak:
+		b.WriteString("<hr>\n")
+
+	case blockCode:
+		b.WriteString(CodeBlock(bl.text, bl.info))
+
+	case blockQuote:
+		b.WriteString("<blockquote>\n")
+		r.renderBlocks(b, bl.children, false)
+		b.WriteString("</blockquote>\n")
+
+	case blockList:
+		tag := "ul"
+		if bl.ordered {
+			tag = "ol"
+		}
+		b.WriteString("<" + tag)
+		if bl.ordered && bl.start != 1 {
+			b.WriteString(` start="` + strconv.Itoa(bl.start) + `"`)
+		}
+		b.WriteString(">\n")
+		for _, item := range bl.children {
+			r.renderItem(b, item, bl.tight)
+		}
+		b.WriteString("</" + tag + ">\n")
+
+	case blockTable:
+		r.renderTable(b, bl)
+	}
+}
+
+func (r *renderer) renderItem(b *strings.Builder, item *block, tight bool) {
+	if item.task == 0 {
+		b.WriteString("<li>")
+	} else {
+		b.WriteString(`<li class="task-list-item">`)
+	}
+
+	for i, child := range item.children {
+		inlineParagraph := tight && child.kind == blockParagraph
+		if i == 0 && !inlineParagraph {
+			b.WriteString("\n")
+		}
+
+		// The checkbox of a task goes in front of its first paragraph
+		if i == 0 && item.task != 0 {
+			if !inlineParagraph {
+				b.WriteString("<p>")
+			}
+			b.WriteString(`<input type="checkbox" disabled`)
+			if item.task == 2 {
+				b.WriteString(" checked")
+			}
+			b.WriteString("> ")
+			r.renderInline(b, child.text)
+			if !inlineParagraph {
+				b.WriteString("</p>\n")
+			}
+		} else {
+			r.renderBlock(b, child, tight)
+		}
+
+		if inlineParagraph && i < len(item.children)-1 {
+			b.WriteString("\n")
+		}
+	}
+	b.WriteString("</li>\n")
+}
+
+func (r *renderer) renderTable(b *strings.Builder, table *block) {
+	b.WriteString("<table>\n<thead>\n")
+	for i, row := range table.rows {
+		cellTag := "td"
+		if i == 0 {
+			cellTag = "th"
+		}
+		if i == 1 {
+			b.WriteString("<tbody>\n")
+		}
+		b.WriteString("<tr>\n")
+		for j, cell := range row {
+			b.WriteString("<" + cellTag)
+			if table.align[j] != "" {
+				b.WriteString(` class="align-` + table.align[j] + `"`)
+			}
+			b.WriteString(">")
+			r.renderInline(b, cell)
+			b.WriteString("</" + cellTag + ">\n")
+		}
+		b.WriteString("</tr>\n")
+		if i == 0 {
+			b.WriteString("</thead>\n")
+		}
+	}
+	if len(table.rows) > 1 {
+		b.WriteString("</tbody>\n")
+	}
+	b.WriteString("</table>\n")
+}
+
+// CodeBlock renders the HTML of a fenced or indented code block
+var CodeBlock = highlight.Render
+
+func (r *renderer) renderInline(b *strings.Builder, text string) {
+	root := parseInline(text, r.refs)
+	if r.mention != nil {
+		linkifyMentions(root, r.mention)
+	}
+	renderInlineNodes(b, root)
+}
+
+func renderInlineNodes(b *strings.Builder, parent *inline) {
+	for n := parent.first; n != nil; n = n.next {
+		switch n.kind {
+		case inlineText:
+			b.WriteString(html.EscapeString(n.text))
+		case inlineCode:
+			b.WriteString("<code>" + html.EscapeString(n.text) + "</code>")
+		case inlineEmph:
+			b.WriteString("<em>")
+			renderInlineNodes(b, n)
+			b.WriteString("</em>")
+		case inlineStrong:
+			b.WriteString("<strong>")
+			renderInlineNodes(b, n)
+			b.WriteString("</strong>")
+		case inlineDel:
+			b.WriteString("<del>")
+			renderInlineNodes(b, n)
+			b.WriteString("</del>")
+		case inlineLink:
+			b.WriteString(`<a href="` + html.EscapeString(normalizeURL(n.dest)) + `"`)
+			if n.mention {
+				b.WriteString(` class="mention"`)
+			}
+			if n.title != "" {
+				b.WriteString(` title="` + html.EscapeString(n.title) + `"`)
+			}
+			b.WriteString(">")
+			renderInlineNodes(b, n)
+			b.WriteString("</a>")
+		case inlineImage:
+			b.WriteString(`<img src="` + html.EscapeString(normalizeURL(n.dest)) + `" alt="` + html.EscapeString(plainText(n)) + `"`)
+			if n.title != "" {
+				b.WriteString(` title="` + html.EscapeString(n.title) + `"`)
+			}
+			b.WriteString(">")
+		case inlineSoftBreak:
+			b.WriteString("\n")
+		case inlineHardBreak:
+			b.WriteString("<br>\n")
+		}
+	}
+}
+
+// plainText returns the text content of inline nodes, used for image descriptions
+func plainText(parent *inline) string {
+	var b strings.Builder
+	for n := parent.first; n != nil; n = n.next {
+		switch n.kind {
+		case inlineText, inlineCode:
+			b.WriteString(n.text)
+		case inlineSoftBreak, inlineHardBreak:
+			b.WriteString(" ")
+		default:
+			b.WriteString(plainText(n))
+		}
+	}
+	return b.String()
+}
+
+// normalizeURL percent-encodes characters that are not allowed in URLs, keeping existing escapes
+func normalizeURL(raw string) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		switch {
+		case c == '%' && i+2 < len(raw) && isHex(raw[i+1]) && isHex(raw[i+2]):
+			b.WriteByte(c)
+		case c < 0x80 && c > 0x20 && c != '%' && c != '"' && c != '<' && c != '>' && c != '\\' &&
+			c != '^' && c != '`' && c != '{' && c != '|' && c != '}' && c != 0x7f:
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&15])
+		}
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
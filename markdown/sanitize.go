@@ -0,0 +1,248 @@
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// allowedTags maps every tag that may appear in rendered content to its allowed attributes
+var allowedTags = map[string]map[string]bool{
+	"p": {}, "br": {}, "hr": {},
+	"h1": {}, "h2": {}, "h3": {}, "h4": {}, "h5": {}, "h6": {},
+	"blockquote": {}, "pre": {}, "em": {}, "strong": {}, "del": {},
+	"ul": {}, "ol": {"start": true}, "li": {"class": true},
+	"code":  {"class": true},
+	"span":  {"class": true},
+	"div":   {"class": true},
+	"a":     {"href": true, "title": true, "class": true},
+	"img":   {"src": true, "alt": true, "title": true},
+	"table": {}, "thead": {}, "tbody": {}, "tr": {},
+	"th":    {"class": true},
+	"td":    {"class": true},
+	"input": {"type": true, "checked": true, "disabled": true},
+}
+
+// voidTags have no closing tag
+var voidTags = map[string]bool{"br": true, "hr": true, "img": true, "input": true}
+
+// droppedContentTags are removed together with everything inside them
+var droppedContentTags = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true, "embed": true,
+	"noscript": true, "template": true, "textarea": true, "title": true, "svg": true, "math": true,
+}
+
+// AllowedClass matches the class names rendered content may use
+var AllowedClass = regexp.MustCompile(`^(language-[A-Za-z0-9_+#-]+|align-(left|center|right)|task-list-item|code-block|line|hl-[a-z]+|mention)$`)
+
+var (
+	reTagName   = regexp.MustCompile(`^</?([A-Za-z][A-Za-z0-9]*)`)
+	reAttribute = regexp.MustCompile(`^[\s/]*([^\s"'<>/=]+)(?:\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'=<>` + "`" + `]+)))?`)
+	reOnlyDigit = regexp.MustCompile(`^[0-9]{1,9}$`)
+)
+
+// Sanitize keeps only allowlisted tags and attributes of an HTML fragment.
+// Text is re-escaped, links get rel="nofollow ugc noopener" and URLs are limited
+// to http, https, mailto and relative addresses.
+func Sanitize(input string) string {
+	var out strings.Builder
+	var open []string
+
+	for i := 0; i < len(input); {
+		if input[i] != '<' {
+			end := strings.IndexByte(input[i:], '<')
+			if end < 0 {
+				end = len(input) - i
+			}
+			out.WriteString(html.EscapeString(html.UnescapeString(input[i : i+end])))
+			i += end
+			continue
+		}
+
+		// Comments are dropped
+		if strings.HasPrefix(input[i:], "<!--") {
+			end := strings.Index(input[i+4:], "-->")
+			if end < 0 {
+				break
+			}
+			i += 4 + end + 3
+			continue
+		}
+
+		name, closing, attrs, end, ok := parseTag(input, i)
+		if !ok {
+			out.WriteString("&lt;")
+			i++
+			continue
+		}
+		i = end
+
+		if droppedContentTags[name] {
+			if !closing {
+				i = skipElement(input, i, name)
+			}
+			continue
+		}
+
+		allowedAttrs, allowed := allowedTags[name]
+		if !allowed {
+			continue
+		}
+
+		if closing {
+			// Close up to the matching open tag, ignoring stray closing tags
+			for j := len(open) - 1; j >= 0; j-- {
+				if open[j] == name {
+					for k := len(open) - 1; k >= j; k-- {
+						out.WriteString("</" + open[k] + ">")
+					}
+					open = open[:j]
+					break
+				}
+			}
+			continue
+		}
+
+		out.WriteString("<" + name)
+		writeAttributes(&out, name, attrs, allowedAttrs)
+		out.WriteString(">")
+		if !voidTags[name] {
+			open = append(open, name)
+		}
+	}
+
+	for j := len(open) - 1; j >= 0; j-- {
+		out.WriteString("</" + open[j] + ">")
+	}
+	return out.String()
+}
+
+type attribute struct {
+	name  string
+	value string
+}
+
+// parseTag reads the tag starting at input[start] and returns the position after it
+func parseTag(input string, start int) (name string, closing bool, attrs []attribute, end int, ok bool) {
+	m := reTagName.FindStringSubmatch(input[start:])
+	if m == nil {
+		return "", false, nil, 0, false
+	}
+	name = strings.ToLower(m[1])
+	closing = input[start+1] == '/'
+	pos := start + len(m[0])
+
+	for pos < len(input) {
+		rest := input[pos:]
+		trimmed := strings.TrimLeft(rest, " \t\n\r\f/")
+		if strings.HasPrefix(trimmed, ">") {
+			return name, closing, attrs, pos + len(rest) - len(trimmed) + 1, true
+		}
+		am := reAttribute.FindStringSubmatch(rest)
+		if am == nil {
+			return "", false, nil, 0, false
+		}
+		value := am[2] + am[3] + am[4]
+		attrs = append(attrs, attribute{name: strings.ToLower(am[1]), value: html.UnescapeString(value)})
+		pos += len(am[0])
+	}
+	return "", false, nil, 0, false
+}
+
+func writeAttributes(out *strings.Builder, tag string, attrs []attribute, allowed map[string]bool) {
+	seen := map[string]bool{}
+	for _, attr := range attrs {
+		if !allowed[attr.name] || seen[attr.name] {
+			continue
+		}
+		value := attr.value
+
+		switch attr.name {
+		case "href":
+			if !safeURL(value, true) {
+				continue
+			}
+		case "src":
+			if !safeURL(value, false) {
+				continue
+			}
+		case "class":
+			var classes []string
+			for _, class := range strings.Fields(value) {
+				if AllowedClass.MatchString(class) {
+					classes = append(classes, class)
+				}
+			}
+			if len(classes) == 0 {
+				continue
+			}
+			value = strings.Join(classes, " ")
+		case "start":
+			if !reOnlyDigit.MatchString(value) {
+				continue
+			}
+		case "type":
+			if value != "checkbox" {
+				continue
+			}
+		case "checked", "disabled":
+			value = ""
+		}
+
+		seen[attr.name] = true
+		if value == "" && (attr.name == "checked" || attr.name == "disabled") {
+			out.WriteString(" " + attr.name)
+			continue
+		}
+		out.WriteString(" " + attr.name + `="` + html.EscapeString(value) + `"`)
+	}
+
+	switch tag {
+	case "a":
+		out.WriteString(` rel="nofollow ugc noopener"`)
+	case "input":
+		// Checkboxes are only ever shown, never edited
+		if !seen["type"] {
+			out.WriteString(` type="checkbox"`)
+		}
+		if !seen["disabled"] {
+			out.WriteString(" disabled")
+		}
+	}
+}
+
+// safeURL allows relative URLs and http(s) URLs, plus mailto links when allowMailto is set
+func safeURL(value string, allowMailto bool) bool {
+	for i := 0; i < len(value); i++ {
+		if value[i] <= ' ' || value[i] == 0x7f {
+			return false
+		}
+	}
+
+	// The scheme is whatever comes before a colon that precedes any /, ? or #
+	colon := strings.IndexByte(value, ':')
+	if colon < 0 || strings.ContainsAny(value[:colon], "/?#") {
+		return true
+	}
+	switch strings.ToLower(value[:colon]) {
+	case "http", "https":
+		return true
+	case "mailto":
+		return allowMailto
+	}
+	return false
+}
+
+// skipElement returns the position after the closing tag of an element whose content is dropped
+func skipElement(input string, pos int, name string) int {
+	lower := strings.ToLower(input[pos:])
+	end := strings.Index(lower, "</"+name)
+	if end < 0 {
+		return len(input)
+	}
+	closeEnd := strings.IndexByte(lower[end:], '>')
+	if closeEnd < 0 {
+		return len(input)
+	}
+	return pos + end + closeEnd + 1
+}
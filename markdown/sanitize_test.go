@@ -0,0 +1,36 @@
+package markdown
+
+import "testing"
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"allowed tags", "<p><em>hi</em></p>", "<p><em>hi</em></p>"},
+		{"script dropped with content", "a<script>alert(1)</script>b", "ab"},
+		{"unknown tag keeps text", "<font color=red>hi</font>", "hi"},
+		{"event handler", `<p onclick="x()">hi</p>`, "<p>hi</p>"},
+		{"javascript href", `<a href="javascript:alert(1)">x</a>`, `<a rel="nofollow ugc noopener">x</a>`},
+		{"encoded scheme", `<a href="java&#09;script:x">x</a>`, `<a rel="nofollow ugc noopener">x</a>`},
+		{"mailto href", `<a href="mailto:a@b.c">x</a>`, `<a href="mailto:a@b.c" rel="nofollow ugc noopener">x</a>`},
+		{"mailto src", `<img src="mailto:a@b.c">`, `<img>`},
+		{"rel overridden", `<a href="/x" rel="follow">x</a>`, `<a href="/x" rel="nofollow ugc noopener">x</a>`},
+		{"class filtered", `<code class="language-go evil">x</code>`, `<code class="language-go">x</code>`},
+		{"checkbox forced disabled", `<input type="checkbox" checked>`, `<input type="checkbox" checked disabled>`},
+		{"text input", `<input type="text">`, `<input type="checkbox" disabled>`},
+		{"unclosed tags", "<ul><li>a", "<ul><li>a</li></ul>"},
+		{"stray close", "a</p></div>b", "ab"},
+		{"comment", "a<!-- <script> -->b", "ab"},
+		{"bare angle", "1 < 2 <3", "1 &lt; 2 &lt;3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Sanitize(tt.input); got != tt.expected {
+				t.Errorf("Sanitize(%q) = %q, expected %q", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,321 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"forum/utils"
+
+	"github.com/google/uuid"
+)
+
+// UsernameChangeCooldown is the minimum time between two username changes.
+// Set it to 0 to let users rename themselves as often as they like.
+var UsernameChangeCooldown = 30 * 24 * time.Hour
+
+// EmailChangeTTL is how long an email verification link stays valid
+var EmailChangeTTL = 24 * time.Hour
+
+// Account audit actions
+const (
+	AuditUsernameChanged    = "username_changed"
+	AuditEmailChangeRequest = "email_change_requested"
+	AuditEmailChanged       = "email_changed"
+	AuditPasswordChanged    = "password_changed"
+	AuditSessionsRevoked    = "sessions_revoked"
+	AuditDataExported       = "data_exported"
+	AuditTokenCreated       = "api_token_created"
+	AuditTokenRevoked       = "api_token_revoked"
+)
+
+type AccountEvent struct {
+	ID        int64
+	UserID    int64
+	Action    string
+	Detail    string
+	IPAddress string
+	CreatedAt time.Time
+}
+
+// RecordAccountEvent adds an entry to the account audit trail
+func RecordAccountEvent(db *sql.DB, userID int64, action, detail, ipAddress string) error {
+	_, err := db.Exec(
+		"INSERT INTO account_audit (user_id, action, detail, ip_address) VALUES (?, ?, ?, ?)",
+		userID, action, detail, ipAddress,
+	)
+	return err
+}
+
+// GetAccountEvents retrieves the most recent audit trail entries for a user
+func GetAccountEvents(db *sql.DB, userID int64, limit int) ([]AccountEvent, error) {
+	rows, err := db.Query(`
+		SELECT id, user_id, action, detail, ip_address, created_at
+		FROM account_audit
+		WHERE user_id = ?
+		ORDER BY id DESC
+		LIMIT ?
+	`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []AccountEvent
+	for rows.Next() {
+		var event AccountEvent
+		if err := rows.Scan(
+			&event.ID, &event.UserID, &event.Action, &event.Detail, &event.IPAddress, &event.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+// ChangeUsername renames a user after checking uniqueness and the rename cooldown
+func ChangeUsername(db *sql.DB, userID int64, newUsername, ipAddress string) error {
+	newUsername = strings.TrimSpace(newUsername)
+	if newUsername == "" {
+		return errors.New("username is required")
+	}
+
+	var currentUsername string
+	var changedAt sql.NullTime
+	err := db.QueryRow(
+		"SELECT username, username_changed_at FROM users WHERE id = ?",
+		userID,
+	).Scan(&currentUsername, &changedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return errors.New("user not found")
+		}
+		return err
+	}
+
+	if newUsername == DeletedUsername {
+		return errors.New("username already taken")
+	}
+
+	if newUsername == currentUsername {
+		return errors.New("that is already your username")
+	}
+
+	if UsernameChangeCooldown > 0 && changedAt.Valid && time.Since(changedAt.Time) < UsernameChangeCooldown {
+		return errors.New("you can only change your username once every " + formatCooldown(UsernameChangeCooldown))
+	}
+
+	// Check if username already exists
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM users WHERE username = ? AND id != ?", newUsername, userID).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("username already taken")
+	}
+
+	_, err = db.Exec(
+		"UPDATE users SET username = ?, username_changed_at = ? WHERE id = ?",
+		newUsername, time.Now(), userID,
+	)
+	if err != nil {
+		return err
+	}
+
+	// Mentions of the user show their current name
+	if err := forgetMentionRenderings(db, userID); err != nil {
+		return err
+	}
+
+	return RecordAccountEvent(db, userID, AuditUsernameChanged, currentUsername+" -> "+newUsername, ipAddress)
+}
+
+// RequestEmailChange stores a pending email change and returns the verification token.
+// The user's email is only updated once the token is confirmed.
+func RequestEmailChange(db *sql.DB, userID int64, newEmail, ipAddress string) (string, error) {
+	newEmail = strings.TrimSpace(newEmail)
+	if newEmail == "" {
+		return "", errors.New("email is required")
+	}
+	if !strings.Contains(newEmail, "@") {
+		return "", errors.New("email is not valid")
+	}
+
+	// Check if email already exists
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM users WHERE email = ?", newEmail).Scan(&count)
+	if err != nil {
+		return "", err
+	}
+	if count > 0 {
+		return "", errors.New("email already registered")
+	}
+
+	token := uuid.New().String()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	// Only the latest request is kept for a user
+	_, err = tx.Exec("DELETE FROM email_changes WHERE user_id = ?", userID)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO email_changes (token, user_id, new_email, expires_at) VALUES (?, ?, ?, ?)",
+		token, userID, newEmail, time.Now().Add(EmailChangeTTL),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO account_audit (user_id, action, detail, ip_address) VALUES (?, ?, ?, ?)",
+		userID, AuditEmailChangeRequest, newEmail, ipAddress,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// ConfirmEmailChange applies a pending email change and returns the user it belongs to
+func ConfirmEmailChange(db *sql.DB, token, ipAddress string) (int64, error) {
+	var userID int64
+	var newEmail string
+	var expiresAt time.Time
+	err := db.QueryRow(
+		"SELECT user_id, new_email, expires_at FROM email_changes WHERE token = ?",
+		token,
+	).Scan(&userID, &newEmail, &expiresAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, errors.New("verification link is invalid")
+		}
+		return 0, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM email_changes WHERE token = ?", token)
+	if err != nil {
+		return 0, err
+	}
+
+	if time.Now().After(expiresAt) {
+		if err = tx.Commit(); err != nil {
+			return 0, err
+		}
+		return 0, errors.New("verification link has expired")
+	}
+
+	// The address may have been taken while the change was pending
+	var count int
+	err = tx.QueryRow("SELECT COUNT(*) FROM users WHERE email = ?", newEmail).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	if count > 0 {
+		return 0, errors.New("email already registered")
+	}
+
+	var oldEmail string
+	err = tx.QueryRow("SELECT email FROM users WHERE id = ?", userID).Scan(&oldEmail)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec("UPDATE users SET email = ? WHERE id = ?", newEmail, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO account_audit (user_id, action, detail, ip_address) VALUES (?, ?, ?, ?)",
+		userID, AuditEmailChanged, oldEmail+" -> "+newEmail, ipAddress,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// GetPendingEmailChange returns the email address awaiting verification, if any
+func GetPendingEmailChange(db *sql.DB, userID int64) (string, error) {
+	var newEmail string
+	err := db.QueryRow(
+		"SELECT new_email FROM email_changes WHERE user_id = ? AND expires_at > ?",
+		userID, time.Now(),
+	).Scan(&newEmail)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return newEmail, err
+}
+
+// ChangePassword updates a user's password after verifying the current one
+func ChangePassword(db *sql.DB, userID int64, currentPassword, newPassword, ipAddress string) error {
+	if newPassword == "" {
+		return errors.New("new password is required")
+	}
+
+	var hash string
+	err := db.QueryRow("SELECT password FROM users WHERE id = ?", userID).Scan(&hash)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return errors.New("user not found")
+		}
+		return err
+	}
+
+	if !utils.CheckPasswordHash(currentPassword, hash) {
+		return errors.New("current password is incorrect")
+	}
+
+	hashedPassword, err := utils.HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("UPDATE users SET password = ? WHERE id = ?", hashedPassword, userID)
+	if err != nil {
+		return err
+	}
+
+	return RecordAccountEvent(db, userID, AuditPasswordChanged, "", ipAddress)
+}
+
+// formatCooldown turns a cooldown duration into a short human readable string
+func formatCooldown(d time.Duration) string {
+	days := int(d / (24 * time.Hour))
+	switch {
+	case days == 1:
+		return "day"
+	case days > 1:
+		return strconv.Itoa(days) + " days"
+	}
+	return d.String()
+}
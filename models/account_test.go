@@ -0,0 +1,175 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChangeUsername(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	userID, err := CreateUser(db, "testuser", "test@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	_, err = CreateUser(db, "otheruser", "other@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Failed to create second user: %v", err)
+	}
+
+	// Test renaming to a taken username
+	err = ChangeUsername(db, userID, "otheruser", "127.0.0.1")
+	if err == nil {
+		t.Fatal("Expected error for taken username, got nil")
+	}
+
+	// Test a valid rename
+	err = ChangeUsername(db, userID, "renamed", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("Failed to change username: %v", err)
+	}
+
+	user, err := GetUserByID(db, userID)
+	if err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+	if user.Username != "renamed" {
+		t.Errorf("Expected username 'renamed', got '%s'", user.Username)
+	}
+
+	// Test the rename cooldown
+	err = ChangeUsername(db, userID, "renamedagain", "127.0.0.1")
+	if err == nil {
+		t.Fatal("Expected error for rename during cooldown, got nil")
+	}
+
+	// Test renaming with the cooldown disabled
+	oldCooldown := UsernameChangeCooldown
+	UsernameChangeCooldown = 0
+	defer func() { UsernameChangeCooldown = oldCooldown }()
+
+	err = ChangeUsername(db, userID, "renamedagain", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("Failed to change username without cooldown: %v", err)
+	}
+
+	// Verify the audit trail recorded both renames
+	events, err := GetAccountEvents(db, userID, 10)
+	if err != nil {
+		t.Fatalf("Failed to get account events: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 account events, got %d", len(events))
+	}
+	if events[0].Action != AuditUsernameChanged || events[0].Detail != "renamed -> renamedagain" {
+		t.Errorf("Unexpected latest event: %+v", events[0])
+	}
+}
+
+func TestEmailChange(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	userID, err := CreateUser(db, "testuser", "test@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+
+	// Test requesting an address that is already registered
+	_, err = RequestEmailChange(db, userID, "test@example.com", "127.0.0.1")
+	if err == nil {
+		t.Fatal("Expected error for registered email, got nil")
+	}
+
+	// Test a valid request
+	token, err := RequestEmailChange(db, userID, "new@example.com", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("Failed to request email change: %v", err)
+	}
+
+	// The email must not change before verification
+	user, err := GetUserByID(db, userID)
+	if err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+	if user.Email != "test@example.com" {
+		t.Errorf("Email changed before verification: %s", user.Email)
+	}
+
+	pending, err := GetPendingEmailChange(db, userID)
+	if err != nil {
+		t.Fatalf("Failed to get pending email change: %v", err)
+	}
+	if pending != "new@example.com" {
+		t.Errorf("Expected pending email 'new@example.com', got '%s'", pending)
+	}
+
+	// Test confirming the change
+	confirmedID, err := ConfirmEmailChange(db, token, "127.0.0.1")
+	if err != nil {
+		t.Fatalf("Failed to confirm email change: %v", err)
+	}
+	if confirmedID != userID {
+		t.Errorf("Expected user ID %d, got %d", userID, confirmedID)
+	}
+
+	user, err = GetUserByID(db, userID)
+	if err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+	if user.Email != "new@example.com" {
+		t.Errorf("Expected email 'new@example.com', got '%s'", user.Email)
+	}
+
+	// Test that a token can only be used once
+	_, err = ConfirmEmailChange(db, token, "127.0.0.1")
+	if err == nil {
+		t.Fatal("Expected error for reused token, got nil")
+	}
+
+	// Test an expired token
+	_, err = db.Exec(
+		"INSERT INTO email_changes (token, user_id, new_email, expires_at) VALUES (?, ?, ?, ?)",
+		"expired-token", userID, "late@example.com", time.Now().Add(-time.Hour),
+	)
+	if err != nil {
+		t.Fatalf("Failed to insert expired token: %v", err)
+	}
+	_, err = ConfirmEmailChange(db, "expired-token", "127.0.0.1")
+	if err == nil {
+		t.Fatal("Expected error for expired token, got nil")
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	userID, err := CreateUser(db, "testuser", "test@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+
+	// Test with a wrong current password
+	err = ChangePassword(db, userID, "wrongpassword", "newpassword456", "127.0.0.1")
+	if err == nil {
+		t.Fatal("Expected error for wrong current password, got nil")
+	}
+
+	// Test a valid change
+	err = ChangePassword(db, userID, "password123", "newpassword456", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("Failed to change password: %v", err)
+	}
+
+	// Verify the new password works and the old one does not
+	_, err = AuthenticateUser(db, "test@example.com", "newpassword456")
+	if err != nil {
+		t.Errorf("Failed to authenticate with new password: %v", err)
+	}
+	_, err = AuthenticateUser(db, "test@example.com", "password123")
+	if err == nil {
+		t.Error("Old password still works after change")
+	}
+}
@@ -0,0 +1,237 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+type Attachment struct {
+	ID           int64
+	Token        string
+	UserID       int64
+	PostID       int64 // 0 while the upload is not attached yet
+	CommentID    int64 // 0 for attachments on the post itself
+	DraftID      int64 // set while the upload belongs to an unpublished draft
+	Filename     string
+	ContentType  string
+	Size         int64
+	Width        int
+	Height       int
+	HasThumbnail bool
+	CreatedAt    time.Time
+}
+
+// IsImage reports whether the attachment can be shown inline
+func (a Attachment) IsImage() bool {
+	return strings.HasPrefix(a.ContentType, "image/")
+}
+
+// URL returns the download path of the attachment
+func (a Attachment) URL() string {
+	return "/attachments/" + a.Token
+}
+
+// ThumbnailURL returns the thumbnail path, or the full image if there is no thumbnail
+func (a Attachment) ThumbnailURL() string {
+	if a.HasThumbnail {
+		return "/attachments/" + a.Token + "/thumb"
+	}
+	return a.URL()
+}
+
+const attachmentColumns = `id, token, user_id, COALESCE(post_id, 0), COALESCE(comment_id, 0), COALESCE(draft_id, 0),
+	filename, content_type, size, width, height, has_thumbnail, created_at`
+
+// CreateAttachment stores the metadata of an uploaded file that is not attached to anything yet
+func CreateAttachment(db *sql.DB, a *Attachment) (int64, error) {
+	if a.CreatedAt.IsZero() {
+		a.CreatedAt = time.Now()
+	}
+	result, err := db.Exec(`
+		INSERT INTO attachments (token, user_id, filename, content_type, size, width, height, has_thumbnail, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, a.Token, a.UserID, a.Filename, a.ContentType, a.Size, a.Width, a.Height, a.HasThumbnail, a.CreatedAt)
+	if err != nil {
+		return 0, err
+	}
+
+	a.ID, err = result.LastInsertId()
+	return a.ID, err
+}
+
+// GetAttachmentByToken retrieves an attachment by its public token
+func GetAttachmentByToken(db *sql.DB, token string) (*Attachment, error) {
+	rows, err := db.Query("SELECT "+attachmentColumns+" FROM attachments WHERE token = ?", token)
+	if err != nil {
+		return nil, err
+	}
+	attachments, err := scanAttachments(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(attachments) == 0 {
+		return nil, errors.New("attachment not found")
+	}
+	return &attachments[0], nil
+}
+
+// AttachToPost links a user's unattached uploads to their post
+func AttachToPost(db *sql.DB, tokens []string, userID, postID int64) (int64, error) {
+	return attach(db, tokens, userID, postID, 0)
+}
+
+// AttachToComment links a user's unattached uploads to their comment
+func AttachToComment(db *sql.DB, tokens []string, userID, postID, commentID int64) (int64, error) {
+	return attach(db, tokens, userID, postID, commentID)
+}
+
+func attach(db *sql.DB, tokens []string, userID, postID, commentID int64) (int64, error) {
+	if len(tokens) == 0 {
+		return 0, nil
+	}
+
+	var commentArg interface{}
+	if commentID > 0 {
+		commentArg = commentID
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(tokens)), ",")
+	args := []interface{}{postID, commentArg, userID}
+	for _, token := range tokens {
+		args = append(args, token)
+	}
+
+	// Only the uploader can attach a file, and only once
+	result, err := db.Exec(`
+		UPDATE attachments SET post_id = ?, comment_id = ?, draft_id = NULL
+		WHERE user_id = ? AND post_id IS NULL AND comment_id IS NULL
+		AND token IN (`+placeholders+`)
+	`, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// AttachToDraft keeps a user's unattached uploads with their draft until it is published
+func AttachToDraft(db *sql.DB, tokens []string, userID, draftID int64) (int64, error) {
+	if len(tokens) == 0 {
+		return 0, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(tokens)), ",")
+	args := []interface{}{draftID, userID}
+	for _, token := range tokens {
+		args = append(args, token)
+	}
+
+	result, err := db.Exec(`
+		UPDATE attachments SET draft_id = ?
+		WHERE user_id = ? AND post_id IS NULL AND comment_id IS NULL
+		AND token IN (`+placeholders+`)
+	`, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetDraftAttachments retrieves the uploads kept with a draft
+func GetDraftAttachments(db *sql.DB, draftID int64) ([]Attachment, error) {
+	rows, err := db.Query(
+		"SELECT "+attachmentColumns+" FROM attachments WHERE draft_id = ? AND post_id IS NULL ORDER BY id ASC",
+		draftID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanAttachments(rows)
+}
+
+// GetPostAttachments retrieves the attachments of a post, without those of its comments
+func GetPostAttachments(db *sql.DB, postID int64) ([]Attachment, error) {
+	rows, err := db.Query(
+		"SELECT "+attachmentColumns+" FROM attachments WHERE post_id = ? AND comment_id IS NULL ORDER BY id ASC",
+		postID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanAttachments(rows)
+}
+
+// GetCommentAttachments retrieves the attachments of every comment on a post, keyed by comment ID
+func GetCommentAttachments(db *sql.DB, postID int64) (map[int64][]Attachment, error) {
+	rows, err := db.Query(
+		"SELECT "+attachmentColumns+" FROM attachments WHERE post_id = ? AND comment_id IS NOT NULL ORDER BY id ASC",
+		postID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	attachments, err := scanAttachments(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	byComment := make(map[int64][]Attachment)
+	for _, a := range attachments {
+		byComment[a.CommentID] = append(byComment[a.CommentID], a)
+	}
+	return byComment, nil
+}
+
+// GetUserAttachments retrieves every file uploaded by a user
+func GetUserAttachments(db *sql.DB, userID int64) ([]Attachment, error) {
+	rows, err := db.Query(
+		"SELECT "+attachmentColumns+" FROM attachments WHERE user_id = ? ORDER BY id ASC",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanAttachments(rows)
+}
+
+// GetOrphanAttachments retrieves uploads created before the cutoff that were never attached
+// to a post, comment or draft, or whose post, comment or draft has been deleted
+func GetOrphanAttachments(db *sql.DB, cutoff time.Time) ([]Attachment, error) {
+	rows, err := db.Query(`
+		SELECT `+attachmentColumns+` FROM attachments a
+		WHERE a.created_at < ? AND (
+			(a.post_id IS NULL AND a.comment_id IS NULL AND a.draft_id IS NULL)
+			OR (a.post_id IS NULL AND a.draft_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM drafts WHERE id = a.draft_id))
+			OR (a.post_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM posts WHERE id = a.post_id))
+			OR (a.comment_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM comments WHERE id = a.comment_id))
+		)
+	`, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	return scanAttachments(rows)
+}
+
+// DeleteAttachment removes an attachment's metadata
+func DeleteAttachment(db *sql.DB, id int64) error {
+	_, err := db.Exec("DELETE FROM attachments WHERE id = ?", id)
+	return err
+}
+
+func scanAttachments(rows *sql.Rows) ([]Attachment, error) {
+	defer rows.Close()
+
+	var attachments []Attachment
+	for rows.Next() {
+		var a Attachment
+		if err := rows.Scan(
+			&a.ID, &a.Token, &a.UserID, &a.PostID, &a.CommentID, &a.DraftID,
+			&a.Filename, &a.ContentType, &a.Size, &a.Width, &a.Height, &a.HasThumbnail, &a.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, rows.Err()
+}
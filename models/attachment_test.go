@@ -0,0 +1,161 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAttachToPost(t *testing.T) {
+	db, cleanup, userID := setupPostTestDB(t)
+	defer cleanup()
+
+	otherID, err := CreateUser(db, "otheruser", "other@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	postID, err := CreatePost(db, "Test Post", "Test content", userID, []int64{1})
+	if err != nil {
+		t.Fatalf("Failed to create post: %v", err)
+	}
+
+	a := &Attachment{Token: "token-1", UserID: userID, Filename: "photo.png", ContentType: "image/png", Size: 100}
+	if _, err := CreateAttachment(db, a); err != nil {
+		t.Fatalf("Failed to create attachment: %v", err)
+	}
+
+	// Test that another user cannot claim the upload
+	n, err := AttachToPost(db, []string{"token-1"}, otherID, postID)
+	if err != nil {
+		t.Fatalf("Failed to attach: %v", err)
+	}
+	if n != 0 {
+		t.Errorf("Expected 0 attachments claimed by another user, got %d", n)
+	}
+
+	// Test attaching by the uploader
+	n, err = AttachToPost(db, []string{"token-1", "unknown"}, userID, postID)
+	if err != nil {
+		t.Fatalf("Failed to attach: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 attachment, got %d", n)
+	}
+
+	// Test that an attached upload cannot be moved to a comment
+	commentID, err := CreateComment(db, "Test comment", userID, postID)
+	if err != nil {
+		t.Fatalf("Failed to create comment: %v", err)
+	}
+	n, err = AttachToComment(db, []string{"token-1"}, userID, postID, commentID)
+	if err != nil {
+		t.Fatalf("Failed to attach: %v", err)
+	}
+	if n != 0 {
+		t.Errorf("Expected attached upload to stay on the post, got %d moved", n)
+	}
+
+	attachments, err := GetPostAttachments(db, postID)
+	if err != nil {
+		t.Fatalf("Failed to get attachments: %v", err)
+	}
+	if len(attachments) != 1 || attachments[0].Filename != "photo.png" {
+		t.Fatalf("Unexpected post attachments: %+v", attachments)
+	}
+	if !attachments[0].IsImage() || attachments[0].URL() != "/attachments/token-1" {
+		t.Errorf("Unexpected attachment details: %+v", attachments[0])
+	}
+}
+
+func TestGetCommentAttachments(t *testing.T) {
+	db, cleanup, userID := setupPostTestDB(t)
+	defer cleanup()
+
+	postID, err := CreatePost(db, "Test Post", "Test content", userID, []int64{1})
+	if err != nil {
+		t.Fatalf("Failed to create post: %v", err)
+	}
+	commentID, err := CreateComment(db, "Test comment", userID, postID)
+	if err != nil {
+		t.Fatalf("Failed to create comment: %v", err)
+	}
+
+	for _, token := range []string{"token-1", "token-2"} {
+		a := &Attachment{Token: token, UserID: userID, Filename: token + ".pdf", ContentType: "application/pdf", Size: 10}
+		if _, err := CreateAttachment(db, a); err != nil {
+			t.Fatalf("Failed to create attachment: %v", err)
+		}
+	}
+	if _, err := AttachToComment(db, []string{"token-1", "token-2"}, userID, postID, commentID); err != nil {
+		t.Fatalf("Failed to attach: %v", err)
+	}
+
+	byComment, err := GetCommentAttachments(db, postID)
+	if err != nil {
+		t.Fatalf("Failed to get comment attachments: %v", err)
+	}
+	if len(byComment[commentID]) != 2 {
+		t.Errorf("Expected 2 comment attachments, got %d", len(byComment[commentID]))
+	}
+
+	// Comment attachments are not listed on the post itself
+	postAttachments, err := GetPostAttachments(db, postID)
+	if err != nil {
+		t.Fatalf("Failed to get post attachments: %v", err)
+	}
+	if len(postAttachments) != 0 {
+		t.Errorf("Expected no post attachments, got %d", len(postAttachments))
+	}
+}
+
+func TestGetOrphanAttachments(t *testing.T) {
+	db, cleanup, userID := setupPostTestDB(t)
+	defer cleanup()
+
+	postID, err := CreatePost(db, "Test Post", "Test content", userID, []int64{1})
+	if err != nil {
+		t.Fatalf("Failed to create post: %v", err)
+	}
+
+	old := time.Now().Add(-48 * time.Hour)
+	for _, a := range []*Attachment{
+		{Token: "unattached-old", CreatedAt: old},
+		{Token: "unattached-new"},
+		{Token: "attached-old", CreatedAt: old},
+		{Token: "deleted-post", CreatedAt: old},
+	} {
+		a.UserID = userID
+		a.Filename = "file.txt"
+		a.ContentType = "text/plain"
+		if _, err := CreateAttachment(db, a); err != nil {
+			t.Fatalf("Failed to create attachment: %v", err)
+		}
+	}
+	if _, err := AttachToPost(db, []string{"attached-old"}, userID, postID); err != nil {
+		t.Fatalf("Failed to attach: %v", err)
+	}
+	if _, err := AttachToPost(db, []string{"deleted-post"}, userID, postID+100); err != nil {
+		t.Fatalf("Failed to attach: %v", err)
+	}
+
+	orphans, err := GetOrphanAttachments(db, time.Now().Add(-24*time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to get orphan attachments: %v", err)
+	}
+
+	tokens := map[string]bool{}
+	for _, a := range orphans {
+		tokens[a.Token] = true
+	}
+	if len(orphans) != 2 || !tokens["unattached-old"] || !tokens["deleted-post"] {
+		t.Errorf("Unexpected orphan attachments: %v", tokens)
+	}
+
+	// Test deleting an orphan
+	if err := DeleteAttachment(db, orphans[0].ID); err != nil {
+		t.Fatalf("Failed to delete attachment: %v", err)
+	}
+	if _, err := GetAttachmentByToken(db, orphans[0].Token); err == nil {
+		t.Error("Expected deleted attachment to be gone")
+	}
+}
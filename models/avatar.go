@@ -0,0 +1,47 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// Avatar versions only ever grow, so a cached image URL never points at a different picture.
+// A version of 0 or below means the user has no uploaded avatar; a removed avatar is stored
+// as the negated version so the next upload continues the sequence.
+
+// GetAvatarVersion returns the current avatar version of a user
+func GetAvatarVersion(db *sql.DB, userID int64) (int, error) {
+	var version int
+	err := db.QueryRow("SELECT avatar_version FROM users WHERE id = ?", userID).Scan(&version)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, errors.New("user not found")
+		}
+		return 0, err
+	}
+	return version, nil
+}
+
+// BumpAvatarVersion marks a new avatar upload and returns the new version
+func BumpAvatarVersion(db *sql.DB, userID int64) (int, error) {
+	var version int
+	err := db.QueryRow("SELECT ABS(avatar_version) + 1 FROM users WHERE id = ?", userID).Scan(&version)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, errors.New("user not found")
+		}
+		return 0, err
+	}
+
+	_, err = db.Exec("UPDATE users SET avatar_version = ? WHERE id = ?", version, userID)
+	if err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// RemoveAvatar switches a user back to their generated identicon
+func RemoveAvatar(db *sql.DB, userID int64) error {
+	_, err := db.Exec("UPDATE users SET avatar_version = -ABS(avatar_version) WHERE id = ?", userID)
+	return err
+}
@@ -0,0 +1,87 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// BlockedUser is an account a user blocked
+type BlockedUser struct {
+	ID        int64
+	Username  string
+	CreatedAt time.Time
+}
+
+// ToggleBlock blocks a user, or unblocks them when already blocked. Blocked users cannot
+// notify the blocker, whether by mentions, comments, posts or reactions.
+// It reports whether the user is blocked afterwards.
+func ToggleBlock(db *sql.DB, userID, blockedID int64) (bool, error) {
+	if userID == blockedID {
+		return false, errors.New("you cannot block yourself")
+	}
+
+	result, err := db.Exec("DELETE FROM user_blocks WHERE user_id = ? AND blocked_id = ?", userID, blockedID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n > 0 {
+		return false, nil
+	}
+
+	var exists bool
+	err = db.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM users WHERE id = ? AND username != ?)",
+		blockedID, DeletedUsername,
+	).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		return false, errors.New("user not found")
+	}
+
+	if _, err := db.Exec("INSERT INTO user_blocks (user_id, blocked_id) VALUES (?, ?)", userID, blockedID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// IsBlocked reports whether a user blocked another one
+func IsBlocked(db *sql.DB, userID, blockedID int64) (bool, error) {
+	var blocked bool
+	err := db.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM user_blocks WHERE user_id = ? AND blocked_id = ?)",
+		userID, blockedID,
+	).Scan(&blocked)
+	return blocked, err
+}
+
+// GetBlockedUsers retrieves the accounts a user blocked, by name
+func GetBlockedUsers(db *sql.DB, userID int64) ([]BlockedUser, error) {
+	rows, err := db.Query(`
+		SELECT u.id, u.username, b.created_at
+		FROM user_blocks b
+		JOIN users u ON u.id = b.blocked_id
+		WHERE b.user_id = ?
+		ORDER BY u.username COLLATE NOCASE ASC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []BlockedUser
+	for rows.Next() {
+		var u BlockedUser
+		if err := rows.Scan(&u.ID, &u.Username, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
@@ -0,0 +1,57 @@
+package models
+
+import "testing"
+
+func TestToggleBlock(t *testing.T) {
+	db, cleanup, userID := setupPostTestDB(t)
+	defer cleanup()
+
+	otherID, err := CreateUser(db, "otheruser", "other@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	blocked, err := ToggleBlock(db, userID, otherID)
+	if err != nil || !blocked {
+		t.Fatalf("Expected user to be blocked, got %v (%v)", blocked, err)
+	}
+	users, err := GetBlockedUsers(db, userID)
+	if err != nil {
+		t.Fatalf("Failed to get blocked users: %v", err)
+	}
+	if len(users) != 1 || users[0].Username != "otheruser" {
+		t.Errorf("Expected otheruser to be blocked, got %+v", users)
+	}
+
+	// Blocked users cannot notify
+	if err := FollowTarget(db, userID, FollowUser, otherID); err != nil {
+		t.Fatalf("Failed to follow user: %v", err)
+	}
+	postID, err := CreatePost(db, "Test Post", "Test content", otherID, []int64{1})
+	if err != nil {
+		t.Fatalf("Failed to create post: %v", err)
+	}
+	if err := NotifyNewPost(db, postID, otherID); err != nil {
+		t.Fatalf("Failed to notify: %v", err)
+	}
+	if n, _ := CountUnreadNotifications(db, userID); n != 0 {
+		t.Errorf("Expected no notifications from a blocked user, got %d", n)
+	}
+
+	// Test unblocking
+	blocked, err = ToggleBlock(db, userID, otherID)
+	if err != nil || blocked {
+		t.Fatalf("Expected user to be unblocked, got %v (%v)", blocked, err)
+	}
+	if blocked, _ := IsBlocked(db, userID, otherID); blocked {
+		t.Error("Expected user not to be blocked")
+	}
+
+	// Test invalid targets
+	if _, err := ToggleBlock(db, userID, userID); err == nil || err.Error() != "you cannot block yourself" {
+		t.Errorf("Expected 'you cannot block yourself', got %v", err)
+	}
+	if _, err := ToggleBlock(db, userID, 9999); err == nil || err.Error() != "user not found" {
+		t.Errorf("Expected 'user not found', got %v", err)
+	}
+}
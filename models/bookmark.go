@@ -0,0 +1,338 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Things that can be bookmarked
+const (
+	BookmarkPost    = "post"
+	BookmarkComment = "comment"
+)
+
+// Orders for listing bookmarks
+const (
+	BookmarkSortNewest = "newest" // most recently saved first
+	BookmarkSortOldest = "oldest" // first saved first
+	BookmarkSortPosted = "posted" // most recently written first
+)
+
+// MaxBookmarkFolderName is the longest folder name accepted
+const MaxBookmarkFolderName = 50
+
+var bookmarkOrders = map[string]string{
+	BookmarkSortNewest: "b.created_at DESC, b.id DESC",
+	BookmarkSortOldest: "b.created_at ASC, b.id ASC",
+	BookmarkSortPosted: "COALESCE(c.created_at, p.created_at) DESC, b.id DESC",
+}
+
+// Bookmark is a privately saved post or comment
+type Bookmark struct {
+	ID              int64
+	TargetType      string
+	TargetID        int64
+	FolderID        int64 // 0 when the bookmark is not in a folder
+	FolderName      string
+	CreatedAt       time.Time
+	PostID          int64
+	PostTitle       string
+	Content         string // the comment for comment bookmarks
+	Author          string
+	TargetCreatedAt time.Time
+}
+
+type BookmarkFolder struct {
+	ID        int64
+	Name      string
+	Count     int
+	CreatedAt time.Time
+}
+
+// ToggleBookmark saves a post or comment for a user, or removes the bookmark when it
+// already exists. It reports whether the target is bookmarked afterwards.
+func ToggleBookmark(db *sql.DB, userID int64, targetType string, targetID, folderID int64) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		"DELETE FROM bookmarks WHERE user_id = ? AND target_type = ? AND target_id = ?",
+		userID, targetType, targetID,
+	)
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return false, err
+	} else if n > 0 {
+		return false, tx.Commit()
+	}
+
+	if err := checkBookmarkTarget(tx, targetType, targetID); err != nil {
+		return false, err
+	}
+	if err := checkBookmarkFolder(tx, userID, folderID); err != nil {
+		return false, err
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO bookmarks (user_id, target_type, target_id, folder_id) VALUES (?, ?, ?, ?)",
+		userID, targetType, targetID, nullableID(folderID),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// MoveBookmark puts a user's bookmark in a folder, or takes it out of its folder when folderID is 0
+func MoveBookmark(db *sql.DB, userID, bookmarkID, folderID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkBookmarkFolder(tx, userID, folderID); err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(
+		"UPDATE bookmarks SET folder_id = ? WHERE id = ? AND user_id = ?",
+		nullableID(folderID), bookmarkID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("bookmark not found")
+	}
+
+	return tx.Commit()
+}
+
+// GetBookmarks retrieves a page of a user's bookmarks. A folderID of 0 lists all bookmarks.
+// It also returns the total number of bookmarks in the listing.
+func GetBookmarks(db *sql.DB, userID, folderID int64, sort string, limit, offset int) ([]Bookmark, int, error) {
+	order, ok := bookmarkOrders[sort]
+	if !ok {
+		order = bookmarkOrders[BookmarkSortNewest]
+	}
+
+	// Bookmarks whose post or comment is gone are left out
+	from := `
+		FROM bookmarks b
+		LEFT JOIN bookmark_folders f ON f.id = b.folder_id
+		LEFT JOIN comments c ON b.target_type = 'comment' AND c.id = b.target_id
+		JOIN posts p ON p.id = CASE WHEN b.target_type = 'comment' THEN c.post_id ELSE b.target_id END
+		JOIN users u ON u.id = CASE WHEN b.target_type = 'comment' THEN c.user_id ELSE p.user_id END
+		WHERE b.user_id = ? AND (b.target_type = 'post' OR c.id IS NOT NULL)`
+	args := []interface{}{userID}
+	if folderID > 0 {
+		from += " AND b.folder_id = ?"
+		args = append(args, folderID)
+	}
+
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) "+from, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.Query(`
+		SELECT b.id, b.target_type, b.target_id, COALESCE(b.folder_id, 0), COALESCE(f.name, ''), b.created_at,
+			p.id, p.title, COALESCE(c.content, ''), u.username, p.created_at, c.created_at
+		`+from+`
+		ORDER BY `+order+`
+		LIMIT ? OFFSET ?
+	`, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var bookmarks []Bookmark
+	for rows.Next() {
+		var b Bookmark
+		var commentCreatedAt sql.NullTime
+		if err := rows.Scan(
+			&b.ID, &b.TargetType, &b.TargetID, &b.FolderID, &b.FolderName, &b.CreatedAt,
+			&b.PostID, &b.PostTitle, &b.Content, &b.Author, &b.TargetCreatedAt, &commentCreatedAt,
+		); err != nil {
+			return nil, 0, err
+		}
+		if commentCreatedAt.Valid {
+			b.TargetCreatedAt = commentCreatedAt.Time
+		}
+		bookmarks = append(bookmarks, b)
+	}
+	return bookmarks, total, rows.Err()
+}
+
+// GetPostBookmarks reports whether a user bookmarked a post, and which of its comments
+func GetPostBookmarks(db *sql.DB, userID, postID int64) (bool, map[int64]bool, error) {
+	rows, err := db.Query(`
+		SELECT target_type, target_id FROM bookmarks
+		WHERE user_id = ? AND (
+			(target_type = 'post' AND target_id = ?)
+			OR (target_type = 'comment' AND target_id IN (SELECT id FROM comments WHERE post_id = ?))
+		)
+	`, userID, postID, postID)
+	if err != nil {
+		return false, nil, err
+	}
+	defer rows.Close()
+
+	post := false
+	comments := make(map[int64]bool)
+	for rows.Next() {
+		var targetType string
+		var targetID int64
+		if err := rows.Scan(&targetType, &targetID); err != nil {
+			return false, nil, err
+		}
+		if targetType == BookmarkPost {
+			post = true
+		} else {
+			comments[targetID] = true
+		}
+	}
+	return post, comments, rows.Err()
+}
+
+// CreateBookmarkFolder adds a bookmark folder for a user
+func CreateBookmarkFolder(db *sql.DB, userID int64, name string) (int64, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return 0, errors.New("folder name is required")
+	}
+	if len(name) > MaxBookmarkFolderName {
+		return 0, errors.New("folder name is too long")
+	}
+
+	var exists bool
+	err := db.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM bookmark_folders WHERE user_id = ? AND name = ?)",
+		userID, name,
+	).Scan(&exists)
+	if err != nil {
+		return 0, err
+	}
+	if exists {
+		return 0, errors.New("folder already exists")
+	}
+
+	result, err := db.Exec("INSERT INTO bookmark_folders (user_id, name) VALUES (?, ?)", userID, name)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// GetBookmarkFolders retrieves a user's bookmark folders by name, with the number of bookmarks in each
+func GetBookmarkFolders(db *sql.DB, userID int64) ([]BookmarkFolder, error) {
+	rows, err := db.Query(`
+		SELECT f.id, f.name, f.created_at,
+		(SELECT COUNT(*) FROM bookmarks b WHERE b.folder_id = f.id)
+		FROM bookmark_folders f
+		WHERE f.user_id = ?
+		ORDER BY f.name COLLATE NOCASE ASC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var folders []BookmarkFolder
+	for rows.Next() {
+		var f BookmarkFolder
+		if err := rows.Scan(&f.ID, &f.Name, &f.CreatedAt, &f.Count); err != nil {
+			return nil, err
+		}
+		folders = append(folders, f)
+	}
+	return folders, rows.Err()
+}
+
+// DeleteBookmarkFolder removes a user's folder. Its bookmarks are kept outside any folder.
+func DeleteBookmarkFolder(db *sql.DB, userID, folderID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM bookmark_folders WHERE id = ? AND user_id = ?", folderID, userID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("folder not found")
+	}
+
+	if _, err := tx.Exec("UPDATE bookmarks SET folder_id = NULL WHERE folder_id = ?", folderID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func checkBookmarkTarget(tx *sql.Tx, targetType string, targetID int64) error {
+	var query string
+	switch targetType {
+	case BookmarkPost:
+		query = "SELECT EXISTS(SELECT 1 FROM posts WHERE id = ?)"
+	case BookmarkComment:
+		query = "SELECT EXISTS(SELECT 1 FROM comments WHERE id = ?)"
+	default:
+		return errors.New("invalid bookmark type")
+	}
+
+	var exists bool
+	if err := tx.QueryRow(query, targetID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return errors.New(targetType + " not found")
+	}
+	return nil
+}
+
+func checkBookmarkFolder(tx *sql.Tx, userID, folderID int64) error {
+	if folderID == 0 {
+		return nil
+	}
+
+	var exists bool
+	err := tx.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM bookmark_folders WHERE id = ? AND user_id = ?)",
+		folderID, userID,
+	).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errors.New("folder not found")
+	}
+	return nil
+}
+
+// nullableID stores a zero ID as NULL
+func nullableID(id int64) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
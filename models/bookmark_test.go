@@ -0,0 +1,152 @@
+package models
+
+import "testing"
+
+func TestToggleBookmark(t *testing.T) {
+	db, cleanup, userID := setupPostTestDB(t)
+	defer cleanup()
+
+	postID, err := CreatePost(db, "Test Post", "Test content", userID, []int64{1})
+	if err != nil {
+		t.Fatalf("Failed to create post: %v", err)
+	}
+	commentID, err := CreateComment(db, "Test comment", userID, postID)
+	if err != nil {
+		t.Fatalf("Failed to create comment: %v", err)
+	}
+
+	// Test saving a post and a comment
+	saved, err := ToggleBookmark(db, userID, BookmarkPost, postID, 0)
+	if err != nil || !saved {
+		t.Fatalf("Expected post to be saved, got %v (%v)", saved, err)
+	}
+	if _, err := ToggleBookmark(db, userID, BookmarkComment, commentID, 0); err != nil {
+		t.Fatalf("Failed to save comment: %v", err)
+	}
+
+	post, comments, err := GetPostBookmarks(db, userID, postID)
+	if err != nil {
+		t.Fatalf("Failed to get bookmarks: %v", err)
+	}
+	if !post || !comments[commentID] {
+		t.Errorf("Expected post and comment to be bookmarked, got %v and %v", post, comments)
+	}
+
+	// Test toggling the post bookmark off
+	saved, err = ToggleBookmark(db, userID, BookmarkPost, postID, 0)
+	if err != nil || saved {
+		t.Fatalf("Expected bookmark to be removed, got %v (%v)", saved, err)
+	}
+
+	bookmarks, total, err := GetBookmarks(db, userID, 0, BookmarkSortNewest, 10, 0)
+	if err != nil {
+		t.Fatalf("Failed to get bookmarks: %v", err)
+	}
+	if total != 1 || len(bookmarks) != 1 || bookmarks[0].TargetType != BookmarkComment {
+		t.Fatalf("Expected only the comment bookmark, got %d: %+v", total, bookmarks)
+	}
+	if bookmarks[0].PostID != postID || bookmarks[0].Content != "Test comment" || bookmarks[0].Author != "testuser" {
+		t.Errorf("Unexpected comment bookmark details: %+v", bookmarks[0])
+	}
+
+	// Test invalid targets
+	if _, err := ToggleBookmark(db, userID, BookmarkPost, 9999, 0); err == nil || err.Error() != "post not found" {
+		t.Errorf("Expected 'post not found', got %v", err)
+	}
+	if _, err := ToggleBookmark(db, userID, "user", userID, 0); err == nil || err.Error() != "invalid bookmark type" {
+		t.Errorf("Expected 'invalid bookmark type', got %v", err)
+	}
+}
+
+func TestBookmarkFolders(t *testing.T) {
+	db, cleanup, userID := setupPostTestDB(t)
+	defer cleanup()
+
+	otherID, err := CreateUser(db, "otheruser", "other@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	folderID, err := CreateBookmarkFolder(db, userID, " Reading ")
+	if err != nil {
+		t.Fatalf("Failed to create folder: %v", err)
+	}
+	if _, err := CreateBookmarkFolder(db, userID, "Reading"); err == nil || err.Error() != "folder already exists" {
+		t.Errorf("Expected 'folder already exists', got %v", err)
+	}
+
+	var postIDs []int64
+	for _, title := range []string{"First", "Second", "Third"} {
+		postID, err := CreatePost(db, title, "Content", userID, []int64{1})
+		if err != nil {
+			t.Fatalf("Failed to create post: %v", err)
+		}
+		postIDs = append(postIDs, postID)
+	}
+
+	// Test that another user's folder cannot be used
+	if _, err := ToggleBookmark(db, otherID, BookmarkPost, postIDs[0], folderID); err == nil || err.Error() != "folder not found" {
+		t.Errorf("Expected 'folder not found', got %v", err)
+	}
+
+	if _, err := ToggleBookmark(db, userID, BookmarkPost, postIDs[0], folderID); err != nil {
+		t.Fatalf("Failed to save post: %v", err)
+	}
+	for _, postID := range postIDs[1:] {
+		if _, err := ToggleBookmark(db, userID, BookmarkPost, postID, 0); err != nil {
+			t.Fatalf("Failed to save post: %v", err)
+		}
+	}
+
+	inFolder, total, err := GetBookmarks(db, userID, folderID, BookmarkSortNewest, 10, 0)
+	if err != nil {
+		t.Fatalf("Failed to get bookmarks: %v", err)
+	}
+	if total != 1 || inFolder[0].PostTitle != "First" || inFolder[0].FolderName != "Reading" {
+		t.Fatalf("Expected the first post in the folder, got %+v", inFolder)
+	}
+
+	// Test moving a bookmark into the folder
+	all, _, err := GetBookmarks(db, userID, 0, BookmarkSortOldest, 10, 0)
+	if err != nil {
+		t.Fatalf("Failed to get bookmarks: %v", err)
+	}
+	if err := MoveBookmark(db, otherID, all[1].ID, 0); err == nil || err.Error() != "bookmark not found" {
+		t.Errorf("Expected 'bookmark not found', got %v", err)
+	}
+	if err := MoveBookmark(db, userID, all[1].ID, folderID); err != nil {
+		t.Fatalf("Failed to move bookmark: %v", err)
+	}
+
+	folders, err := GetBookmarkFolders(db, userID)
+	if err != nil {
+		t.Fatalf("Failed to get folders: %v", err)
+	}
+	if len(folders) != 1 || folders[0].Count != 2 {
+		t.Errorf("Expected one folder with 2 bookmarks, got %+v", folders)
+	}
+
+	// Test pagination
+	page, total, err := GetBookmarks(db, userID, 0, BookmarkSortOldest, 2, 2)
+	if err != nil {
+		t.Fatalf("Failed to get bookmarks: %v", err)
+	}
+	if total != 3 || len(page) != 1 {
+		t.Errorf("Expected 1 bookmark on the second page of 3, got %d of %d", len(page), total)
+	}
+
+	// Test that deleting a folder keeps its bookmarks
+	if err := DeleteBookmarkFolder(db, otherID, folderID); err == nil || err.Error() != "folder not found" {
+		t.Errorf("Expected 'folder not found', got %v", err)
+	}
+	if err := DeleteBookmarkFolder(db, userID, folderID); err != nil {
+		t.Fatalf("Failed to delete folder: %v", err)
+	}
+	all, total, err = GetBookmarks(db, userID, 0, BookmarkSortNewest, 10, 0)
+	if err != nil {
+		t.Fatalf("Failed to get bookmarks: %v", err)
+	}
+	if total != 3 || all[0].FolderID != 0 {
+		t.Errorf("Expected 3 unfiled bookmarks, got %d", total)
+	}
+}
@@ -0,0 +1,179 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// MaxChatMessageLength is the longest chat message in characters
+const MaxChatMessageLength = 1000
+
+// MaxChatMute is the longest time a user can be muted in a chat room
+const MaxChatMute = 7 * 24 * time.Hour
+
+type ChatMessage struct {
+	ID            int64
+	CategoryID    int64
+	UserID        int64
+	Username      string
+	AvatarVersion int
+	Content       string
+	CreatedAt     time.Time
+}
+
+// CreateChatMessage posts a message in the chat room of a category
+func CreateChatMessage(db *sql.DB, categoryID, userID int64, content string) (*ChatMessage, error) {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return nil, errors.New("message is empty")
+	}
+	if len([]rune(content)) > MaxChatMessageLength {
+		return nil, errors.New("message is too long")
+	}
+
+	var exists bool
+	if err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM categories WHERE id = ?)", categoryID).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.New("category not found")
+	}
+
+	if _, muted, err := ChatMutedUntil(db, categoryID, userID, time.Now()); err != nil {
+		return nil, err
+	} else if muted {
+		return nil, errors.New("you are muted in this chat")
+	}
+
+	result, err := db.Exec(
+		"INSERT INTO chat_messages (category_id, user_id, content, created_at) VALUES (?, ?, ?, ?)",
+		categoryID, userID, content, time.Now().UTC(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	messageID, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return GetChatMessage(db, messageID)
+}
+
+const chatMessageColumns = `m.id, m.category_id, m.user_id, COALESCE(u.username, ?), COALESCE(u.avatar_version, 0), m.content, m.created_at
+	FROM chat_messages m
+	LEFT JOIN users u ON u.id = m.user_id`
+
+// GetChatMessage retrieves a chat message that was not deleted
+func GetChatMessage(db *sql.DB, messageID int64) (*ChatMessage, error) {
+	var m ChatMessage
+	err := db.QueryRow(
+		"SELECT "+chatMessageColumns+" WHERE m.id = ? AND m.deleted_at IS NULL",
+		DeletedUsername, messageID,
+	).Scan(&m.ID, &m.CategoryID, &m.UserID, &m.Username, &m.AvatarVersion, &m.Content, &m.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, errors.New("message not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// GetChatHistory retrieves the latest messages of a chat room, oldest first
+func GetChatHistory(db *sql.DB, categoryID int64, limit int) ([]ChatMessage, error) {
+	rows, err := db.Query(`
+		SELECT * FROM (
+			SELECT `+chatMessageColumns+`
+			WHERE m.category_id = ? AND m.deleted_at IS NULL
+			ORDER BY m.id DESC
+			LIMIT ?
+		) ORDER BY id ASC
+	`, DeletedUsername, categoryID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []ChatMessage{}
+	for rows.Next() {
+		var m ChatMessage
+		if err := rows.Scan(&m.ID, &m.CategoryID, &m.UserID, &m.Username, &m.AvatarVersion, &m.Content, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+// DeleteChatMessage removes a chat message. Authors can delete their own messages
+// and moderators any message. It returns the deleted message.
+func DeleteChatMessage(db *sql.DB, messageID int64, user *User) (*ChatMessage, error) {
+	message, err := GetChatMessage(db, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if message.UserID != user.ID && !user.IsModerator() {
+		return nil, errors.New("you cannot delete this message")
+	}
+
+	_, err = db.Exec(
+		"UPDATE chat_messages SET deleted_at = ?, deleted_by = ? WHERE id = ? AND deleted_at IS NULL",
+		time.Now().UTC(), user.ID, messageID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// MuteChatUser keeps a user from writing in a category's chat room until the given time.
+// Only moderators can mute, and moderators cannot be muted.
+func MuteChatUser(db *sql.DB, categoryID, userID int64, moderator *User, until time.Time) error {
+	if !moderator.IsModerator() {
+		return errors.New("only moderators can mute users")
+	}
+	if until.After(time.Now().Add(MaxChatMute)) {
+		return errors.New("users can be muted for at most a week")
+	}
+
+	target, err := GetUserByID(db, userID)
+	if err != nil {
+		return errors.New("user not found")
+	}
+	if target.IsModerator() {
+		return errors.New("moderators cannot be muted")
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO chat_mutes (category_id, user_id, muted_by, muted_until) VALUES (?, ?, ?, ?)
+		ON CONFLICT(category_id, user_id) DO UPDATE SET muted_by = excluded.muted_by, muted_until = excluded.muted_until
+	`, categoryID, userID, moderator.ID, until.UTC())
+	return err
+}
+
+// UnmuteChatUser lets a muted user write in a category's chat room again
+func UnmuteChatUser(db *sql.DB, categoryID, userID int64, moderator *User) error {
+	if !moderator.IsModerator() {
+		return errors.New("only moderators can mute users")
+	}
+	_, err := db.Exec("DELETE FROM chat_mutes WHERE category_id = ? AND user_id = ?", categoryID, userID)
+	return err
+}
+
+// ChatMutedUntil reports whether a user is muted in a category's chat room at the given time, and until when
+func ChatMutedUntil(db *sql.DB, categoryID, userID int64, now time.Time) (time.Time, bool, error) {
+	var until time.Time
+	err := db.QueryRow(
+		"SELECT muted_until FROM chat_mutes WHERE category_id = ? AND user_id = ?",
+		categoryID, userID,
+	).Scan(&until)
+	if err == sql.ErrNoRows {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return until, until.After(now), nil
+}
@@ -0,0 +1,116 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChatMessages(t *testing.T) {
+	db, cleanup, userID := setupPostTestDB(t)
+	defer cleanup()
+
+	categoryID, err := CreateCategory(db, "General")
+	if err != nil {
+		t.Fatalf("Failed to create category: %v", err)
+	}
+
+	if _, err := CreateChatMessage(db, categoryID, userID, "   "); err == nil || err.Error() != "message is empty" {
+		t.Errorf("Expected message is empty error, got %v", err)
+	}
+	if _, err := CreateChatMessage(db, categoryID, userID, strings.Repeat("a", MaxChatMessageLength+1)); err == nil || err.Error() != "message is too long" {
+		t.Errorf("Expected message is too long error, got %v", err)
+	}
+	if _, err := CreateChatMessage(db, 999, userID, "Hello"); err == nil || err.Error() != "category not found" {
+		t.Errorf("Expected category not found error, got %v", err)
+	}
+
+	for _, content := range []string{"First", "Second", "Third"} {
+		if _, err := CreateChatMessage(db, categoryID, userID, content); err != nil {
+			t.Fatalf("Failed to create message: %v", err)
+		}
+	}
+
+	// The latest messages, oldest first
+	history, err := GetChatHistory(db, categoryID, 2)
+	if err != nil {
+		t.Fatalf("Failed to get history: %v", err)
+	}
+	if len(history) != 2 || history[0].Content != "Second" || history[1].Content != "Third" || history[1].Username != "testuser" {
+		t.Fatalf("Unexpected history %+v", history)
+	}
+
+	// Only the author or a moderator can delete a message
+	otherID, err := CreateUser(db, "otheruser", "other@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	other, _ := GetUserByID(db, otherID)
+	if _, err := DeleteChatMessage(db, history[1].ID, other); err == nil || err.Error() != "you cannot delete this message" {
+		t.Errorf("Expected you cannot delete this message error, got %v", err)
+	}
+	author, _ := GetUserByID(db, userID)
+	if _, err := DeleteChatMessage(db, history[1].ID, author); err != nil {
+		t.Fatalf("Failed to delete message: %v", err)
+	}
+	if _, err := GetChatMessage(db, history[1].ID); err == nil || err.Error() != "message not found" {
+		t.Errorf("Expected the deleted message to be gone, got %v", err)
+	}
+	history, _ = GetChatHistory(db, categoryID, 10)
+	if len(history) != 2 {
+		t.Errorf("Expected 2 messages after deleting one, got %d", len(history))
+	}
+}
+
+func TestChatMutes(t *testing.T) {
+	db, cleanup, userID := setupPostTestDB(t)
+	defer cleanup()
+
+	categoryID, err := CreateCategory(db, "General")
+	if err != nil {
+		t.Fatalf("Failed to create category: %v", err)
+	}
+	moderatorID, err := CreateUser(db, "moderator", "moderator@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if err := SetUserRole(db, moderatorID, RoleModerator); err != nil {
+		t.Fatalf("Failed to set role: %v", err)
+	}
+	moderator, _ := GetUserByID(db, moderatorID)
+	user, _ := GetUserByID(db, userID)
+
+	until := time.Now().Add(10 * time.Minute)
+	if err := MuteChatUser(db, categoryID, moderatorID, user, until); err == nil || err.Error() != "only moderators can mute users" {
+		t.Errorf("Expected only moderators can mute users error, got %v", err)
+	}
+	if err := MuteChatUser(db, categoryID, moderatorID, moderator, until); err == nil || err.Error() != "moderators cannot be muted" {
+		t.Errorf("Expected moderators cannot be muted error, got %v", err)
+	}
+	if err := MuteChatUser(db, categoryID, userID, moderator, time.Now().Add(MaxChatMute+time.Hour)); err == nil {
+		t.Error("Expected an error for a mute longer than a week")
+	}
+
+	if err := MuteChatUser(db, categoryID, userID, moderator, until); err != nil {
+		t.Fatalf("Failed to mute: %v", err)
+	}
+	if _, err := CreateChatMessage(db, categoryID, userID, "Hello"); err == nil || err.Error() != "you are muted in this chat" {
+		t.Errorf("Expected you are muted in this chat error, got %v", err)
+	}
+	if _, muted, _ := ChatMutedUntil(db, categoryID, userID, until.Add(time.Second)); muted {
+		t.Error("Expected the mute to end")
+	}
+
+	// Mutes are per room
+	otherCategoryID, _ := CreateCategory(db, "Other")
+	if _, err := CreateChatMessage(db, otherCategoryID, userID, "Hello"); err != nil {
+		t.Errorf("Expected the user to write in another room, got %v", err)
+	}
+
+	if err := UnmuteChatUser(db, categoryID, userID, moderator); err != nil {
+		t.Fatalf("Failed to unmute: %v", err)
+	}
+	if _, err := CreateChatMessage(db, categoryID, userID, "Hello again"); err != nil {
+		t.Errorf("Expected the user to write after being unmuted, got %v", err)
+	}
+}
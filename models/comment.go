@@ -2,6 +2,8 @@ package models
 
 import (
 	"database/sql"
+	"errors"
+	"html/template"
 	"time"
 )
 
@@ -14,7 +16,11 @@ type Comment struct {
 	CreatedAt    time.Time
 	Likes        int
 	Dislikes     int
-	UserReaction int // 1 for like, -1 for dislike, 0 for none
+	UserReaction  int // 1 for like, -1 for dislike, 0 for none
+	AvatarVersion int
+	Attachments []Attachment
+	ContentHTML template.HTML // rendered Markdown, filled in by handlers
+	Bookmarked  bool          // saved by the current user, filled in by handlers
 }
 
 // CreateComment creates a new comment on a post
@@ -27,7 +33,12 @@ func CreateComment(db *sql.DB, content string, userID, postID int64) (int64, err
 		return 0, err
 	}
 
-	return result.LastInsertId()
+	commentID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	publishNewComment(commentID, postID, userID)
+	return commentID, nil
 }
 
 // GetCommentsByPostID retrieves all comments for a post
@@ -36,7 +47,8 @@ func GetCommentsByPostID(db *sql.DB, postID, currentUserID int64) ([]Comment, er
 		SELECT c.id, c.content, c.user_id, u.username, c.post_id, c.created_at,
 		(SELECT COUNT(*) FROM comment_reactions WHERE comment_id = c.id AND reaction = 1) as likes,
 		(SELECT COUNT(*) FROM comment_reactions WHERE comment_id = c.id AND reaction = -1) as dislikes,
-		COALESCE((SELECT reaction FROM comment_reactions WHERE comment_id = c.id AND user_id = ?), 0) as user_reaction
+		COALESCE((SELECT reaction FROM comment_reactions WHERE comment_id = c.id AND user_id = ?), 0) as user_reaction,
+		u.avatar_version
 		FROM comments c
 		JOIN users u ON c.user_id = u.id
 		WHERE c.post_id = ?
@@ -53,6 +65,7 @@ func GetCommentsByPostID(db *sql.DB, postID, currentUserID int64) ([]Comment, er
 		if err := rows.Scan(
 			&comment.ID, &comment.Content, &comment.UserID, &comment.Username, 
 			&comment.PostID, &comment.CreatedAt, &comment.Likes, &comment.Dislikes, &comment.UserReaction,
+			&comment.AvatarVersion,
 		); err != nil {
 			return nil, err
 		}
@@ -62,6 +75,88 @@ func GetCommentsByPostID(db *sql.DB, postID, currentUserID int64) ([]Comment, er
 	return comments, nil
 }
 
+// GetCommentPage returns a page of a post's comments, oldest first, and how many there are.
+// A non-zero afterID is a cursor: only comments after that one are counted and listed, so a
+// client walking the list does not skip or repeat comments when new ones arrive.
+func GetCommentPage(db *sql.DB, postID, currentUserID, afterID int64, limit, offset int) ([]Comment, int, error) {
+	where := "c.post_id = ?"
+	args := []interface{}{postID}
+	if afterID > 0 {
+		var exists bool
+		err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM comments WHERE id = ? AND post_id = ?)", afterID, postID).Scan(&exists)
+		if err != nil {
+			return nil, 0, err
+		}
+		if !exists {
+			return nil, 0, errors.New("comment not found")
+		}
+		where += " AND (c.created_at, c.id) > (SELECT created_at, id FROM comments WHERE id = ?)"
+		args = append(args, afterID)
+	}
+
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM comments c WHERE "+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.Query(`
+		SELECT c.id, c.content, c.user_id, u.username, c.post_id, c.created_at,
+		(SELECT COUNT(*) FROM comment_reactions WHERE comment_id = c.id AND reaction = 1) as likes,
+		(SELECT COUNT(*) FROM comment_reactions WHERE comment_id = c.id AND reaction = -1) as dislikes,
+		COALESCE((SELECT reaction FROM comment_reactions WHERE comment_id = c.id AND user_id = ?), 0) as user_reaction,
+		u.avatar_version
+		FROM comments c
+		JOIN users u ON c.user_id = u.id
+		WHERE `+where+`
+		ORDER BY c.created_at ASC, c.id ASC
+		LIMIT ? OFFSET ?
+	`, append(append([]interface{}{currentUserID}, args...), limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	comments := []Comment{}
+	for rows.Next() {
+		var comment Comment
+		if err := rows.Scan(
+			&comment.ID, &comment.Content, &comment.UserID, &comment.Username,
+			&comment.PostID, &comment.CreatedAt, &comment.Likes, &comment.Dislikes, &comment.UserReaction,
+			&comment.AvatarVersion,
+		); err != nil {
+			return nil, 0, err
+		}
+		comments = append(comments, comment)
+	}
+	return comments, total, rows.Err()
+}
+
+// GetCommentByID retrieves a comment with its reactions
+func GetCommentByID(db *sql.DB, commentID, currentUserID int64) (*Comment, error) {
+	var comment Comment
+	err := db.QueryRow(`
+		SELECT c.id, c.content, c.user_id, u.username, c.post_id, c.created_at,
+		(SELECT COUNT(*) FROM comment_reactions WHERE comment_id = c.id AND reaction = 1) as likes,
+		(SELECT COUNT(*) FROM comment_reactions WHERE comment_id = c.id AND reaction = -1) as dislikes,
+		COALESCE((SELECT reaction FROM comment_reactions WHERE comment_id = c.id AND user_id = ?), 0) as user_reaction,
+		u.avatar_version
+		FROM comments c
+		JOIN users u ON c.user_id = u.id
+		WHERE c.id = ?
+	`, currentUserID, commentID).Scan(
+		&comment.ID, &comment.Content, &comment.UserID, &comment.Username,
+		&comment.PostID, &comment.CreatedAt, &comment.Likes, &comment.Dislikes, &comment.UserReaction,
+		&comment.AvatarVersion,
+	)
+	if err == sql.ErrNoRows {
+		return nil, errors.New("comment not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &comment, nil
+}
+
 // ReactToComment allows a user to like or dislike a comment
 func ReactToComment(db *sql.DB, commentID, userID int64, reaction int) error {
 	tx, err := db.Begin()
@@ -106,5 +201,19 @@ func ReactToComment(db *sql.DB, commentID, userID int64, reaction int) error {
 		return err
 	}
 
-	return tx.Commit()
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	publishCommentReactions(db, commentID)
+	return nil
+}
+
+// GetCommentPostID returns the post a comment belongs to
+func GetCommentPostID(db *sql.DB, commentID int64) (int64, error) {
+	var postID int64
+	err := db.QueryRow("SELECT post_id FROM comments WHERE id = ?", commentID).Scan(&postID)
+	if err == sql.ErrNoRows {
+		return 0, errors.New("comment not found")
+	}
+	return postID, err
 }
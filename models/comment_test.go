@@ -114,6 +114,41 @@ func TestGetCommentsByPostID(t *testing.T) {
 	}
 }
 
+func TestGetCommentPage(t *testing.T) {
+	db, cleanup, userID, postID := setupCommentTestDB(t)
+	defer cleanup()
+
+	var ids []int64
+	for _, content := range []string{"Comment 1", "Comment 2", "Comment 3"} {
+		id, err := CreateComment(db, content, userID, postID)
+		if err != nil {
+			t.Fatalf("Failed to create comment: %v", err)
+		}
+		ids = append(ids, id)
+	}
+
+	comments, total, err := GetCommentPage(db, postID, userID, 0, 2, 0)
+	if err != nil {
+		t.Fatalf("Failed to get comments: %v", err)
+	}
+	if total != 3 || len(comments) != 2 || comments[0].ID != ids[0] || comments[1].ID != ids[1] {
+		t.Errorf("Unexpected first page: %d comments of %d", len(comments), total)
+	}
+	comments, _, _ = GetCommentPage(db, postID, userID, 0, 2, 2)
+	if len(comments) != 1 || comments[0].ID != ids[2] {
+		t.Errorf("Unexpected second page: %+v", comments)
+	}
+
+	// The cursor continues after a comment
+	comments, total, err = GetCommentPage(db, postID, userID, ids[0], 10, 0)
+	if err != nil || total != 2 || len(comments) != 2 || comments[0].ID != ids[1] {
+		t.Errorf("Unexpected comments after the first: %d of %d, %v", len(comments), total, err)
+	}
+	if _, _, err := GetCommentPage(db, 9999, userID, ids[0], 10, 0); err == nil || err.Error() != "comment not found" {
+		t.Errorf("Expected comment not found for a comment of another post, got %v", err)
+	}
+}
+
 func TestReactToComment(t *testing.T) {
 	db, cleanup, userID, postID := setupCommentTestDB(t)
 	defer cleanup()
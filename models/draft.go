@@ -0,0 +1,269 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Draft struct {
+	ID          int64
+	UserID      int64
+	Title       string
+	Content     string
+	CategoryIDs []int64
+	Poll        *Poll     // nil unless a poll was added
+	PublishAt   time.Time // zero unless the draft is scheduled
+	LastError   string    // why the scheduler could not publish the draft
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// IsScheduled reports whether the draft is waiting to be published automatically
+func (d Draft) IsScheduled() bool {
+	return !d.PublishAt.IsZero()
+}
+
+// HasCategory reports whether the draft has the category selected
+func (d Draft) HasCategory(categoryID int64) bool {
+	for _, id := range d.CategoryIDs {
+		if id == categoryID {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate returns the problems that prevent the draft from being published
+func (d Draft) Validate() []string {
+	var problems []string
+	if strings.TrimSpace(d.Title) == "" {
+		problems = append(problems, "Title is required")
+	}
+	if strings.TrimSpace(d.Content) == "" {
+		problems = append(problems, "Content is required")
+	}
+	if len(d.CategoryIDs) == 0 {
+		problems = append(problems, "At least one category is required")
+	}
+	if d.Poll != nil {
+		problems = append(problems, d.Poll.Validate()...)
+	}
+	return problems
+}
+
+// PollOptionInputs returns the poll option texts for the editor, with room to add more
+func (d Draft) PollOptionInputs() []string {
+	var inputs []string
+	if d.Poll != nil {
+		for _, option := range d.Poll.Options {
+			inputs = append(inputs, option.Text)
+		}
+	}
+	for len(inputs) < MinPollOptions+2 && len(inputs) < MaxPollOptions {
+		inputs = append(inputs, "")
+	}
+	return inputs
+}
+
+const draftColumns = "id, user_id, title, content, category_ids, poll, publish_at, last_error, created_at, updated_at"
+
+// SaveDraft creates a draft, or updates it when it has an ID. Only the owner can update a draft.
+func SaveDraft(db *sql.DB, d *Draft) error {
+	now := time.Now().UTC()
+	poll, err := encodeDraftPoll(d.Poll)
+	if err != nil {
+		return err
+	}
+	var publishAt interface{}
+	if d.IsScheduled() {
+		publishAt = d.PublishAt.UTC()
+	}
+
+	if d.ID == 0 {
+		result, err := db.Exec(`
+			INSERT INTO drafts (user_id, title, content, category_ids, poll, publish_at, last_error, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, d.UserID, d.Title, d.Content, joinIDs(d.CategoryIDs), poll, publishAt, d.LastError, now, now)
+		if err != nil {
+			return err
+		}
+		d.ID, err = result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		d.CreatedAt = now
+		d.UpdatedAt = now
+		return nil
+	}
+
+	result, err := db.Exec(`
+		UPDATE drafts SET title = ?, content = ?, category_ids = ?, poll = ?, publish_at = ?, last_error = ?, updated_at = ?
+		WHERE id = ? AND user_id = ?
+	`, d.Title, d.Content, joinIDs(d.CategoryIDs), poll, publishAt, d.LastError, now, d.ID, d.UserID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("draft not found")
+	}
+	d.UpdatedAt = now
+	return nil
+}
+
+// GetDraft retrieves one of a user's drafts
+func GetDraft(db *sql.DB, draftID, userID int64) (*Draft, error) {
+	rows, err := db.Query("SELECT "+draftColumns+" FROM drafts WHERE id = ? AND user_id = ?", draftID, userID)
+	if err != nil {
+		return nil, err
+	}
+	drafts, err := scanDrafts(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(drafts) == 0 {
+		return nil, errors.New("draft not found")
+	}
+	return &drafts[0], nil
+}
+
+// GetUserDrafts retrieves a user's drafts, most recently edited first
+func GetUserDrafts(db *sql.DB, userID int64) ([]Draft, error) {
+	rows, err := db.Query("SELECT "+draftColumns+" FROM drafts WHERE user_id = ? ORDER BY updated_at DESC, id DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanDrafts(rows)
+}
+
+// GetDueDrafts retrieves scheduled drafts whose publish time is not after now
+func GetDueDrafts(db *sql.DB, now time.Time) ([]Draft, error) {
+	rows, err := db.Query(
+		"SELECT "+draftColumns+" FROM drafts WHERE publish_at IS NOT NULL AND publish_at <= ? ORDER BY publish_at ASC",
+		now.UTC(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanDrafts(rows)
+}
+
+// DeleteDraft removes a user's draft. Its uploads become unattached and are cleaned up later.
+func DeleteDraft(db *sql.DB, draftID, userID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM drafts WHERE id = ? AND user_id = ?", draftID, userID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("draft not found")
+	}
+
+	if _, err := tx.Exec("UPDATE attachments SET draft_id = NULL WHERE draft_id = ?", draftID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// PublishDraft turns a draft into a post, moving its uploads to the post, and deletes the draft
+func PublishDraft(db *sql.DB, d *Draft) (int64, error) {
+	if problems := d.Validate(); len(problems) > 0 {
+		return 0, errors.New(strings.Join(problems, "; "))
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM drafts WHERE id = ? AND user_id = ?", d.ID, d.UserID)
+	if err != nil {
+		return 0, err
+	}
+	// A draft deleted or published meanwhile must not be published again
+	if n, err := result.RowsAffected(); err != nil {
+		return 0, err
+	} else if n == 0 {
+		return 0, errors.New("draft not found")
+	}
+
+	postID, err := insertPost(tx, d.Title, d.Content, d.UserID, d.CategoryIDs, d.Poll)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(
+		"UPDATE attachments SET post_id = ?, draft_id = NULL WHERE draft_id = ? AND post_id IS NULL",
+		postID, d.ID,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	publishNewPost(postID, d.Title, d.CategoryIDs)
+	return postID, nil
+}
+
+func scanDrafts(rows *sql.Rows) ([]Draft, error) {
+	defer rows.Close()
+
+	var drafts []Draft
+	for rows.Next() {
+		var d Draft
+		var categoryIDs, poll string
+		var publishAt sql.NullTime
+		if err := rows.Scan(
+			&d.ID, &d.UserID, &d.Title, &d.Content, &categoryIDs, &poll, &publishAt, &d.LastError, &d.CreatedAt, &d.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		d.CategoryIDs = splitIDs(categoryIDs)
+		var err error
+		if d.Poll, err = decodeDraftPoll(poll); err != nil {
+			return nil, err
+		}
+		if publishAt.Valid {
+			d.PublishAt = publishAt.Time
+		}
+		drafts = append(drafts, d)
+	}
+	return drafts, rows.Err()
+}
+
+// joinIDs stores a list of IDs as a comma-separated string
+func joinIDs(ids []int64) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.FormatInt(id, 10)
+	}
+	return strings.Join(parts, ",")
+}
+
+func splitIDs(s string) []int64 {
+	var ids []int64
+	for _, part := range strings.Split(s, ",") {
+		if id, err := strconv.ParseInt(part, 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,179 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSaveDraft(t *testing.T) {
+	db, cleanup, userID := setupPostTestDB(t)
+	defer cleanup()
+
+	otherID, err := CreateUser(db, "otheruser", "other@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	draft := &Draft{UserID: userID, Title: "Draft", Content: "Some content", CategoryIDs: []int64{1, 2}}
+	if err := SaveDraft(db, draft); err != nil {
+		t.Fatalf("Failed to save draft: %v", err)
+	}
+	if draft.ID == 0 {
+		t.Fatal("Expected draft to get an ID")
+	}
+
+	// Test updating the draft
+	draft.Title = "Updated draft"
+	if err := SaveDraft(db, draft); err != nil {
+		t.Fatalf("Failed to update draft: %v", err)
+	}
+
+	got, err := GetDraft(db, draft.ID, userID)
+	if err != nil {
+		t.Fatalf("Failed to get draft: %v", err)
+	}
+	if got.Title != "Updated draft" {
+		t.Errorf("Expected title 'Updated draft', got '%s'", got.Title)
+	}
+	if len(got.CategoryIDs) != 2 || !got.HasCategory(2) {
+		t.Errorf("Expected categories [1 2], got %v", got.CategoryIDs)
+	}
+	if got.IsScheduled() {
+		t.Error("Expected draft not to be scheduled")
+	}
+
+	// Test that another user can neither read nor overwrite the draft
+	if _, err := GetDraft(db, draft.ID, otherID); err == nil || err.Error() != "draft not found" {
+		t.Errorf("Expected 'draft not found', got %v", err)
+	}
+	stolen := &Draft{ID: draft.ID, UserID: otherID, Title: "Mine now"}
+	if err := SaveDraft(db, stolen); err == nil || err.Error() != "draft not found" {
+		t.Errorf("Expected 'draft not found', got %v", err)
+	}
+
+	// Test that drafts are not listed as posts
+	posts, err := GetPosts(db, userID, 0, 0, false)
+	if err != nil {
+		t.Fatalf("Failed to get posts: %v", err)
+	}
+	if len(posts) != 0 {
+		t.Errorf("Expected no posts, got %d", len(posts))
+	}
+}
+
+func TestGetDueDrafts(t *testing.T) {
+	db, cleanup, userID := setupPostTestDB(t)
+	defer cleanup()
+
+	now := time.Now()
+	drafts := []*Draft{
+		{UserID: userID, Title: "Due", Content: "c", PublishAt: now.Add(-time.Minute)},
+		{UserID: userID, Title: "Later", Content: "c", PublishAt: now.Add(time.Hour)},
+		{UserID: userID, Title: "Unscheduled", Content: "c"},
+	}
+	for _, d := range drafts {
+		if err := SaveDraft(db, d); err != nil {
+			t.Fatalf("Failed to save draft: %v", err)
+		}
+	}
+
+	due, err := GetDueDrafts(db, now)
+	if err != nil {
+		t.Fatalf("Failed to get due drafts: %v", err)
+	}
+	if len(due) != 1 || due[0].Title != "Due" {
+		t.Fatalf("Expected only the due draft, got %v", due)
+	}
+	if !due[0].PublishAt.Equal(drafts[0].PublishAt) {
+		t.Errorf("Expected publish time %v, got %v", drafts[0].PublishAt, due[0].PublishAt)
+	}
+}
+
+func TestPublishDraft(t *testing.T) {
+	db, cleanup, userID := setupPostTestDB(t)
+	defer cleanup()
+
+	// Test that an incomplete draft cannot be published
+	incomplete := &Draft{UserID: userID, Title: "No content"}
+	if err := SaveDraft(db, incomplete); err != nil {
+		t.Fatalf("Failed to save draft: %v", err)
+	}
+	if _, err := PublishDraft(db, incomplete); err == nil {
+		t.Error("Expected error publishing a draft without content")
+	}
+
+	draft := &Draft{UserID: userID, Title: "Draft", Content: "Content", CategoryIDs: []int64{1}}
+	if err := SaveDraft(db, draft); err != nil {
+		t.Fatalf("Failed to save draft: %v", err)
+	}
+
+	a := &Attachment{Token: "draft-token", UserID: userID, Filename: "photo.png", ContentType: "image/png", Size: 100}
+	if _, err := CreateAttachment(db, a); err != nil {
+		t.Fatalf("Failed to create attachment: %v", err)
+	}
+	if n, err := AttachToDraft(db, []string{"draft-token"}, userID, draft.ID); err != nil || n != 1 {
+		t.Fatalf("Expected 1 attachment kept with the draft, got %d (%v)", n, err)
+	}
+
+	postID, err := PublishDraft(db, draft)
+	if err != nil {
+		t.Fatalf("Failed to publish draft: %v", err)
+	}
+
+	post, err := GetPostByID(db, postID, userID)
+	if err != nil {
+		t.Fatalf("Failed to get post: %v", err)
+	}
+	if post.Title != "Draft" {
+		t.Errorf("Expected title 'Draft', got '%s'", post.Title)
+	}
+
+	attachments, err := GetPostAttachments(db, postID)
+	if err != nil {
+		t.Fatalf("Failed to get attachments: %v", err)
+	}
+	if len(attachments) != 1 {
+		t.Errorf("Expected the draft's attachment on the post, got %d", len(attachments))
+	}
+
+	// Test that the draft is gone and cannot be published twice
+	if _, err := GetDraft(db, draft.ID, userID); err == nil {
+		t.Error("Expected draft to be deleted after publishing")
+	}
+	if _, err := PublishDraft(db, draft); err == nil || err.Error() != "draft not found" {
+		t.Errorf("Expected 'draft not found', got %v", err)
+	}
+}
+
+func TestDeleteDraft(t *testing.T) {
+	db, cleanup, userID := setupPostTestDB(t)
+	defer cleanup()
+
+	draft := &Draft{UserID: userID, Title: "Draft"}
+	if err := SaveDraft(db, draft); err != nil {
+		t.Fatalf("Failed to save draft: %v", err)
+	}
+
+	a := &Attachment{Token: "draft-token", UserID: userID, Filename: "notes.txt", ContentType: "text/plain", Size: 10}
+	if _, err := CreateAttachment(db, a); err != nil {
+		t.Fatalf("Failed to create attachment: %v", err)
+	}
+	if _, err := AttachToDraft(db, []string{"draft-token"}, userID, draft.ID); err != nil {
+		t.Fatalf("Failed to attach: %v", err)
+	}
+
+	if err := DeleteDraft(db, draft.ID, userID); err != nil {
+		t.Fatalf("Failed to delete draft: %v", err)
+	}
+	if err := DeleteDraft(db, draft.ID, userID); err == nil || err.Error() != "draft not found" {
+		t.Errorf("Expected 'draft not found', got %v", err)
+	}
+
+	attachments, err := GetDraftAttachments(db, draft.ID)
+	if err != nil {
+		t.Fatalf("Failed to get attachments: %v", err)
+	}
+	if len(attachments) != 0 {
+		t.Errorf("Expected uploads to be released from the deleted draft, got %d", len(attachments))
+	}
+}
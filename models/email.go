@@ -0,0 +1,327 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Digest frequencies
+const (
+	DigestOff    = "off"
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// Email lists a user can unsubscribe from. EmailListAll turns every list off.
+const (
+	EmailListReplies  = "replies"
+	EmailListMentions = "mentions"
+	EmailListDigest   = "digest"
+	EmailListAll      = "all"
+)
+
+// MaxDigestNotifications is the largest number of notifications listed in one digest
+const MaxDigestNotifications = 50
+
+// OutboxMaxAttempts is how many times an email is tried before it is given up
+var OutboxMaxAttempts = 5
+
+// OutboxRetryDelay is the wait after the first failed attempt. It grows fivefold with every further attempt.
+var OutboxRetryDelay = time.Minute
+
+// EmailPreferences are the emails a user wants to receive
+type EmailPreferences struct {
+	Replies  bool   // replies to the user's posts and comments
+	Mentions bool   // mentions with @username
+	Digest   string // DigestOff, DigestDaily or DigestWeekly
+}
+
+// OutboxEmail is a rendered email waiting to be sent
+type OutboxEmail struct {
+	ID             int64
+	UserID         int64
+	To             string
+	Subject        string
+	Text           string
+	HTML           string
+	UnsubscribeURL string
+	Attempts       int
+	LastError      string
+	NextAttemptAt  time.Time
+	CreatedAt      time.Time
+}
+
+// DigestRecipient is a user whose digest is due
+type DigestRecipient struct {
+	UserID    int64
+	Username  string
+	Email     string
+	Frequency string
+	Since     time.Time // activity after this time goes into the digest
+}
+
+// DigestPeriod is the time between two digests of the given frequency, or 0 for DigestOff
+func DigestPeriod(frequency string) time.Duration {
+	switch frequency {
+	case DigestDaily:
+		return 24 * time.Hour
+	case DigestWeekly:
+		return 7 * 24 * time.Hour
+	}
+	return 0
+}
+
+// GetEmailPreferences retrieves the emails a user wants to receive
+func GetEmailPreferences(db *sql.DB, userID int64) (*EmailPreferences, error) {
+	var prefs EmailPreferences
+	err := db.QueryRow(
+		"SELECT email_replies, email_mentions, email_digest FROM users WHERE id = ?",
+		userID,
+	).Scan(&prefs.Replies, &prefs.Mentions, &prefs.Digest)
+	if err == sql.ErrNoRows {
+		return nil, errors.New("user not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &prefs, nil
+}
+
+// UpdateEmailPreferences changes the emails a user receives. A digest that is
+// switched on starts with activity from now on.
+func UpdateEmailPreferences(db *sql.DB, userID int64, prefs EmailPreferences) error {
+	if prefs.Digest != DigestOff && DigestPeriod(prefs.Digest) == 0 {
+		return errors.New("invalid digest frequency")
+	}
+
+	_, err := db.Exec(`
+		UPDATE users SET email_replies = ?, email_mentions = ?,
+			last_digest_at = CASE WHEN email_digest = 'off' AND ? != 'off' THEN ? ELSE last_digest_at END,
+			email_digest = ?
+		WHERE id = ?
+	`, prefs.Replies, prefs.Mentions, prefs.Digest, time.Now().UTC(), prefs.Digest, userID)
+	return err
+}
+
+// GetEmailToken returns the token of a user's unsubscribe links, creating it on first use
+func GetEmailToken(db *sql.DB, userID int64) (string, error) {
+	var token sql.NullString
+	err := db.QueryRow("SELECT email_token FROM users WHERE id = ?", userID).Scan(&token)
+	if err == sql.ErrNoRows {
+		return "", errors.New("user not found")
+	}
+	if err != nil {
+		return "", err
+	}
+	if token.Valid && token.String != "" {
+		return token.String, nil
+	}
+
+	// Another request may have set a token in the meantime; keep whichever came first
+	_, err = db.Exec(
+		"UPDATE users SET email_token = ? WHERE id = ? AND (email_token IS NULL OR email_token = '')",
+		uuid.New().String(), userID,
+	)
+	if err != nil {
+		return "", err
+	}
+	err = db.QueryRow("SELECT email_token FROM users WHERE id = ?", userID).Scan(&token)
+	return token.String, err
+}
+
+// Unsubscribe turns off one email list, or all of them, for the user the token belongs to.
+// It returns the user's ID.
+func Unsubscribe(db *sql.DB, token, list string) (int64, error) {
+	var query string
+	switch list {
+	case EmailListReplies:
+		query = "UPDATE users SET email_replies = 0 WHERE email_token = ?"
+	case EmailListMentions:
+		query = "UPDATE users SET email_mentions = 0 WHERE email_token = ?"
+	case EmailListDigest:
+		query = "UPDATE users SET email_digest = 'off' WHERE email_token = ?"
+	case EmailListAll:
+		query = "UPDATE users SET email_replies = 0, email_mentions = 0, email_digest = 'off' WHERE email_token = ?"
+	default:
+		return 0, errors.New("invalid email list")
+	}
+
+	var userID int64
+	if token != "" {
+		err := db.QueryRow("SELECT id FROM users WHERE email_token = ?", token).Scan(&userID)
+		if err != nil && err != sql.ErrNoRows {
+			return 0, err
+		}
+	}
+	if userID == 0 {
+		return 0, errors.New("invalid unsubscribe link")
+	}
+
+	_, err := db.Exec(query, token)
+	return userID, err
+}
+
+// QueueEmail adds an email to the outbox to be sent right away
+func QueueEmail(db *sql.DB, email *OutboxEmail) error {
+	result, err := db.Exec(`
+		INSERT INTO email_outbox (user_id, to_address, subject, text_body, html_body, unsubscribe_url, next_attempt_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, email.UserID, email.To, email.Subject, email.Text, email.HTML, email.UnsubscribeURL, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	email.ID, err = result.LastInsertId()
+	return err
+}
+
+// GetDueEmails retrieves the oldest unsent emails whose next attempt is due
+func GetDueEmails(db *sql.DB, now time.Time, limit int) ([]OutboxEmail, error) {
+	rows, err := db.Query(`
+		SELECT id, user_id, to_address, subject, text_body, html_body, unsubscribe_url, attempts, last_error, next_attempt_at, created_at
+		FROM email_outbox
+		WHERE sent_at IS NULL AND failed_at IS NULL AND next_attempt_at <= ?
+		ORDER BY next_attempt_at ASC, id ASC
+		LIMIT ?
+	`, now.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var emails []OutboxEmail
+	for rows.Next() {
+		var e OutboxEmail
+		if err := rows.Scan(
+			&e.ID, &e.UserID, &e.To, &e.Subject, &e.Text, &e.HTML, &e.UnsubscribeURL,
+			&e.Attempts, &e.LastError, &e.NextAttemptAt, &e.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		emails = append(emails, e)
+	}
+	return emails, rows.Err()
+}
+
+// MarkEmailSent records that an outbox email was delivered
+func MarkEmailSent(db *sql.DB, emailID int64, now time.Time) error {
+	_, err := db.Exec("UPDATE email_outbox SET sent_at = ?, attempts = attempts + 1 WHERE id = ?", now.UTC(), emailID)
+	return err
+}
+
+// MarkEmailFailed records a failed attempt to send an outbox email and schedules
+// the next one. After OutboxMaxAttempts the email is given up. It reports whether it was.
+func MarkEmailFailed(db *sql.DB, email *OutboxEmail, sendErr error, now time.Time) (bool, error) {
+	attempts := email.Attempts + 1
+	if attempts >= OutboxMaxAttempts {
+		_, err := db.Exec(
+			"UPDATE email_outbox SET attempts = ?, last_error = ?, failed_at = ? WHERE id = ?",
+			attempts, sendErr.Error(), now.UTC(), email.ID,
+		)
+		return true, err
+	}
+
+	delay := OutboxRetryDelay
+	for i := 1; i < attempts; i++ {
+		delay *= 5
+	}
+	_, err := db.Exec(
+		"UPDATE email_outbox SET attempts = ?, last_error = ?, next_attempt_at = ? WHERE id = ?",
+		attempts, sendErr.Error(), now.Add(delay).UTC(), email.ID,
+	)
+	return false, err
+}
+
+// CleanOutbox deletes emails that were sent or given up before the given time
+func CleanOutbox(db *sql.DB, before time.Time) (int64, error) {
+	result, err := db.Exec(
+		"DELETE FROM email_outbox WHERE (sent_at IS NOT NULL AND sent_at < ?) OR (failed_at IS NOT NULL AND failed_at < ?)",
+		before.UTC(), before.UTC(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetPendingNotificationEmails retrieves the unread notifications not yet considered for an
+// immediate email whose recipients want one: mentions, replies, and comments on their own posts.
+// It also returns the newest pending ID to pass to ClearPendingNotificationEmails.
+func GetPendingNotificationEmails(db *sql.DB) ([]Notification, int64, error) {
+	var upTo sql.NullInt64
+	if err := db.QueryRow("SELECT MAX(id) FROM notifications WHERE email_pending = 1").Scan(&upTo); err != nil {
+		return nil, 0, err
+	}
+	if !upTo.Valid {
+		return nil, 0, nil
+	}
+
+	notifications, err := queryNotifications(db, `
+		JOIN users r ON r.id = n.user_id
+		WHERE n.email_pending = 1 AND n.id <= ? AND n.read_at IS NULL AND (
+			(n.type = ? AND r.email_mentions = 1)
+			OR (n.type = ? AND r.email_replies = 1)
+			OR (n.type = ? AND r.email_replies = 1 AND p.user_id = n.user_id)
+		)
+		ORDER BY n.id ASC
+	`, upTo.Int64, NotifyMention, NotifyReply, NotifyComment)
+	return notifications, upTo.Int64, err
+}
+
+// ClearPendingNotificationEmails marks notifications up to the given ID as considered for an email
+func ClearPendingNotificationEmails(db *sql.DB, upTo int64) error {
+	_, err := db.Exec("UPDATE notifications SET email_pending = 0 WHERE email_pending = 1 AND id <= ?", upTo)
+	return err
+}
+
+// GetDueDigests retrieves the users whose daily or weekly digest is due
+func GetDueDigests(db *sql.DB, now time.Time) ([]DigestRecipient, error) {
+	rows, err := db.Query(`
+		SELECT id, username, email, email_digest, last_digest_at FROM users
+		WHERE email_digest != 'off' AND username != ?
+	`, DeletedUsername)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recipients []DigestRecipient
+	for rows.Next() {
+		var r DigestRecipient
+		var last sql.NullTime
+		if err := rows.Scan(&r.UserID, &r.Username, &r.Email, &r.Frequency, &last); err != nil {
+			return nil, err
+		}
+		period := DigestPeriod(r.Frequency)
+		if period == 0 {
+			continue
+		}
+		// Users who never had a digest get one about the last period
+		r.Since = now.Add(-period)
+		if last.Valid {
+			r.Since = last.Time
+		}
+		if !now.Before(r.Since.Add(period)) {
+			recipients = append(recipients, r)
+		}
+	}
+	return recipients, rows.Err()
+}
+
+// GetDigestNotifications retrieves a user's notifications about activity in followed
+// categories and posts since the given time, oldest first
+func GetDigestNotifications(db *sql.DB, userID int64, since time.Time) ([]Notification, error) {
+	return queryNotifications(db, `
+		WHERE n.user_id = ? AND n.created_at > ? AND n.type IN (?, ?, ?)
+		ORDER BY n.created_at ASC, n.id ASC
+		LIMIT ?
+	`, userID, since.UTC(), NotifyComment, NotifyReply, NotifyPost, MaxDigestNotifications)
+}
+
+// MarkDigestSent records when a user's digest was last sent
+func MarkDigestSent(db *sql.DB, userID int64, at time.Time) error {
+	_, err := db.Exec("UPDATE users SET last_digest_at = ? WHERE id = ?", at.UTC(), userID)
+	return err
+}
@@ -0,0 +1,231 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEmailPreferences(t *testing.T) {
+	db, cleanup, userID := setupPostTestDB(t)
+	defer cleanup()
+
+	prefs, err := GetEmailPreferences(db, userID)
+	if err != nil {
+		t.Fatalf("Failed to get preferences: %v", err)
+	}
+	if !prefs.Replies || !prefs.Mentions || prefs.Digest != DigestOff {
+		t.Fatalf("Unexpected default preferences %+v", prefs)
+	}
+
+	if err := UpdateEmailPreferences(db, userID, EmailPreferences{Digest: "hourly"}); err == nil || err.Error() != "invalid digest frequency" {
+		t.Errorf("Expected invalid digest frequency error, got %v", err)
+	}
+	if err := UpdateEmailPreferences(db, userID, EmailPreferences{Mentions: true, Digest: DigestWeekly}); err != nil {
+		t.Fatalf("Failed to update preferences: %v", err)
+	}
+	prefs, _ = GetEmailPreferences(db, userID)
+	if prefs.Replies || !prefs.Mentions || prefs.Digest != DigestWeekly {
+		t.Errorf("Preferences not saved: %+v", prefs)
+	}
+
+	// A digest that was just switched on is not due yet
+	due, err := GetDueDigests(db, time.Now())
+	if err != nil {
+		t.Fatalf("Failed to get due digests: %v", err)
+	}
+	if len(due) != 0 {
+		t.Errorf("Expected no due digests, got %+v", due)
+	}
+	due, _ = GetDueDigests(db, time.Now().Add(8*24*time.Hour))
+	if len(due) != 1 || due[0].UserID != userID {
+		t.Errorf("Expected the weekly digest to be due, got %+v", due)
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	db, cleanup, userID := setupPostTestDB(t)
+	defer cleanup()
+
+	token, err := GetEmailToken(db, userID)
+	if err != nil || token == "" {
+		t.Fatalf("Failed to get email token: %q %v", token, err)
+	}
+	if again, _ := GetEmailToken(db, userID); again != token {
+		t.Errorf("Expected the same token, got %q and %q", token, again)
+	}
+
+	if _, err := Unsubscribe(db, "wrong", EmailListReplies); err == nil || err.Error() != "invalid unsubscribe link" {
+		t.Errorf("Expected invalid unsubscribe link error, got %v", err)
+	}
+	if _, err := Unsubscribe(db, token, "spam"); err == nil || err.Error() != "invalid email list" {
+		t.Errorf("Expected invalid email list error, got %v", err)
+	}
+
+	if _, err := Unsubscribe(db, token, EmailListMentions); err != nil {
+		t.Fatalf("Failed to unsubscribe: %v", err)
+	}
+	prefs, _ := GetEmailPreferences(db, userID)
+	if !prefs.Replies || prefs.Mentions {
+		t.Errorf("Expected only mention emails off, got %+v", prefs)
+	}
+
+	UpdateEmailPreferences(db, userID, EmailPreferences{Replies: true, Mentions: true, Digest: DigestDaily})
+	if id, err := Unsubscribe(db, token, EmailListAll); err != nil || id != userID {
+		t.Fatalf("Failed to unsubscribe: %d %v", id, err)
+	}
+	prefs, _ = GetEmailPreferences(db, userID)
+	if prefs.Replies || prefs.Mentions || prefs.Digest != DigestOff {
+		t.Errorf("Expected all emails off, got %+v", prefs)
+	}
+}
+
+func TestOutboxRetries(t *testing.T) {
+	db, cleanup, userID := setupPostTestDB(t)
+	defer cleanup()
+
+	email := &OutboxEmail{UserID: userID, To: "test@example.com", Subject: "Hello", Text: "Hi", HTML: "<p>Hi</p>"}
+	if err := QueueEmail(db, email); err != nil {
+		t.Fatalf("Failed to queue email: %v", err)
+	}
+
+	now := time.Now()
+	due, err := GetDueEmails(db, now, 10)
+	if err != nil {
+		t.Fatalf("Failed to get due emails: %v", err)
+	}
+	if len(due) != 1 || due[0].Subject != "Hello" {
+		t.Fatalf("Expected the queued email, got %+v", due)
+	}
+
+	// A failed email waits before the next attempt
+	gaveUp, err := MarkEmailFailed(db, &due[0], errors.New("connection refused"), now)
+	if err != nil || gaveUp {
+		t.Fatalf("Unexpected result of a first failure: %v %v", gaveUp, err)
+	}
+	if due, _ = GetDueEmails(db, now, 10); len(due) != 0 {
+		t.Errorf("Expected no due emails right after a failure, got %d", len(due))
+	}
+	due, _ = GetDueEmails(db, now.Add(OutboxRetryDelay), 10)
+	if len(due) != 1 || due[0].Attempts != 1 || due[0].LastError != "connection refused" {
+		t.Fatalf("Expected the email to be retried, got %+v", due)
+	}
+
+	// It is given up after the last attempt
+	due[0].Attempts = OutboxMaxAttempts - 1
+	gaveUp, err = MarkEmailFailed(db, &due[0], errors.New("connection refused"), now)
+	if err != nil || !gaveUp {
+		t.Fatalf("Expected the email to be given up: %v %v", gaveUp, err)
+	}
+	if due, _ = GetDueEmails(db, now.Add(24*time.Hour), 10); len(due) != 0 {
+		t.Errorf("Expected no due emails after giving up, got %d", len(due))
+	}
+
+	// Sent emails are not sent again
+	other := &OutboxEmail{UserID: userID, To: "test@example.com", Subject: "Again"}
+	QueueEmail(db, other)
+	if err := MarkEmailSent(db, other.ID, now); err != nil {
+		t.Fatalf("Failed to mark email sent: %v", err)
+	}
+	if due, _ = GetDueEmails(db, now.Add(time.Hour), 10); len(due) != 0 {
+		t.Errorf("Expected no due emails, got %d", len(due))
+	}
+	if n, err := CleanOutbox(db, now.Add(time.Hour)); err != nil || n != 2 {
+		t.Errorf("Expected 2 cleaned emails, got %d %v", n, err)
+	}
+}
+
+func TestPendingNotificationEmails(t *testing.T) {
+	db, cleanup, userID := setupPostTestDB(t)
+	defer cleanup()
+
+	otherID, err := CreateUser(db, "otheruser", "other@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	postID, err := CreatePost(db, "Test Post", "Test content", userID, []int64{1})
+	if err != nil {
+		t.Fatalf("Failed to create post: %v", err)
+	}
+	FollowTarget(db, userID, FollowPost, postID)
+
+	// A comment on the author's own post is emailed as a reply
+	commentID, err := CreateComment(db, "Hello", otherID, postID)
+	if err != nil {
+		t.Fatalf("Failed to create comment: %v", err)
+	}
+	if err := NotifyNewComment(db, postID, commentID, otherID); err != nil {
+		t.Fatalf("Failed to notify: %v", err)
+	}
+	if err := NotifyMentions(db, postID, commentID, userID, []int64{otherID}); err != nil {
+		t.Fatalf("Failed to notify: %v", err)
+	}
+
+	notifications, upTo, err := GetPendingNotificationEmails(db)
+	if err != nil {
+		t.Fatalf("Failed to get pending emails: %v", err)
+	}
+	if len(notifications) != 2 || notifications[0].UserID != userID || notifications[1].Type != NotifyMention {
+		t.Fatalf("Expected a comment and a mention email, got %+v", notifications)
+	}
+	if err := ClearPendingNotificationEmails(db, upTo); err != nil {
+		t.Fatalf("Failed to clear pending emails: %v", err)
+	}
+	if notifications, _, _ = GetPendingNotificationEmails(db); len(notifications) != 0 {
+		t.Errorf("Expected no pending emails after clearing, got %d", len(notifications))
+	}
+
+	// Nothing is emailed to users who turned the emails off
+	UpdateEmailPreferences(db, otherID, EmailPreferences{Replies: true, Digest: DigestOff})
+	if err := NotifyMentions(db, postID, 0, userID, []int64{otherID}); err != nil {
+		t.Fatalf("Failed to notify: %v", err)
+	}
+	if notifications, _, _ = GetPendingNotificationEmails(db); len(notifications) != 0 {
+		t.Errorf("Expected no email with mention emails off, got %+v", notifications)
+	}
+}
+
+func TestDigestNotifications(t *testing.T) {
+	db, cleanup, userID := setupPostTestDB(t)
+	defer cleanup()
+
+	otherID, err := CreateUser(db, "otheruser", "other@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	categoryID, err := CreateCategory(db, "General")
+	if err != nil {
+		t.Fatalf("Failed to create category: %v", err)
+	}
+	FollowTarget(db, userID, FollowCategory, categoryID)
+
+	since := time.Now().Add(-time.Hour)
+	postID, err := CreatePost(db, "Followed Post", "Content", otherID, []int64{categoryID})
+	if err != nil {
+		t.Fatalf("Failed to create post: %v", err)
+	}
+	if err := NotifyNewPost(db, postID, otherID); err != nil {
+		t.Fatalf("Failed to notify: %v", err)
+	}
+
+	notifications, err := GetDigestNotifications(db, userID, since)
+	if err != nil {
+		t.Fatalf("Failed to get digest: %v", err)
+	}
+	if len(notifications) != 1 || notifications[0].PostTitle != "Followed Post" {
+		t.Fatalf("Expected the new post in the digest, got %+v", notifications)
+	}
+
+	if notifications, _ = GetDigestNotifications(db, userID, time.Now().Add(time.Hour)); len(notifications) != 0 {
+		t.Errorf("Expected nothing newer than the last digest, got %+v", notifications)
+	}
+
+	UpdateEmailPreferences(db, userID, EmailPreferences{Digest: DigestDaily})
+	if err := MarkDigestSent(db, userID, time.Now().Add(-25*time.Hour)); err != nil {
+		t.Fatalf("Failed to mark digest sent: %v", err)
+	}
+	due, _ := GetDueDigests(db, time.Now())
+	if len(due) != 1 || due[0].Frequency != DigestDaily {
+		t.Errorf("Expected the daily digest to be due, got %+v", due)
+	}
+}
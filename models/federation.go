@@ -0,0 +1,697 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"forum/activitypub"
+)
+
+// Owners of federation keys
+const (
+	FederationCategory = "category"
+	FederationUser     = "user"
+)
+
+// FederationMaxAttempts is how many times an activity is sent before it is given up
+var FederationMaxAttempts = 8
+
+// FederationRetryDelay is the wait after the first failed attempt. It doubles with every further attempt.
+var FederationRetryDelay = time.Minute
+
+// FederationKey is the key pair a category or user signs its activities with
+type FederationKey struct {
+	PrivateKey string
+	PublicKey  string
+}
+
+// RemoteActor is a user or group on another server
+type RemoteActor struct {
+	ID          int64
+	URI         string
+	Type        string
+	Username    string
+	Host        string
+	Name        string
+	Inbox       string
+	SharedInbox string
+	KeyID       string
+	PublicKey   string
+	UserID      int64 // local account of the actor's content, 0 until it has some
+	FetchedAt   time.Time
+}
+
+// Handle is the actor's name as other servers show it
+func (a *RemoteActor) Handle() string {
+	return a.Username + "@" + a.Host
+}
+
+// DeliveryInbox is where activities for the actor are sent, the shared inbox of its server if it has one
+func (a *RemoteActor) DeliveryInbox() string {
+	if a.SharedInbox != "" {
+		return a.SharedInbox
+	}
+	return a.Inbox
+}
+
+// CategorySubscription is a remote group a category follows
+type CategorySubscription struct {
+	CategoryID   int64
+	CategoryName string
+	Actor        RemoteActor
+	AcceptedAt   *time.Time // nil while the group has not accepted
+	CreatedAt    time.Time
+}
+
+// FederatedObject is a post or comment received from another server
+type FederatedObject struct {
+	ID         int64
+	URI        string
+	TargetType string // "post" or "comment"
+	TargetID   int64
+	ActorID    int64  // the remote author
+	GroupID    int64  // the remote group it was shared through, 0 if it was sent directly
+	ReplyInbox string // where replies to it are sent
+}
+
+// FederationDelivery is an activity sent, or to be sent, to an inbox on another server
+type FederationDelivery struct {
+	ID            int64
+	SenderType    string
+	SenderID      int64
+	Inbox         string
+	Activity      string
+	Attempts      int
+	LastError     string
+	NextAttemptAt time.Time
+	DeliveredAt   *time.Time
+	FailedAt      *time.Time
+	CreatedAt     time.Time
+}
+
+// GetFederationKey returns the key pair of a category or user, creating it the first time
+func GetFederationKey(db *sql.DB, ownerType string, ownerID int64) (*FederationKey, error) {
+	var key FederationKey
+	err := db.QueryRow(
+		"SELECT private_key, public_key FROM federation_keys WHERE owner_type = ? AND owner_id = ?",
+		ownerType, ownerID,
+	).Scan(&key.PrivateKey, &key.PublicKey)
+	if err != sql.ErrNoRows {
+		return &key, err
+	}
+
+	private, public, err := activitypub.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	// Another request may have created the key meanwhile, in which case its key is kept
+	_, err = db.Exec(
+		"INSERT OR IGNORE INTO federation_keys (owner_type, owner_id, private_key, public_key, created_at) VALUES (?, ?, ?, ?, ?)",
+		ownerType, ownerID, private, public, time.Now().UTC(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return GetFederationKey(db, ownerType, ownerID)
+}
+
+// SaveRemoteActor adds a fetched actor or updates the stored copy, and returns its ID
+func SaveRemoteActor(db *sql.DB, a *RemoteActor) (int64, error) {
+	_, err := db.Exec(`
+		INSERT INTO remote_actors (uri, type, username, host, name, inbox, shared_inbox, key_id, public_key, fetched_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(uri) DO UPDATE SET type = excluded.type, username = excluded.username, host = excluded.host,
+			name = excluded.name, inbox = excluded.inbox, shared_inbox = excluded.shared_inbox,
+			key_id = excluded.key_id, public_key = excluded.public_key, fetched_at = excluded.fetched_at
+	`, a.URI, a.Type, a.Username, a.Host, a.Name, a.Inbox, a.SharedInbox, a.KeyID, a.PublicKey, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err = db.QueryRow("SELECT id FROM remote_actors WHERE uri = ?", a.URI).Scan(&id)
+	return id, err
+}
+
+const remoteActorColumns = `id, uri, type, username, host, name, inbox, shared_inbox, key_id, public_key,
+	COALESCE(user_id, 0), fetched_at FROM remote_actors`
+
+func scanRemoteActor(scanner interface{ Scan(...interface{}) error }) (*RemoteActor, error) {
+	var a RemoteActor
+	err := scanner.Scan(
+		&a.ID, &a.URI, &a.Type, &a.Username, &a.Host, &a.Name, &a.Inbox, &a.SharedInbox,
+		&a.KeyID, &a.PublicKey, &a.UserID, &a.FetchedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func getRemoteActor(db *sql.DB, where string, arg interface{}) (*RemoteActor, error) {
+	a, err := scanRemoteActor(db.QueryRow("SELECT "+remoteActorColumns+" WHERE "+where, arg))
+	if err == sql.ErrNoRows {
+		return nil, errors.New("actor not found")
+	}
+	return a, err
+}
+
+// GetRemoteActor retrieves a stored actor by its ID on its server
+func GetRemoteActor(db *sql.DB, uri string) (*RemoteActor, error) {
+	return getRemoteActor(db, "uri = ?", uri)
+}
+
+// GetRemoteActorByID retrieves a stored actor
+func GetRemoteActorByID(db *sql.DB, actorID int64) (*RemoteActor, error) {
+	return getRemoteActor(db, "id = ?", actorID)
+}
+
+// GetRemoteActorByKey retrieves the stored actor a signing key belongs to
+func GetRemoteActorByKey(db *sql.DB, keyID string) (*RemoteActor, error) {
+	return getRemoteActor(db, "key_id = ?", keyID)
+}
+
+// GetRemoteActorUser returns the local account that a remote actor's posts and comments
+// belong to, creating it the first time. Nobody can log in to it, and it gets no emails.
+func GetRemoteActorUser(db *sql.DB, a *RemoteActor) (int64, error) {
+	if a.UserID != 0 {
+		return a.UserID, nil
+	}
+
+	// Handles are unique, but a local user may already have the same name
+	username := a.Handle()
+	var taken bool
+	if err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE username = ?)", username).Scan(&taken); err != nil {
+		return 0, err
+	}
+	if taken {
+		username = fmt.Sprintf("%s~%d", username, a.ID)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		INSERT INTO users (username, email, password, notify_mentions, email_replies, email_mentions)
+		VALUES (?, ?, '!', 0, 0, 0)
+	`, username, fmt.Sprintf("actor-%d@remote.invalid", a.ID))
+	if err != nil {
+		return 0, err
+	}
+	userID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("UPDATE remote_actors SET user_id = ? WHERE id = ?", userID, a.ID); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	a.UserID = userID
+	return userID, nil
+}
+
+// IsRemoteUser reports whether a local account holds the content of a remote actor
+func IsRemoteUser(db *sql.DB, userID int64) (bool, error) {
+	var remote bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM remote_actors WHERE user_id = ?)", userID).Scan(&remote)
+	return remote, err
+}
+
+// AddCategoryFollower records that a remote actor follows a category, with the ID of its Follow activity
+func AddCategoryFollower(db *sql.DB, categoryID, actorID int64, followURI string) error {
+	_, err := db.Exec(`
+		INSERT INTO category_followers (category_id, actor_id, follow_uri, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(category_id, actor_id) DO UPDATE SET follow_uri = excluded.follow_uri
+	`, categoryID, actorID, followURI, time.Now().UTC())
+	return err
+}
+
+// RemoveCategoryFollower stops a remote actor following a category
+func RemoveCategoryFollower(db *sql.DB, categoryID, actorID int64) error {
+	_, err := db.Exec("DELETE FROM category_followers WHERE category_id = ? AND actor_id = ?", categoryID, actorID)
+	return err
+}
+
+// RemoveCategoryFollow undoes the Follow activity of a remote actor, whichever category it was for
+func RemoveCategoryFollow(db *sql.DB, actorID int64, followURI string) error {
+	_, err := db.Exec("DELETE FROM category_followers WHERE actor_id = ? AND follow_uri = ?", actorID, followURI)
+	return err
+}
+
+// CountCategoryFollowers returns how many remote actors follow each category
+func CountCategoryFollowers(db *sql.DB) (map[int64]int, error) {
+	rows, err := db.Query("SELECT category_id, COUNT(*) FROM category_followers GROUP BY category_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[int64]int{}
+	for rows.Next() {
+		var categoryID int64
+		var count int
+		if err := rows.Scan(&categoryID, &count); err != nil {
+			return nil, err
+		}
+		counts[categoryID] = count
+	}
+	return counts, rows.Err()
+}
+
+// GetCategoryFollowerInboxes returns the inboxes of the remote followers of a category,
+// each server's shared inbox once
+func GetCategoryFollowerInboxes(db *sql.DB, categoryID int64) ([]string, error) {
+	rows, err := db.Query(`
+		SELECT DISTINCT CASE WHEN a.shared_inbox != '' THEN a.shared_inbox ELSE a.inbox END
+		FROM category_followers f JOIN remote_actors a ON a.id = f.actor_id
+		WHERE f.category_id = ?
+	`, categoryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var inboxes []string
+	for rows.Next() {
+		var inbox string
+		if err := rows.Scan(&inbox); err != nil {
+			return nil, err
+		}
+		inboxes = append(inboxes, inbox)
+	}
+	return inboxes, rows.Err()
+}
+
+// GetCategoryGroups returns the remote groups a category follows and that accepted
+func GetCategoryGroups(db *sql.DB, categoryID int64) ([]RemoteActor, error) {
+	rows, err := db.Query(`
+		SELECT `+remoteActorColumns+`
+		WHERE id IN (SELECT actor_id FROM category_subscriptions WHERE category_id = ? AND accepted_at IS NOT NULL)
+		ORDER BY id
+	`, categoryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var actors []RemoteActor
+	for rows.Next() {
+		a, err := scanRemoteActor(rows)
+		if err != nil {
+			return nil, err
+		}
+		actors = append(actors, *a)
+	}
+	return actors, rows.Err()
+}
+
+// AddCategorySubscription makes a category follow a remote group. It waits for the group to accept.
+func AddCategorySubscription(db *sql.DB, categoryID, actorID int64) error {
+	var exists bool
+	err := db.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM category_subscriptions WHERE category_id = ? AND actor_id = ?)",
+		categoryID, actorID,
+	).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return errors.New("already following")
+	}
+
+	_, err = db.Exec(
+		"INSERT INTO category_subscriptions (category_id, actor_id, created_at) VALUES (?, ?, ?)",
+		categoryID, actorID, time.Now().UTC(),
+	)
+	return err
+}
+
+// AcceptCategorySubscription records that a remote group accepted a category's follow
+func AcceptCategorySubscription(db *sql.DB, categoryID, actorID int64) error {
+	result, err := db.Exec(
+		"UPDATE category_subscriptions SET accepted_at = COALESCE(accepted_at, ?) WHERE category_id = ? AND actor_id = ?",
+		time.Now().UTC(), categoryID, actorID,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("subscription not found")
+	}
+	return nil
+}
+
+// RemoveCategorySubscription stops a category following a remote group
+func RemoveCategorySubscription(db *sql.DB, categoryID, actorID int64) error {
+	result, err := db.Exec("DELETE FROM category_subscriptions WHERE category_id = ? AND actor_id = ?", categoryID, actorID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("subscription not found")
+	}
+	return nil
+}
+
+// GetCategorySubscriptions retrieves the remote groups every category follows, by category
+func GetCategorySubscriptions(db *sql.DB) ([]CategorySubscription, error) {
+	rows, err := db.Query(`
+		SELECT s.category_id, c.name, s.accepted_at, s.created_at,
+			a.id, a.uri, a.type, a.username, a.host, a.name, a.inbox
+		FROM category_subscriptions s
+		JOIN categories c ON c.id = s.category_id
+		JOIN remote_actors a ON a.id = s.actor_id
+		ORDER BY c.name ASC, a.username ASC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subscriptions []CategorySubscription
+	for rows.Next() {
+		var s CategorySubscription
+		var acceptedAt sql.NullTime
+		if err := rows.Scan(
+			&s.CategoryID, &s.CategoryName, &acceptedAt, &s.CreatedAt,
+			&s.Actor.ID, &s.Actor.URI, &s.Actor.Type, &s.Actor.Username, &s.Actor.Host, &s.Actor.Name, &s.Actor.Inbox,
+		); err != nil {
+			return nil, err
+		}
+		if acceptedAt.Valid {
+			s.AcceptedAt = &acceptedAt.Time
+		}
+		subscriptions = append(subscriptions, s)
+	}
+	return subscriptions, rows.Err()
+}
+
+// GetSubscribedCategories returns the categories that follow a remote group and that it accepted
+func GetSubscribedCategories(db *sql.DB, actorID int64) ([]int64, error) {
+	rows, err := db.Query(
+		"SELECT category_id FROM category_subscriptions WHERE actor_id = ? AND accepted_at IS NOT NULL ORDER BY category_id",
+		actorID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var categoryIDs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		categoryIDs = append(categoryIDs, id)
+	}
+	return categoryIDs, rows.Err()
+}
+
+// ForgetRemoteActor removes the follows of an actor whose account was deleted. Its posts and comments are kept.
+func ForgetRemoteActor(db *sql.DB, actorID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range []string{
+		"DELETE FROM category_followers WHERE actor_id = ?",
+		"DELETE FROM category_subscriptions WHERE actor_id = ?",
+	} {
+		if _, err := tx.Exec(stmt, actorID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+const federatedObjectColumns = "id, uri, target_type, target_id, actor_id, group_id, reply_inbox FROM federated_objects"
+
+func getFederatedObject(db *sql.DB, where string, args ...interface{}) (*FederatedObject, error) {
+	var o FederatedObject
+	err := db.QueryRow("SELECT "+federatedObjectColumns+" WHERE "+where, args...).Scan(
+		&o.ID, &o.URI, &o.TargetType, &o.TargetID, &o.ActorID, &o.GroupID, &o.ReplyInbox,
+	)
+	if err == sql.ErrNoRows {
+		return nil, errors.New("object not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// GetFederatedObject retrieves a received object by its ID on its server
+func GetFederatedObject(db *sql.DB, uri string) (*FederatedObject, error) {
+	return getFederatedObject(db, "uri = ?", uri)
+}
+
+// GetFederatedObjectFor retrieves the received object a post or comment was made from
+func GetFederatedObjectFor(db *sql.DB, targetType string, targetID int64) (*FederatedObject, error) {
+	return getFederatedObject(db, "target_type = ? AND target_id = ?", targetType, targetID)
+}
+
+// insertFederatedObject records what a received object became, unless it was received before
+func insertFederatedObject(tx *sql.Tx, o *FederatedObject) error {
+	var exists bool
+	if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM federated_objects WHERE uri = ?)", o.URI).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return errors.New("object already received")
+	}
+	_, err := tx.Exec(`
+		INSERT INTO federated_objects (uri, target_type, target_id, actor_id, group_id, reply_inbox, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, o.URI, o.TargetType, o.TargetID, o.ActorID, o.GroupID, o.ReplyInbox, time.Now().UTC())
+	return err
+}
+
+// CreateFederatedPost adds a post received from another server to the given categories
+func CreateFederatedPost(db *sql.DB, o *FederatedObject, title, content string, userID int64, categoryIDs []int64) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	postID, err := insertPost(tx, title, content, userID, categoryIDs, nil)
+	if err != nil {
+		return 0, err
+	}
+	o.TargetType, o.TargetID = "post", postID
+	if err := insertFederatedObject(tx, o); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	publishNewPost(postID, title, categoryIDs)
+	return postID, nil
+}
+
+// CreateFederatedComment adds a comment received from another server to a post
+func CreateFederatedComment(db *sql.DB, o *FederatedObject, content string, userID, postID int64) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("INSERT INTO comments (content, user_id, post_id) VALUES (?, ?, ?)", content, userID, postID)
+	if err != nil {
+		return 0, err
+	}
+	commentID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	o.TargetType, o.TargetID = "comment", commentID
+	if err := insertFederatedObject(tx, o); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	publishNewComment(commentID, postID, userID)
+	return commentID, nil
+}
+
+// DeleteFederatedObject removes a received post or comment after its author deleted it,
+// together with what hangs off it
+func DeleteFederatedObject(db *sql.DB, o *FederatedObject) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var statements []string
+	if o.TargetType == "comment" {
+		statements = []string{
+			"DELETE FROM comment_reactions WHERE comment_id = ?1",
+			"DELETE FROM rendered_content WHERE target_type = 'comment' AND target_id = ?1",
+			"DELETE FROM bookmarks WHERE target_type = 'comment' AND target_id = ?1",
+			"DELETE FROM notifications WHERE comment_id = ?1",
+			"DELETE FROM mentions WHERE target_type = 'comment' AND target_id = ?1",
+			"DELETE FROM comments WHERE id = ?1",
+		}
+	} else {
+		statements = []string{
+			"DELETE FROM comment_reactions WHERE comment_id IN (SELECT id FROM comments WHERE post_id = ?1)",
+			`DELETE FROM rendered_content WHERE (target_type = 'post' AND target_id = ?1)
+				OR (target_type = 'comment' AND target_id IN (SELECT id FROM comments WHERE post_id = ?1))`,
+			`DELETE FROM bookmarks WHERE (target_type = 'post' AND target_id = ?1)
+				OR (target_type = 'comment' AND target_id IN (SELECT id FROM comments WHERE post_id = ?1))`,
+			"DELETE FROM notifications WHERE post_id = ?1",
+			"DELETE FROM mentions WHERE post_id = ?1",
+			"DELETE FROM federated_objects WHERE target_type = 'comment' AND target_id IN (SELECT id FROM comments WHERE post_id = ?1)",
+			"DELETE FROM comments WHERE post_id = ?1",
+			"DELETE FROM follows WHERE target_type = 'post' AND target_id = ?1",
+			"DELETE FROM post_reactions WHERE post_id = ?1",
+			"DELETE FROM post_categories WHERE post_id = ?1",
+			"DELETE FROM posts WHERE id = ?1",
+		}
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt, o.TargetID); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM federated_objects WHERE id = ?", o.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// QueueFederationDelivery queues an activity for each of the inboxes, signed by the given sender
+func QueueFederationDelivery(db *sql.DB, senderType string, senderID int64, inboxes []string, activity []byte) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	queued := map[string]bool{}
+	for _, inbox := range inboxes {
+		if inbox == "" || queued[inbox] {
+			continue
+		}
+		queued[inbox] = true
+		_, err := tx.Exec(`
+			INSERT INTO federation_deliveries (sender_type, sender_id, inbox, activity, next_attempt_at, created_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, senderType, senderID, inbox, string(activity), now, now)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetDueFederationDeliveries retrieves the oldest pending deliveries whose next attempt is due
+func GetDueFederationDeliveries(db *sql.DB, now time.Time, limit int) ([]FederationDelivery, error) {
+	rows, err := db.Query(`
+		SELECT id, sender_type, sender_id, inbox, activity, attempts, last_error, next_attempt_at, created_at
+		FROM federation_deliveries
+		WHERE delivered_at IS NULL AND failed_at IS NULL AND next_attempt_at <= ?
+		ORDER BY next_attempt_at ASC, id ASC
+		LIMIT ?
+	`, now.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []FederationDelivery
+	for rows.Next() {
+		var d FederationDelivery
+		if err := rows.Scan(
+			&d.ID, &d.SenderType, &d.SenderID, &d.Inbox, &d.Activity, &d.Attempts, &d.LastError,
+			&d.NextAttemptAt, &d.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// MarkFederationDelivered records that an inbox accepted a delivery
+func MarkFederationDelivered(db *sql.DB, deliveryID int64, now time.Time) error {
+	_, err := db.Exec(
+		"UPDATE federation_deliveries SET delivered_at = ?, attempts = attempts + 1, last_error = '' WHERE id = ?",
+		now.UTC(), deliveryID,
+	)
+	return err
+}
+
+// MarkFederationFailed records a failed attempt of a delivery and schedules the next one.
+// After FederationMaxAttempts the delivery is given up. It reports whether it was.
+func MarkFederationFailed(db *sql.DB, d *FederationDelivery, sendErr error, now time.Time) (bool, error) {
+	attempts := d.Attempts + 1
+	if attempts >= FederationMaxAttempts {
+		_, err := db.Exec(
+			"UPDATE federation_deliveries SET attempts = ?, last_error = ?, failed_at = ? WHERE id = ?",
+			attempts, sendErr.Error(), now.UTC(), d.ID,
+		)
+		return true, err
+	}
+
+	delay := FederationRetryDelay
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+	}
+	_, err := db.Exec(
+		"UPDATE federation_deliveries SET attempts = ?, last_error = ?, next_attempt_at = ? WHERE id = ?",
+		attempts, sendErr.Error(), now.Add(delay).UTC(), d.ID,
+	)
+	return false, err
+}
+
+// CleanFederationDeliveries deletes deliveries that were delivered or given up before the given time
+func CleanFederationDeliveries(db *sql.DB, before time.Time) (int64, error) {
+	result, err := db.Exec(
+		"DELETE FROM federation_deliveries WHERE (delivered_at IS NOT NULL AND delivered_at < ?) OR (failed_at IS NOT NULL AND failed_at < ?)",
+		before.UTC(), before.UTC(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// CategorySlug is the name of a category's group on other servers, its name in lower case
+// with anything but letters and digits turned into underscores
+func CategorySlug(name string) string {
+	var slug strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			slug.WriteRune(r)
+		} else {
+			slug.WriteByte('_')
+		}
+	}
+	return slug.String()
+}
@@ -0,0 +1,206 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRemoteActorUser(t *testing.T) {
+	db, cleanup, _ := setupPostTestDB(t)
+	defer cleanup()
+
+	actor := &RemoteActor{URI: "https://b.example/u/alice", Type: "Person", Username: "alice", Host: "b.example", Inbox: "https://b.example/inbox"}
+	actorID, err := SaveRemoteActor(db, actor)
+	if err != nil {
+		t.Fatalf("Failed to save actor: %v", err)
+	}
+
+	// Saving again updates the same actor
+	actor.KeyID = actor.URI + "#main-key"
+	if id, err := SaveRemoteActor(db, actor); err != nil || id != actorID {
+		t.Fatalf("Expected actor %d to be updated, got %d: %v", actorID, id, err)
+	}
+	stored, err := GetRemoteActorByKey(db, actor.URI+"#main-key")
+	if err != nil || stored.ID != actorID || stored.Handle() != "alice@b.example" || stored.DeliveryInbox() != actor.Inbox {
+		t.Fatalf("Unexpected actor %+v: %v", stored, err)
+	}
+
+	userID, err := GetRemoteActorUser(db, stored)
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	user, err := GetUserByID(db, userID)
+	if err != nil || user.Username != "alice@b.example" {
+		t.Fatalf("Unexpected user %+v: %v", user, err)
+	}
+	if _, err := AuthenticateUser(db, user.Email, "!"); err == nil {
+		t.Error("Expected nobody to be able to log in as a remote actor")
+	}
+	if remote, _ := IsRemoteUser(db, userID); !remote {
+		t.Error("Expected the user to be remote")
+	}
+
+	// The account is created once
+	stored, _ = GetRemoteActor(db, actor.URI)
+	if again, err := GetRemoteActorUser(db, stored); err != nil || again != userID {
+		t.Errorf("Expected user %d again, got %d: %v", userID, again, err)
+	}
+}
+
+func TestFederatedObjects(t *testing.T) {
+	db, cleanup, userID := setupPostTestDB(t)
+	defer cleanup()
+
+	categoryID, _ := CreateCategory(db, "Federated")
+	actorID, _ := SaveRemoteActor(db, &RemoteActor{URI: "https://b.example/u/alice", Type: "Person", Username: "alice", Host: "b.example", Inbox: "https://b.example/inbox"})
+
+	post := &FederatedObject{URI: "https://b.example/post/1", ActorID: actorID, ReplyInbox: "https://b.example/inbox"}
+	postID, err := CreateFederatedPost(db, post, "Remote", "Hello from b", userID, []int64{categoryID})
+	if err != nil {
+		t.Fatalf("Failed to create post: %v", err)
+	}
+	comment := &FederatedObject{URI: "https://b.example/comment/1", ActorID: actorID}
+	commentID, err := CreateFederatedComment(db, comment, "A reply", userID, postID)
+	if err != nil {
+		t.Fatalf("Failed to create comment: %v", err)
+	}
+
+	// Objects are received once
+	if _, err := CreateFederatedComment(db, &FederatedObject{URI: comment.URI, ActorID: actorID}, "Again", userID, postID); err == nil || err.Error() != "object already received" {
+		t.Errorf("Expected object already received error, got %v", err)
+	}
+	if comments, _ := GetCommentsByPostID(db, postID, 0); len(comments) != 1 {
+		t.Errorf("Expected 1 comment, got %d", len(comments))
+	}
+
+	found, err := GetFederatedObjectFor(db, "post", postID)
+	if err != nil || found.URI != post.URI || found.ReplyInbox != post.ReplyInbox {
+		t.Fatalf("Unexpected object %+v: %v", found, err)
+	}
+
+	// Deleting the post takes its comments along
+	if err := DeleteFederatedObject(db, found); err != nil {
+		t.Fatalf("Failed to delete post: %v", err)
+	}
+	if _, err := GetPostByID(db, postID, 0); err == nil {
+		t.Error("Expected the post to be deleted")
+	}
+	if _, err := GetCommentByID(db, commentID, 0); err == nil {
+		t.Error("Expected the comment to be deleted")
+	}
+	if _, err := GetFederatedObject(db, comment.URI); err == nil || err.Error() != "object not found" {
+		t.Errorf("Expected object not found error, got %v", err)
+	}
+}
+
+func TestCategoryFederation(t *testing.T) {
+	db, cleanup, _ := setupPostTestDB(t)
+	defer cleanup()
+
+	categoryID, _ := CreateCategory(db, "Federated")
+	aliceID, _ := SaveRemoteActor(db, &RemoteActor{URI: "https://b.example/u/alice", Type: "Person", Username: "alice", Host: "b.example", Inbox: "https://b.example/u/alice/inbox", SharedInbox: "https://b.example/inbox"})
+	bobID, _ := SaveRemoteActor(db, &RemoteActor{URI: "https://b.example/u/bob", Type: "Person", Username: "bob", Host: "b.example", Inbox: "https://b.example/u/bob/inbox", SharedInbox: "https://b.example/inbox"})
+	groupID, _ := SaveRemoteActor(db, &RemoteActor{URI: "https://c.example/c/news", Type: "Group", Username: "news", Host: "c.example", Inbox: "https://c.example/c/news/inbox"})
+
+	AddCategoryFollower(db, categoryID, aliceID, "https://b.example/follows/1")
+	AddCategoryFollower(db, categoryID, bobID, "https://b.example/follows/2")
+	if inboxes, _ := GetCategoryFollowerInboxes(db, categoryID); len(inboxes) != 1 || inboxes[0] != "https://b.example/inbox" {
+		t.Errorf("Expected the shared inbox once, got %v", inboxes)
+	}
+	if err := RemoveCategoryFollow(db, bobID, "https://b.example/follows/2"); err != nil {
+		t.Fatalf("Failed to undo follow: %v", err)
+	}
+	if counts, _ := CountCategoryFollowers(db); counts[categoryID] != 1 {
+		t.Errorf("Expected 1 follower, got %v", counts)
+	}
+
+	// Subscriptions count once the group accepted
+	if err := AddCategorySubscription(db, categoryID, groupID); err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+	if err := AddCategorySubscription(db, categoryID, groupID); err == nil || err.Error() != "already following" {
+		t.Errorf("Expected already following error, got %v", err)
+	}
+	if categories, _ := GetSubscribedCategories(db, groupID); len(categories) != 0 {
+		t.Errorf("Expected no accepted subscription, got %v", categories)
+	}
+	if err := AcceptCategorySubscription(db, categoryID, groupID); err != nil {
+		t.Fatalf("Failed to accept: %v", err)
+	}
+	if categories, _ := GetSubscribedCategories(db, groupID); len(categories) != 1 || categories[0] != categoryID {
+		t.Errorf("Expected the category, got %v", categories)
+	}
+	if groups, _ := GetCategoryGroups(db, categoryID); len(groups) != 1 || groups[0].Inbox != "https://c.example/c/news/inbox" {
+		t.Errorf("Expected the group, got %v", groups)
+	}
+	subscriptions, err := GetCategorySubscriptions(db)
+	if err != nil || len(subscriptions) != 1 || subscriptions[0].AcceptedAt == nil || subscriptions[0].Actor.Handle() != "news@c.example" {
+		t.Errorf("Unexpected subscriptions %+v: %v", subscriptions, err)
+	}
+
+	if err := ForgetRemoteActor(db, groupID); err != nil {
+		t.Fatalf("Failed to forget actor: %v", err)
+	}
+	if err := RemoveCategorySubscription(db, categoryID, groupID); err == nil || err.Error() != "subscription not found" {
+		t.Errorf("Expected subscription not found error, got %v", err)
+	}
+
+	if slug := CategorySlug("Science & Tech"); slug != "science___tech" {
+		t.Errorf("Unexpected slug %q", slug)
+	}
+}
+
+func TestFederationKeysAndDeliveries(t *testing.T) {
+	db, cleanup, userID := setupPostTestDB(t)
+	defer cleanup()
+
+	key, err := GetFederationKey(db, FederationUser, userID)
+	if err != nil {
+		t.Fatalf("Failed to create key: %v", err)
+	}
+	if again, _ := GetFederationKey(db, FederationUser, userID); again.PrivateKey != key.PrivateKey {
+		t.Error("Expected the same key again")
+	}
+
+	err = QueueFederationDelivery(db, FederationUser, userID, []string{"https://b.example/inbox", "https://b.example/inbox", "https://c.example/inbox"}, []byte(`{}`))
+	if err != nil {
+		t.Fatalf("Failed to queue: %v", err)
+	}
+	now := time.Now()
+	due, err := GetDueFederationDeliveries(db, now, 10)
+	if err != nil || len(due) != 2 {
+		t.Fatalf("Expected 2 deliveries, got %d: %v", len(due), err)
+	}
+
+	MarkFederationDelivered(db, due[0].ID, now)
+	gaveUp, err := MarkFederationFailed(db, &due[1], errors.New("unexpected status 500"), now)
+	if err != nil || gaveUp {
+		t.Fatalf("Expected a retry, got %v %v", gaveUp, err)
+	}
+	if due, _ := GetDueFederationDeliveries(db, now, 10); len(due) != 0 {
+		t.Errorf("Expected nothing due yet, got %d", len(due))
+	}
+	due, _ = GetDueFederationDeliveries(db, now.Add(FederationRetryDelay), 10)
+	if len(due) != 1 || due[0].Attempts != 1 || due[0].LastError != "unexpected status 500" {
+		t.Fatalf("Expected the retry to be due, got %+v", due)
+	}
+	due[0].Attempts = FederationMaxAttempts - 1
+	if gaveUp, _ := MarkFederationFailed(db, &due[0], errors.New("timeout"), now); !gaveUp {
+		t.Error("Expected the delivery to be given up")
+	}
+
+	if n, _ := CleanFederationDeliveries(db, now.Add(time.Minute)); n != 2 {
+		t.Errorf("Expected 2 deliveries cleaned, got %d", n)
+	}
+
+	// Keys go with the account
+	if _, err := DeleteUser(db, userID, DeleteModeEverything); err != nil {
+		t.Fatalf("Failed to delete user: %v", err)
+	}
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM federation_keys WHERE owner_type = 'user'").Scan(&count)
+	if count != 0 {
+		t.Errorf("Expected the key to be deleted, got %d", count)
+	}
+}
@@ -0,0 +1,128 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Things that can be followed
+const (
+	FollowPost     = "post"
+	FollowCategory = "category"
+	FollowUser     = "user"
+)
+
+// Follow is a subscription to a post, a category or a user
+type Follow struct {
+	ID         int64
+	TargetType string
+	TargetID   int64
+	Name       string // post title, category name or username
+	CreatedAt  time.Time
+}
+
+// FollowTarget subscribes a user to a post, category or user. Following twice has no effect.
+func FollowTarget(db *sql.DB, userID int64, targetType string, targetID int64) error {
+	if err := checkFollowTarget(db, userID, targetType, targetID); err != nil {
+		return err
+	}
+
+	_, err := db.Exec(
+		"INSERT OR IGNORE INTO follows (user_id, target_type, target_id) VALUES (?, ?, ?)",
+		userID, targetType, targetID,
+	)
+	return err
+}
+
+// ToggleFollow follows a target, or unfollows it when already followed.
+// It reports whether the target is followed afterwards.
+func ToggleFollow(db *sql.DB, userID int64, targetType string, targetID int64) (bool, error) {
+	result, err := db.Exec(
+		"DELETE FROM follows WHERE user_id = ? AND target_type = ? AND target_id = ?",
+		userID, targetType, targetID,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n > 0 {
+		return false, nil
+	}
+
+	if err := FollowTarget(db, userID, targetType, targetID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// IsFollowing reports whether a user follows a target
+func IsFollowing(db *sql.DB, userID int64, targetType string, targetID int64) (bool, error) {
+	var following bool
+	err := db.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM follows WHERE user_id = ? AND target_type = ? AND target_id = ?)",
+		userID, targetType, targetID,
+	).Scan(&following)
+	return following, err
+}
+
+// GetUserFollows retrieves everything a user follows, most recent first
+func GetUserFollows(db *sql.DB, userID int64) ([]Follow, error) {
+	rows, err := db.Query(`
+		SELECT f.id, f.target_type, f.target_id, f.created_at,
+		COALESCE(CASE f.target_type
+			WHEN 'post' THEN (SELECT title FROM posts WHERE id = f.target_id)
+			WHEN 'category' THEN (SELECT name FROM categories WHERE id = f.target_id)
+			WHEN 'user' THEN (SELECT username FROM users WHERE id = f.target_id)
+		END, '')
+		FROM follows f
+		WHERE f.user_id = ?
+		ORDER BY f.created_at DESC, f.id DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var follows []Follow
+	for rows.Next() {
+		var f Follow
+		if err := rows.Scan(&f.ID, &f.TargetType, &f.TargetID, &f.CreatedAt, &f.Name); err != nil {
+			return nil, err
+		}
+		follows = append(follows, f)
+	}
+	return follows, rows.Err()
+}
+
+func checkFollowTarget(db *sql.DB, userID int64, targetType string, targetID int64) error {
+	query := ""
+	args := []interface{}{targetID}
+	switch targetType {
+	case FollowPost:
+		query = "SELECT EXISTS(SELECT 1 FROM posts WHERE id = ?)"
+	case FollowCategory:
+		query = "SELECT EXISTS(SELECT 1 FROM categories WHERE id = ?)"
+	case FollowUser:
+		if targetID == userID {
+			return errors.New("you cannot follow yourself")
+		}
+		// The placeholder for deleted accounts cannot be followed
+		query = "SELECT EXISTS(SELECT 1 FROM users WHERE id = ? AND username != ?)"
+		args = append(args, DeletedUsername)
+	default:
+		return errors.New("invalid follow type")
+	}
+
+	var exists bool
+	if err := db.QueryRow(query, args...).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return errors.New(targetType + " not found")
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package models
+
+import "testing"
+
+func TestToggleFollow(t *testing.T) {
+	db, cleanup, userID := setupPostTestDB(t)
+	defer cleanup()
+
+	otherID, err := CreateUser(db, "otheruser", "other@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	categoryID, err := CreateCategory(db, "General")
+	if err != nil {
+		t.Fatalf("Failed to create category: %v", err)
+	}
+	postID, err := CreatePost(db, "Test Post", "Test content", otherID, []int64{categoryID})
+	if err != nil {
+		t.Fatalf("Failed to create post: %v", err)
+	}
+
+	// Test following a post, a category and a user
+	targets := []struct {
+		targetType string
+		targetID   int64
+	}{
+		{FollowPost, postID},
+		{FollowCategory, categoryID},
+		{FollowUser, otherID},
+	}
+	for _, target := range targets {
+		following, err := ToggleFollow(db, userID, target.targetType, target.targetID)
+		if err != nil || !following {
+			t.Fatalf("Expected to follow %s %d, got %v (%v)", target.targetType, target.targetID, following, err)
+		}
+	}
+
+	follows, err := GetUserFollows(db, userID)
+	if err != nil {
+		t.Fatalf("Failed to get follows: %v", err)
+	}
+	if len(follows) != 3 {
+		t.Fatalf("Expected 3 follows, got %d", len(follows))
+	}
+	names := map[string]string{}
+	for _, f := range follows {
+		names[f.TargetType] = f.Name
+	}
+	if names[FollowPost] != "Test Post" || names[FollowUser] != "otheruser" || names[FollowCategory] != "General" {
+		t.Errorf("Unexpected follow names: %v", names)
+	}
+
+	// Test that following twice has no effect and toggling unfollows
+	if err := FollowTarget(db, userID, FollowPost, postID); err != nil {
+		t.Fatalf("Failed to follow post again: %v", err)
+	}
+	following, err := ToggleFollow(db, userID, FollowPost, postID)
+	if err != nil || following {
+		t.Fatalf("Expected post to be unfollowed, got %v (%v)", following, err)
+	}
+	if following, _ := IsFollowing(db, userID, FollowPost, postID); following {
+		t.Error("Expected post not to be followed")
+	}
+
+	// Test invalid targets
+	if _, err := ToggleFollow(db, userID, FollowUser, userID); err == nil || err.Error() != "you cannot follow yourself" {
+		t.Errorf("Expected 'you cannot follow yourself', got %v", err)
+	}
+	if _, err := ToggleFollow(db, userID, FollowPost, 9999); err == nil || err.Error() != "post not found" {
+		t.Errorf("Expected 'post not found', got %v", err)
+	}
+	if _, err := ToggleFollow(db, userID, "comment", 1); err == nil || err.Error() != "invalid follow type" {
+		t.Errorf("Expected 'invalid follow type', got %v", err)
+	}
+}
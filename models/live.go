@@ -0,0 +1,93 @@
+package models
+
+import (
+	"database/sql"
+	"log"
+
+	"forum/live"
+)
+
+// Live event names
+const (
+	LiveComment  = "comment"  // new comment on a post
+	LiveReaction = "reaction" // reaction counts of a post or comment changed
+	LivePost     = "post"     // new post in a category
+)
+
+// LiveCommentEvent announces a new comment to the readers of its post
+type LiveCommentEvent struct {
+	ID     int64 `json:"id"`
+	PostID int64 `json:"post_id"`
+	UserID int64 `json:"user_id"`
+}
+
+// LiveReactionEvent carries the new reaction counts of a post or comment
+type LiveReactionEvent struct {
+	TargetType string `json:"target_type"` // "post" or "comment"
+	TargetID   int64  `json:"target_id"`
+	Likes      int    `json:"likes"`
+	Dislikes   int    `json:"dislikes"`
+}
+
+// LivePostEvent announces a new post to the readers of its categories and the home page
+type LivePostEvent struct {
+	ID    int64  `json:"id"`
+	Title string `json:"title"`
+}
+
+// publishLive sends an event to the open pages subscribed to a topic. Failures are
+// only logged since the change itself has been saved.
+func publishLive(topic, name string, data interface{}) {
+	if err := live.Default.Publish(topic, name, data); err != nil {
+		log.Printf("Failed to publish live event: %v", err)
+	}
+}
+
+// publishNewComment tells the readers of a post about a new comment
+func publishNewComment(commentID, postID, userID int64) {
+	publishLive(live.PostTopic(postID), LiveComment, LiveCommentEvent{ID: commentID, PostID: postID, UserID: userID})
+}
+
+// publishNewPost tells the readers of the post's categories and of all posts about a new post
+func publishNewPost(postID int64, title string, categoryIDs []int64) {
+	event := LivePostEvent{ID: postID, Title: title}
+	publishLive(live.PostsTopic, LivePost, event)
+	for _, categoryID := range categoryIDs {
+		publishLive(live.CategoryTopic(categoryID), LivePost, event)
+	}
+}
+
+// publishPostReactions sends the current reaction counts of a post to its readers
+func publishPostReactions(db *sql.DB, postID int64) {
+	if live.Default.Subscribers(live.PostTopic(postID)) == 0 {
+		return
+	}
+	likes, dislikes, err := GetPostReactionStats(db, postID)
+	if err != nil {
+		log.Printf("Failed to get reactions of post %d: %v", postID, err)
+		return
+	}
+	publishLive(live.PostTopic(postID), LiveReaction, LiveReactionEvent{
+		TargetType: "post", TargetID: postID, Likes: likes, Dislikes: dislikes,
+	})
+}
+
+// publishCommentReactions sends the current reaction counts of a comment to the readers of its post
+func publishCommentReactions(db *sql.DB, commentID int64) {
+	var postID int64
+	if err := db.QueryRow("SELECT post_id FROM comments WHERE id = ?", commentID).Scan(&postID); err != nil {
+		log.Printf("Failed to get post of comment %d: %v", commentID, err)
+		return
+	}
+	if live.Default.Subscribers(live.PostTopic(postID)) == 0 {
+		return
+	}
+	likes, dislikes, err := GetCommentReactionStats(db, commentID)
+	if err != nil {
+		log.Printf("Failed to get reactions of comment %d: %v", commentID, err)
+		return
+	}
+	publishLive(live.PostTopic(postID), LiveReaction, LiveReactionEvent{
+		TargetType: "comment", TargetID: commentID, Likes: likes, Dislikes: dislikes,
+	})
+}
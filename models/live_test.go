@@ -0,0 +1,53 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+
+	"forum/live"
+)
+
+func TestLiveEvents(t *testing.T) {
+	db, cleanup, userID := setupPostTestDB(t)
+	defer cleanup()
+
+	categoryID, err := CreateCategory(db, "General")
+	if err != nil {
+		t.Fatalf("Failed to create category: %v", err)
+	}
+	posts := live.Default.Subscribe(live.CategoryTopic(categoryID))
+	defer live.Default.Unsubscribe(posts)
+
+	postID, err := CreatePost(db, "Live Post", "Content", userID, []int64{categoryID})
+	if err != nil {
+		t.Fatalf("Failed to create post: %v", err)
+	}
+	event := <-posts.Events
+	if event.Name != LivePost {
+		t.Fatalf("Expected a post event, got %+v", event)
+	}
+
+	readers := live.Default.Subscribe(live.PostTopic(postID))
+	defer live.Default.Unsubscribe(readers)
+
+	commentID, err := CreateComment(db, "Hello", userID, postID)
+	if err != nil {
+		t.Fatalf("Failed to create comment: %v", err)
+	}
+	event = <-readers.Events
+	var comment LiveCommentEvent
+	json.Unmarshal(event.Data, &comment)
+	if event.Name != LiveComment || comment.ID != commentID {
+		t.Fatalf("Expected a comment event, got %+v", event)
+	}
+
+	if err := ReactToComment(db, commentID, userID, 1); err != nil {
+		t.Fatalf("Failed to react: %v", err)
+	}
+	event = <-readers.Events
+	var reaction LiveReactionEvent
+	json.Unmarshal(event.Data, &reaction)
+	if event.Name != LiveReaction || reaction.TargetType != "comment" || reaction.Likes != 1 {
+		t.Errorf("Expected a comment reaction event, got %s", event.Data)
+	}
+}
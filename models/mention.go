@@ -0,0 +1,199 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+
+	"forum/markdown"
+)
+
+// MaxMentions is the largest number of users one post or comment can mention
+const MaxMentions = 20
+
+// UserSuggestion is a username offered while typing a mention
+type UserSuggestion struct {
+	ID            int64
+	Username      string
+	AvatarVersion int
+}
+
+// SaveMentions stores the users a post or comment mentions, replacing what was stored for an
+// earlier version of the content. It returns the users mentioned for the first time, except the author.
+func SaveMentions(db *sql.DB, targetType string, targetID, postID, authorID int64, content string) ([]int64, error) {
+	names := markdown.Mentions(content)
+	if len(names) > MaxMentions {
+		names = names[:MaxMentions]
+	}
+	users, err := FindUsernames(db, names)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT user_id FROM mentions WHERE target_type = ? AND target_id = ?", targetType, targetID)
+	if err != nil {
+		return nil, err
+	}
+	known := map[int64]bool{}
+	for rows.Next() {
+		var userID int64
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		known[userID] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM mentions WHERE target_type = ? AND target_id = ?", targetType, targetID); err != nil {
+		return nil, err
+	}
+
+	var added []int64
+	for _, name := range names {
+		userID, ok := users[name]
+		if !ok {
+			continue
+		}
+		_, err := tx.Exec(
+			"INSERT INTO mentions (target_type, target_id, post_id, user_id, author_id, name) VALUES (?, ?, ?, ?, ?, ?)",
+			targetType, targetID, postID, userID, authorID, name,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if !known[userID] && userID != authorID {
+			added = append(added, userID)
+		}
+	}
+
+	return added, tx.Commit()
+}
+
+// GetMentionNames maps the names mentioned in a post or comment to the current usernames of those users
+func GetMentionNames(db *sql.DB, targetType string, targetID int64) (map[string]string, error) {
+	rows, err := db.Query(`
+		SELECT m.name, u.username FROM mentions m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.target_type = ? AND m.target_id = ?
+	`, targetType, targetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := map[string]string{}
+	for rows.Next() {
+		var name, username string
+		if err := rows.Scan(&name, &username); err != nil {
+			return nil, err
+		}
+		names[name] = username
+	}
+	return names, rows.Err()
+}
+
+// FindUsernames returns the IDs of the users with the given names. Unknown names are left out.
+func FindUsernames(db *sql.DB, names []string) (map[string]int64, error) {
+	users := map[string]int64{}
+	for _, name := range names {
+		if name == DeletedUsername {
+			continue
+		}
+		var id int64
+		err := db.QueryRow("SELECT id FROM users WHERE username = ?", name).Scan(&id)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		users[name] = id
+	}
+	return users, nil
+}
+
+// SearchUsernames suggests users whose name starts with the prefix, for mention autocomplete
+func SearchUsernames(db *sql.DB, prefix string, limit int) ([]UserSuggestion, error) {
+	// LIKE wildcards in the prefix are matched literally
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(prefix)
+	rows, err := db.Query(`
+		SELECT id, username, avatar_version FROM users
+		WHERE username LIKE ? ESCAPE '\' AND username != ?
+		ORDER BY LENGTH(username) ASC, username COLLATE NOCASE ASC
+		LIMIT ?
+	`, escaped+"%", DeletedUsername, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []UserSuggestion
+	for rows.Next() {
+		var u UserSuggestion
+		if err := rows.Scan(&u.ID, &u.Username, &u.AvatarVersion); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// NotifyMentions tells users they were mentioned in a post or comment, unless they turned
+// mention notifications off. commentID is 0 for mentions in the post itself.
+func NotifyMentions(db *sql.DB, postID, commentID, actorID int64, userIDs []int64) error {
+	var notifications []Notification
+	for _, userID := range userIDs {
+		enabled, err := MentionNotificationsEnabled(db, userID)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if enabled {
+			notifications = append(notifications, Notification{
+				UserID: userID, ActorID: actorID, Type: NotifyMention, PostID: postID, CommentID: commentID,
+			})
+		}
+	}
+	return createNotifications(db, notifications)
+}
+
+// MentionNotificationsEnabled reports whether a user wants to hear about mentions
+func MentionNotificationsEnabled(db *sql.DB, userID int64) (bool, error) {
+	var enabled bool
+	err := db.QueryRow("SELECT notify_mentions FROM users WHERE id = ?", userID).Scan(&enabled)
+	return enabled, err
+}
+
+// SetMentionNotifications turns mention notifications on or off for a user
+func SetMentionNotifications(db *sql.DB, userID int64, enabled bool) error {
+	_, err := db.Exec("UPDATE users SET notify_mentions = ? WHERE id = ?", enabled, userID)
+	return err
+}
+
+// execer runs statements on a database or inside a transaction
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// forgetMentionRenderings drops the cached HTML of content that mentions a user,
+// so links are rendered again with the user's current name
+func forgetMentionRenderings(db execer, userID int64) error {
+	_, err := db.Exec(`
+		DELETE FROM rendered_content WHERE EXISTS (
+			SELECT 1 FROM mentions m
+			WHERE m.user_id = ? AND m.target_type = rendered_content.target_type AND m.target_id = rendered_content.target_id
+		)
+	`, userID)
+	return err
+}
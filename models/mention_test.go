@@ -0,0 +1,129 @@
+package models
+
+import "testing"
+
+func TestSaveMentions(t *testing.T) {
+	db, cleanup, userID := setupPostTestDB(t)
+	defer cleanup()
+
+	aliceID, err := CreateUser(db, "alice", "alice@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	bobID, err := CreateUser(db, "bob", "bob@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	postID, err := CreatePost(db, "Test Post", "Hi @alice", userID, []int64{1})
+	if err != nil {
+		t.Fatalf("Failed to create post: %v", err)
+	}
+
+	// Unknown names and the author are not returned
+	added, err := SaveMentions(db, RenderedPost, postID, postID, userID, "Hi @alice, @testuser and @nobody")
+	if err != nil {
+		t.Fatalf("Failed to save mentions: %v", err)
+	}
+	if len(added) != 1 || added[0] != aliceID {
+		t.Errorf("Expected alice to be newly mentioned, got %v", added)
+	}
+
+	// Saving an edited version only returns the users mentioned for the first time
+	added, err = SaveMentions(db, RenderedPost, postID, postID, userID, "Hi @alice and @bob")
+	if err != nil {
+		t.Fatalf("Failed to save mentions: %v", err)
+	}
+	if len(added) != 1 || added[0] != bobID {
+		t.Errorf("Expected bob to be newly mentioned, got %v", added)
+	}
+
+	// Renamed users keep their mentions
+	oldCooldown := UsernameChangeCooldown
+	UsernameChangeCooldown = 0
+	defer func() { UsernameChangeCooldown = oldCooldown }()
+	if err := ChangeUsername(db, aliceID, "alice2", "127.0.0.1"); err != nil {
+		t.Fatalf("Failed to rename user: %v", err)
+	}
+	names, err := GetMentionNames(db, RenderedPost, postID)
+	if err != nil {
+		t.Fatalf("Failed to get mentions: %v", err)
+	}
+	if names["alice"] != "alice2" || names["bob"] != "bob" || len(names) != 2 {
+		t.Errorf("Unexpected mention names: %v", names)
+	}
+}
+
+func TestNotifyMentions(t *testing.T) {
+	db, cleanup, userID := setupPostTestDB(t)
+	defer cleanup()
+
+	aliceID, err := CreateUser(db, "alice", "alice@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	bobID, err := CreateUser(db, "bob", "bob@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	carolID, err := CreateUser(db, "carol", "carol@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	postID, err := CreatePost(db, "Test Post", "Hi @alice @bob @carol", userID, []int64{1})
+	if err != nil {
+		t.Fatalf("Failed to create post: %v", err)
+	}
+
+	// Bob does not want mention notifications and carol blocked the author
+	if err := SetMentionNotifications(db, bobID, false); err != nil {
+		t.Fatalf("Failed to update settings: %v", err)
+	}
+	if _, err := ToggleBlock(db, carolID, userID); err != nil {
+		t.Fatalf("Failed to block user: %v", err)
+	}
+
+	if err := NotifyMentions(db, postID, 0, userID, []int64{aliceID, bobID, carolID}); err != nil {
+		t.Fatalf("Failed to notify: %v", err)
+	}
+
+	notifications, _, err := GetNotifications(db, aliceID, 10, 0)
+	if err != nil {
+		t.Fatalf("Failed to get notifications: %v", err)
+	}
+	if len(notifications) != 1 || notifications[0].Type != NotifyMention {
+		t.Errorf("Expected a mention notification for alice, got %+v", notifications)
+	}
+	for _, id := range []int64{bobID, carolID} {
+		if n, _ := CountUnreadNotifications(db, id); n != 0 {
+			t.Errorf("Expected no notifications for user %d, got %d", id, n)
+		}
+	}
+}
+
+func TestSearchUsernames(t *testing.T) {
+	db, cleanup, _ := setupPostTestDB(t)
+	defer cleanup()
+
+	for _, name := range []string{"alice", "Alicia", "al_x", "bob"} {
+		if _, err := CreateUser(db, name, name+"@example.com", "password123"); err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+	}
+
+	users, err := SearchUsernames(db, "ali", 10)
+	if err != nil {
+		t.Fatalf("Failed to search users: %v", err)
+	}
+	if len(users) != 2 || users[0].Username != "alice" || users[1].Username != "Alicia" {
+		t.Errorf("Expected alice and Alicia, got %+v", users)
+	}
+
+	// Underscores are not wildcards
+	users, err = SearchUsernames(db, "al_", 10)
+	if err != nil {
+		t.Fatalf("Failed to search users: %v", err)
+	}
+	if len(users) != 1 || users[0].Username != "al_x" {
+		t.Errorf("Expected only al_x, got %+v", users)
+	}
+}
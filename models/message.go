@@ -0,0 +1,614 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// MaxMessageLength is the longest private message in characters
+const MaxMessageLength = 5000
+
+// MaxConversationMembers is the most people a conversation can have, the starter included
+const MaxConversationMembers = 10
+
+// MaxReportReasonLength is the longest reason a user can give when reporting a message
+const MaxReportReasonLength = 500
+
+// messagePreviewLength is how much of the latest message the inbox shows
+const messagePreviewLength = 100
+
+// Conversation is a private conversation as seen by one of its members
+type Conversation struct {
+	ID            int64
+	Members       []ConversationMember // the other people still in the conversation
+	Preview       string               // start of the latest message
+	LastMessageAt time.Time
+	Unread        int
+}
+
+// ConversationMember is someone taking part in a conversation
+type ConversationMember struct {
+	ID            int64
+	Username      string
+	AvatarVersion int
+}
+
+// Names lists the other members, for conversation titles
+func (c *Conversation) Names() string {
+	if len(c.Members) == 0 {
+		return "Nobody else"
+	}
+	names := make([]string, len(c.Members))
+	for i, m := range c.Members {
+		names[i] = m.Username
+	}
+	return strings.Join(names, ", ")
+}
+
+type PrivateMessage struct {
+	ID             int64
+	ConversationID int64
+	UserID         int64
+	Username       string
+	AvatarVersion  int
+	Content        string
+	CreatedAt      time.Time
+}
+
+// MessageReport is a private message a member asked moderators to look at
+type MessageReport struct {
+	ID        int64
+	Message   PrivateMessage
+	Reporter  string
+	Reason    string
+	CreatedAt time.Time
+}
+
+// StartConversation sends a first message to one or more users, by name. Writing to a single
+// user again continues the conversation the two of them already have.
+// It returns the conversation's ID.
+func StartConversation(db *sql.DB, senderID int64, usernames []string, content string) (int64, error) {
+	content, err := checkMessage(content)
+	if err != nil {
+		return 0, err
+	}
+
+	var recipients []int64
+	seen := map[int64]bool{}
+	for _, name := range usernames {
+		name = strings.TrimPrefix(strings.TrimSpace(name), "@")
+		if name == "" {
+			continue
+		}
+		found, err := FindUsernames(db, []string{name})
+		if err != nil {
+			return 0, err
+		}
+		id, ok := found[name]
+		if !ok {
+			return 0, errors.New("user not found")
+		}
+		if id == senderID {
+			return 0, errors.New("you cannot message yourself")
+		}
+		if !seen[id] {
+			seen[id] = true
+			recipients = append(recipients, id)
+		}
+	}
+	if len(recipients) == 0 {
+		return 0, errors.New("add at least one recipient")
+	}
+	if len(recipients)+1 > MaxConversationMembers {
+		return 0, errors.New("too many recipients")
+	}
+	for _, id := range recipients {
+		if err := checkMessagingBlocks(db, senderID, id); err != nil {
+			return 0, err
+		}
+	}
+
+	if len(recipients) == 1 {
+		conversationID, err := findDirectConversation(db, senderID, recipients[0])
+		if err != nil {
+			return 0, err
+		}
+		if conversationID != 0 {
+			_, err := SendMessage(db, conversationID, senderID, content)
+			return conversationID, err
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	result, err := tx.Exec("INSERT INTO conversations (created_at, last_message_at) VALUES (?, ?)", now, now)
+	if err != nil {
+		return 0, err
+	}
+	conversationID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, id := range append([]int64{senderID}, recipients...) {
+		_, err := tx.Exec(
+			"INSERT INTO conversation_members (conversation_id, user_id, joined_at) VALUES (?, ?, ?)",
+			conversationID, id, now,
+		)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if _, err := insertMessage(tx, conversationID, senderID, content, now); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return conversationID, nil
+}
+
+// SendMessage adds a message to a conversation the sender is still part of
+func SendMessage(db *sql.DB, conversationID, senderID int64, content string) (*PrivateMessage, error) {
+	content, err := checkMessage(content)
+	if err != nil {
+		return nil, err
+	}
+
+	if member, err := isConversationMember(db, conversationID, senderID); err != nil {
+		return nil, err
+	} else if !member {
+		return nil, errors.New("conversation not found")
+	}
+
+	others, err := conversationMembers(db, conversationID, senderID)
+	if err != nil {
+		return nil, err
+	}
+	if len(others) == 0 {
+		return nil, errors.New("everyone else left this conversation")
+	}
+	for _, m := range others {
+		if err := checkMessagingBlocks(db, senderID, m.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	messageID, err := insertMessage(tx, conversationID, senderID, content, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return getPrivateMessage(db, messageID)
+}
+
+// insertMessage stores a message, moves the conversation up the inbox and marks it read for the sender
+func insertMessage(tx *sql.Tx, conversationID, senderID int64, content string, now time.Time) (int64, error) {
+	result, err := tx.Exec(
+		"INSERT INTO private_messages (conversation_id, user_id, content, created_at) VALUES (?, ?, ?, ?)",
+		conversationID, senderID, content, now,
+	)
+	if err != nil {
+		return 0, err
+	}
+	messageID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.Exec("UPDATE conversations SET last_message_at = ? WHERE id = ?", now, conversationID); err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(
+		"UPDATE conversation_members SET last_read_id = ? WHERE conversation_id = ? AND user_id = ?",
+		messageID, conversationID, senderID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return messageID, nil
+}
+
+// checkMessage trims a message and checks its length
+func checkMessage(content string) (string, error) {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return "", errors.New("message is empty")
+	}
+	if len([]rune(content)) > MaxMessageLength {
+		return "", errors.New("message is too long")
+	}
+	return content, nil
+}
+
+// checkMessagingBlocks fails when either user blocked the other
+func checkMessagingBlocks(db *sql.DB, userID, otherID int64) error {
+	var blocked bool
+	err := db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM user_blocks
+			WHERE (user_id = ? AND blocked_id = ?) OR (user_id = ? AND blocked_id = ?)
+		)
+	`, userID, otherID, otherID, userID).Scan(&blocked)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return errors.New("you cannot message users you blocked or who blocked you")
+	}
+	return nil
+}
+
+// findDirectConversation finds a conversation that only ever had the two users in it,
+// and that neither of them left. It returns 0 when there is none.
+func findDirectConversation(db *sql.DB, userID, otherID int64) (int64, error) {
+	var conversationID int64
+	err := db.QueryRow(`
+		SELECT m.conversation_id
+		FROM conversation_members m
+		JOIN conversation_members o ON o.conversation_id = m.conversation_id AND o.user_id = ? AND o.left_at IS NULL
+		WHERE m.user_id = ? AND m.left_at IS NULL
+		AND (SELECT COUNT(*) FROM conversation_members a WHERE a.conversation_id = m.conversation_id) = 2
+		ORDER BY m.conversation_id DESC
+		LIMIT 1
+	`, otherID, userID).Scan(&conversationID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return conversationID, err
+}
+
+func isConversationMember(db *sql.DB, conversationID, userID int64) (bool, error) {
+	var member bool
+	err := db.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM conversation_members WHERE conversation_id = ? AND user_id = ? AND left_at IS NULL)",
+		conversationID, userID,
+	).Scan(&member)
+	return member, err
+}
+
+// conversationMembers lists the people still in a conversation apart from the given user
+func conversationMembers(db *sql.DB, conversationID, userID int64) ([]ConversationMember, error) {
+	rows, err := db.Query(`
+		SELECT u.id, u.username, u.avatar_version
+		FROM conversation_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.conversation_id = ? AND m.user_id != ? AND m.left_at IS NULL
+		ORDER BY u.username COLLATE NOCASE ASC
+	`, conversationID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []ConversationMember
+	for rows.Next() {
+		var m ConversationMember
+		if err := rows.Scan(&m.ID, &m.Username, &m.AvatarVersion); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// GetConversations retrieves a user's inbox, latest activity first, and how many
+// conversations they are in
+func GetConversations(db *sql.DB, userID int64, limit, offset int) ([]Conversation, int, error) {
+	var total int
+	err := db.QueryRow(
+		"SELECT COUNT(*) FROM conversation_members WHERE user_id = ? AND left_at IS NULL",
+		userID,
+	).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.Query(`
+		SELECT c.id, c.last_message_at,
+			COALESCE((SELECT substr(p.content, 1, ?) FROM private_messages p WHERE p.conversation_id = c.id ORDER BY p.id DESC LIMIT 1), ''),
+			(SELECT COUNT(*) FROM private_messages p WHERE p.conversation_id = c.id AND p.id > m.last_read_id AND p.user_id != m.user_id)
+		FROM conversation_members m
+		JOIN conversations c ON c.id = m.conversation_id
+		WHERE m.user_id = ? AND m.left_at IS NULL
+		ORDER BY c.last_message_at DESC, c.id DESC
+		LIMIT ? OFFSET ?
+	`, messagePreviewLength, userID, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var conversations []Conversation
+	for rows.Next() {
+		var c Conversation
+		if err := rows.Scan(&c.ID, &c.LastMessageAt, &c.Preview, &c.Unread); err != nil {
+			rows.Close()
+			return nil, 0, err
+		}
+		conversations = append(conversations, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	for i := range conversations {
+		conversations[i].Members, err = conversationMembers(db, conversations[i].ID, userID)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return conversations, total, nil
+}
+
+// GetConversation retrieves a conversation for one of its members. Nobody else can see it,
+// moderators included.
+func GetConversation(db *sql.DB, conversationID, userID int64) (*Conversation, error) {
+	var c Conversation
+	err := db.QueryRow(`
+		SELECT c.id, c.last_message_at,
+			(SELECT COUNT(*) FROM private_messages p WHERE p.conversation_id = c.id AND p.id > m.last_read_id AND p.user_id != m.user_id)
+		FROM conversation_members m
+		JOIN conversations c ON c.id = m.conversation_id
+		WHERE m.conversation_id = ? AND m.user_id = ? AND m.left_at IS NULL
+	`, conversationID, userID).Scan(&c.ID, &c.LastMessageAt, &c.Unread)
+	if err == sql.ErrNoRows {
+		return nil, errors.New("conversation not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.Members, err = conversationMembers(db, conversationID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+const privateMessageColumns = `p.id, p.conversation_id, p.user_id, COALESCE(u.username, ?), COALESCE(u.avatar_version, 0), p.content, p.created_at
+	FROM private_messages p
+	LEFT JOIN users u ON u.id = p.user_id`
+
+func scanPrivateMessage(scanner interface{ Scan(...interface{}) error }, m *PrivateMessage) error {
+	return scanner.Scan(&m.ID, &m.ConversationID, &m.UserID, &m.Username, &m.AvatarVersion, &m.Content, &m.CreatedAt)
+}
+
+func getPrivateMessage(db *sql.DB, messageID int64) (*PrivateMessage, error) {
+	var m PrivateMessage
+	err := scanPrivateMessage(db.QueryRow("SELECT "+privateMessageColumns+" WHERE p.id = ?", DeletedUsername, messageID), &m)
+	if err == sql.ErrNoRows {
+		return nil, errors.New("message not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// GetConversationMessages retrieves a page of a conversation, counting pages back from the
+// latest message. The page is ordered oldest first. It also returns how many messages there are.
+func GetConversationMessages(db *sql.DB, conversationID int64, limit, offset int) ([]PrivateMessage, int, error) {
+	var total int
+	err := db.QueryRow("SELECT COUNT(*) FROM private_messages WHERE conversation_id = ?", conversationID).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.Query(`
+		SELECT * FROM (
+			SELECT `+privateMessageColumns+`
+			WHERE p.conversation_id = ?
+			ORDER BY p.id DESC
+			LIMIT ? OFFSET ?
+		) ORDER BY id ASC
+	`, DeletedUsername, conversationID, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var messages []PrivateMessage
+	for rows.Next() {
+		var m PrivateMessage
+		if err := scanPrivateMessage(rows, &m); err != nil {
+			return nil, 0, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, total, rows.Err()
+}
+
+// MarkConversationRead marks every message in a conversation as read for a member
+func MarkConversationRead(db *sql.DB, conversationID, userID int64) error {
+	_, err := db.Exec(`
+		UPDATE conversation_members
+		SET last_read_id = COALESCE((SELECT MAX(id) FROM private_messages WHERE conversation_id = ?), 0)
+		WHERE conversation_id = ? AND user_id = ?
+	`, conversationID, conversationID, userID)
+	return err
+}
+
+// CountUnreadMessages returns how many messages a user has not read, across their conversations
+func CountUnreadMessages(db *sql.DB, userID int64) (int, error) {
+	var count int
+	err := db.QueryRow(`
+		SELECT COUNT(*)
+		FROM conversation_members m
+		JOIN private_messages p ON p.conversation_id = m.conversation_id
+		WHERE m.user_id = ? AND m.left_at IS NULL AND p.id > m.last_read_id AND p.user_id != m.user_id
+	`, userID).Scan(&count)
+	return count, err
+}
+
+// LeaveConversation takes a user out of a conversation. They no longer see it or receive its
+// messages. Once everybody left, the conversation is removed, except for reported messages.
+func LeaveConversation(db *sql.DB, conversationID, userID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		"UPDATE conversation_members SET left_at = ? WHERE conversation_id = ? AND user_id = ? AND left_at IS NULL",
+		time.Now().UTC(), conversationID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("conversation not found")
+	}
+
+	var remaining int
+	err = tx.QueryRow(
+		"SELECT COUNT(*) FROM conversation_members WHERE conversation_id = ? AND left_at IS NULL",
+		conversationID,
+	).Scan(&remaining)
+	if err != nil {
+		return err
+	}
+	if remaining == 0 {
+		for _, stmt := range []string{
+			"DELETE FROM private_messages WHERE conversation_id = ? AND id NOT IN (SELECT message_id FROM message_reports)",
+			"DELETE FROM conversation_members WHERE conversation_id = ?",
+			"DELETE FROM conversations WHERE id = ?",
+		} {
+			if _, err := tx.Exec(stmt, conversationID); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ReportMessage asks moderators to look at a message the reporter received. Members who
+// left the conversation can still report it.
+func ReportMessage(db *sql.DB, messageID, reporterID int64, reason string) error {
+	reason = strings.TrimSpace(reason)
+	if len([]rune(reason)) > MaxReportReasonLength {
+		return errors.New("reason is too long")
+	}
+
+	message, err := getPrivateMessage(db, messageID)
+	if err != nil {
+		return err
+	}
+	var member bool
+	err = db.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM conversation_members WHERE conversation_id = ? AND user_id = ?)",
+		message.ConversationID, reporterID,
+	).Scan(&member)
+	if err != nil {
+		return err
+	}
+	if !member {
+		return errors.New("message not found")
+	}
+	if message.UserID == reporterID {
+		return errors.New("you cannot report your own message")
+	}
+
+	var reported bool
+	err = db.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM message_reports WHERE message_id = ? AND reporter_id = ?)",
+		messageID, reporterID,
+	).Scan(&reported)
+	if err != nil {
+		return err
+	}
+	if reported {
+		return errors.New("you already reported this message")
+	}
+
+	_, err = db.Exec(
+		"INSERT INTO message_reports (message_id, reporter_id, reason, created_at) VALUES (?, ?, ?, ?)",
+		messageID, reporterID, reason, time.Now().UTC(),
+	)
+	return err
+}
+
+// GetMessageReports retrieves the open reports for moderators, oldest first, and how many there are.
+// Only the reported messages are included, never the rest of their conversations.
+func GetMessageReports(db *sql.DB, moderator *User, limit, offset int) ([]MessageReport, int, error) {
+	if !moderator.IsModerator() {
+		return nil, 0, errors.New("only moderators can review reports")
+	}
+
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM message_reports WHERE resolved_at IS NULL").Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.Query(`
+		SELECT r.id, COALESCE(ru.username, ?), r.reason, r.created_at, `+privateMessageColumns+`
+		JOIN message_reports r ON r.message_id = p.id
+		LEFT JOIN users ru ON ru.id = r.reporter_id
+		WHERE r.resolved_at IS NULL
+		ORDER BY r.id ASC
+		LIMIT ? OFFSET ?
+	`, DeletedUsername, DeletedUsername, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var reports []MessageReport
+	for rows.Next() {
+		var r MessageReport
+		m := &r.Message
+		if err := rows.Scan(
+			&r.ID, &r.Reporter, &r.Reason, &r.CreatedAt,
+			&m.ID, &m.ConversationID, &m.UserID, &m.Username, &m.AvatarVersion, &m.Content, &m.CreatedAt,
+		); err != nil {
+			return nil, 0, err
+		}
+		reports = append(reports, r)
+	}
+	return reports, total, rows.Err()
+}
+
+// ResolveMessageReport closes a report once a moderator dealt with it
+func ResolveMessageReport(db *sql.DB, reportID int64, moderator *User) error {
+	if !moderator.IsModerator() {
+		return errors.New("only moderators can review reports")
+	}
+
+	result, err := db.Exec(
+		"UPDATE message_reports SET resolved_at = ?, resolved_by = ? WHERE id = ? AND resolved_at IS NULL",
+		time.Now().UTC(), moderator.ID, reportID,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("report not found")
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPrivateConversations(t *testing.T) {
+	db, cleanup, userID := setupPostTestDB(t)
+	defer cleanup()
+
+	otherID, err := CreateUser(db, "otheruser", "other@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	thirdID, err := CreateUser(db, "thirduser", "third@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	// Test invalid conversations
+	if _, err := StartConversation(db, userID, []string{"otheruser"}, "  "); err == nil || err.Error() != "message is empty" {
+		t.Errorf("Expected message is empty error, got %v", err)
+	}
+	if _, err := StartConversation(db, userID, []string{"otheruser"}, strings.Repeat("a", MaxMessageLength+1)); err == nil || err.Error() != "message is too long" {
+		t.Errorf("Expected message is too long error, got %v", err)
+	}
+	if _, err := StartConversation(db, userID, []string{"nobody"}, "Hi"); err == nil || err.Error() != "user not found" {
+		t.Errorf("Expected user not found error, got %v", err)
+	}
+	if _, err := StartConversation(db, userID, []string{"testuser"}, "Hi"); err == nil || err.Error() != "you cannot message yourself" {
+		t.Errorf("Expected you cannot message yourself error, got %v", err)
+	}
+	if _, err := StartConversation(db, userID, []string{" ", ""}, "Hi"); err == nil || err.Error() != "add at least one recipient" {
+		t.Errorf("Expected add at least one recipient error, got %v", err)
+	}
+
+	// Writing to the same user again continues the conversation
+	conversationID, err := StartConversation(db, userID, []string{"otheruser"}, "Hello")
+	if err != nil {
+		t.Fatalf("Failed to start conversation: %v", err)
+	}
+	again, err := StartConversation(db, userID, []string{"@otheruser"}, "Are you there?")
+	if err != nil || again != conversationID {
+		t.Fatalf("Expected conversation %d to continue, got %d (%v)", conversationID, again, err)
+	}
+
+	// The recipient has two unread messages, the sender none
+	if n, _ := CountUnreadMessages(db, otherID); n != 2 {
+		t.Errorf("Expected 2 unread messages, got %d", n)
+	}
+	if n, _ := CountUnreadMessages(db, userID); n != 0 {
+		t.Errorf("Expected no unread messages for the sender, got %d", n)
+	}
+	inbox, total, err := GetConversations(db, otherID, 10, 0)
+	if err != nil {
+		t.Fatalf("Failed to get conversations: %v", err)
+	}
+	if total != 1 || len(inbox) != 1 || inbox[0].Unread != 2 || inbox[0].Preview != "Are you there?" || inbox[0].Names() != "testuser" {
+		t.Fatalf("Unexpected inbox %+v", inbox)
+	}
+
+	if _, err := SendMessage(db, conversationID, otherID, "Yes"); err != nil {
+		t.Fatalf("Failed to reply: %v", err)
+	}
+	if err := MarkConversationRead(db, conversationID, otherID); err != nil {
+		t.Fatalf("Failed to mark conversation read: %v", err)
+	}
+	if n, _ := CountUnreadMessages(db, otherID); n != 0 {
+		t.Errorf("Expected no unread messages after reading, got %d", n)
+	}
+
+	// Pages count back from the latest message and are ordered oldest first
+	messages, total, err := GetConversationMessages(db, conversationID, 2, 0)
+	if err != nil {
+		t.Fatalf("Failed to get messages: %v", err)
+	}
+	if total != 3 || len(messages) != 2 || messages[0].Content != "Are you there?" || messages[1].Content != "Yes" {
+		t.Fatalf("Unexpected first page %+v", messages)
+	}
+	messages, _, _ = GetConversationMessages(db, conversationID, 2, 2)
+	if len(messages) != 1 || messages[0].Content != "Hello" {
+		t.Fatalf("Unexpected second page %+v", messages)
+	}
+
+	// Nobody else can see or write to the conversation
+	if _, err := GetConversation(db, conversationID, thirdID); err == nil || err.Error() != "conversation not found" {
+		t.Errorf("Expected conversation not found error, got %v", err)
+	}
+	if _, err := SendMessage(db, conversationID, thirdID, "Hi"); err == nil || err.Error() != "conversation not found" {
+		t.Errorf("Expected conversation not found error, got %v", err)
+	}
+
+	// Group conversations are separate from the one-to-one conversation
+	groupID, err := StartConversation(db, userID, []string{"otheruser", "thirduser"}, "Hi both")
+	if err != nil || groupID == conversationID {
+		t.Fatalf("Expected a new group conversation, got %d (%v)", groupID, err)
+	}
+	group, err := GetConversation(db, groupID, thirdID)
+	if err != nil || group.Names() != "otheruser, testuser" {
+		t.Fatalf("Unexpected group %+v (%v)", group, err)
+	}
+}
+
+func TestLeaveConversation(t *testing.T) {
+	db, cleanup, userID := setupPostTestDB(t)
+	defer cleanup()
+
+	otherID, err := CreateUser(db, "otheruser", "other@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	conversationID, err := StartConversation(db, userID, []string{"otheruser"}, "Hello")
+	if err != nil {
+		t.Fatalf("Failed to start conversation: %v", err)
+	}
+	if err := LeaveConversation(db, conversationID, otherID); err != nil {
+		t.Fatalf("Failed to leave conversation: %v", err)
+	}
+	if err := LeaveConversation(db, conversationID, otherID); err == nil || err.Error() != "conversation not found" {
+		t.Errorf("Expected conversation not found error, got %v", err)
+	}
+	if _, total, _ := GetConversations(db, otherID, 10, 0); total != 0 {
+		t.Errorf("Expected the conversation to leave the inbox, got %d", total)
+	}
+	if _, err := SendMessage(db, conversationID, userID, "Hello?"); err == nil || err.Error() != "everyone else left this conversation" {
+		t.Errorf("Expected everyone else left this conversation error, got %v", err)
+	}
+
+	// Writing again starts a new conversation
+	newID, err := StartConversation(db, userID, []string{"otheruser"}, "Hello again")
+	if err != nil || newID == conversationID {
+		t.Fatalf("Expected a new conversation, got %d (%v)", newID, err)
+	}
+
+	// Once everybody left, the conversation is gone
+	if err := LeaveConversation(db, conversationID, userID); err != nil {
+		t.Fatalf("Failed to leave conversation: %v", err)
+	}
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM private_messages WHERE conversation_id = ?", conversationID).Scan(&count)
+	if count != 0 {
+		t.Errorf("Expected the messages to be removed, got %d", count)
+	}
+}
+
+func TestMessagingBlocks(t *testing.T) {
+	db, cleanup, userID := setupPostTestDB(t)
+	defer cleanup()
+
+	otherID, err := CreateUser(db, "otheruser", "other@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	conversationID, err := StartConversation(db, userID, []string{"otheruser"}, "Hello")
+	if err != nil {
+		t.Fatalf("Failed to start conversation: %v", err)
+	}
+	if _, err := ToggleBlock(db, otherID, userID); err != nil {
+		t.Fatalf("Failed to block: %v", err)
+	}
+
+	// Neither side can write while one blocks the other
+	if _, err := SendMessage(db, conversationID, userID, "Hello?"); err == nil || err.Error() != "you cannot message users you blocked or who blocked you" {
+		t.Errorf("Expected a block error for the blocked user, got %v", err)
+	}
+	if _, err := SendMessage(db, conversationID, otherID, "Go away"); err == nil || err.Error() != "you cannot message users you blocked or who blocked you" {
+		t.Errorf("Expected a block error for the blocker, got %v", err)
+	}
+	if _, err := StartConversation(db, userID, []string{"otheruser"}, "Hi"); err == nil || err.Error() != "you cannot message users you blocked or who blocked you" {
+		t.Errorf("Expected a block error for a new conversation, got %v", err)
+	}
+}
+
+func TestMessageReports(t *testing.T) {
+	db, cleanup, userID := setupPostTestDB(t)
+	defer cleanup()
+
+	otherID, err := CreateUser(db, "otheruser", "other@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	moderatorID, err := CreateUser(db, "moderator", "moderator@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if err := SetUserRole(db, moderatorID, RoleModerator); err != nil {
+		t.Fatalf("Failed to set role: %v", err)
+	}
+	moderator, _ := GetUserByID(db, moderatorID)
+	user, _ := GetUserByID(db, userID)
+
+	conversationID, err := StartConversation(db, userID, []string{"otheruser"}, "Something rude")
+	if err != nil {
+		t.Fatalf("Failed to start conversation: %v", err)
+	}
+	if _, err := SendMessage(db, conversationID, userID, "Something private"); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	messages, _, _ := GetConversationMessages(db, conversationID, 10, 0)
+	rudeID := messages[0].ID
+
+	// Moderators are not members, so they cannot read the conversation
+	if _, err := GetConversation(db, conversationID, moderatorID); err == nil {
+		t.Error("Expected moderators not to see the conversation")
+	}
+
+	if err := ReportMessage(db, rudeID, userID, ""); err == nil || err.Error() != "you cannot report your own message" {
+		t.Errorf("Expected you cannot report your own message error, got %v", err)
+	}
+	if err := ReportMessage(db, rudeID, moderatorID, ""); err == nil || err.Error() != "message not found" {
+		t.Errorf("Expected message not found error for a non-member, got %v", err)
+	}
+	if err := ReportMessage(db, rudeID, otherID, "Insulting"); err != nil {
+		t.Fatalf("Failed to report message: %v", err)
+	}
+	if err := ReportMessage(db, rudeID, otherID, "Insulting"); err == nil || err.Error() != "you already reported this message" {
+		t.Errorf("Expected you already reported this message error, got %v", err)
+	}
+
+	if _, _, err := GetMessageReports(db, user, 10, 0); err == nil || err.Error() != "only moderators can review reports" {
+		t.Errorf("Expected only moderators can review reports error, got %v", err)
+	}
+	reports, total, err := GetMessageReports(db, moderator, 10, 0)
+	if err != nil {
+		t.Fatalf("Failed to get reports: %v", err)
+	}
+	// Only the reported message is shown
+	if total != 1 || len(reports) != 1 || reports[0].Message.Content != "Something rude" || reports[0].Reporter != "otheruser" || reports[0].Reason != "Insulting" {
+		t.Fatalf("Unexpected reports %+v", reports)
+	}
+
+	// Reported messages outlive the conversation until moderators are done with them
+	LeaveConversation(db, conversationID, userID)
+	LeaveConversation(db, conversationID, otherID)
+	if reports, _, _ := GetMessageReports(db, moderator, 10, 0); len(reports) != 1 {
+		t.Errorf("Expected the report to remain, got %d", len(reports))
+	}
+
+	if err := ResolveMessageReport(db, reports[0].ID, moderator); err != nil {
+		t.Fatalf("Failed to resolve report: %v", err)
+	}
+	if err := ResolveMessageReport(db, reports[0].ID, moderator); err == nil || err.Error() != "report not found" {
+		t.Errorf("Expected report not found error, got %v", err)
+	}
+	if _, total, _ := GetMessageReports(db, moderator, 10, 0); total != 0 {
+		t.Errorf("Expected no open reports, got %d", total)
+	}
+}
@@ -0,0 +1,301 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Notification types
+const (
+	NotifyComment  = "comment"  // new comment on a followed post
+	NotifyReply    = "reply"    // new comment on a followed post the recipient commented on
+	NotifyPost     = "post"     // new post in a followed category or by a followed user
+	NotifyReaction = "reaction" // like or dislike on the recipient's post or comment
+	NotifyMention  = "mention"  // the recipient was mentioned with @username
+)
+
+type Notification struct {
+	ID           int64
+	UserID       int64 // recipient
+	ActorID      int64
+	ActorName    string
+	ActorAvatar  int // avatar version of the actor
+	Type         string
+	PostID       int64
+	PostTitle    string
+	CommentID    int64  // 0 unless the notification is about a comment
+	CategoryName string // followed category a new post appeared in
+	categoryID   int64
+	Reaction     int // 1 for like, -1 for dislike
+	Read         bool
+	CreatedAt    time.Time
+}
+
+// Message describes the notification for display
+func (n Notification) Message() string {
+	switch n.Type {
+	case NotifyComment:
+		return fmt.Sprintf("%s commented on %q", n.ActorName, n.PostTitle)
+	case NotifyReply:
+		return fmt.Sprintf("%s replied after your comment on %q", n.ActorName, n.PostTitle)
+	case NotifyPost:
+		if n.CategoryName != "" {
+			return fmt.Sprintf("%s posted %q in %s", n.ActorName, n.PostTitle, n.CategoryName)
+		}
+		return fmt.Sprintf("%s posted %q", n.ActorName, n.PostTitle)
+	case NotifyMention:
+		if n.CommentID > 0 {
+			return fmt.Sprintf("%s mentioned you in a comment on %q", n.ActorName, n.PostTitle)
+		}
+		return fmt.Sprintf("%s mentioned you in %q", n.ActorName, n.PostTitle)
+	case NotifyReaction:
+		verb := "liked"
+		if n.Reaction < 0 {
+			verb = "disliked"
+		}
+		if n.CommentID > 0 {
+			return fmt.Sprintf("%s %s your comment on %q", n.ActorName, verb, n.PostTitle)
+		}
+		return fmt.Sprintf("%s %s your post %q", n.ActorName, verb, n.PostTitle)
+	}
+	return fmt.Sprintf("New activity on %q", n.PostTitle)
+}
+
+// URL is the page the notification is about
+func (n Notification) URL() string {
+	if n.CommentID > 0 {
+		return fmt.Sprintf("/post/%d#comment-%d", n.PostID, n.CommentID)
+	}
+	return fmt.Sprintf("/post/%d", n.PostID)
+}
+
+// NotifyNewComment notifies the followers of a post about a new comment
+func NotifyNewComment(db *sql.DB, postID, commentID, actorID int64) error {
+	rows, err := db.Query(`
+		SELECT f.user_id,
+		EXISTS(SELECT 1 FROM comments c WHERE c.post_id = f.target_id AND c.user_id = f.user_id AND c.id != ?)
+		FROM follows f
+		WHERE f.target_type = 'post' AND f.target_id = ? AND f.user_id != ?
+	`, commentID, postID, actorID)
+	if err != nil {
+		return err
+	}
+
+	var notifications []Notification
+	for rows.Next() {
+		n := Notification{ActorID: actorID, Type: NotifyComment, PostID: postID, CommentID: commentID}
+		var commented bool
+		if err := rows.Scan(&n.UserID, &commented); err != nil {
+			rows.Close()
+			return err
+		}
+		if commented {
+			n.Type = NotifyReply
+		}
+		notifications = append(notifications, n)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return createNotifications(db, notifications)
+}
+
+// NotifyNewPost notifies the followers of the post's categories and of its author about a new post
+func NotifyNewPost(db *sql.DB, postID, actorID int64) error {
+	rows, err := db.Query(`
+		SELECT f.user_id, COALESCE(MIN(CASE WHEN f.target_type = 'category' THEN f.target_id END), 0)
+		FROM follows f
+		WHERE ((f.target_type = 'category' AND f.target_id IN (SELECT category_id FROM post_categories WHERE post_id = ?))
+			OR (f.target_type = 'user' AND f.target_id = ?))
+		AND f.user_id != ?
+		GROUP BY f.user_id
+	`, postID, actorID, actorID)
+	if err != nil {
+		return err
+	}
+
+	var notifications []Notification
+	for rows.Next() {
+		n := Notification{ActorID: actorID, Type: NotifyPost, PostID: postID}
+		if err := rows.Scan(&n.UserID, &n.categoryID); err != nil {
+			rows.Close()
+			return err
+		}
+		notifications = append(notifications, n)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return createNotifications(db, notifications)
+}
+
+// NotifyNewReaction notifies the author of a post or comment about a user's reaction to it.
+// targetType is "post" or "comment". When the reaction was taken back, its unread
+// notification is withdrawn instead.
+func NotifyNewReaction(db *sql.DB, actorID int64, targetType string, targetID int64) error {
+	var ownerQuery, reactionQuery string
+	switch targetType {
+	case "post":
+		ownerQuery = "SELECT user_id, id FROM posts WHERE id = ?"
+		reactionQuery = "SELECT reaction FROM post_reactions WHERE post_id = ? AND user_id = ?"
+	case "comment":
+		ownerQuery = "SELECT user_id, post_id FROM comments WHERE id = ?"
+		reactionQuery = "SELECT reaction FROM comment_reactions WHERE comment_id = ? AND user_id = ?"
+	default:
+		return errors.New("invalid reaction target")
+	}
+
+	n := Notification{ActorID: actorID, Type: NotifyReaction}
+	if err := db.QueryRow(ownerQuery, targetID).Scan(&n.UserID, &n.PostID); err != nil {
+		if err == sql.ErrNoRows {
+			return errors.New(targetType + " not found")
+		}
+		return err
+	}
+	if targetType == "comment" {
+		n.CommentID = targetID
+	}
+	if n.UserID == actorID {
+		return nil
+	}
+
+	// Only the latest reaction of a user on something is worth a notification
+	_, err := db.Exec(`
+		DELETE FROM notifications
+		WHERE user_id = ? AND actor_id = ? AND type = ? AND post_id = ? AND comment_id = ? AND read_at IS NULL
+	`, n.UserID, actorID, NotifyReaction, n.PostID, n.CommentID)
+	if err != nil {
+		return err
+	}
+
+	err = db.QueryRow(reactionQuery, targetID, actorID).Scan(&n.Reaction)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return createNotifications(db, []Notification{n})
+}
+
+// createNotifications stores notifications, leaving out those whose recipient blocked the actor
+func createNotifications(db *sql.DB, notifications []Notification) error {
+	if len(notifications) == 0 {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, n := range notifications {
+		_, err := tx.Exec(`
+			INSERT INTO notifications (user_id, actor_id, type, post_id, comment_id, category_id, reaction, email_pending)
+			SELECT ?1, ?2, ?3, ?4, ?5, ?6, ?7, 1
+			WHERE NOT EXISTS (SELECT 1 FROM user_blocks WHERE user_id = ?1 AND blocked_id = ?2)
+		`, n.UserID, n.ActorID, n.Type, n.PostID, n.CommentID, n.categoryID, n.Reaction)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetNotifications retrieves a page of a user's notifications, newest first, and their total number
+func GetNotifications(db *sql.DB, userID int64, limit, offset int) ([]Notification, int, error) {
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM notifications WHERE user_id = ?", userID).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	notifications, err := queryNotifications(db, `
+		WHERE n.user_id = ?
+		ORDER BY n.created_at DESC, n.id DESC
+		LIMIT ? OFFSET ?
+	`, userID, limit, offset)
+	return notifications, total, err
+}
+
+// queryNotifications retrieves notifications with the names they refer to. The query
+// continues the SELECT with WHERE and ORDER clauses on the notifications table n.
+func queryNotifications(db *sql.DB, query string, args ...interface{}) ([]Notification, error) {
+	rows, err := db.Query(`
+		SELECT n.id, n.user_id, n.actor_id, COALESCE(u.username, ?), COALESCE(u.avatar_version, 0), n.type, n.post_id, COALESCE(p.title, ''),
+			n.comment_id, COALESCE(c.name, ''), n.reaction, n.read_at IS NOT NULL, n.created_at
+		FROM notifications n
+		LEFT JOIN users u ON u.id = n.actor_id
+		LEFT JOIN posts p ON p.id = n.post_id
+		LEFT JOIN categories c ON c.id = n.category_id
+		`+query, append([]interface{}{DeletedUsername}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notifications []Notification
+	for rows.Next() {
+		var n Notification
+		if err := rows.Scan(
+			&n.ID, &n.UserID, &n.ActorID, &n.ActorName, &n.ActorAvatar, &n.Type, &n.PostID, &n.PostTitle,
+			&n.CommentID, &n.CategoryName, &n.Reaction, &n.Read, &n.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+// GetNotification retrieves one of a user's notifications
+func GetNotification(db *sql.DB, userID, notificationID int64) (*Notification, error) {
+	var n Notification
+	err := db.QueryRow(
+		"SELECT id, user_id, type, post_id, comment_id, read_at IS NOT NULL FROM notifications WHERE id = ? AND user_id = ?",
+		notificationID, userID,
+	).Scan(&n.ID, &n.UserID, &n.Type, &n.PostID, &n.CommentID, &n.Read)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("notification not found")
+		}
+		return nil, err
+	}
+	return &n, nil
+}
+
+// CountUnreadNotifications returns how many notifications a user has not read
+func CountUnreadNotifications(db *sql.DB, userID int64) (int, error) {
+	var count int
+	err := db.QueryRow(
+		"SELECT COUNT(*) FROM notifications WHERE user_id = ? AND read_at IS NULL",
+		userID,
+	).Scan(&count)
+	return count, err
+}
+
+// MarkNotificationRead marks one of a user's notifications as read
+func MarkNotificationRead(db *sql.DB, userID, notificationID int64) error {
+	_, err := db.Exec(
+		"UPDATE notifications SET read_at = ? WHERE id = ? AND user_id = ? AND read_at IS NULL",
+		time.Now().UTC(), notificationID, userID,
+	)
+	return err
+}
+
+// MarkAllNotificationsRead marks all of a user's notifications as read
+func MarkAllNotificationsRead(db *sql.DB, userID int64) error {
+	_, err := db.Exec(
+		"UPDATE notifications SET read_at = ? WHERE user_id = ? AND read_at IS NULL",
+		time.Now().UTC(), userID,
+	)
+	return err
+}
@@ -0,0 +1,222 @@
+package models
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCommentNotifications(t *testing.T) {
+	db, cleanup, userID := setupPostTestDB(t)
+	defer cleanup()
+
+	otherID, err := CreateUser(db, "otheruser", "other@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	postID, err := CreatePost(db, "Test Post", "Test content", userID, []int64{1})
+	if err != nil {
+		t.Fatalf("Failed to create post: %v", err)
+	}
+	if err := FollowTarget(db, userID, FollowPost, postID); err != nil {
+		t.Fatalf("Failed to follow post: %v", err)
+	}
+
+	// The author hears about a new comment, the commenter does not
+	commentID, err := CreateComment(db, "First", otherID, postID)
+	if err != nil {
+		t.Fatalf("Failed to create comment: %v", err)
+	}
+	if err := FollowTarget(db, otherID, FollowPost, postID); err != nil {
+		t.Fatalf("Failed to follow post: %v", err)
+	}
+	if err := NotifyNewComment(db, postID, commentID, otherID); err != nil {
+		t.Fatalf("Failed to notify: %v", err)
+	}
+
+	notifications, total, err := GetNotifications(db, userID, 10, 0)
+	if err != nil {
+		t.Fatalf("Failed to get notifications: %v", err)
+	}
+	if total != 1 || notifications[0].Type != NotifyComment || notifications[0].ActorName != "otheruser" {
+		t.Fatalf("Expected a comment notification from otheruser, got %+v", notifications)
+	}
+	if notifications[0].URL() != fmt.Sprintf("/post/%d#comment-%d", postID, commentID) {
+		t.Errorf("Unexpected notification URL %q", notifications[0].URL())
+	}
+	if n, _ := CountUnreadNotifications(db, otherID); n != 0 {
+		t.Errorf("Expected no notifications for the commenter, got %d", n)
+	}
+
+	// A comment after the other user's comment is a reply for them
+	replyID, err := CreateComment(db, "Second", userID, postID)
+	if err != nil {
+		t.Fatalf("Failed to create comment: %v", err)
+	}
+	if err := NotifyNewComment(db, postID, replyID, userID); err != nil {
+		t.Fatalf("Failed to notify: %v", err)
+	}
+	notifications, _, err = GetNotifications(db, otherID, 10, 0)
+	if err != nil {
+		t.Fatalf("Failed to get notifications: %v", err)
+	}
+	if len(notifications) != 1 || notifications[0].Type != NotifyReply {
+		t.Errorf("Expected a reply notification, got %+v", notifications)
+	}
+}
+
+func TestPostNotifications(t *testing.T) {
+	db, cleanup, userID := setupPostTestDB(t)
+	defer cleanup()
+
+	otherID, err := CreateUser(db, "otheruser", "other@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	categoryID, err := CreateCategory(db, "General")
+	if err != nil {
+		t.Fatalf("Failed to create category: %v", err)
+	}
+	if err := FollowTarget(db, otherID, FollowCategory, categoryID); err != nil {
+		t.Fatalf("Failed to follow category: %v", err)
+	}
+	if err := FollowTarget(db, otherID, FollowUser, userID); err != nil {
+		t.Fatalf("Failed to follow user: %v", err)
+	}
+
+	// Following both the category and the author gives one notification
+	postID, err := CreatePost(db, "Test Post", "Test content", userID, []int64{categoryID, 2})
+	if err != nil {
+		t.Fatalf("Failed to create post: %v", err)
+	}
+	if err := NotifyNewPost(db, postID, userID); err != nil {
+		t.Fatalf("Failed to notify: %v", err)
+	}
+
+	notifications, total, err := GetNotifications(db, otherID, 10, 0)
+	if err != nil {
+		t.Fatalf("Failed to get notifications: %v", err)
+	}
+	if total != 1 || notifications[0].Type != NotifyPost || notifications[0].CategoryName != "General" {
+		t.Fatalf("Expected one post notification with its category, got %+v", notifications)
+	}
+	if n, _ := CountUnreadNotifications(db, userID); n != 0 {
+		t.Errorf("Expected no notifications for the author, got %d", n)
+	}
+}
+
+func TestReactionNotifications(t *testing.T) {
+	db, cleanup, userID := setupPostTestDB(t)
+	defer cleanup()
+
+	otherID, err := CreateUser(db, "otheruser", "other@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	postID, err := CreatePost(db, "Test Post", "Test content", userID, []int64{1})
+	if err != nil {
+		t.Fatalf("Failed to create post: %v", err)
+	}
+	commentID, err := CreateComment(db, "Test comment", userID, postID)
+	if err != nil {
+		t.Fatalf("Failed to create comment: %v", err)
+	}
+
+	react := func(targetType string, targetID int64, reaction int) {
+		t.Helper()
+		if targetType == "post" {
+			err = ReactToPost(db, targetID, otherID, reaction)
+		} else {
+			err = ReactToComment(db, targetID, otherID, reaction)
+		}
+		if err != nil {
+			t.Fatalf("Failed to react: %v", err)
+		}
+		if err := NotifyNewReaction(db, otherID, targetType, targetID); err != nil {
+			t.Fatalf("Failed to notify: %v", err)
+		}
+	}
+
+	// Changing a reaction replaces its unread notification
+	react("post", postID, 1)
+	react("post", postID, -1)
+	react("comment", commentID, 1)
+
+	notifications, total, err := GetNotifications(db, userID, 10, 0)
+	if err != nil {
+		t.Fatalf("Failed to get notifications: %v", err)
+	}
+	if total != 2 {
+		t.Fatalf("Expected 2 notifications, got %d: %+v", total, notifications)
+	}
+	for _, n := range notifications {
+		if n.CommentID == 0 && n.Reaction != -1 {
+			t.Errorf("Expected the post notification to be a dislike, got %+v", n)
+		}
+	}
+
+	// Taking a reaction back withdraws the notification
+	react("comment", commentID, 1)
+	if n, _ := CountUnreadNotifications(db, userID); n != 1 {
+		t.Errorf("Expected 1 unread notification, got %d", n)
+	}
+
+	// Reacting to your own content notifies nobody
+	if err := NotifyNewReaction(db, userID, "post", postID); err != nil {
+		t.Fatalf("Failed to notify: %v", err)
+	}
+	if err := NotifyNewReaction(db, otherID, "user", userID); err == nil || err.Error() != "invalid reaction target" {
+		t.Errorf("Expected 'invalid reaction target', got %v", err)
+	}
+}
+
+func TestMarkNotificationsRead(t *testing.T) {
+	db, cleanup, userID := setupPostTestDB(t)
+	defer cleanup()
+
+	otherID, err := CreateUser(db, "otheruser", "other@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if err := FollowTarget(db, otherID, FollowUser, userID); err != nil {
+		t.Fatalf("Failed to follow user: %v", err)
+	}
+	for _, title := range []string{"First", "Second", "Third"} {
+		postID, err := CreatePost(db, title, "Content", userID, []int64{1})
+		if err != nil {
+			t.Fatalf("Failed to create post: %v", err)
+		}
+		if err := NotifyNewPost(db, postID, userID); err != nil {
+			t.Fatalf("Failed to notify: %v", err)
+		}
+	}
+
+	notifications, _, err := GetNotifications(db, otherID, 10, 0)
+	if err != nil {
+		t.Fatalf("Failed to get notifications: %v", err)
+	}
+
+	// Test that users cannot read each other's notifications
+	if _, err := GetNotification(db, userID, notifications[0].ID); err == nil || err.Error() != "notification not found" {
+		t.Errorf("Expected 'notification not found', got %v", err)
+	}
+	if err := MarkNotificationRead(db, userID, notifications[0].ID); err != nil {
+		t.Fatalf("Failed to mark notification read: %v", err)
+	}
+	if n, _ := CountUnreadNotifications(db, otherID); n != 3 {
+		t.Errorf("Expected 3 unread notifications, got %d", n)
+	}
+
+	if err := MarkNotificationRead(db, otherID, notifications[0].ID); err != nil {
+		t.Fatalf("Failed to mark notification read: %v", err)
+	}
+	if n, _ := CountUnreadNotifications(db, otherID); n != 2 {
+		t.Errorf("Expected 2 unread notifications, got %d", n)
+	}
+
+	if err := MarkAllNotificationsRead(db, otherID); err != nil {
+		t.Fatalf("Failed to mark notifications read: %v", err)
+	}
+	if n, _ := CountUnreadNotifications(db, otherID); n != 0 {
+		t.Errorf("Expected no unread notifications, got %d", n)
+	}
+}
@@ -0,0 +1,353 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Limits on polls
+const (
+	MinPollOptions        = 2
+	MaxPollOptions        = 10
+	MaxPollQuestionLength = 300
+	MaxPollOptionLength   = 200
+)
+
+type Poll struct {
+	ID                int64
+	PostID            int64
+	CreatorID         int64 // author of the post
+	Question          string
+	MultipleChoice    bool
+	ResultsBeforeVote bool      // results are shown to users who have not voted
+	ClosesAt          time.Time // zero when the poll has no end date
+	ClosedAt          time.Time // set when closed early
+	CreatedAt         time.Time
+	Options           []PollOption
+	Voters            int  // number of users who voted
+	HasVoted          bool // whether the current user voted
+}
+
+type PollOption struct {
+	ID      int64
+	Text    string
+	Votes   int
+	Percent int  // share of voters who chose the option
+	Chosen  bool // chosen by the current user
+}
+
+// IsClosed reports whether the poll no longer takes votes
+func (p *Poll) IsClosed() bool {
+	if !p.ClosedAt.IsZero() {
+		return true
+	}
+	return !p.ClosesAt.IsZero() && !time.Now().Before(p.ClosesAt)
+}
+
+// ShowResults reports whether the current user may see the vote counts
+func (p *Poll) ShowResults() bool {
+	return p.ResultsBeforeVote || p.HasVoted || p.IsClosed()
+}
+
+// CanClose reports whether a user may close the poll early: its creator or a moderator
+func (p *Poll) CanClose(u *User) bool {
+	return u != nil && !p.IsClosed() && (u.ID == p.CreatorID || u.IsModerator())
+}
+
+// Validate returns the problems that prevent the poll from being created
+func (p *Poll) Validate() []string {
+	var problems []string
+	if len(p.Question) > MaxPollQuestionLength {
+		problems = append(problems, fmt.Sprintf("The poll question must be at most %d characters", MaxPollQuestionLength))
+	}
+	if len(p.Options) < MinPollOptions || len(p.Options) > MaxPollOptions {
+		problems = append(problems, fmt.Sprintf("A poll needs %d to %d options", MinPollOptions, MaxPollOptions))
+	}
+
+	seen := make(map[string]bool)
+	for _, option := range p.Options {
+		text := strings.TrimSpace(option.Text)
+		if text == "" {
+			problems = append(problems, "Poll options cannot be empty")
+			break
+		}
+		if len(text) > MaxPollOptionLength {
+			problems = append(problems, fmt.Sprintf("Poll options must be at most %d characters", MaxPollOptionLength))
+			break
+		}
+		if seen[strings.ToLower(text)] {
+			problems = append(problems, "Poll options must be different")
+			break
+		}
+		seen[strings.ToLower(text)] = true
+	}
+
+	if !p.ClosesAt.IsZero() && !p.ClosesAt.After(time.Now()) {
+		problems = append(problems, "The poll closing time must be in the future")
+	}
+	return problems
+}
+
+// insertPoll adds a poll to a post as part of a transaction
+func insertPoll(tx *sql.Tx, postID int64, p *Poll) error {
+	var closesAt interface{}
+	if !p.ClosesAt.IsZero() {
+		closesAt = p.ClosesAt.UTC()
+	}
+
+	result, err := tx.Exec(
+		"INSERT INTO polls (post_id, question, multiple_choice, results_before_vote, closes_at) VALUES (?, ?, ?, ?, ?)",
+		postID, p.Question, p.MultipleChoice, p.ResultsBeforeVote, closesAt,
+	)
+	if err != nil {
+		return err
+	}
+	pollID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	for i, option := range p.Options {
+		_, err := tx.Exec(
+			"INSERT INTO poll_options (poll_id, position, text) VALUES (?, ?, ?)",
+			pollID, i, strings.TrimSpace(option.Text),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetPostPoll retrieves the poll of a post with its results as seen by the current user.
+// It returns nil when the post has no poll.
+func GetPostPoll(db *sql.DB, postID, currentUserID int64) (*Poll, error) {
+	poll, err := getPoll(db, "p.post_id = ?", postID, currentUserID)
+	if err != nil && err.Error() == "poll not found" {
+		return nil, nil
+	}
+	return poll, err
+}
+
+// GetPollByID retrieves a poll with its results as seen by the current user
+func GetPollByID(db *sql.DB, pollID, currentUserID int64) (*Poll, error) {
+	return getPoll(db, "p.id = ?", pollID, currentUserID)
+}
+
+func getPoll(db *sql.DB, where string, arg, currentUserID int64) (*Poll, error) {
+	var p Poll
+	var closesAt, closedAt sql.NullTime
+	err := db.QueryRow(`
+		SELECT p.id, p.post_id, posts.user_id, p.question, p.multiple_choice, p.results_before_vote,
+			p.closes_at, p.closed_at, p.created_at
+		FROM polls p
+		JOIN posts ON posts.id = p.post_id
+		WHERE `+where, arg,
+	).Scan(&p.ID, &p.PostID, &p.CreatorID, &p.Question, &p.MultipleChoice, &p.ResultsBeforeVote,
+		&closesAt, &closedAt, &p.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("poll not found")
+		}
+		return nil, err
+	}
+	if closesAt.Valid {
+		p.ClosesAt = closesAt.Time
+	}
+	if closedAt.Valid {
+		p.ClosedAt = closedAt.Time
+	}
+
+	rows, err := db.Query(`
+		SELECT o.id, o.text,
+			(SELECT COUNT(*) FROM poll_votes v WHERE v.option_id = o.id),
+			EXISTS(SELECT 1 FROM poll_votes v WHERE v.option_id = o.id AND v.user_id = ?)
+		FROM poll_options o
+		WHERE o.poll_id = ?
+		ORDER BY o.position ASC
+	`, currentUserID, p.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var option PollOption
+		if err := rows.Scan(&option.ID, &option.Text, &option.Votes, &option.Chosen); err != nil {
+			return nil, err
+		}
+		if option.Chosen {
+			p.HasVoted = true
+		}
+		p.Options = append(p.Options, option)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = db.QueryRow("SELECT COUNT(DISTINCT user_id) FROM poll_votes WHERE poll_id = ?", p.ID).Scan(&p.Voters)
+	if err != nil {
+		return nil, err
+	}
+	if p.Voters > 0 {
+		for i := range p.Options {
+			p.Options[i].Percent = (p.Options[i].Votes*100 + p.Voters/2) / p.Voters
+		}
+	}
+
+	return &p, nil
+}
+
+// VotePoll records a user's ballot, replacing any earlier one. Single-choice polls
+// take exactly one option.
+func VotePoll(db *sql.DB, pollID, userID int64, optionIDs []int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var multipleChoice bool
+	var closesAt, closedAt sql.NullTime
+	err = tx.QueryRow(
+		"SELECT multiple_choice, closes_at, closed_at FROM polls WHERE id = ?",
+		pollID,
+	).Scan(&multipleChoice, &closesAt, &closedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return errors.New("poll not found")
+		}
+		return err
+	}
+	if closedAt.Valid || (closesAt.Valid && !time.Now().Before(closesAt.Time)) {
+		return errors.New("the poll is closed")
+	}
+
+	chosen := make(map[int64]bool)
+	var unique []int64
+	for _, id := range optionIDs {
+		if !chosen[id] {
+			chosen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	if len(unique) == 0 {
+		return errors.New("choose an option to vote")
+	}
+	if !multipleChoice && len(unique) > 1 {
+		return errors.New("choose only one option")
+	}
+
+	for _, id := range unique {
+		var exists bool
+		err := tx.QueryRow(
+			"SELECT EXISTS(SELECT 1 FROM poll_options WHERE id = ? AND poll_id = ?)",
+			id, pollID,
+		).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return errors.New("invalid poll option")
+		}
+	}
+
+	// Replace the earlier ballot
+	_, err = tx.Exec("DELETE FROM poll_votes WHERE poll_id = ? AND user_id = ?", pollID, userID)
+	if err != nil {
+		return err
+	}
+	for _, id := range unique {
+		_, err := tx.Exec(
+			"INSERT INTO poll_votes (poll_id, option_id, user_id) VALUES (?, ?, ?)",
+			pollID, id, userID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ClosePoll stops a poll from taking votes
+func ClosePoll(db *sql.DB, pollID int64) error {
+	result, err := db.Exec(
+		"UPDATE polls SET closed_at = ? WHERE id = ? AND closed_at IS NULL",
+		time.Now().UTC(), pollID,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		var exists bool
+		if err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM polls WHERE id = ?)", pollID).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return errors.New("poll not found")
+		}
+		return errors.New("the poll is already closed")
+	}
+	return nil
+}
+
+// draftPoll is how a poll is kept with a draft until the draft is published
+type draftPoll struct {
+	Question          string     `json:"question"`
+	Options           []string   `json:"options"`
+	MultipleChoice    bool       `json:"multiple_choice"`
+	ResultsBeforeVote bool       `json:"results_before_vote"`
+	ClosesAt          *time.Time `json:"closes_at,omitempty"`
+}
+
+func encodeDraftPoll(p *Poll) (string, error) {
+	if p == nil {
+		return "", nil
+	}
+	stored := draftPoll{
+		Question:          p.Question,
+		Options:           []string{},
+		MultipleChoice:    p.MultipleChoice,
+		ResultsBeforeVote: p.ResultsBeforeVote,
+	}
+	for _, option := range p.Options {
+		stored.Options = append(stored.Options, option.Text)
+	}
+	if !p.ClosesAt.IsZero() {
+		closesAt := p.ClosesAt.UTC()
+		stored.ClosesAt = &closesAt
+	}
+	data, err := json.Marshal(stored)
+	return string(data), err
+}
+
+func decodeDraftPoll(s string) (*Poll, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var stored draftPoll
+	if err := json.Unmarshal([]byte(s), &stored); err != nil {
+		return nil, err
+	}
+	p := &Poll{
+		Question:          stored.Question,
+		MultipleChoice:    stored.MultipleChoice,
+		ResultsBeforeVote: stored.ResultsBeforeVote,
+	}
+	for _, text := range stored.Options {
+		p.Options = append(p.Options, PollOption{Text: text})
+	}
+	if stored.ClosesAt != nil {
+		p.ClosesAt = *stored.ClosesAt
+	}
+	return p, nil
+}
@@ -0,0 +1,221 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPollVoting(t *testing.T) {
+	db, cleanup, userID := setupPostTestDB(t)
+	defer cleanup()
+
+	voterID, err := CreateUser(db, "voter", "voter@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	poll := &Poll{
+		Question: "Favourite language?",
+		Options:  []PollOption{{Text: "Go"}, {Text: "Rust"}, {Text: "Python"}},
+	}
+	postID, err := CreatePostWithPoll(db, "Poll post", "Vote below", userID, []int64{1}, poll)
+	if err != nil {
+		t.Fatalf("Failed to create post: %v", err)
+	}
+
+	got, err := GetPostPoll(db, postID, voterID)
+	if err != nil {
+		t.Fatalf("Failed to get poll: %v", err)
+	}
+	if got == nil || len(got.Options) != 3 || got.Options[1].Text != "Rust" {
+		t.Fatalf("Expected poll with 3 options, got %+v", got)
+	}
+	if got.CreatorID != userID {
+		t.Errorf("Expected creator %d, got %d", userID, got.CreatorID)
+	}
+	if got.ShowResults() {
+		t.Error("Expected results to be hidden before voting")
+	}
+
+	// Test that a single-choice poll takes one option
+	if err := VotePoll(db, got.ID, voterID, []int64{got.Options[0].ID, got.Options[1].ID}); err == nil {
+		t.Error("Expected error voting for two options")
+	}
+
+	// Test voting, then changing the vote
+	if err := VotePoll(db, got.ID, voterID, []int64{got.Options[0].ID}); err != nil {
+		t.Fatalf("Failed to vote: %v", err)
+	}
+	if err := VotePoll(db, got.ID, voterID, []int64{got.Options[2].ID}); err != nil {
+		t.Fatalf("Failed to change vote: %v", err)
+	}
+	if err := VotePoll(db, got.ID, userID, []int64{got.Options[2].ID}); err != nil {
+		t.Fatalf("Failed to vote: %v", err)
+	}
+
+	got, err = GetPollByID(db, got.ID, voterID)
+	if err != nil {
+		t.Fatalf("Failed to get poll: %v", err)
+	}
+	if got.Voters != 2 {
+		t.Errorf("Expected 2 voters, got %d", got.Voters)
+	}
+	if got.Options[0].Votes != 0 || got.Options[2].Votes != 2 || got.Options[2].Percent != 100 {
+		t.Errorf("Expected both votes on the last option, got %+v", got.Options)
+	}
+	if !got.HasVoted || !got.Options[2].Chosen || !got.ShowResults() {
+		t.Error("Expected the voter's choice to be marked and results shown")
+	}
+
+	// Test that options of another poll are rejected
+	if err := VotePoll(db, got.ID, voterID, []int64{9999}); err == nil || err.Error() != "invalid poll option" {
+		t.Errorf("Expected 'invalid poll option', got %v", err)
+	}
+}
+
+func TestPollMultipleChoice(t *testing.T) {
+	db, cleanup, userID := setupPostTestDB(t)
+	defer cleanup()
+
+	poll := &Poll{MultipleChoice: true, Options: []PollOption{{Text: "A"}, {Text: "B"}}}
+	postID, err := CreatePostWithPoll(db, "Poll post", "Vote below", userID, []int64{1}, poll)
+	if err != nil {
+		t.Fatalf("Failed to create post: %v", err)
+	}
+	got, err := GetPostPoll(db, postID, userID)
+	if err != nil {
+		t.Fatalf("Failed to get poll: %v", err)
+	}
+
+	if err := VotePoll(db, got.ID, userID, []int64{got.Options[0].ID, got.Options[1].ID}); err != nil {
+		t.Fatalf("Failed to vote: %v", err)
+	}
+	if err := VotePoll(db, got.ID, userID, nil); err == nil {
+		t.Error("Expected error voting without options")
+	}
+
+	got, err = GetPostPoll(db, postID, userID)
+	if err != nil {
+		t.Fatalf("Failed to get poll: %v", err)
+	}
+	if got.Voters != 1 || got.Options[0].Votes != 1 || got.Options[1].Votes != 1 {
+		t.Errorf("Expected one voter choosing both options, got %d voters and %+v", got.Voters, got.Options)
+	}
+}
+
+func TestClosePoll(t *testing.T) {
+	db, cleanup, userID := setupPostTestDB(t)
+	defer cleanup()
+
+	otherID, err := CreateUser(db, "otheruser", "other@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	moderatorID, err := CreateUser(db, "moderator", "moderator@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if err := SetUserRole(db, moderatorID, RoleModerator); err != nil {
+		t.Fatalf("Failed to set role: %v", err)
+	}
+
+	poll := &Poll{Options: []PollOption{{Text: "Yes"}, {Text: "No"}}}
+	postID, err := CreatePostWithPoll(db, "Poll post", "Vote below", userID, []int64{1}, poll)
+	if err != nil {
+		t.Fatalf("Failed to create post: %v", err)
+	}
+	got, err := GetPostPoll(db, postID, userID)
+	if err != nil {
+		t.Fatalf("Failed to get poll: %v", err)
+	}
+
+	creator, _ := GetUserByID(db, userID)
+	other, _ := GetUserByID(db, otherID)
+	moderator, _ := GetUserByID(db, moderatorID)
+	if !got.CanClose(creator) || !got.CanClose(moderator) || got.CanClose(other) || got.CanClose(nil) {
+		t.Error("Expected only the creator and moderators to be able to close the poll")
+	}
+
+	if err := ClosePoll(db, got.ID); err != nil {
+		t.Fatalf("Failed to close poll: %v", err)
+	}
+	if err := ClosePoll(db, got.ID); err == nil || err.Error() != "the poll is already closed" {
+		t.Errorf("Expected 'the poll is already closed', got %v", err)
+	}
+	if err := VotePoll(db, got.ID, otherID, []int64{got.Options[0].ID}); err == nil || err.Error() != "the poll is closed" {
+		t.Errorf("Expected 'the poll is closed', got %v", err)
+	}
+
+	got, err = GetPostPoll(db, postID, otherID)
+	if err != nil {
+		t.Fatalf("Failed to get poll: %v", err)
+	}
+	if !got.IsClosed() || !got.ShowResults() {
+		t.Error("Expected closed poll to show its results")
+	}
+}
+
+func TestPollValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		poll  Poll
+		valid bool
+	}{
+		{"valid", Poll{Options: []PollOption{{Text: "A"}, {Text: "B"}}}, true},
+		{"one option", Poll{Options: []PollOption{{Text: "A"}}}, false},
+		{"duplicate options", Poll{Options: []PollOption{{Text: "A"}, {Text: "a"}}}, false},
+		{"closes in the past", Poll{Options: []PollOption{{Text: "A"}, {Text: "B"}}, ClosesAt: time.Now().Add(-time.Hour)}, false},
+		{"too many options", Poll{Options: make([]PollOption, MaxPollOptions+1)}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems := tt.poll.Validate()
+			if (len(problems) == 0) != tt.valid {
+				t.Errorf("Validate() = %v, expected valid = %v", problems, tt.valid)
+			}
+		})
+	}
+}
+
+func TestDraftPoll(t *testing.T) {
+	db, cleanup, userID := setupPostTestDB(t)
+	defer cleanup()
+
+	closesAt := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	draft := &Draft{
+		UserID:      userID,
+		Title:       "Draft with poll",
+		Content:     "Content",
+		CategoryIDs: []int64{1},
+		Poll: &Poll{
+			Question:       "Which?",
+			Options:        []PollOption{{Text: "This"}, {Text: "That"}},
+			MultipleChoice: true,
+			ClosesAt:       closesAt,
+		},
+	}
+	if err := SaveDraft(db, draft); err != nil {
+		t.Fatalf("Failed to save draft: %v", err)
+	}
+
+	saved, err := GetDraft(db, draft.ID, userID)
+	if err != nil {
+		t.Fatalf("Failed to get draft: %v", err)
+	}
+	if saved.Poll == nil || saved.Poll.Question != "Which?" || len(saved.Poll.Options) != 2 || !saved.Poll.ClosesAt.Equal(closesAt) {
+		t.Fatalf("Expected the poll to be kept with the draft, got %+v", saved.Poll)
+	}
+
+	postID, err := PublishDraft(db, saved)
+	if err != nil {
+		t.Fatalf("Failed to publish draft: %v", err)
+	}
+	poll, err := GetPostPoll(db, postID, userID)
+	if err != nil {
+		t.Fatalf("Failed to get poll: %v", err)
+	}
+	if poll == nil || !poll.MultipleChoice || poll.Options[1].Text != "That" {
+		t.Errorf("Expected the draft's poll on the post, got %+v", poll)
+	}
+}
@@ -2,6 +2,9 @@ package models
 
 import (
 	"database/sql"
+	"errors"
+	"html/template"
+	"strings"
 	"time"
 )
 
@@ -16,16 +19,41 @@ type Post struct {
 	Likes      int
 	Dislikes   int
 	UserReaction int // 1 for like, -1 for dislike, 0 for none
+	AvatarVersion int
+	Attachments []Attachment
+	ContentHTML template.HTML // rendered Markdown, filled in by handlers
+	Poll        *Poll         // nil unless the post has a poll, filled in by handlers
+	Bookmarked  bool          // saved by the current user, filled in by handlers
 }
 
 // CreatePost creates a new post in the database
 func CreatePost(db *sql.DB, title, content string, userID int64, categoryIDs []int64) (int64, error) {
+	return CreatePostWithPoll(db, title, content, userID, categoryIDs, nil)
+}
+
+// CreatePostWithPoll creates a new post together with its poll, if it has one
+func CreatePostWithPoll(db *sql.DB, title, content string, userID int64, categoryIDs []int64, poll *Poll) (int64, error) {
 	tx, err := db.Begin()
 	if err != nil {
 		return 0, err
 	}
 	defer tx.Rollback()
 
+	postID, err := insertPost(tx, title, content, userID, categoryIDs, poll)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	publishNewPost(postID, title, categoryIDs)
+
+	return postID, nil
+}
+
+// insertPost adds a post, its categories and its poll as part of a transaction
+func insertPost(tx *sql.Tx, title, content string, userID int64, categoryIDs []int64, poll *Poll) (int64, error) {
 	// Insert the post
 	result, err := tx.Exec(
 		"INSERT INTO posts (title, content, user_id) VALUES (?, ?, ?)",
@@ -51,8 +79,10 @@ func CreatePost(db *sql.DB, title, content string, userID int64, categoryIDs []i
 		}
 	}
 
-	if err = tx.Commit(); err != nil {
-		return 0, err
+	if poll != nil {
+		if err := insertPoll(tx, postID, poll); err != nil {
+			return 0, err
+		}
 	}
 
 	return postID, nil
@@ -66,13 +96,14 @@ func GetPostByID(db *sql.DB, postID int64, currentUserID int64) (*Post, error) {
 		SELECT p.id, p.title, p.content, p.user_id, u.username, p.created_at,
 		(SELECT COUNT(*) FROM post_reactions WHERE post_id = p.id AND reaction = 1) as likes,
 		(SELECT COUNT(*) FROM post_reactions WHERE post_id = p.id AND reaction = -1) as dislikes,
-		COALESCE((SELECT reaction FROM post_reactions WHERE post_id = p.id AND user_id = ?), 0) as user_reaction
+		COALESCE((SELECT reaction FROM post_reactions WHERE post_id = p.id AND user_id = ?), 0) as user_reaction,
+		u.avatar_version
 		FROM posts p
 		JOIN users u ON p.user_id = u.id
 		WHERE p.id = ?
 	`, currentUserID, postID).Scan(
 		&post.ID, &post.Title, &post.Content, &post.UserID, &post.Username, 
-		&post.CreatedAt, &post.Likes, &post.Dislikes, &post.UserReaction,
+		&post.CreatedAt, &post.Likes, &post.Dislikes, &post.UserReaction, &post.AvatarVersion,
 	)
 	if err != nil {
 		return nil, err
@@ -108,7 +139,8 @@ func GetPosts(db *sql.DB, currentUserID int64, categoryID int64, userID int64, l
 		SELECT p.id, p.title, p.content, p.user_id, u.username, p.created_at,
 		(SELECT COUNT(*) FROM post_reactions WHERE post_id = p.id AND reaction = 1) as likes,
 		(SELECT COUNT(*) FROM post_reactions WHERE post_id = p.id AND reaction = -1) as dislikes,
-		COALESCE((SELECT reaction FROM post_reactions WHERE post_id = p.id AND user_id = ?), 0) as user_reaction
+		COALESCE((SELECT reaction FROM post_reactions WHERE post_id = p.id AND user_id = ?), 0) as user_reaction,
+		u.avatar_version
 		FROM posts p
 		JOIN users u ON p.user_id = u.id
 	`
@@ -152,7 +184,7 @@ func GetPosts(db *sql.DB, currentUserID int64, categoryID int64, userID int64, l
 		var post Post
 		if err := rows.Scan(
 			&post.ID, &post.Title, &post.Content, &post.UserID, &post.Username, 
-			&post.CreatedAt, &post.Likes, &post.Dislikes, &post.UserReaction,
+			&post.CreatedAt, &post.Likes, &post.Dislikes, &post.UserReaction, &post.AvatarVersion,
 		); err != nil {
 			return nil, err
 		}
@@ -185,6 +217,125 @@ func GetPosts(db *sql.DB, currentUserID int64, categoryID int64, userID int64, l
 	return posts, nil
 }
 
+// Post list orders
+const (
+	PostSortNew = "new" // newest first
+	PostSortOld = "old" // oldest first
+	PostSortTop = "top" // most likes after dislikes are taken off
+)
+
+// PostFilter selects and orders posts for GetPostPage. Zero values select every post, newest first.
+type PostFilter struct {
+	CategoryID int64
+	AuthorID   int64
+	LikedBy    int64 // only posts this user liked
+	Sort       string
+}
+
+// GetPostPage retrieves a page of the posts matching a filter, and how many match in total
+func GetPostPage(db *sql.DB, currentUserID int64, filter PostFilter, limit, offset int) ([]Post, int, error) {
+	var conditions []string
+	var args []interface{}
+	if filter.CategoryID > 0 {
+		conditions = append(conditions, "p.id IN (SELECT post_id FROM post_categories WHERE category_id = ?)")
+		args = append(args, filter.CategoryID)
+	}
+	if filter.AuthorID > 0 {
+		conditions = append(conditions, "p.user_id = ?")
+		args = append(args, filter.AuthorID)
+	}
+	if filter.LikedBy > 0 {
+		conditions = append(conditions, "p.id IN (SELECT post_id FROM post_reactions WHERE user_id = ? AND reaction = 1)")
+		args = append(args, filter.LikedBy)
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var order string
+	switch filter.Sort {
+	case "", PostSortNew:
+		order = "p.created_at DESC, p.id DESC"
+	case PostSortOld:
+		order = "p.created_at ASC, p.id ASC"
+	case PostSortTop:
+		order = "(likes - dislikes) DESC, p.created_at DESC, p.id DESC"
+	default:
+		return nil, 0, errors.New("unknown sort order")
+	}
+
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM posts p"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.Query(`
+		SELECT p.id, p.title, p.content, p.user_id, u.username, p.created_at,
+		(SELECT COUNT(*) FROM post_reactions WHERE post_id = p.id AND reaction = 1) as likes,
+		(SELECT COUNT(*) FROM post_reactions WHERE post_id = p.id AND reaction = -1) as dislikes,
+		COALESCE((SELECT reaction FROM post_reactions WHERE post_id = p.id AND user_id = ?), 0) as user_reaction,
+		u.avatar_version
+		FROM posts p
+		JOIN users u ON p.user_id = u.id
+		`+where+`
+		ORDER BY `+order+`
+		LIMIT ? OFFSET ?
+	`, append(append([]interface{}{currentUserID}, args...), limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	posts := []Post{}
+	for rows.Next() {
+		var post Post
+		if err := rows.Scan(
+			&post.ID, &post.Title, &post.Content, &post.UserID, &post.Username,
+			&post.CreatedAt, &post.Likes, &post.Dislikes, &post.UserReaction, &post.AvatarVersion,
+		); err != nil {
+			return nil, 0, err
+		}
+		posts = append(posts, post)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	rows.Close()
+
+	for i := range posts {
+		posts[i].Categories, err = getPostCategories(db, posts[i].ID)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return posts, total, nil
+}
+
+// getPostCategories retrieves the categories of a post
+func getPostCategories(db *sql.DB, postID int64) ([]Category, error) {
+	rows, err := db.Query(`
+		SELECT c.id, c.name
+		FROM categories c
+		JOIN post_categories pc ON c.id = pc.category_id
+		WHERE pc.post_id = ?
+	`, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := []Category{}
+	for rows.Next() {
+		var category Category
+		if err := rows.Scan(&category.ID, &category.Name); err != nil {
+			return nil, err
+		}
+		categories = append(categories, category)
+	}
+	return categories, rows.Err()
+}
+
 // ReactToPost allows a user to like or dislike a post
 func ReactToPost(db *sql.DB, postID, userID int64, reaction int) error {
 	tx, err := db.Begin()
@@ -229,5 +380,9 @@ func ReactToPost(db *sql.DB, postID, userID int64, reaction int) error {
 		return err
 	}
 
-	return tx.Commit()
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	publishPostReactions(db, postID)
+	return nil
 }
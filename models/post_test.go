@@ -181,6 +181,71 @@ func TestGetPosts(t *testing.T) {
 	}
 }
 
+func TestGetPostPage(t *testing.T) {
+	db, cleanup, userID := setupPostTestDB(t)
+	defer cleanup()
+
+	otherID, err := CreateUser(db, "otheruser", "other@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	categoryID, err := CreateCategory(db, "Category 1")
+	if err != nil {
+		t.Fatalf("Failed to create category: %v", err)
+	}
+	otherCategoryID, err := CreateCategory(db, "Category 2")
+	if err != nil {
+		t.Fatalf("Failed to create category: %v", err)
+	}
+
+	first, _ := CreatePost(db, "First", "Content", userID, []int64{categoryID})
+	second, _ := CreatePost(db, "Second", "Content", otherID, []int64{categoryID, otherCategoryID})
+	third, _ := CreatePost(db, "Third", "Content", userID, []int64{otherCategoryID})
+	ReactToPost(db, second, userID, 1)
+	ReactToPost(db, second, otherID, 1)
+	ReactToPost(db, first, otherID, 1)
+	ReactToPost(db, third, otherID, -1)
+
+	// Pages of the newest posts, with the total count
+	posts, total, err := GetPostPage(db, userID, PostFilter{}, 2, 0)
+	if err != nil {
+		t.Fatalf("Failed to get posts: %v", err)
+	}
+	if total != 3 || len(posts) != 2 || posts[0].ID != third || posts[1].ID != second {
+		t.Fatalf("Unexpected first page %+v (total %d)", posts, total)
+	}
+	if len(posts[1].Categories) != 2 || posts[1].Likes != 2 || posts[1].UserReaction != 1 {
+		t.Errorf("Unexpected post details %+v", posts[1])
+	}
+	posts, _, _ = GetPostPage(db, userID, PostFilter{}, 2, 2)
+	if len(posts) != 1 || posts[0].ID != first {
+		t.Fatalf("Unexpected second page %+v", posts)
+	}
+
+	posts, _, _ = GetPostPage(db, userID, PostFilter{Sort: PostSortTop}, 10, 0)
+	if len(posts) != 3 || posts[0].ID != second || posts[1].ID != first || posts[2].ID != third {
+		t.Errorf("Unexpected top order %+v", posts)
+	}
+	posts, _, _ = GetPostPage(db, userID, PostFilter{Sort: PostSortOld}, 10, 0)
+	if len(posts) != 3 || posts[0].ID != first {
+		t.Errorf("Unexpected old order %+v", posts)
+	}
+
+	// Filters combine
+	posts, total, _ = GetPostPage(db, userID, PostFilter{CategoryID: categoryID, AuthorID: userID}, 10, 0)
+	if total != 1 || len(posts) != 1 || posts[0].ID != first {
+		t.Errorf("Unexpected filtered posts %+v", posts)
+	}
+	posts, total, _ = GetPostPage(db, 0, PostFilter{LikedBy: otherID}, 10, 0)
+	if total != 2 || len(posts) != 2 || posts[0].UserReaction != 0 {
+		t.Errorf("Unexpected liked posts %+v", posts)
+	}
+
+	if _, _, err := GetPostPage(db, userID, PostFilter{Sort: "random"}, 10, 0); err == nil || err.Error() != "unknown sort order" {
+		t.Errorf("Expected unknown sort order error, got %v", err)
+	}
+}
+
 func TestReactToPost(t *testing.T) {
 	db, cleanup, userID := setupPostTestDB(t)
 	defer cleanup()
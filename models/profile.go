@@ -0,0 +1,192 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Profile fields a user can hide from their public profile page
+const (
+	ProfileFieldJoined     = "joined"
+	ProfileFieldBio        = "bio"
+	ProfileFieldStats      = "stats"
+	ProfileFieldReputation = "reputation"
+	ProfileFieldPosts      = "posts"
+	ProfileFieldComments   = "comments"
+)
+
+// ProfileFields lists every hideable profile field in display order
+var ProfileFields = []string{
+	ProfileFieldJoined,
+	ProfileFieldBio,
+	ProfileFieldStats,
+	ProfileFieldReputation,
+	ProfileFieldPosts,
+	ProfileFieldComments,
+}
+
+// MaxBioLength is the maximum number of characters in a profile bio
+const MaxBioLength = 500
+
+type Profile struct {
+	UserID        int64
+	Username      string
+	Bio           string
+	CreatedAt     time.Time
+	PostCount     int
+	CommentCount  int
+	Reputation    int
+	AvatarVersion int
+	Hidden        map[string]bool
+}
+
+// ProfilePost is a short summary of a post shown on a profile page
+type ProfilePost struct {
+	ID        int64
+	Title     string
+	CreatedAt time.Time
+	Likes     int
+	Dislikes  int
+}
+
+// ProfileComment is a comment shown on a profile page with the title of its post
+type ProfileComment struct {
+	ID        int64
+	Content   string
+	PostID    int64
+	PostTitle string
+	CreatedAt time.Time
+}
+
+// GetProfileByUsername retrieves a user's public profile with counts and reputation
+func GetProfileByUsername(db *sql.DB, username string) (*Profile, error) {
+	var profile Profile
+	var hidden string
+	err := db.QueryRow(`
+		SELECT u.id, u.username, u.bio, u.hidden_profile_fields, u.created_at, u.avatar_version,
+		(SELECT COUNT(*) FROM posts WHERE user_id = u.id) as post_count,
+		(SELECT COUNT(*) FROM comments WHERE user_id = u.id) as comment_count,
+		(SELECT COALESCE(SUM(pr.reaction), 0) FROM post_reactions pr
+			JOIN posts p ON pr.post_id = p.id WHERE p.user_id = u.id) +
+		(SELECT COALESCE(SUM(cr.reaction), 0) FROM comment_reactions cr
+			JOIN comments c ON cr.comment_id = c.id WHERE c.user_id = u.id) as reputation
+		FROM users u
+		WHERE u.username = ?
+	`, username).Scan(
+		&profile.UserID, &profile.Username, &profile.Bio, &hidden, &profile.CreatedAt, &profile.AvatarVersion,
+		&profile.PostCount, &profile.CommentCount, &profile.Reputation,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("user not found")
+		}
+		return nil, err
+	}
+
+	profile.Hidden = parseHiddenFields(hidden)
+	return &profile, nil
+}
+
+// GetProfileSettings returns a user's bio and hidden profile fields for editing
+func GetProfileSettings(db *sql.DB, userID int64) (string, map[string]bool, error) {
+	var bio, hidden string
+	err := db.QueryRow(
+		"SELECT bio, hidden_profile_fields FROM users WHERE id = ?",
+		userID,
+	).Scan(&bio, &hidden)
+	if err != nil {
+		return "", nil, err
+	}
+	return bio, parseHiddenFields(hidden), nil
+}
+
+// UpdateProfile changes a user's bio and the profile fields hidden from other users
+func UpdateProfile(db *sql.DB, userID int64, bio string, hiddenFields []string) error {
+	bio = strings.TrimSpace(bio)
+	if len([]rune(bio)) > MaxBioLength {
+		return errors.New("bio is too long")
+	}
+
+	var hidden []string
+	for _, field := range ProfileFields {
+		for _, f := range hiddenFields {
+			if f == field {
+				hidden = append(hidden, field)
+				break
+			}
+		}
+	}
+
+	_, err := db.Exec(
+		"UPDATE users SET bio = ?, hidden_profile_fields = ? WHERE id = ?",
+		bio, strings.Join(hidden, ","), userID,
+	)
+	return err
+}
+
+// GetProfilePosts retrieves a page of a user's posts, newest first
+func GetProfilePosts(db *sql.DB, userID int64, limit, offset int) ([]ProfilePost, error) {
+	rows, err := db.Query(`
+		SELECT p.id, p.title, p.created_at,
+		(SELECT COUNT(*) FROM post_reactions WHERE post_id = p.id AND reaction = 1) as likes,
+		(SELECT COUNT(*) FROM post_reactions WHERE post_id = p.id AND reaction = -1) as dislikes
+		FROM posts p
+		WHERE p.user_id = ?
+		ORDER BY p.created_at DESC, p.id DESC
+		LIMIT ? OFFSET ?
+	`, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var posts []ProfilePost
+	for rows.Next() {
+		var post ProfilePost
+		if err := rows.Scan(&post.ID, &post.Title, &post.CreatedAt, &post.Likes, &post.Dislikes); err != nil {
+			return nil, err
+		}
+		posts = append(posts, post)
+	}
+
+	return posts, nil
+}
+
+// GetProfileComments retrieves a page of a user's comments, newest first
+func GetProfileComments(db *sql.DB, userID int64, limit, offset int) ([]ProfileComment, error) {
+	rows, err := db.Query(`
+		SELECT c.id, c.content, c.post_id, p.title, c.created_at
+		FROM comments c
+		JOIN posts p ON c.post_id = p.id
+		WHERE c.user_id = ?
+		ORDER BY c.created_at DESC, c.id DESC
+		LIMIT ? OFFSET ?
+	`, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []ProfileComment
+	for rows.Next() {
+		var comment ProfileComment
+		if err := rows.Scan(&comment.ID, &comment.Content, &comment.PostID, &comment.PostTitle, &comment.CreatedAt); err != nil {
+			return nil, err
+		}
+		comments = append(comments, comment)
+	}
+
+	return comments, nil
+}
+
+func parseHiddenFields(hidden string) map[string]bool {
+	fields := make(map[string]bool)
+	for _, field := range strings.Split(hidden, ",") {
+		if field != "" {
+			fields[field] = true
+		}
+	}
+	return fields
+}
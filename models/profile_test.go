@@ -0,0 +1,148 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestGetProfileByUsername(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	userID, err := CreateUser(db, "author", "author@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	readerID, err := CreateUser(db, "reader", "reader@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Failed to create second user: %v", err)
+	}
+
+	postID, err := CreatePost(db, "Author post", "Content", userID, []int64{})
+	if err != nil {
+		t.Fatalf("Failed to create post: %v", err)
+	}
+	commentID, err := CreateComment(db, "Author comment", userID, postID)
+	if err != nil {
+		t.Fatalf("Failed to create comment: %v", err)
+	}
+
+	// Two likes and one dislike on the author's content
+	if err := ReactToPost(db, postID, readerID, 1); err != nil {
+		t.Fatalf("Failed to react to post: %v", err)
+	}
+	if err := ReactToPost(db, postID, userID, 1); err != nil {
+		t.Fatalf("Failed to react to post: %v", err)
+	}
+	if err := ReactToComment(db, commentID, readerID, -1); err != nil {
+		t.Fatalf("Failed to react to comment: %v", err)
+	}
+
+	profile, err := GetProfileByUsername(db, "author")
+	if err != nil {
+		t.Fatalf("Failed to get profile: %v", err)
+	}
+	if profile.UserID != userID {
+		t.Errorf("Expected user ID %d, got %d", userID, profile.UserID)
+	}
+	if profile.PostCount != 1 || profile.CommentCount != 1 {
+		t.Errorf("Expected 1 post and 1 comment, got %d and %d", profile.PostCount, profile.CommentCount)
+	}
+	if profile.Reputation != 1 {
+		t.Errorf("Expected reputation 1, got %d", profile.Reputation)
+	}
+
+	// Test non-existent user
+	_, err = GetProfileByUsername(db, "nobody")
+	if err == nil {
+		t.Fatal("Expected error for non-existent user, got nil")
+	}
+}
+
+func TestUpdateProfile(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	userID, err := CreateUser(db, "testuser", "test@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+
+	// Unknown fields are ignored
+	err = UpdateProfile(db, userID, "  Hello there  ", []string{ProfileFieldBio, "password", ProfileFieldComments})
+	if err != nil {
+		t.Fatalf("Failed to update profile: %v", err)
+	}
+
+	profile, err := GetProfileByUsername(db, "testuser")
+	if err != nil {
+		t.Fatalf("Failed to get profile: %v", err)
+	}
+	if profile.Bio != "Hello there" {
+		t.Errorf("Expected bio 'Hello there', got '%s'", profile.Bio)
+	}
+	if len(profile.Hidden) != 2 || !profile.Hidden[ProfileFieldBio] || !profile.Hidden[ProfileFieldComments] {
+		t.Errorf("Unexpected hidden fields: %v", profile.Hidden)
+	}
+
+	// Test a bio that is too long
+	long := make([]byte, MaxBioLength+1)
+	for i := range long {
+		long[i] = 'a'
+	}
+	err = UpdateProfile(db, userID, string(long), nil)
+	if err == nil {
+		t.Fatal("Expected error for long bio, got nil")
+	}
+}
+
+func TestGetProfilePostsAndComments(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	userID, err := CreateUser(db, "testuser", "test@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+
+	var postID int64
+	for i := 0; i < 3; i++ {
+		postID, err = CreatePost(db, "Post", "Content", userID, []int64{})
+		if err != nil {
+			t.Fatalf("Failed to create post: %v", err)
+		}
+		if _, err := CreateComment(db, "Comment", userID, postID); err != nil {
+			t.Fatalf("Failed to create comment: %v", err)
+		}
+	}
+
+	// Test pagination
+	posts, err := GetProfilePosts(db, userID, 2, 0)
+	if err != nil {
+		t.Fatalf("Failed to get profile posts: %v", err)
+	}
+	if len(posts) != 2 {
+		t.Fatalf("Expected 2 posts on the first page, got %d", len(posts))
+	}
+	if posts[0].ID != postID {
+		t.Errorf("Expected newest post %d first, got %d", postID, posts[0].ID)
+	}
+
+	posts, err = GetProfilePosts(db, userID, 2, 2)
+	if err != nil {
+		t.Fatalf("Failed to get profile posts: %v", err)
+	}
+	if len(posts) != 1 {
+		t.Errorf("Expected 1 post on the second page, got %d", len(posts))
+	}
+
+	comments, err := GetProfileComments(db, userID, 10, 0)
+	if err != nil {
+		t.Fatalf("Failed to get profile comments: %v", err)
+	}
+	if len(comments) != 3 {
+		t.Fatalf("Expected 3 comments, got %d", len(comments))
+	}
+	if comments[0].PostTitle != "Post" {
+		t.Errorf("Expected post title 'Post', got '%s'", comments[0].PostTitle)
+	}
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+)
+
+// Target types of rendered content
+const (
+	RenderedPost    = "post"
+	RenderedComment = "comment"
+)
+
+// ContentRevision identifies a version of source text rendered by a given renderer version
+func ContentRevision(rendererVersion, content string) string {
+	sum := sha256.Sum256([]byte(rendererVersion + "\n" + content))
+	return hex.EncodeToString(sum[:])
+}
+
+// GetRenderedContent returns the cached HTML for a post or comment if it matches the revision
+func GetRenderedContent(db *sql.DB, targetType string, targetID int64, revision string) (string, bool, error) {
+	var html string
+	err := db.QueryRow(
+		"SELECT html FROM rendered_content WHERE target_type = ? AND target_id = ? AND revision = ?",
+		targetType, targetID, revision,
+	).Scan(&html)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return html, true, nil
+}
+
+// SaveRenderedContent stores the HTML of a post or comment, replacing older revisions
+func SaveRenderedContent(db *sql.DB, targetType string, targetID int64, revision, html string) error {
+	_, err := db.Exec(`
+		INSERT INTO rendered_content (target_type, target_id, revision, html)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(target_type, target_id) DO UPDATE SET revision = excluded.revision, html = excluded.html
+	`, targetType, targetID, revision, html)
+	return err
+}
@@ -0,0 +1,43 @@
+package models
+
+import "testing"
+
+func TestRenderedContent(t *testing.T) {
+	db, cleanup, _ := setupPostTestDB(t)
+	defer cleanup()
+
+	first := ContentRevision("1", "*hello*")
+	if _, found, err := GetRenderedContent(db, RenderedPost, 1, first); err != nil || found {
+		t.Fatalf("Expected empty cache, got found=%v err=%v", found, err)
+	}
+
+	if err := SaveRenderedContent(db, RenderedPost, 1, first, "<p><em>hello</em></p>"); err != nil {
+		t.Fatalf("Failed to save rendered content: %v", err)
+	}
+	html, found, err := GetRenderedContent(db, RenderedPost, 1, first)
+	if err != nil || !found {
+		t.Fatalf("Expected cached content, got found=%v err=%v", found, err)
+	}
+	if html != "<p><em>hello</em></p>" {
+		t.Errorf("Expected cached HTML, got %q", html)
+	}
+
+	// Test that a comment with the same ID is cached separately
+	if _, found, _ := GetRenderedContent(db, RenderedComment, 1, first); found {
+		t.Error("Expected no cached content for the comment")
+	}
+
+	// Test that a new revision replaces the old one
+	second := ContentRevision("1", "**hello**")
+	if err := SaveRenderedContent(db, RenderedPost, 1, second, "<p><strong>hello</strong></p>"); err != nil {
+		t.Fatalf("Failed to save rendered content: %v", err)
+	}
+	if _, found, _ := GetRenderedContent(db, RenderedPost, 1, first); found {
+		t.Error("Expected old revision to be replaced")
+	}
+
+	// Test that a renderer update invalidates the cache
+	if ContentRevision("2", "**hello**") == second {
+		t.Error("Expected revision to change with the renderer version")
+	}
+}
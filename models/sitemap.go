@@ -0,0 +1,50 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// SitemapPost is a post as listed in a sitemap
+type SitemapPost struct {
+	ID           int64
+	LastModified time.Time // when the post or its latest comment was made
+}
+
+// CountPosts returns the number of posts on the forum
+func CountPosts(db *sql.DB) (int, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM posts").Scan(&count)
+	return count, err
+}
+
+// GetSitemapPosts returns a page of posts, oldest first so pages keep their posts as new ones are made
+func GetSitemapPosts(db *sql.DB, limit, offset int) ([]SitemapPost, error) {
+	rows, err := db.Query(`
+		SELECT p.id, p.created_at, c.created_at
+		FROM posts p
+		LEFT JOIN comments c ON c.id = (
+			SELECT id FROM comments WHERE post_id = p.id ORDER BY created_at DESC, id DESC LIMIT 1
+		)
+		ORDER BY p.id
+		LIMIT ? OFFSET ?
+	`, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var posts []SitemapPost
+	for rows.Next() {
+		var post SitemapPost
+		var commented sql.NullTime
+		if err := rows.Scan(&post.ID, &post.LastModified, &commented); err != nil {
+			return nil, err
+		}
+		if commented.Valid && commented.Time.After(post.LastModified) {
+			post.LastModified = commented.Time
+		}
+		posts = append(posts, post)
+	}
+	return posts, rows.Err()
+}
@@ -0,0 +1,212 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// API token scopes
+const (
+	ScopeRead          = "read"
+	ScopeWritePosts    = "write:posts"
+	ScopeWriteComments = "write:comments"
+	ScopeReact         = "react"
+	ScopeAdmin         = "admin" // only admins can grant it
+)
+
+// TokenScopes lists the scopes a token can have
+var TokenScopes = []string{ScopeRead, ScopeWritePosts, ScopeWriteComments, ScopeReact, ScopeAdmin}
+
+// APITokenPrefix starts every token, so leaked tokens are easy to recognise
+const APITokenPrefix = "forum_"
+
+// Limits on API tokens
+const (
+	MaxAPITokens         = 20
+	MaxTokenNameLength   = 50
+	tokenDisplayLength   = len(APITokenPrefix) + 6
+	tokenLastUsedUpdates = time.Minute // last use is recorded at most this often
+)
+
+// APIToken is a personal access token for the JSON API. Only a hash of the token is stored.
+type APIToken struct {
+	ID         int64
+	UserID     int64
+	Name       string
+	Prefix     string // start of the token, to tell tokens apart
+	Scopes     []string
+	ExpiresAt  *time.Time // nil when the token does not expire
+	LastUsedAt *time.Time
+	CreatedAt  time.Time
+}
+
+// HasScope reports whether the token grants a scope
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired reports whether the token can no longer be used
+func (t *APIToken) Expired(now time.Time) bool {
+	return t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)
+}
+
+// hashAPIToken returns the hash stored for a token
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIToken adds a token for a user and returns it. This is the only time the token
+// itself is available. A zero expiresAt makes a token that does not expire.
+func CreateAPIToken(db *sql.DB, user *User, name string, scopes []string, expiresAt time.Time) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("token name is required")
+	}
+	if utf8.RuneCountInString(name) > MaxTokenNameLength {
+		return "", errors.New("token name is too long")
+	}
+	if len(scopes) == 0 {
+		return "", errors.New("choose at least one scope")
+	}
+
+	all := &APIToken{Scopes: TokenScopes}
+	chosen := &APIToken{Scopes: scopes}
+	for _, s := range scopes {
+		if !all.HasScope(s) {
+			return "", errors.New("unknown scope")
+		}
+	}
+	if chosen.HasScope(ScopeAdmin) && !user.IsAdmin() {
+		return "", errors.New("only admins can create admin tokens")
+	}
+	// Stored in the order of TokenScopes, without repeats
+	var list []string
+	for _, s := range TokenScopes {
+		if chosen.HasScope(s) {
+			list = append(list, s)
+		}
+	}
+
+	var expires interface{}
+	if !expiresAt.IsZero() {
+		if !expiresAt.After(time.Now()) {
+			return "", errors.New("expiry must be in the future")
+		}
+		expires = expiresAt.UTC()
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM api_tokens WHERE user_id = ?", user.ID).Scan(&count); err != nil {
+		return "", err
+	}
+	if count >= MaxAPITokens {
+		return "", errors.New("you have too many tokens, revoke one first")
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	token := APITokenPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	_, err := db.Exec(
+		"INSERT INTO api_tokens (user_id, name, token_hash, prefix, scopes, expires_at, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		user.ID, name, hashAPIToken(token), token[:tokenDisplayLength], strings.Join(list, ","), expires, time.Now().UTC(),
+	)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+const apiTokenColumns = "id, user_id, name, prefix, scopes, expires_at, last_used_at, created_at FROM api_tokens"
+
+func scanAPIToken(scanner interface{ Scan(...interface{}) error }) (*APIToken, error) {
+	var t APIToken
+	var scopes string
+	var expiresAt, lastUsedAt sql.NullTime
+	if err := scanner.Scan(&t.ID, &t.UserID, &t.Name, &t.Prefix, &scopes, &expiresAt, &lastUsedAt, &t.CreatedAt); err != nil {
+		return nil, err
+	}
+	t.Scopes = strings.Split(scopes, ",")
+	if expiresAt.Valid {
+		t.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		t.LastUsedAt = &lastUsedAt.Time
+	}
+	return &t, nil
+}
+
+// GetAPITokens retrieves a user's tokens, newest first
+func GetAPITokens(db *sql.DB, userID int64) ([]APIToken, error) {
+	rows, err := db.Query("SELECT "+apiTokenColumns+" WHERE user_id = ? ORDER BY id DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []APIToken
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *t)
+	}
+	return tokens, rows.Err()
+}
+
+// RevokeAPIToken deletes one of a user's tokens and returns its name
+func RevokeAPIToken(db *sql.DB, tokenID, userID int64) (string, error) {
+	var name string
+	err := db.QueryRow("SELECT name FROM api_tokens WHERE id = ? AND user_id = ?", tokenID, userID).Scan(&name)
+	if err == sql.ErrNoRows {
+		return "", errors.New("token not found")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	_, err = db.Exec("DELETE FROM api_tokens WHERE id = ?", tokenID)
+	return name, err
+}
+
+// AuthenticateAPIToken finds the token a client sent and records that it was used
+func AuthenticateAPIToken(db *sql.DB, token string, now time.Time) (*APIToken, error) {
+	if !strings.HasPrefix(token, APITokenPrefix) {
+		return nil, errors.New("invalid token")
+	}
+	t, err := scanAPIToken(db.QueryRow("SELECT "+apiTokenColumns+" WHERE token_hash = ?", hashAPIToken(token)))
+	if err == sql.ErrNoRows {
+		return nil, errors.New("invalid token")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if t.Expired(now) {
+		return nil, errors.New("token expired")
+	}
+
+	if t.LastUsedAt == nil || now.Sub(*t.LastUsedAt) >= tokenLastUsedUpdates {
+		if _, err := db.Exec("UPDATE api_tokens SET last_used_at = ? WHERE id = ?", now.UTC(), t.ID); err != nil {
+			return nil, err
+		}
+		used := now.UTC()
+		t.LastUsedAt = &used
+	}
+	return t, nil
+}
@@ -0,0 +1,111 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCreateAPIToken(t *testing.T) {
+	db, cleanup, userID := setupPostTestDB(t)
+	defer cleanup()
+
+	user, _ := GetUserByID(db, userID)
+	adminID, _ := CreateUser(db, "admin", "admin@example.com", "password123")
+	if err := SetUserRole(db, adminID, RoleAdmin); err != nil {
+		t.Fatalf("Failed to set role: %v", err)
+	}
+	admin, _ := GetUserByID(db, adminID)
+
+	for _, tc := range []struct {
+		name    string
+		scopes  []string
+		expires time.Time
+		want    string
+	}{
+		{" ", []string{ScopeRead}, time.Time{}, "token name is required"},
+		{strings.Repeat("a", MaxTokenNameLength+1), []string{ScopeRead}, time.Time{}, "token name is too long"},
+		{"script", nil, time.Time{}, "choose at least one scope"},
+		{"script", []string{"delete:everything"}, time.Time{}, "unknown scope"},
+		{"script", []string{ScopeAdmin}, time.Time{}, "only admins can create admin tokens"},
+		{"script", []string{ScopeRead}, time.Now().Add(-time.Hour), "expiry must be in the future"},
+	} {
+		if _, err := CreateAPIToken(db, user, tc.name, tc.scopes, tc.expires); err == nil || err.Error() != tc.want {
+			t.Errorf("Expected %s error, got %v", tc.want, err)
+		}
+	}
+
+	token, err := CreateAPIToken(db, user, " script ", []string{ScopeReact, ScopeRead, ScopeRead}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+	if !strings.HasPrefix(token, APITokenPrefix) {
+		t.Errorf("Expected the token to start with %s, got %s", APITokenPrefix, token)
+	}
+	if _, err := CreateAPIToken(db, admin, "admin script", []string{ScopeAdmin}, time.Time{}); err != nil {
+		t.Errorf("Expected admins to create admin tokens, got %v", err)
+	}
+
+	// Only the hash is stored
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM api_tokens WHERE token_hash = ? OR prefix = ?", token, token).Scan(&count)
+	if count != 0 {
+		t.Error("Expected the token not to be stored")
+	}
+
+	tokens, err := GetAPITokens(db, userID)
+	if err != nil {
+		t.Fatalf("Failed to get tokens: %v", err)
+	}
+	if len(tokens) != 1 || tokens[0].Name != "script" || strings.Join(tokens[0].Scopes, ",") != "read,react" ||
+		!strings.HasPrefix(token, tokens[0].Prefix) || tokens[0].ExpiresAt == nil || tokens[0].LastUsedAt != nil {
+		t.Fatalf("Unexpected tokens %+v", tokens)
+	}
+}
+
+func TestAuthenticateAPIToken(t *testing.T) {
+	db, cleanup, userID := setupPostTestDB(t)
+	defer cleanup()
+
+	user, _ := GetUserByID(db, userID)
+	token, err := CreateAPIToken(db, user, "script", []string{ScopeRead, ScopeWritePosts}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+
+	for _, bad := range []string{"", "forum_nope", token[len(APITokenPrefix):], token + "x"} {
+		if _, err := AuthenticateAPIToken(db, bad, time.Now()); err == nil || err.Error() != "invalid token" {
+			t.Errorf("Expected invalid token error for %q, got %v", bad, err)
+		}
+	}
+
+	now := time.Now()
+	apiToken, err := AuthenticateAPIToken(db, token, now)
+	if err != nil {
+		t.Fatalf("Failed to authenticate: %v", err)
+	}
+	if apiToken.UserID != userID || !apiToken.HasScope(ScopeWritePosts) || apiToken.HasScope(ScopeReact) {
+		t.Errorf("Unexpected token %+v", apiToken)
+	}
+	tokens, _ := GetAPITokens(db, userID)
+	if tokens[0].LastUsedAt == nil {
+		t.Error("Expected the last use to be recorded")
+	}
+
+	if _, err := AuthenticateAPIToken(db, token, now.Add(2*time.Hour)); err == nil || err.Error() != "token expired" {
+		t.Errorf("Expected token expired error, got %v", err)
+	}
+
+	// Revoked tokens stop working, and other users cannot revoke them
+	otherID, _ := CreateUser(db, "otheruser", "other@example.com", "password123")
+	if _, err := RevokeAPIToken(db, apiToken.ID, otherID); err == nil || err.Error() != "token not found" {
+		t.Errorf("Expected token not found error, got %v", err)
+	}
+	name, err := RevokeAPIToken(db, apiToken.ID, userID)
+	if err != nil || name != "script" {
+		t.Fatalf("Failed to revoke token: %q (%v)", name, err)
+	}
+	if _, err := AuthenticateAPIToken(db, token, now); err == nil || err.Error() != "invalid token" {
+		t.Errorf("Expected a revoked token to be invalid, got %v", err)
+	}
+}
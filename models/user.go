@@ -12,11 +12,37 @@ type User struct {
 	Username  string
 	Email     string
 	Password  string
+	Role      string
 	CreatedAt time.Time
+
+	UnreadNotifications int // filled in by the session middleware
+	UnreadMessages      int // filled in by the session middleware
+}
+
+// User roles. Moderators and admins can manage other users' content.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// IsModerator reports whether the user can moderate content
+func (u *User) IsModerator() bool {
+	return u != nil && (u.Role == RoleModerator || u.Role == RoleAdmin)
+}
+
+// IsAdmin reports whether the user can manage site settings such as webhooks
+func (u *User) IsAdmin() bool {
+	return u != nil && u.Role == RoleAdmin
 }
 
 // CreateUser creates a new user in the database
 func CreateUser(db *sql.DB, username, email, password string) (int64, error) {
+	// The placeholder for deleted accounts is reserved
+	if username == DeletedUsername {
+		return 0, errors.New("username already taken")
+	}
+
 	// Check if username already exists
 	var count int
 	err := db.QueryRow("SELECT COUNT(*) FROM users WHERE username = ?", username).Scan(&count)
@@ -58,9 +84,9 @@ func CreateUser(db *sql.DB, username, email, password string) (int64, error) {
 func GetUserByID(db *sql.DB, id int64) (*User, error) {
 	var user User
 	err := db.QueryRow(
-		"SELECT id, username, email, password, created_at FROM users WHERE id = ?",
+		"SELECT id, username, email, password, role, created_at FROM users WHERE id = ?",
 		id,
-	).Scan(&user.ID, &user.Username, &user.Email, &user.Password, &user.CreatedAt)
+	).Scan(&user.ID, &user.Username, &user.Email, &user.Password, &user.Role, &user.CreatedAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, errors.New("user not found")
@@ -74,9 +100,9 @@ func GetUserByID(db *sql.DB, id int64) (*User, error) {
 func AuthenticateUser(db *sql.DB, email, password string) (*User, error) {
 	var user User
 	err := db.QueryRow(
-		"SELECT id, username, email, password, created_at FROM users WHERE email = ?",
+		"SELECT id, username, email, password, role, created_at FROM users WHERE email = ?",
 		email,
-	).Scan(&user.ID, &user.Username, &user.Email, &user.Password, &user.CreatedAt)
+	).Scan(&user.ID, &user.Username, &user.Email, &user.Password, &user.Role, &user.CreatedAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, errors.New("invalid email or password")
@@ -91,3 +117,23 @@ func AuthenticateUser(db *sql.DB, email, password string) (*User, error) {
 
 	return &user, nil
 }
+
+// SetUserRole changes a user's role
+func SetUserRole(db *sql.DB, userID int64, role string) error {
+	if role != RoleUser && role != RoleModerator && role != RoleAdmin {
+		return errors.New("invalid role")
+	}
+
+	result, err := db.Exec("UPDATE users SET role = ? WHERE id = ?", role, userID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("user not found")
+	}
+	return nil
+}
@@ -0,0 +1,686 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Account deletion modes
+const (
+	DeleteModeAnonymize  = "anonymize"
+	DeleteModeEverything = "everything"
+)
+
+// DeletedUsername is the name of the placeholder account that anonymized content is moved to
+const DeletedUsername = "[deleted user]"
+
+const deletedUserEmail = "deleted-user@localhost.invalid"
+
+// userOwnedRows lists rows that belong to a user and are removed with the account,
+// whatever happens to their posts and comments. Each entry is a table and its user column.
+var userOwnedRows = [][2]string{
+	{"sessions", "user_id"},
+	{"email_changes", "user_id"},
+	{"account_audit", "user_id"},
+	{"post_reactions", "user_id"},
+	{"comment_reactions", "user_id"},
+	{"drafts", "user_id"},
+	{"poll_votes", "user_id"},
+	{"bookmarks", "user_id"},
+	{"bookmark_folders", "user_id"},
+	{"follows", "user_id"},
+	{"notifications", "user_id"},
+	{"mentions", "user_id"},
+	{"user_blocks", "user_id"},
+	{"user_blocks", "blocked_id"},
+	{"email_outbox", "user_id"},
+	{"chat_mutes", "user_id"},
+	{"conversation_members", "user_id"},
+	{"message_reports", "reporter_id"},
+	{"api_tokens", "user_id"},
+}
+
+type ExportProfile struct {
+	ID        int64     `json:"id"`
+	Username  string    `json:"username"`
+	Email     string    `json:"email"`
+	Bio       string    `json:"bio"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ExportPost struct {
+	ID         int64     `json:"id"`
+	Title      string    `json:"title"`
+	Content    string    `json:"content"`
+	Categories []string  `json:"categories"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type ExportComment struct {
+	ID        int64     `json:"id"`
+	PostID    int64     `json:"post_id"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ExportReaction struct {
+	TargetID int64 `json:"target_id"`
+	Reaction int   `json:"reaction"`
+}
+
+type ExportAttachment struct {
+	Token       string    `json:"token"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	PostID      int64     `json:"post_id,omitempty"`
+	CommentID   int64     `json:"comment_id,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type ExportDraft struct {
+	Title       string     `json:"title"`
+	Content     string     `json:"content"`
+	CategoryIDs []int64    `json:"category_ids"`
+	PublishAt   *time.Time `json:"publish_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+type ExportPollVote struct {
+	PollID    int64     `json:"poll_id"`
+	PostID    int64     `json:"post_id"`
+	Option    string    `json:"option"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ExportBookmark struct {
+	TargetType string    `json:"target_type"`
+	TargetID   int64     `json:"target_id"`
+	Folder     string    `json:"folder,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type ExportFollow struct {
+	TargetType string    `json:"target_type"`
+	TargetID   int64     `json:"target_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type ExportNotification struct {
+	Type      string     `json:"type"`
+	ActorID   int64      `json:"actor_id"`
+	PostID    int64      `json:"post_id"`
+	CommentID int64      `json:"comment_id,omitempty"`
+	ReadAt    *time.Time `json:"read_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type ExportBlock struct {
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ExportChatMessage struct {
+	ID        int64     `json:"id"`
+	Category  string    `json:"category"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ExportPrivateMessage struct {
+	ID             int64     `json:"id"`
+	ConversationID int64     `json:"conversation_id"`
+	Content        string    `json:"content"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+type ExportAccountEvent struct {
+	Action    string    `json:"action"`
+	Detail    string    `json:"detail"`
+	IPAddress string    `json:"ip_address"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// UserExport holds everything a user has created, for personal data downloads
+type UserExport struct {
+	ExportedAt       time.Time              `json:"exported_at"`
+	Profile          ExportProfile          `json:"profile"`
+	Posts            []ExportPost           `json:"posts"`
+	Comments         []ExportComment        `json:"comments"`
+	PostReactions    []ExportReaction       `json:"post_reactions"`
+	CommentReactions []ExportReaction       `json:"comment_reactions"`
+	Attachments      []ExportAttachment     `json:"attachments"`
+	Drafts           []ExportDraft          `json:"drafts"`
+	PollVotes        []ExportPollVote       `json:"poll_votes"`
+	Bookmarks        []ExportBookmark       `json:"bookmarks"`
+	Follows          []ExportFollow         `json:"follows"`
+	Notifications    []ExportNotification   `json:"notifications"`
+	Blocks           []ExportBlock          `json:"blocks"`
+	ChatMessages     []ExportChatMessage    `json:"chat_messages"`
+	PrivateMessages  []ExportPrivateMessage `json:"private_messages"`
+	AccountEvents    []ExportAccountEvent   `json:"account_events"`
+}
+
+// ExportUserData collects a user's profile, posts, comments and reactions
+func ExportUserData(db *sql.DB, userID int64) (*UserExport, error) {
+	user, err := GetUserByID(db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	bio, _, err := GetProfileSettings(db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &UserExport{
+		ExportedAt: time.Now(),
+		Profile: ExportProfile{
+			ID:        user.ID,
+			Username:  user.Username,
+			Email:     user.Email,
+			Bio:       bio,
+			CreatedAt: user.CreatedAt,
+		},
+		Posts:            []ExportPost{},
+		Comments:         []ExportComment{},
+		PostReactions:    []ExportReaction{},
+		CommentReactions: []ExportReaction{},
+		Attachments:      []ExportAttachment{},
+		Drafts:           []ExportDraft{},
+		PollVotes:        []ExportPollVote{},
+		Bookmarks:        []ExportBookmark{},
+		Follows:          []ExportFollow{},
+		Notifications:    []ExportNotification{},
+		Blocks:           []ExportBlock{},
+		ChatMessages:     []ExportChatMessage{},
+		PrivateMessages:  []ExportPrivateMessage{},
+		AccountEvents:    []ExportAccountEvent{},
+	}
+
+	// Posts
+	rows, err := db.Query(
+		"SELECT id, title, content, created_at FROM posts WHERE user_id = ? ORDER BY id ASC",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var post ExportPost
+		if err := rows.Scan(&post.ID, &post.Title, &post.Content, &post.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		post.Categories = []string{}
+		export.Posts = append(export.Posts, post)
+	}
+	rows.Close()
+
+	for i := range export.Posts {
+		catRows, err := db.Query(`
+			SELECT c.name
+			FROM categories c
+			JOIN post_categories pc ON c.id = pc.category_id
+			WHERE pc.post_id = ?
+		`, export.Posts[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		for catRows.Next() {
+			var name string
+			if err := catRows.Scan(&name); err != nil {
+				catRows.Close()
+				return nil, err
+			}
+			export.Posts[i].Categories = append(export.Posts[i].Categories, name)
+		}
+		catRows.Close()
+	}
+
+	// Comments
+	rows, err = db.Query(
+		"SELECT id, post_id, content, created_at FROM comments WHERE user_id = ? ORDER BY id ASC",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var comment ExportComment
+		if err := rows.Scan(&comment.ID, &comment.PostID, &comment.Content, &comment.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		export.Comments = append(export.Comments, comment)
+	}
+	rows.Close()
+
+	// Reactions
+	export.PostReactions, err = exportReactions(db, "SELECT post_id, reaction FROM post_reactions WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	export.CommentReactions, err = exportReactions(db, "SELECT comment_id, reaction FROM comment_reactions WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Uploaded files
+	attachments, err := GetUserAttachments(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range attachments {
+		export.Attachments = append(export.Attachments, ExportAttachment{
+			Token:       a.Token,
+			Filename:    a.Filename,
+			ContentType: a.ContentType,
+			Size:        a.Size,
+			PostID:      a.PostID,
+			CommentID:   a.CommentID,
+			CreatedAt:   a.CreatedAt,
+		})
+	}
+
+	// Unpublished drafts
+	drafts, err := GetUserDrafts(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range drafts {
+		draft := ExportDraft{
+			Title:       d.Title,
+			Content:     d.Content,
+			CategoryIDs: d.CategoryIDs,
+			CreatedAt:   d.CreatedAt,
+			UpdatedAt:   d.UpdatedAt,
+		}
+		if draft.CategoryIDs == nil {
+			draft.CategoryIDs = []int64{}
+		}
+		if d.IsScheduled() {
+			publishAt := d.PublishAt
+			draft.PublishAt = &publishAt
+		}
+		export.Drafts = append(export.Drafts, draft)
+	}
+
+	// Poll votes
+	rows, err = db.Query(`
+		SELECT v.poll_id, p.post_id, o.text, v.created_at
+		FROM poll_votes v
+		JOIN polls p ON p.id = v.poll_id
+		JOIN poll_options o ON o.id = v.option_id
+		WHERE v.user_id = ?
+		ORDER BY v.poll_id ASC, o.position ASC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var vote ExportPollVote
+		if err := rows.Scan(&vote.PollID, &vote.PostID, &vote.Option, &vote.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		export.PollVotes = append(export.PollVotes, vote)
+	}
+	rows.Close()
+
+	// Bookmarks
+	rows, err = db.Query(`
+		SELECT b.target_type, b.target_id, COALESCE(f.name, ''), b.created_at
+		FROM bookmarks b
+		LEFT JOIN bookmark_folders f ON f.id = b.folder_id
+		WHERE b.user_id = ?
+		ORDER BY b.id ASC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var bookmark ExportBookmark
+		if err := rows.Scan(&bookmark.TargetType, &bookmark.TargetID, &bookmark.Folder, &bookmark.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		export.Bookmarks = append(export.Bookmarks, bookmark)
+	}
+	rows.Close()
+
+	// Follows
+	rows, err = db.Query(
+		"SELECT target_type, target_id, created_at FROM follows WHERE user_id = ? ORDER BY id ASC",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var follow ExportFollow
+		if err := rows.Scan(&follow.TargetType, &follow.TargetID, &follow.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		export.Follows = append(export.Follows, follow)
+	}
+	rows.Close()
+
+	// Notifications
+	rows, err = db.Query(
+		"SELECT type, actor_id, post_id, comment_id, read_at, created_at FROM notifications WHERE user_id = ? ORDER BY id ASC",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var notification ExportNotification
+		var readAt sql.NullTime
+		if err := rows.Scan(
+			&notification.Type, &notification.ActorID, &notification.PostID, &notification.CommentID,
+			&readAt, &notification.CreatedAt,
+		); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if readAt.Valid {
+			notification.ReadAt = &readAt.Time
+		}
+		export.Notifications = append(export.Notifications, notification)
+	}
+	rows.Close()
+
+	// Blocked users
+	blocked, err := GetBlockedUsers(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range blocked {
+		export.Blocks = append(export.Blocks, ExportBlock{Username: u.Username, CreatedAt: u.CreatedAt})
+	}
+
+	// Chat messages that were not deleted
+	rows, err = db.Query(`
+		SELECT m.id, COALESCE(c.name, ''), m.content, m.created_at
+		FROM chat_messages m
+		LEFT JOIN categories c ON c.id = m.category_id
+		WHERE m.user_id = ? AND m.deleted_at IS NULL
+		ORDER BY m.id ASC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var message ExportChatMessage
+		if err := rows.Scan(&message.ID, &message.Category, &message.Content, &message.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		export.ChatMessages = append(export.ChatMessages, message)
+	}
+	rows.Close()
+
+	// Private messages the user sent; what others wrote is theirs
+	rows, err = db.Query(
+		"SELECT id, conversation_id, content, created_at FROM private_messages WHERE user_id = ? ORDER BY id ASC",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var message ExportPrivateMessage
+		if err := rows.Scan(&message.ID, &message.ConversationID, &message.Content, &message.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		export.PrivateMessages = append(export.PrivateMessages, message)
+	}
+	rows.Close()
+
+	// Account audit trail
+	rows, err = db.Query(
+		"SELECT action, detail, ip_address, created_at FROM account_audit WHERE user_id = ? ORDER BY id ASC",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var event ExportAccountEvent
+		if err := rows.Scan(&event.Action, &event.Detail, &event.IPAddress, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		export.AccountEvents = append(export.AccountEvents, event)
+	}
+
+	return export, nil
+}
+
+func exportReactions(db *sql.DB, query string, userID int64) ([]ExportReaction, error) {
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reactions := []ExportReaction{}
+	for rows.Next() {
+		var reaction ExportReaction
+		if err := rows.Scan(&reaction.TargetID, &reaction.Reaction); err != nil {
+			return nil, err
+		}
+		reactions = append(reactions, reaction)
+	}
+	return reactions, nil
+}
+
+// GetDeletedUserID returns the placeholder account for anonymized content, creating it if needed
+func GetDeletedUserID(db *sql.DB) (int64, error) {
+	var id int64
+	err := db.QueryRow("SELECT id FROM users WHERE username = ?", DeletedUsername).Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	// The password is not a valid bcrypt hash, so nobody can log in as this user
+	result, err := db.Exec(
+		"INSERT INTO users (username, email, password) VALUES (?, ?, ?)",
+		DeletedUsername, deletedUserEmail, "!",
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// DeleteUser removes a user account. With DeleteModeAnonymize their posts and comments
+// are kept and moved to the placeholder account; with DeleteModeEverything they are
+// removed together with the comments and reactions that hang off them.
+//
+// The attachments that go with the account are returned so the caller can remove their
+// stored files once the deletion is committed.
+//
+// Foreign keys are not enforced on the connection, so dependent rows are removed explicitly.
+func DeleteUser(db *sql.DB, userID int64, mode string) ([]Attachment, error) {
+	if mode != DeleteModeAnonymize && mode != DeleteModeEverything {
+		return nil, errors.New("invalid deletion mode")
+	}
+
+	deletedUserID, err := GetDeletedUserID(db)
+	if err != nil {
+		return nil, err
+	}
+	if userID == deletedUserID {
+		return nil, errors.New("the placeholder account cannot be deleted")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE id = ?)", userID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.New("user not found")
+	}
+
+	if mode == DeleteModeAnonymize {
+		statements := []string{
+			"UPDATE posts SET user_id = ? WHERE user_id = ?",
+			"UPDATE comments SET user_id = ? WHERE user_id = ?",
+			// Files on kept content stay with it, unattached uploads are removed below
+			"UPDATE attachments SET user_id = ? WHERE user_id = ? AND post_id IS NOT NULL",
+			"UPDATE notifications SET actor_id = ? WHERE actor_id = ?",
+			"UPDATE mentions SET author_id = ? WHERE author_id = ?",
+			"UPDATE chat_messages SET user_id = ? WHERE user_id = ?",
+			"UPDATE private_messages SET user_id = ? WHERE user_id = ?",
+		}
+		for _, stmt := range statements {
+			if _, err := tx.Exec(stmt, deletedUserID, userID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	attachments, err := removeUserAttachments(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if mode == DeleteModeEverything {
+		if err := deleteUserContent(tx, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	// Mentions of the user are rendered as plain text from now on
+	if err := forgetMentionRenderings(tx, userID); err != nil {
+		return nil, err
+	}
+
+	// Moderation done by the user stays on record
+	for _, stmt := range []string{
+		"UPDATE chat_messages SET deleted_by = ? WHERE deleted_by = ?",
+		"UPDATE chat_mutes SET muted_by = ? WHERE muted_by = ?",
+		"UPDATE message_reports SET resolved_by = ? WHERE resolved_by = ?",
+	} {
+		if _, err := tx.Exec(stmt, deletedUserID, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	// Nobody can follow an account that is gone
+	if _, err := tx.Exec("DELETE FROM follows WHERE target_type = 'user' AND target_id = ?", userID); err != nil {
+		return nil, err
+	}
+
+	// Nor sign activities as it on other servers
+	if _, err := tx.Exec("DELETE FROM federation_keys WHERE owner_type = 'user' AND owner_id = ?", userID); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("UPDATE remote_actors SET user_id = NULL WHERE user_id = ?", userID); err != nil {
+		return nil, err
+	}
+
+	for _, owned := range userOwnedRows {
+		if _, err := tx.Exec("DELETE FROM "+owned[0]+" WHERE "+owned[1]+" = ?", userID); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM users WHERE id = ?", userID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+// removeUserAttachments deletes the attachments still owned by a user, together with the files
+// others attached under the user's posts, and returns them
+func removeUserAttachments(tx *sql.Tx, userID int64) ([]Attachment, error) {
+	const where = "user_id = ?1 OR post_id IN (SELECT id FROM posts WHERE user_id = ?1)"
+
+	rows, err := tx.Query("SELECT "+attachmentColumns+" FROM attachments WHERE "+where+" ORDER BY id ASC", userID)
+	if err != nil {
+		return nil, err
+	}
+	attachments, err := scanAttachments(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM attachments WHERE "+where, userID); err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+// deleteUserContent removes a user's posts and comments and everything attached to them
+func deleteUserContent(tx *sql.Tx, userID int64) error {
+	statements := []string{
+		// Reactions on the user's comments and on any comment under the user's posts
+		`DELETE FROM comment_reactions WHERE comment_id IN (
+			SELECT id FROM comments WHERE user_id = ?1
+			OR post_id IN (SELECT id FROM posts WHERE user_id = ?1)
+		)`,
+		// Cached HTML of the user's comments, of comments under the user's posts and of the posts
+		`DELETE FROM rendered_content WHERE target_type = 'comment' AND target_id IN (
+			SELECT id FROM comments WHERE user_id = ?1
+			OR post_id IN (SELECT id FROM posts WHERE user_id = ?1)
+		)`,
+		"DELETE FROM rendered_content WHERE target_type = 'post' AND target_id IN (SELECT id FROM posts WHERE user_id = ?1)",
+		// Other users' bookmarks of the user's comments, of comments under the user's posts and of the posts
+		`DELETE FROM bookmarks WHERE target_type = 'comment' AND target_id IN (
+			SELECT id FROM comments WHERE user_id = ?1
+			OR post_id IN (SELECT id FROM posts WHERE user_id = ?1)
+		)`,
+		"DELETE FROM bookmarks WHERE target_type = 'post' AND target_id IN (SELECT id FROM posts WHERE user_id = ?1)",
+		// Notifications caused by the user and about the user's comments or posts
+		`DELETE FROM notifications WHERE actor_id = ?1
+			OR comment_id IN (SELECT id FROM comments WHERE user_id = ?1)
+			OR post_id IN (SELECT id FROM posts WHERE user_id = ?1)`,
+		// Mentions in the user's comments and posts and in comments under the posts
+		`DELETE FROM mentions WHERE author_id = ?1
+			OR post_id IN (SELECT id FROM posts WHERE user_id = ?1)`,
+		// Comments by the user and comments under the user's posts
+		`DELETE FROM comments WHERE user_id = ?1
+			OR post_id IN (SELECT id FROM posts WHERE user_id = ?1)`,
+		// Follows of the user's posts
+		"DELETE FROM follows WHERE target_type = 'post' AND target_id IN (SELECT id FROM posts WHERE user_id = ?1)",
+		// Reactions and category links of the user's posts
+		"DELETE FROM post_reactions WHERE post_id IN (SELECT id FROM posts WHERE user_id = ?1)",
+		"DELETE FROM post_categories WHERE post_id IN (SELECT id FROM posts WHERE user_id = ?1)",
+		// Polls on the user's posts, with their options and votes
+		"DELETE FROM poll_votes WHERE poll_id IN (SELECT id FROM polls WHERE post_id IN (SELECT id FROM posts WHERE user_id = ?1))",
+		"DELETE FROM poll_options WHERE poll_id IN (SELECT id FROM polls WHERE post_id IN (SELECT id FROM posts WHERE user_id = ?1))",
+		"DELETE FROM polls WHERE post_id IN (SELECT id FROM posts WHERE user_id = ?1)",
+		"DELETE FROM posts WHERE user_id = ?1",
+		"DELETE FROM chat_messages WHERE user_id = ?1",
+		// Private messages the user sent, and reports about them
+		"DELETE FROM message_reports WHERE message_id IN (SELECT id FROM private_messages WHERE user_id = ?1)",
+		"DELETE FROM private_messages WHERE user_id = ?1",
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setupUserDataTestDB creates two users where the second one interacts with the first one's content
+func setupUserDataTestDB(t *testing.T) (*sql.DB, func(), int64, int64) {
+	db, cleanup := setupTestDB(t)
+
+	userID, err := CreateUser(db, "leaving", "leaving@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	otherID, err := CreateUser(db, "staying", "staying@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Failed to create second user: %v", err)
+	}
+
+	categoryID, err := CreateCategory(db, "General")
+	if err != nil {
+		t.Fatalf("Failed to create category: %v", err)
+	}
+
+	// Content of the user who is leaving, with activity from the other user
+	postID, err := CreatePost(db, "Leaving post", "Content", userID, []int64{categoryID})
+	if err != nil {
+		t.Fatalf("Failed to create post: %v", err)
+	}
+	commentID, err := CreateComment(db, "Reply from other user", otherID, postID)
+	if err != nil {
+		t.Fatalf("Failed to create comment: %v", err)
+	}
+	if err := ReactToPost(db, postID, otherID, 1); err != nil {
+		t.Fatalf("Failed to react to post: %v", err)
+	}
+	if err := ReactToComment(db, commentID, userID, 1); err != nil {
+		t.Fatalf("Failed to react to comment: %v", err)
+	}
+
+	// Content of the other user, with activity from the user who is leaving
+	otherPostID, err := CreatePost(db, "Staying post", "Content", otherID, []int64{categoryID})
+	if err != nil {
+		t.Fatalf("Failed to create post: %v", err)
+	}
+	if _, err := CreateComment(db, "Comment from leaving user", userID, otherPostID); err != nil {
+		t.Fatalf("Failed to create comment: %v", err)
+	}
+	if err := ReactToPost(db, otherPostID, userID, -1); err != nil {
+		t.Fatalf("Failed to react to post: %v", err)
+	}
+
+	_, err = db.Exec(
+		"INSERT INTO sessions (id, user_id, expires_at) VALUES (?, ?, ?)",
+		"leaving-session", userID, time.Now().Add(time.Hour),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	return db, cleanup, userID, otherID
+}
+
+// createUserDataAttachments adds an upload of the leaving user on their post, an unattached one,
+// and files of the other user under the leaving user's post and on their own post
+func createUserDataAttachments(t *testing.T, db *sql.DB, userID, otherID int64) {
+	var postID, otherPostID, commentID int64
+	db.QueryRow("SELECT id FROM posts WHERE user_id = ?", userID).Scan(&postID)
+	db.QueryRow("SELECT id FROM posts WHERE user_id = ?", otherID).Scan(&otherPostID)
+	db.QueryRow("SELECT id FROM comments WHERE user_id = ? AND post_id = ?", otherID, postID).Scan(&commentID)
+
+	for _, a := range []Attachment{
+		{Token: "leaving-post", UserID: userID},
+		{Token: "leaving-upload", UserID: userID},
+		{Token: "other-reply", UserID: otherID},
+		{Token: "other-post", UserID: otherID},
+	} {
+		a.Filename, a.ContentType, a.Size = "file.txt", "text/plain", 10
+		if _, err := CreateAttachment(db, &a); err != nil {
+			t.Fatalf("Failed to create attachment: %v", err)
+		}
+	}
+	if _, err := AttachToPost(db, []string{"leaving-post"}, userID, postID); err != nil {
+		t.Fatalf("Failed to attach: %v", err)
+	}
+	if _, err := AttachToComment(db, []string{"other-reply"}, otherID, postID, commentID); err != nil {
+		t.Fatalf("Failed to attach: %v", err)
+	}
+	if _, err := AttachToPost(db, []string{"other-post"}, otherID, otherPostID); err != nil {
+		t.Fatalf("Failed to attach: %v", err)
+	}
+}
+
+// attachmentTokens lists the tokens of attachments in order
+func attachmentTokens(attachments []Attachment) string {
+	var tokens []string
+	for _, a := range attachments {
+		tokens = append(tokens, a.Token)
+	}
+	return strings.Join(tokens, ",")
+}
+
+// countRows returns the number of rows matching a query
+func countRows(t *testing.T, db *sql.DB, query string, args ...interface{}) int {
+	var count int
+	if err := db.QueryRow(query, args...).Scan(&count); err != nil {
+		t.Fatalf("Failed to count rows: %v", err)
+	}
+	return count
+}
+
+func TestExportUserData(t *testing.T) {
+	db, cleanup, userID, _ := setupUserDataTestDB(t)
+	defer cleanup()
+
+	export, err := ExportUserData(db, userID)
+	if err != nil {
+		t.Fatalf("Failed to export user data: %v", err)
+	}
+
+	if export.Profile.Username != "leaving" {
+		t.Errorf("Expected username 'leaving', got '%s'", export.Profile.Username)
+	}
+	if len(export.Posts) != 1 || export.Posts[0].Title != "Leaving post" {
+		t.Errorf("Unexpected exported posts: %+v", export.Posts)
+	}
+	if len(export.Posts) == 1 && (len(export.Posts[0].Categories) != 1 || export.Posts[0].Categories[0] != "General") {
+		t.Errorf("Unexpected exported categories: %+v", export.Posts[0].Categories)
+	}
+	if len(export.Comments) != 1 || export.Comments[0].Content != "Comment from leaving user" {
+		t.Errorf("Unexpected exported comments: %+v", export.Comments)
+	}
+	if len(export.PostReactions) != 1 || export.PostReactions[0].Reaction != -1 {
+		t.Errorf("Unexpected exported post reactions: %+v", export.PostReactions)
+	}
+	if len(export.CommentReactions) != 1 || export.CommentReactions[0].Reaction != 1 {
+		t.Errorf("Unexpected exported comment reactions: %+v", export.CommentReactions)
+	}
+}
+
+func TestDeleteUserAnonymize(t *testing.T) {
+	db, cleanup, userID, otherID := setupUserDataTestDB(t)
+	defer cleanup()
+
+	createUserDataAttachments(t, db, userID, otherID)
+
+	removed, err := DeleteUser(db, userID, DeleteModeAnonymize)
+	if err != nil {
+		t.Fatalf("Failed to delete user: %v", err)
+	}
+
+	// Files on kept content stay, the unattached upload goes with the account
+	if got := attachmentTokens(removed); got != "leaving-upload" {
+		t.Errorf("Expected the unattached upload to be removed, got %q", got)
+	}
+	if n := countRows(t, db, "SELECT COUNT(*) FROM attachments"); n != 3 {
+		t.Errorf("Expected 3 attachments to be kept, got %d", n)
+	}
+
+	if _, err := GetUserByID(db, userID); err == nil {
+		t.Error("User still exists after deletion")
+	}
+
+	// Content is kept but now belongs to the placeholder account
+	posts, err := GetPosts(db, otherID, 0, 0, false)
+	if err != nil {
+		t.Fatalf("Failed to get posts: %v", err)
+	}
+	if len(posts) != 2 {
+		t.Fatalf("Expected 2 posts, got %d", len(posts))
+	}
+	for _, post := range posts {
+		if post.Title == "Leaving post" && post.Username != DeletedUsername {
+			t.Errorf("Expected post author '%s', got '%s'", DeletedUsername, post.Username)
+		}
+	}
+	if n := countRows(t, db, "SELECT COUNT(*) FROM comments"); n != 2 {
+		t.Errorf("Expected 2 comments to be kept, got %d", n)
+	}
+
+	// Personal rows are removed
+	if n := countRows(t, db, "SELECT COUNT(*) FROM sessions WHERE user_id = ?", userID); n != 0 {
+		t.Errorf("Expected sessions to be removed, got %d", n)
+	}
+	if n := countRows(t, db, "SELECT COUNT(*) FROM post_reactions WHERE user_id = ?", userID); n != 0 {
+		t.Errorf("Expected post reactions to be removed, got %d", n)
+	}
+	if n := countRows(t, db, "SELECT COUNT(*) FROM comment_reactions WHERE user_id = ?", userID); n != 0 {
+		t.Errorf("Expected comment reactions to be removed, got %d", n)
+	}
+
+	// The other user's reaction on the anonymized post is kept
+	if n := countRows(t, db, "SELECT COUNT(*) FROM post_reactions WHERE user_id = ?", otherID); n != 1 {
+		t.Errorf("Expected other user's reaction to be kept, got %d", n)
+	}
+
+	// The placeholder account cannot log in
+	if _, err := AuthenticateUser(db, deletedUserEmail, "!"); err == nil {
+		t.Error("Placeholder account should not be able to log in")
+	}
+}
+
+func TestDeleteUserEverything(t *testing.T) {
+	db, cleanup, userID, otherID := setupUserDataTestDB(t)
+	defer cleanup()
+
+	createUserDataAttachments(t, db, userID, otherID)
+
+	removed, err := DeleteUser(db, userID, DeleteModeEverything)
+	if err != nil {
+		t.Fatalf("Failed to delete user: %v", err)
+	}
+
+	// The user's files and those under the deleted post are returned for removal from storage
+	if got := attachmentTokens(removed); got != "leaving-post,leaving-upload,other-reply" {
+		t.Errorf("Unexpected removed attachments %q", got)
+	}
+	if n := countRows(t, db, "SELECT COUNT(*) FROM attachments"); n != 1 {
+		t.Errorf("Expected only the other user's post file to be kept, got %d", n)
+	}
+
+	// Only the other user's post is left, without the leaving user's comment
+	if n := countRows(t, db, "SELECT COUNT(*) FROM posts"); n != 1 {
+		t.Errorf("Expected 1 post, got %d", n)
+	}
+	if n := countRows(t, db, "SELECT COUNT(*) FROM comments"); n != 0 {
+		t.Errorf("Expected no comments, got %d", n)
+	}
+	if n := countRows(t, db, "SELECT COUNT(*) FROM post_categories"); n != 1 {
+		t.Errorf("Expected 1 post category link, got %d", n)
+	}
+
+	// No reaction may point at deleted content or belong to the deleted user
+	if n := countRows(t, db, "SELECT COUNT(*) FROM post_reactions"); n != 0 {
+		t.Errorf("Expected no post reactions, got %d", n)
+	}
+	if n := countRows(t, db, "SELECT COUNT(*) FROM comment_reactions"); n != 0 {
+		t.Errorf("Expected no comment reactions, got %d", n)
+	}
+	if n := countRows(t, db, "SELECT COUNT(*) FROM sessions WHERE user_id = ?", userID); n != 0 {
+		t.Errorf("Expected sessions to be removed, got %d", n)
+	}
+
+	if _, err := GetUserByID(db, otherID); err != nil {
+		t.Errorf("Other user should not be affected: %v", err)
+	}
+
+	// Test an invalid mode
+	if _, err := DeleteUser(db, otherID, "sometimes"); err == nil {
+		t.Error("Expected error for invalid deletion mode, got nil")
+	}
+}
@@ -0,0 +1,374 @@
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Webhook event types
+const (
+	WebhookPostCreated    = "post.created"
+	WebhookCommentCreated = "comment.created"
+	WebhookReaction       = "reaction.changed"
+	WebhookUserRegistered = "user.registered"
+	WebhookPing           = "ping" // sent on request to check a webhook, never subscribed to
+)
+
+// WebhookEvents lists the event types a webhook can subscribe to
+var WebhookEvents = []string{WebhookPostCreated, WebhookCommentCreated, WebhookReaction, WebhookUserRegistered}
+
+// WebhookMaxAttempts is how many times a delivery is tried before it is given up
+var WebhookMaxAttempts = 8
+
+// WebhookRetryDelay is the wait after the first failed attempt. It doubles with every further attempt.
+var WebhookRetryDelay = 30 * time.Second
+
+// Webhook is an address that receives forum events
+type Webhook struct {
+	ID        int64
+	URL       string
+	Secret    string // signs every request
+	Events    []string
+	Active    bool
+	CreatedAt time.Time
+}
+
+// Subscribes reports whether the webhook receives an event type
+func (w *Webhook) Subscribes(event string) bool {
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is one event sent, or to be sent, to a webhook
+type WebhookDelivery struct {
+	ID            int64
+	WebhookID     int64
+	Event         string
+	Payload       string
+	Attempts      int
+	StatusCode    int    // of the latest attempt, 0 when there was no answer
+	ResponseBody  string // start of the latest answer
+	LastError     string
+	NextAttemptAt time.Time
+	DeliveredAt   *time.Time
+	FailedAt      *time.Time
+	CreatedAt     time.Time
+}
+
+// Status is "delivered", "failed" or "pending"
+func (d *WebhookDelivery) Status() string {
+	switch {
+	case d.DeliveredAt != nil:
+		return "delivered"
+	case d.FailedAt != nil:
+		return "failed"
+	default:
+		return "pending"
+	}
+}
+
+// checkWebhook validates a webhook address and its event types
+func checkWebhook(rawURL string, events []string) (string, string, error) {
+	rawURL = strings.TrimSpace(rawURL)
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", "", errors.New("invalid webhook URL")
+	}
+	if len(events) == 0 {
+		return "", "", errors.New("choose at least one event")
+	}
+
+	all := &Webhook{Events: WebhookEvents}
+	chosen := &Webhook{Events: events}
+	for _, e := range events {
+		if !all.Subscribes(e) {
+			return "", "", errors.New("unknown event")
+		}
+	}
+	// Stored in the order of WebhookEvents, without repeats
+	var list []string
+	for _, e := range WebhookEvents {
+		if chosen.Subscribes(e) {
+			list = append(list, e)
+		}
+	}
+	return rawURL, strings.Join(list, ","), nil
+}
+
+// CreateWebhook adds a webhook for the given event types with a new random secret
+func CreateWebhook(db *sql.DB, rawURL string, events []string) (int64, error) {
+	rawURL, eventList, err := checkWebhook(rawURL, events)
+	if err != nil {
+		return 0, err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return 0, err
+	}
+
+	result, err := db.Exec(
+		"INSERT INTO webhooks (url, secret, events, created_at) VALUES (?, ?, ?, ?)",
+		rawURL, hex.EncodeToString(secret), eventList, time.Now().UTC(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// UpdateWebhook changes a webhook's address and events, and turns it on or off.
+// Deliveries of a webhook that is off wait until it is turned on again.
+func UpdateWebhook(db *sql.DB, webhookID int64, rawURL string, events []string, active bool) error {
+	rawURL, eventList, err := checkWebhook(rawURL, events)
+	if err != nil {
+		return err
+	}
+
+	result, err := db.Exec(
+		"UPDATE webhooks SET url = ?, events = ?, active = ? WHERE id = ?",
+		rawURL, eventList, active, webhookID,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("webhook not found")
+	}
+	return nil
+}
+
+// DeleteWebhook removes a webhook and its deliveries
+func DeleteWebhook(db *sql.DB, webhookID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM webhooks WHERE id = ?", webhookID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("webhook not found")
+	}
+	if _, err := tx.Exec("DELETE FROM webhook_deliveries WHERE webhook_id = ?", webhookID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const webhookColumns = "id, url, secret, events, active, created_at FROM webhooks"
+
+func scanWebhook(scanner interface{ Scan(...interface{}) error }) (*Webhook, error) {
+	var w Webhook
+	var events string
+	if err := scanner.Scan(&w.ID, &w.URL, &w.Secret, &events, &w.Active, &w.CreatedAt); err != nil {
+		return nil, err
+	}
+	w.Events = strings.Split(events, ",")
+	return &w, nil
+}
+
+// GetWebhooks retrieves every webhook, oldest first
+func GetWebhooks(db *sql.DB) ([]Webhook, error) {
+	rows, err := db.Query("SELECT " + webhookColumns + " ORDER BY id ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var webhooks []Webhook
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, *w)
+	}
+	return webhooks, rows.Err()
+}
+
+// GetWebhook retrieves a webhook by ID
+func GetWebhook(db *sql.DB, webhookID int64) (*Webhook, error) {
+	w, err := scanWebhook(db.QueryRow("SELECT "+webhookColumns+" WHERE id = ?", webhookID))
+	if err == sql.ErrNoRows {
+		return nil, errors.New("webhook not found")
+	}
+	return w, err
+}
+
+// QueueWebhookEvent queues a JSON payload for every active webhook subscribed to the event
+func QueueWebhookEvent(db *sql.DB, event string, payload []byte) error {
+	webhooks, err := GetWebhooks(db)
+	if err != nil {
+		return err
+	}
+	for i := range webhooks {
+		if !webhooks[i].Active || !webhooks[i].Subscribes(event) {
+			continue
+		}
+		if _, err := QueueWebhookDelivery(db, webhooks[i].ID, event, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// QueueWebhookDelivery queues a JSON payload for one webhook, to be sent right away
+func QueueWebhookDelivery(db *sql.DB, webhookID int64, event string, payload []byte) (int64, error) {
+	now := time.Now().UTC()
+	result, err := db.Exec(
+		"INSERT INTO webhook_deliveries (webhook_id, event, payload, next_attempt_at, created_at) VALUES (?, ?, ?, ?, ?)",
+		webhookID, event, string(payload), now, now,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+const webhookDeliveryColumns = `d.id, d.webhook_id, d.event, d.payload, d.attempts, d.status_code, d.response_body,
+	d.last_error, d.next_attempt_at, d.delivered_at, d.failed_at, d.created_at
+	FROM webhook_deliveries d`
+
+func queryWebhookDeliveries(db *sql.DB, query string, args ...interface{}) ([]WebhookDelivery, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		var deliveredAt, failedAt sql.NullTime
+		if err := rows.Scan(
+			&d.ID, &d.WebhookID, &d.Event, &d.Payload, &d.Attempts, &d.StatusCode, &d.ResponseBody,
+			&d.LastError, &d.NextAttemptAt, &deliveredAt, &failedAt, &d.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		if deliveredAt.Valid {
+			d.DeliveredAt = &deliveredAt.Time
+		}
+		if failedAt.Valid {
+			d.FailedAt = &failedAt.Time
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// GetDueWebhookDeliveries retrieves the oldest pending deliveries of active webhooks whose next attempt is due
+func GetDueWebhookDeliveries(db *sql.DB, now time.Time, limit int) ([]WebhookDelivery, error) {
+	return queryWebhookDeliveries(db, `
+		SELECT `+webhookDeliveryColumns+`
+		JOIN webhooks w ON w.id = d.webhook_id
+		WHERE w.active = 1 AND d.delivered_at IS NULL AND d.failed_at IS NULL AND d.next_attempt_at <= ?
+		ORDER BY d.next_attempt_at ASC, d.id ASC
+		LIMIT ?
+	`, now.UTC(), limit)
+}
+
+// GetWebhookDeliveries retrieves a page of a webhook's delivery log, newest first, and how many deliveries it has
+func GetWebhookDeliveries(db *sql.DB, webhookID int64, limit, offset int) ([]WebhookDelivery, int, error) {
+	var total int
+	err := db.QueryRow("SELECT COUNT(*) FROM webhook_deliveries WHERE webhook_id = ?", webhookID).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	deliveries, err := queryWebhookDeliveries(db, `
+		SELECT `+webhookDeliveryColumns+`
+		WHERE d.webhook_id = ?
+		ORDER BY d.id DESC
+		LIMIT ? OFFSET ?
+	`, webhookID, limit, offset)
+	return deliveries, total, err
+}
+
+// MarkWebhookDelivered records that a receiver accepted a delivery
+func MarkWebhookDelivered(db *sql.DB, deliveryID int64, statusCode int, responseBody string, now time.Time) error {
+	_, err := db.Exec(`
+		UPDATE webhook_deliveries
+		SET delivered_at = ?, attempts = attempts + 1, status_code = ?, response_body = ?, last_error = ''
+		WHERE id = ?
+	`, now.UTC(), statusCode, responseBody, deliveryID)
+	return err
+}
+
+// MarkWebhookFailed records a failed attempt of a delivery and schedules the next one.
+// After WebhookMaxAttempts the delivery is given up. It reports whether it was.
+func MarkWebhookFailed(db *sql.DB, d *WebhookDelivery, statusCode int, responseBody string, sendErr error, now time.Time) (bool, error) {
+	attempts := d.Attempts + 1
+	if attempts >= WebhookMaxAttempts {
+		_, err := db.Exec(`
+			UPDATE webhook_deliveries
+			SET attempts = ?, status_code = ?, response_body = ?, last_error = ?, failed_at = ?
+			WHERE id = ?
+		`, attempts, statusCode, responseBody, sendErr.Error(), now.UTC(), d.ID)
+		return true, err
+	}
+
+	delay := WebhookRetryDelay
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+	}
+	_, err := db.Exec(`
+		UPDATE webhook_deliveries
+		SET attempts = ?, status_code = ?, response_body = ?, last_error = ?, next_attempt_at = ?
+		WHERE id = ?
+	`, attempts, statusCode, responseBody, sendErr.Error(), now.Add(delay).UTC(), d.ID)
+	return false, err
+}
+
+// RedeliverWebhook queues the payload of an earlier delivery again, as a new delivery.
+// It returns the webhook the delivery belongs to.
+func RedeliverWebhook(db *sql.DB, deliveryID int64) (int64, error) {
+	var webhookID int64
+	var event, payload string
+	err := db.QueryRow(
+		"SELECT webhook_id, event, payload FROM webhook_deliveries WHERE id = ?",
+		deliveryID,
+	).Scan(&webhookID, &event, &payload)
+	if err == sql.ErrNoRows {
+		return 0, errors.New("delivery not found")
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = QueueWebhookDelivery(db, webhookID, event, []byte(payload))
+	return webhookID, err
+}
+
+// CleanWebhookDeliveries deletes deliveries that were delivered or given up before the given time
+func CleanWebhookDeliveries(db *sql.DB, before time.Time) (int64, error) {
+	result, err := db.Exec(
+		"DELETE FROM webhook_deliveries WHERE (delivered_at IS NOT NULL AND delivered_at < ?) OR (failed_at IS NOT NULL AND failed_at < ?)",
+		before.UTC(), before.UTC(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
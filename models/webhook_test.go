@@ -0,0 +1,157 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWebhookValidation(t *testing.T) {
+	db, cleanup, _ := setupPostTestDB(t)
+	defer cleanup()
+
+	if _, err := CreateWebhook(db, "ftp://example.com", []string{WebhookPostCreated}); err == nil || err.Error() != "invalid webhook URL" {
+		t.Errorf("Expected invalid webhook URL error, got %v", err)
+	}
+	if _, err := CreateWebhook(db, "https://", []string{WebhookPostCreated}); err == nil || err.Error() != "invalid webhook URL" {
+		t.Errorf("Expected invalid webhook URL error for a missing host, got %v", err)
+	}
+	if _, err := CreateWebhook(db, "https://example.com/hook", nil); err == nil || err.Error() != "choose at least one event" {
+		t.Errorf("Expected choose at least one event error, got %v", err)
+	}
+	if _, err := CreateWebhook(db, "https://example.com/hook", []string{WebhookPing}); err == nil || err.Error() != "unknown event" {
+		t.Errorf("Expected unknown event error, got %v", err)
+	}
+
+	// Events are stored once, in a fixed order
+	webhookID, err := CreateWebhook(db, " https://example.com/hook ", []string{WebhookUserRegistered, WebhookPostCreated, WebhookUserRegistered})
+	if err != nil {
+		t.Fatalf("Failed to create webhook: %v", err)
+	}
+	hook, err := GetWebhook(db, webhookID)
+	if err != nil {
+		t.Fatalf("Failed to get webhook: %v", err)
+	}
+	if hook.URL != "https://example.com/hook" || len(hook.Events) != 2 || hook.Events[0] != WebhookPostCreated || !hook.Active || len(hook.Secret) != 64 {
+		t.Fatalf("Unexpected webhook %+v", hook)
+	}
+
+	if err := UpdateWebhook(db, webhookID+1, "https://example.com", []string{WebhookReaction}, true); err == nil || err.Error() != "webhook not found" {
+		t.Errorf("Expected webhook not found error, got %v", err)
+	}
+}
+
+func TestQueueWebhookEvent(t *testing.T) {
+	db, cleanup, _ := setupPostTestDB(t)
+	defer cleanup()
+
+	postsID, _ := CreateWebhook(db, "https://example.com/posts", []string{WebhookPostCreated})
+	usersID, _ := CreateWebhook(db, "https://example.com/users", []string{WebhookUserRegistered})
+	pausedID, _ := CreateWebhook(db, "https://example.com/paused", []string{WebhookPostCreated})
+	if err := UpdateWebhook(db, pausedID, "https://example.com/paused", []string{WebhookPostCreated}, false); err != nil {
+		t.Fatalf("Failed to pause webhook: %v", err)
+	}
+
+	if err := QueueWebhookEvent(db, WebhookPostCreated, []byte(`{"event":"post.created"}`)); err != nil {
+		t.Fatalf("Failed to queue event: %v", err)
+	}
+
+	// Only the active subscriber gets the event
+	due, err := GetDueWebhookDeliveries(db, time.Now(), 10)
+	if err != nil {
+		t.Fatalf("Failed to get due deliveries: %v", err)
+	}
+	if len(due) != 1 || due[0].WebhookID != postsID || due[0].Payload != `{"event":"post.created"}` || due[0].Status() != "pending" {
+		t.Fatalf("Unexpected deliveries %+v", due)
+	}
+	if _, total, _ := GetWebhookDeliveries(db, usersID, 10, 0); total != 0 {
+		t.Errorf("Expected no deliveries for a webhook without the event, got %d", total)
+	}
+
+	// Paused webhooks keep nothing queued
+	if _, total, _ := GetWebhookDeliveries(db, pausedID, 10, 0); total != 0 {
+		t.Errorf("Expected no deliveries for a paused webhook, got %d", total)
+	}
+
+	if err := DeleteWebhook(db, postsID); err != nil {
+		t.Fatalf("Failed to delete webhook: %v", err)
+	}
+	if due, _ := GetDueWebhookDeliveries(db, time.Now(), 10); len(due) != 0 {
+		t.Errorf("Expected the deliveries to go with the webhook, got %d", len(due))
+	}
+	if err := DeleteWebhook(db, postsID); err == nil || err.Error() != "webhook not found" {
+		t.Errorf("Expected webhook not found error, got %v", err)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	db, cleanup, _ := setupPostTestDB(t)
+	defer cleanup()
+
+	oldAttempts, oldDelay := WebhookMaxAttempts, WebhookRetryDelay
+	WebhookMaxAttempts, WebhookRetryDelay = 3, time.Minute
+	defer func() { WebhookMaxAttempts, WebhookRetryDelay = oldAttempts, oldDelay }()
+
+	webhookID, _ := CreateWebhook(db, "https://example.com/hook", []string{WebhookReaction})
+	deliveryID, err := QueueWebhookDelivery(db, webhookID, WebhookReaction, []byte(`{}`))
+	if err != nil {
+		t.Fatalf("Failed to queue delivery: %v", err)
+	}
+
+	now := time.Now()
+	sendErr := errors.New("unexpected status 500 Internal Server Error")
+
+	// The wait doubles after every failed attempt
+	for attempt, wait := range []time.Duration{time.Minute, 2 * time.Minute} {
+		due, _ := GetDueWebhookDeliveries(db, now, 10)
+		if len(due) != 1 {
+			t.Fatalf("Expected the delivery to be due on attempt %d, got %d", attempt+1, len(due))
+		}
+		gaveUp, err := MarkWebhookFailed(db, &due[0], 500, "oops", sendErr, now)
+		if err != nil || gaveUp {
+			t.Fatalf("Expected a retry on attempt %d, got %v (%v)", attempt+1, gaveUp, err)
+		}
+		if due, _ := GetDueWebhookDeliveries(db, now.Add(wait-time.Second), 10); len(due) != 0 {
+			t.Fatalf("Expected attempt %d to wait %v", attempt+2, wait)
+		}
+		now = now.Add(wait)
+	}
+
+	due, _ := GetDueWebhookDeliveries(db, now, 10)
+	if len(due) != 1 {
+		t.Fatalf("Expected the last attempt to be due, got %d", len(due))
+	}
+	gaveUp, err := MarkWebhookFailed(db, &due[0], 0, "", errors.New("connection refused"), now)
+	if err != nil || !gaveUp {
+		t.Fatalf("Expected the delivery to be given up, got %v (%v)", gaveUp, err)
+	}
+
+	entries, _, _ := GetWebhookDeliveries(db, webhookID, 10, 0)
+	if len(entries) != 1 || entries[0].Status() != "failed" || entries[0].Attempts != 3 || entries[0].LastError != "connection refused" {
+		t.Fatalf("Unexpected log %+v", entries)
+	}
+
+	// Redelivering queues the payload again
+	if _, err := RedeliverWebhook(db, deliveryID+100); err == nil || err.Error() != "delivery not found" {
+		t.Errorf("Expected delivery not found error, got %v", err)
+	}
+	hookID, err := RedeliverWebhook(db, deliveryID)
+	if err != nil || hookID != webhookID {
+		t.Fatalf("Failed to redeliver: %d (%v)", hookID, err)
+	}
+	due, _ = GetDueWebhookDeliveries(db, time.Now(), 10)
+	if len(due) != 1 || due[0].ID == deliveryID || due[0].Attempts != 0 {
+		t.Fatalf("Expected a new delivery, got %+v", due)
+	}
+	if err := MarkWebhookDelivered(db, due[0].ID, 200, "ok", time.Now()); err != nil {
+		t.Fatalf("Failed to mark delivered: %v", err)
+	}
+
+	// Finished deliveries are cleaned up once they are old enough
+	if n, err := CleanWebhookDeliveries(db, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Errorf("Expected nothing to clean yet, got %d (%v)", n, err)
+	}
+	if n, err := CleanWebhookDeliveries(db, now.Add(time.Hour)); err != nil || n != 2 {
+		t.Errorf("Expected 2 deliveries to be cleaned, got %d (%v)", n, err)
+	}
+}
@@ -53,25 +53,602 @@ document.addEventListener("DOMContentLoaded", function () {
     });
   });
 
-  // Preview comment
-  const commentInput = document.getElementById("comment-input");
-  const commentPreview = document.getElementById("comment-preview");
-  const previewToggle = document.getElementById("preview-toggle");
+  // Add a copy button to every highlighted code block
+  const addCopyButtons = (root) => {
+    root.querySelectorAll(".code-block").forEach((block) => {
+      if (block.querySelector(".copy-button")) return;
+      const code = block.querySelector("code");
+      if (!code || !navigator.clipboard) return;
 
-  if (commentInput && commentPreview && previewToggle) {
-    previewToggle.addEventListener("click", function (e) {
+      const button = document.createElement("button");
+      button.type = "button";
+      button.className = "copy-button";
+      button.textContent = "Copy";
+      button.addEventListener("click", async () => {
+        try {
+          await navigator.clipboard.writeText(code.textContent.replace(/\n$/, ""));
+          button.textContent = "Copied";
+        } catch (err) {
+          button.textContent = "Failed";
+        }
+        setTimeout(() => (button.textContent = "Copy"), 1500);
+      });
+      block.appendChild(button);
+    });
+  };
+  addCopyButtons(document);
+
+  // Preview posts and comments with the same renderer used when they are saved
+  document.querySelectorAll(".preview-toggle").forEach((toggle) => {
+    const input = document.getElementById(toggle.dataset.target);
+    const preview = document.getElementById(toggle.dataset.preview);
+    if (!input || !preview) return;
+
+    toggle.addEventListener("click", async function (e) {
       e.preventDefault();
-      const isPreviewVisible = commentPreview.style.display === "block";
+      const isPreviewVisible = preview.style.display === "block";
 
       if (isPreviewVisible) {
-        commentPreview.style.display = "none";
-        commentInput.style.display = "block";
+        preview.style.display = "none";
+        input.style.display = "block";
         this.textContent = "Preview";
-      } else {
-        commentPreview.innerHTML = commentInput.value;
-        commentPreview.style.display = "block";
-        commentInput.style.display = "none";
+        return;
+      }
+
+      this.disabled = true;
+      try {
+        const response = await fetch("/markdown/preview", {
+          method: "POST",
+          body: new URLSearchParams({ content: input.value }),
+        });
+        const result = await response.json();
+        if (!response.ok) {
+          alert(result.error || "Preview failed");
+          return;
+        }
+        // The server returns sanitized HTML
+        preview.innerHTML = result.html;
+        addCopyButtons(preview);
+        preview.style.display = "block";
+        input.style.display = "none";
         this.textContent = "Edit";
+      } catch (err) {
+        alert("Preview failed");
+      } finally {
+        this.disabled = false;
+      }
+    });
+  });
+
+  // Upload an image and insert it into the textarea at the cursor
+  document.querySelectorAll(".insert-image").forEach((button) => {
+    const textarea = document.getElementById(button.dataset.target);
+    if (!textarea) return;
+
+    const picker = document.createElement("input");
+    picker.type = "file";
+    picker.accept = "image/png,image/jpeg,image/gif,image/webp";
+    picker.style.display = "none";
+    button.after(picker);
+
+    button.addEventListener("click", () => picker.click());
+
+    picker.addEventListener("change", async () => {
+      if (picker.files.length === 0) return;
+
+      const body = new FormData();
+      body.append("file", picker.files[0]);
+      button.disabled = true;
+
+      try {
+        const response = await fetch("/attachments/upload", {
+          method: "POST",
+          body: body,
+        });
+        const result = await response.json();
+        if (!response.ok) {
+          alert(result.error || "Upload failed");
+          return;
+        }
+
+        const start = textarea.selectionStart;
+        const end = textarea.selectionEnd;
+        textarea.value =
+          textarea.value.slice(0, start) +
+          result.snippet +
+          textarea.value.slice(end);
+        textarea.selectionStart = textarea.selectionEnd =
+          start + result.snippet.length;
+        textarea.focus();
+      } catch (err) {
+        alert("Upload failed");
+      } finally {
+        button.disabled = false;
+        picker.value = "";
+      }
+    });
+  });
+
+  // Show UTC times in the reader's timezone
+  const toLocalInput = (date) => {
+    const local = new Date(date.getTime() - date.getTimezoneOffset() * 60000);
+    return local.toISOString().slice(0, 16);
+  };
+  document.querySelectorAll("time.local-time").forEach((el) => {
+    const date = new Date(el.getAttribute("datetime"));
+    if (!isNaN(date)) {
+      el.textContent = date.toLocaleString([], {
+        dateStyle: "medium",
+        timeStyle: "short",
+      });
+    }
+  });
+
+  // Drafts: scheduling in local time and autosave
+  const draftForm = document.getElementById("post-form");
+  if (draftForm) {
+    const draftId = document.getElementById("draft-id");
+    const timezoneOffset = document.getElementById("timezone-offset");
+    const publishAt = document.getElementById("publish-at");
+    const autosaveStatus = document.getElementById("autosave-status");
+
+    if (timezoneOffset) {
+      timezoneOffset.value = new Date().getTimezoneOffset();
+    }
+    if (publishAt) {
+      publishAt.min = toLocalInput(new Date());
+    }
+    draftForm.querySelectorAll("input[type=datetime-local][data-utc]").forEach((input) => {
+      input.value = toLocalInput(new Date(input.dataset.utc));
+    });
+
+    // Polls take up to 10 options
+    const addPollOption = document.getElementById("add-poll-option");
+    const pollOptions = document.getElementById("poll-options");
+    if (addPollOption && pollOptions) {
+      const updateAddButton = () => {
+        addPollOption.disabled = pollOptions.querySelectorAll("input").length >= 10;
+      };
+      addPollOption.addEventListener("click", () => {
+        const input = document.createElement("input");
+        input.type = "text";
+        input.name = "poll_options";
+        input.className = "form-control poll-option-input";
+        input.maxLength = 200;
+        pollOptions.appendChild(input);
+        input.focus();
+        updateAddButton();
+      });
+      updateAddButton();
+    }
+
+    // Only text fields are autosaved; files are kept when the draft is saved with the form
+    const draftFields = () => {
+      const fields = new URLSearchParams();
+      for (const [name, value] of new FormData(draftForm)) {
+        if (typeof value === "string" && name !== "publish_at") {
+          fields.append(name, value);
+        }
+      }
+      return fields;
+    };
+
+    let lastSaved = draftFields().toString();
+    let timer = null;
+    let submitting = false;
+
+    const autosave = async () => {
+      const fields = draftFields();
+      const body = fields.toString();
+      if (submitting || body === lastSaved) return;
+
+      try {
+        const response = await fetch("/drafts/autosave", {
+          method: "POST",
+          body: fields,
+        });
+        const result = await response.json();
+        if (!response.ok) {
+          if (autosaveStatus) autosaveStatus.textContent = result.error || "Draft not saved";
+          return;
+        }
+        lastSaved = body;
+        if (result.id && draftId) {
+          draftId.value = result.id;
+          lastSaved = draftFields().toString();
+        }
+        if (result.saved_at && autosaveStatus) {
+          autosaveStatus.textContent =
+            "Draft saved at " +
+            new Date(result.saved_at).toLocaleTimeString([], {
+              hour: "2-digit",
+              minute: "2-digit",
+            });
+        }
+      } catch (err) {
+        if (autosaveStatus) autosaveStatus.textContent = "Draft not saved";
+      }
+    };
+
+    const scheduleAutosave = () => {
+      clearTimeout(timer);
+      timer = setTimeout(autosave, 3000);
+    };
+    draftForm.addEventListener("input", scheduleAutosave);
+    draftForm.addEventListener("change", scheduleAutosave);
+    draftForm.addEventListener("submit", () => {
+      submitting = true;
+      clearTimeout(timer);
+    });
+  }
+
+  // Saved posts sort order
+  const savedSort = document.getElementById("saved-sort");
+  if (savedSort) {
+    savedSort.addEventListener("change", function () {
+      this.form.submit();
+    });
+  }
+
+  // Suggest usernames while typing an @mention
+  document.querySelectorAll('textarea[name="content"]').forEach((textarea) => {
+    const list = document.createElement("ul");
+    list.className = "mention-suggestions";
+    list.hidden = true;
+    textarea.insertAdjacentElement("afterend", list);
+
+    let timer;
+    let active = -1;
+
+    // The @name being typed just before the cursor, if any
+    const currentMention = () => {
+      const before = textarea.value.slice(0, textarea.selectionStart);
+      const match = before.match(/(^|[^\w.@\/])@([\w.-]*)$/);
+      return match ? { query: match[2], start: before.length - match[2].length } : null;
+    };
+
+    const close = () => {
+      list.hidden = true;
+      list.innerHTML = "";
+      active = -1;
+    };
+
+    const highlight = (index) => {
+      const items = list.querySelectorAll("li");
+      items.forEach((item, i) => item.classList.toggle("active", i === index));
+      active = index;
+    };
+
+    const choose = (username) => {
+      const mention = currentMention();
+      if (!mention) return close();
+      const end = textarea.selectionStart;
+      textarea.value =
+        textarea.value.slice(0, mention.start) + username + " " + textarea.value.slice(end);
+      textarea.selectionStart = textarea.selectionEnd = mention.start + username.length + 1;
+      textarea.dispatchEvent(new Event("input", { bubbles: true }));
+      close();
+      textarea.focus();
+    };
+
+    textarea.addEventListener("input", function () {
+      clearTimeout(timer);
+      const mention = currentMention();
+      if (!mention || mention.query === "") return close();
+
+      timer = setTimeout(async () => {
+        try {
+          const response = await fetch("/users/search?q=" + encodeURIComponent(mention.query));
+          if (!response.ok) return close();
+          const result = await response.json();
+          if (!result.users.length) return close();
+
+          list.innerHTML = "";
+          result.users.forEach((user) => {
+            const item = document.createElement("li");
+            const avatar = document.createElement("img");
+            avatar.className = "avatar avatar-small";
+            avatar.src = user.avatar_url;
+            avatar.alt = "";
+            item.appendChild(avatar);
+            item.appendChild(document.createTextNode(" " + user.username));
+            // Keep the focus in the textarea while clicking
+            item.addEventListener("mousedown", (e) => {
+              e.preventDefault();
+              choose(user.username);
+            });
+            list.appendChild(item);
+          });
+          list.hidden = false;
+          highlight(0);
+        } catch (err) {
+          close();
+        }
+      }, 150);
+    });
+
+    textarea.addEventListener("keydown", function (e) {
+      if (list.hidden) return;
+      const items = list.querySelectorAll("li");
+      if (e.key === "ArrowDown" || e.key === "ArrowUp") {
+        e.preventDefault();
+        const step = e.key === "ArrowDown" ? 1 : -1;
+        highlight((active + step + items.length) % items.length);
+      } else if ((e.key === "Enter" || e.key === "Tab") && active >= 0) {
+        e.preventDefault();
+        choose(items[active].textContent.trim());
+      } else if (e.key === "Escape") {
+        close();
+      }
+    });
+
+    textarea.addEventListener("blur", close);
+  });
+
+  // Live updates: new comments and reaction counts on a post
+  const liveComments = document.querySelector("#comments[data-events]");
+  if (liveComments && window.EventSource) {
+    const events = new EventSource(liveComments.dataset.events);
+
+    events.addEventListener("reaction", (e) => {
+      const data = JSON.parse(e.data);
+      const container = document.querySelector(
+        '[data-reactions="' + data.target_type + "-" + data.target_id + '"]'
+      );
+      if (!container) return;
+      container.querySelectorAll('[data-count="likes"]').forEach((el) => (el.textContent = data.likes));
+      container.querySelectorAll('[data-count="dislikes"]').forEach((el) => (el.textContent = data.dislikes));
+    });
+
+    // New comments are taken from a fresh copy of the page, so they look the same as on reload
+    events.addEventListener("comment", async (e) => {
+      const data = JSON.parse(e.data);
+      const id = "comment-" + data.id;
+      if (document.getElementById(id)) return;
+      try {
+        const response = await fetch(window.location.pathname);
+        if (!response.ok) return;
+        const page = new DOMParser().parseFromString(await response.text(), "text/html");
+        const comment = page.getElementById(id);
+        if (!comment || document.getElementById(id)) return;
+
+        const empty = liveComments.querySelector(".no-comments");
+        if (empty) empty.remove();
+        comment.classList.add("comment-new");
+        liveComments.appendChild(document.adoptNode(comment));
+
+        const title = liveComments.querySelector(".comments-title");
+        if (title) {
+          title.textContent = "Comments (" + liveComments.querySelectorAll(".comment-card").length + ")";
+        }
+      } catch (err) {
+        // The comment shows up on the next reload
+      }
+    });
+
+    // React without reloading; the new counts arrive as a live event
+    document.addEventListener("submit", async (e) => {
+      const form = e.target;
+      const action = form.getAttribute("action");
+      if (action !== "/post/react" && action !== "/comment/react") return;
+      e.preventDefault();
+
+      try {
+        const response = await fetch(action, {
+          method: "POST",
+          body: new URLSearchParams(new FormData(form)),
+          redirect: "manual",
+        });
+        if (response.type !== "opaqueredirect" && !response.ok) throw new Error(response.statusText);
+      } catch (err) {
+        form.submit();
+        return;
+      }
+
+      // Show the user's own choice: clicking the same button again takes it back
+      const button = form.querySelector("button");
+      const className =
+        form.querySelector('[name="reaction"]').value === "1" ? "reaction-btn-liked" : "reaction-btn-disliked";
+      const selected = !button.classList.contains(className);
+      form.parentElement.querySelectorAll(".reaction-btn").forEach((b) => {
+        b.classList.remove("reaction-btn-liked", "reaction-btn-disliked");
+      });
+      if (selected) button.classList.add(className);
+    });
+  }
+
+  // Live updates: notice of new posts on post listings
+  const livePosts = document.getElementById("live-posts");
+  if (livePosts && window.EventSource) {
+    const events = new EventSource(livePosts.dataset.events);
+    let count = 0;
+    events.addEventListener("post", () => {
+      count++;
+      livePosts.querySelector("a").textContent =
+        count === 1 ? "1 new post has been published. Show it" : count + " new posts have been published. Show them";
+      livePosts.hidden = false;
+    });
+  }
+
+  // Category chat rooms
+  const chat = document.getElementById("chat");
+  if (chat && window.WebSocket) {
+    const list = document.getElementById("chat-messages");
+    const presence = document.getElementById("chat-presence");
+    const typing = document.getElementById("chat-typing");
+    const status = document.getElementById("chat-status");
+    const form = document.getElementById("chat-form");
+    const input = document.getElementById("chat-input");
+    const userID = Number(chat.dataset.userId);
+    const moderator = chat.dataset.moderator === "true";
+    const typists = new Map(); // username -> timer that clears the indicator
+    let socket;
+    let retry = 1000;
+    let statusTimer;
+
+    const send = (request) => {
+      if (socket && socket.readyState === WebSocket.OPEN) socket.send(JSON.stringify(request));
+    };
+
+    const showStatus = (text) => {
+      clearTimeout(statusTimer);
+      status.textContent = text;
+      statusTimer = setTimeout(() => (status.textContent = ""), 5000);
+    };
+
+    const showTyping = () => {
+      const names = Array.from(typists.keys());
+      typing.textContent =
+        names.length === 0 ? "" : names.join(", ") + (names.length === 1 ? " is typing..." : " are typing...");
+    };
+
+    const stopTyping = (username) => {
+      clearTimeout(typists.get(username));
+      typists.delete(username);
+      showTyping();
+    };
+
+    const setMuted = (muted, placeholder) => {
+      input.disabled = muted;
+      form.querySelector("button").disabled = muted;
+      input.placeholder = placeholder;
+    };
+
+    const actionButton = (label, onClick) => {
+      const button = document.createElement("button");
+      button.type = "button";
+      button.className = "chat-action";
+      button.textContent = label;
+      button.addEventListener("click", onClick);
+      return button;
+    };
+
+    // Keep the newest message in view unless the reader scrolled up
+    const append = (item) => {
+      const atBottom = list.scrollHeight - list.scrollTop - list.clientHeight < 50;
+      list.appendChild(item);
+      if (atBottom) list.scrollTop = list.scrollHeight;
+    };
+
+    const addNotice = (text, button) => {
+      const item = document.createElement("li");
+      item.className = "chat-notice";
+      item.textContent = text;
+      if (button) item.appendChild(button);
+      append(item);
+    };
+
+    const addMessage = (message) => {
+      if (document.getElementById("chat-message-" + message.id)) return;
+      const item = document.createElement("li");
+      item.id = "chat-message-" + message.id;
+      item.className = "chat-message";
+
+      const avatar = document.createElement("img");
+      avatar.className = "avatar avatar-small";
+      avatar.src = message.avatar_url;
+      avatar.alt = "";
+      const author = document.createElement("span");
+      author.className = "chat-author";
+      author.textContent = message.username;
+      const time = document.createElement("time");
+      time.className = "chat-time";
+      time.dateTime = message.created_at;
+      time.textContent = new Date(message.created_at).toLocaleTimeString([], { hour: "2-digit", minute: "2-digit" });
+      const content = document.createElement("span");
+      content.className = "chat-content";
+      content.textContent = message.content;
+      item.append(avatar, author, time, content);
+
+      if (message.user_id === userID || moderator) {
+        item.appendChild(actionButton("Delete", () => send({ type: "delete", id: message.id })));
+      }
+      if (moderator && message.user_id !== userID) {
+        item.appendChild(
+          actionButton("Mute", () => {
+            const minutes = Number(prompt("Mute " + message.username + " for how many minutes?", "10"));
+            if (minutes > 0) send({ type: "mute", user_id: message.user_id, minutes: minutes });
+          })
+        );
+      }
+      append(item);
+    };
+
+    const events = {
+      history: (messages) => {
+        list.innerHTML = "";
+        messages.forEach(addMessage);
+        list.scrollTop = list.scrollHeight;
+      },
+      message: (message) => {
+        addMessage(message);
+        stopTyping(message.username);
+      },
+      delete: (data) => {
+        const item = document.getElementById("chat-message-" + data.id);
+        if (item) item.remove();
+      },
+      presence: (users) => {
+        presence.innerHTML = "";
+        users.forEach((user) => {
+          const item = document.createElement("li");
+          item.textContent = user.username;
+          presence.appendChild(item);
+        });
+      },
+      typing: (user) => {
+        if (user.id === userID) return;
+        clearTimeout(typists.get(user.username));
+        typists.set(user.username, setTimeout(() => stopTyping(user.username), 3000));
+        showTyping();
+      },
+      mute: (data) => {
+        const until = new Date(data.until).toLocaleString();
+        const undo = moderator ? actionButton("Unmute", () => send({ type: "unmute", user_id: data.user_id })) : null;
+        addNotice(data.username + " was muted until " + until + ". ", undo);
+        if (data.user_id === userID) setMuted(true, "You are muted until " + until);
+      },
+      unmute: (data) => {
+        addNotice(data.username + " can write again.");
+        if (data.user_id === userID) setMuted(false, "Say something...");
+      },
+      error: (data) => showStatus(data.error),
+    };
+
+    const connect = () => {
+      const scheme = location.protocol === "https:" ? "wss://" : "ws://";
+      socket = new WebSocket(scheme + location.host + "/chat/ws?category=" + chat.dataset.categoryId);
+      socket.addEventListener("open", () => {
+        status.textContent = "";
+        retry = 1000;
+      });
+      socket.addEventListener("message", (e) => {
+        const event = JSON.parse(e.data);
+        if (events[event.type]) events[event.type](event.data);
+      });
+      // Reconnect with a growing delay; the history is sent again on connect
+      socket.addEventListener("close", () => {
+        status.textContent = "Disconnected. Reconnecting...";
+        setTimeout(connect, retry);
+        retry = Math.min(retry * 2, 30000);
+      });
+    };
+    connect();
+
+    form.addEventListener("submit", (e) => {
+      e.preventDefault();
+      const content = input.value.trim();
+      if (!content) return;
+      send({ type: "message", content: content });
+      input.value = "";
+    });
+
+    let lastTyping = 0;
+    input.addEventListener("input", () => {
+      const now = Date.now();
+      if (input.value && now - lastTyping > 2000) {
+        lastTyping = now;
+        send({ type: "typing" });
       }
     });
   }
@@ -82,6 +659,9 @@ document.addEventListener("DOMContentLoaded", function () {
     if (!form) return;
 
     form.addEventListener("submit", function (e) {
+      // Drafts may be saved incomplete
+      if (e.submitter && e.submitter.formNoValidate) return;
+
       let isValid = true;
       const errorMessages = [];
 
@@ -244,6 +824,7 @@ document.addEventListener("DOMContentLoaded", function () {
     const postForm = document.getElementById("post-form");
     if (postForm) {
       postForm.addEventListener("submit", function (e) {
+        if (e.submitter && e.submitter.formNoValidate) return;
         const anyChecked = Array.from(categoryCheckboxes).some(
           (cb) => cb.checked
         );
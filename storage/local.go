@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LocalURLPrefix is the path under which signed local URLs are served
+const LocalURLPrefix = "/files/"
+
+// Local stores blobs as files below a directory
+type Local struct {
+	Dir    string
+	secret []byte
+	now    func() time.Time
+}
+
+// NewLocal creates a local backend rooted at dir. URLs are signed with the secret;
+// if it is empty a random one is used, so signed URLs do not survive a restart.
+func NewLocal(dir string, secret []byte) (*Local, error) {
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return &Local{Dir: dir, secret: secret, now: time.Now}, nil
+}
+
+// Put writes a blob through a temporary file so readers never see partial data
+func (l *Local) Put(key string, data []byte, contentType string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Get reads a blob
+func (l *Local) Get(key string) ([]byte, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+// Delete removes a blob
+func (l *Local) Delete(key string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// List walks the directory for blobs starting with the prefix
+func (l *Local) List(prefix string) ([]string, error) {
+	var keys []string
+	err := filepath.WalkDir(l.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// Skip temporary files of writes in progress
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".upload-") {
+			return nil
+		}
+		rel, err := filepath.Rel(l.Dir, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	sort.Strings(keys)
+	return keys, err
+}
+
+// SignedURL returns a path under LocalURLPrefix carrying an expiry and an HMAC signature
+func (l *Local) SignedURL(key string, opts URLOptions) (string, error) {
+	if err := validKey(key); err != nil {
+		return "", err
+	}
+	if opts.Expires <= 0 {
+		opts.Expires = DefaultURLExpiry
+	}
+
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(l.now().Add(opts.Expires).Unix(), 10))
+	if opts.ContentType != "" {
+		query.Set("type", opts.ContentType)
+	}
+	if opts.ContentDisposition != "" {
+		query.Set("disposition", opts.ContentDisposition)
+	}
+	query.Set("signature", l.sign(key, query))
+
+	return LocalURLPrefix + escapeKey(key) + "?" + query.Encode(), nil
+}
+
+// ServeHTTP serves blobs requested through URLs created by SignedURL
+func (l *Local) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), LocalURLPrefix))
+	if err != nil || validKey(key) != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	query := r.URL.Query()
+	signature := query.Get("signature")
+	query.Del("signature")
+	expected := l.sign(key, query)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		http.Error(w, "Invalid signature", http.StatusForbidden)
+		return
+	}
+
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil || l.now().Unix() > expires {
+		http.Error(w, "Link has expired", http.StatusForbidden)
+		return
+	}
+
+	data, err := l.Get(key)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	contentType := query.Get("type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	if disposition := query.Get("disposition"); disposition != "" {
+		w.Header().Set("Content-Disposition", disposition)
+	}
+	// Uploaded files must never run as part of the site
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; img-src 'self'; style-src 'unsafe-inline'; sandbox")
+	w.Header().Set("Cache-Control", "private, max-age="+strconv.FormatInt(expires-l.now().Unix(), 10))
+	w.Write(data)
+}
+
+// sign computes the signature over the key and every other query parameter
+func (l *Local) sign(key string, query url.Values) string {
+	mac := hmac.New(sha256.New, l.secret)
+	mac.Write([]byte(key + "\n" + query.Encode()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (l *Local) path(key string) (string, error) {
+	if err := validKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(l.Dir, filepath.FromSlash(key)), nil
+}
+
+// escapeKey escapes each segment of a key for use in a URL path
+func escapeKey(key string) string {
+	parts := strings.Split(key, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalBackend(t *testing.T) {
+	local, err := NewLocal(t.TempDir(), []byte("secret"))
+	if err != nil {
+		t.Fatalf("Failed to create local storage: %v", err)
+	}
+
+	if err := local.Put("avatars/1-1-64.png", []byte("png"), "image/png"); err != nil {
+		t.Fatalf("Failed to put blob: %v", err)
+	}
+	if err := local.Put("attachments/token", []byte("file"), "text/plain"); err != nil {
+		t.Fatalf("Failed to put blob: %v", err)
+	}
+
+	data, err := local.Get("avatars/1-1-64.png")
+	if err != nil {
+		t.Fatalf("Failed to get blob: %v", err)
+	}
+	if string(data) != "png" {
+		t.Errorf("Expected png, got %s", data)
+	}
+
+	keys, err := local.List("")
+	if err != nil {
+		t.Fatalf("Failed to list blobs: %v", err)
+	}
+	if len(keys) != 2 || keys[0] != "attachments/token" || keys[1] != "avatars/1-1-64.png" {
+		t.Errorf("Unexpected keys: %v", keys)
+	}
+
+	if err := local.Delete("avatars/1-1-64.png"); err != nil {
+		t.Fatalf("Failed to delete blob: %v", err)
+	}
+	if _, err := local.Get("avatars/1-1-64.png"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if err := local.Delete("avatars/1-1-64.png"); err != nil {
+		t.Errorf("Expected deleting a missing blob to succeed, got %v", err)
+	}
+
+	// Test keys that would escape the directory
+	for _, key := range []string{"../secret", "/etc/passwd", "a//b", "a/./b", ""} {
+		if err := local.Put(key, []byte("x"), ""); err == nil {
+			t.Errorf("Expected error for key %q, got nil", key)
+		}
+	}
+}
+
+func TestLocalSignedURL(t *testing.T) {
+	local, err := NewLocal(t.TempDir(), []byte("secret"))
+	if err != nil {
+		t.Fatalf("Failed to create local storage: %v", err)
+	}
+	now := time.Now()
+	local.now = func() time.Time { return now }
+
+	if err := local.Put("attachments/token", []byte("hello"), "text/plain"); err != nil {
+		t.Fatalf("Failed to put blob: %v", err)
+	}
+
+	signed, err := local.SignedURL("attachments/token", URLOptions{
+		Expires:            time.Minute,
+		ContentType:        "text/plain",
+		ContentDisposition: `attachment; filename="notes.txt"`,
+	})
+	if err != nil {
+		t.Fatalf("Failed to sign URL: %v", err)
+	}
+	if !strings.HasPrefix(signed, LocalURLPrefix+"attachments/token?") {
+		t.Fatalf("Unexpected URL: %s", signed)
+	}
+
+	// Test a valid URL
+	rr := httptest.NewRecorder()
+	local.ServeHTTP(rr, httptest.NewRequest("GET", signed, nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "hello" {
+		t.Fatalf("Expected 200 with content, got %d %s", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("Content-Disposition") != `attachment; filename="notes.txt"` {
+		t.Errorf("Unexpected Content-Disposition: %s", rr.Header().Get("Content-Disposition"))
+	}
+
+	// Test a tampered URL
+	rr = httptest.NewRecorder()
+	local.ServeHTTP(rr, httptest.NewRequest("GET", strings.Replace(signed, "text%2Fplain", "text%2Fhtml", 1), nil))
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for tampered URL, got %d", rr.Code)
+	}
+
+	// Test an expired URL
+	local.now = func() time.Time { return now.Add(2 * time.Minute) }
+	rr = httptest.NewRecorder()
+	local.ServeHTTP(rr, httptest.NewRequest("GET", signed, nil))
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for expired URL, got %d", rr.Code)
+	}
+}
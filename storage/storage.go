@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when a blob does not exist
+var ErrNotFound = errors.New("blob not found")
+
+// Storage stores uploaded files as blobs addressed by slash-separated keys,
+// such as "avatars/1-2-64.png" or "attachments/{token}"
+type Storage interface {
+	// Put stores a blob, replacing any existing blob with the same key
+	Put(key string, data []byte, contentType string) error
+	// Get returns the content of a blob, or ErrNotFound
+	Get(key string) ([]byte, error)
+	// Delete removes a blob. Deleting a missing blob is not an error.
+	Delete(key string) error
+	// List returns the keys of all blobs starting with the prefix, in lexical order
+	List(prefix string) ([]string, error)
+	// SignedURL returns a download URL for a blob that stops working after the expiry
+	SignedURL(key string, opts URLOptions) (string, error)
+}
+
+// URLOptions control how a signed download URL is served
+type URLOptions struct {
+	Expires            time.Duration
+	ContentType        string
+	ContentDisposition string
+}
+
+// DefaultURLExpiry is used when URLOptions does not set an expiry
+const DefaultURLExpiry = 15 * time.Minute
+
+// FromEnv creates the storage backend selected by the STORAGE_BACKEND
+// environment variable ("local" or "s3", default "local")
+func FromEnv() (Storage, error) {
+	return New(os.Getenv("STORAGE_BACKEND"))
+}
+
+// New creates a storage backend by name, configured from the environment.
+//
+// The local backend uses STORAGE_DIR (default "uploads") and signs URLs with
+// STORAGE_SECRET. The s3 backend uses S3_ENDPOINT, S3_REGION, S3_BUCKET,
+// S3_ACCESS_KEY, S3_SECRET_KEY and S3_PATH_STYLE.
+func New(backend string) (Storage, error) {
+	switch backend {
+	case "", "local":
+		dir := os.Getenv("STORAGE_DIR")
+		if dir == "" {
+			dir = "uploads"
+		}
+		return NewLocal(dir, []byte(os.Getenv("STORAGE_SECRET")))
+	case "s3":
+		s3 := &S3{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			PathStyle: os.Getenv("S3_PATH_STYLE") != "false",
+		}
+		if s3.Region == "" {
+			s3.Region = "us-east-1"
+		}
+		if s3.Endpoint == "" || s3.Bucket == "" || s3.AccessKey == "" || s3.SecretKey == "" {
+			return nil, errors.New("S3_ENDPOINT, S3_BUCKET, S3_ACCESS_KEY and S3_SECRET_KEY are required")
+		}
+		return s3, nil
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", backend)
+}
+
+// Migrate copies every blob under the prefix from one backend to another.
+// Blobs are only removed from the source when deleteSource is set and the copy succeeded.
+func Migrate(from, to Storage, prefix string, deleteSource bool, progress func(key string)) (int, error) {
+	keys, err := from.List(prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	copied := 0
+	for _, key := range keys {
+		data, err := from.Get(key)
+		if err == ErrNotFound {
+			// Removed while migrating
+			continue
+		}
+		if err != nil {
+			return copied, fmt.Errorf("reading %s: %w", key, err)
+		}
+		if err := to.Put(key, data, contentTypeFor(data)); err != nil {
+			return copied, fmt.Errorf("writing %s: %w", key, err)
+		}
+		if deleteSource {
+			if err := from.Delete(key); err != nil {
+				return copied, fmt.Errorf("deleting %s: %w", key, err)
+			}
+		}
+		copied++
+		if progress != nil {
+			progress(key)
+		}
+	}
+
+	return copied, nil
+}
+
+// validKey rejects keys that could escape the storage root
+func validKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("invalid blob key %q", key)
+		}
+	}
+	return nil
+}
+
+// contentTypeFor guesses the type of a migrated blob from its content
+func contentTypeFor(data []byte) string {
+	contentType := http.DetectContentType(data)
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	return contentType
+}
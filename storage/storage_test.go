@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	local, err := NewLocal(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("Failed to create local storage: %v", err)
+	}
+	s3, server := newFakeS3(t)
+	defer server.Close()
+
+	blobs := map[string]string{
+		"avatars/1-1-32.png":  "small",
+		"avatars/1-1-64.png":  "large",
+		"attachments/token-1": "file",
+	}
+	for key, data := range blobs {
+		if err := local.Put(key, []byte(data), ""); err != nil {
+			t.Fatalf("Failed to put blob: %v", err)
+		}
+	}
+
+	// Test copying a prefix only
+	copied, err := Migrate(local, s3, "avatars/", false, nil)
+	if err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	if copied != 2 {
+		t.Errorf("Expected 2 blobs copied, got %d", copied)
+	}
+	if _, err := local.Get("avatars/1-1-32.png"); err != nil {
+		t.Errorf("Expected source blob to be kept, got %v", err)
+	}
+
+	// Test moving everything
+	var migrated []string
+	copied, err = Migrate(local, s3, "", true, func(key string) { migrated = append(migrated, key) })
+	if err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	if copied != 3 || len(migrated) != 3 {
+		t.Errorf("Expected 3 blobs moved, got %d", copied)
+	}
+
+	for key, expected := range blobs {
+		data, err := s3.Get(key)
+		if err != nil {
+			t.Errorf("Failed to get migrated blob %s: %v", key, err)
+		} else if string(data) != expected {
+			t.Errorf("Expected %s, got %s", expected, data)
+		}
+	}
+	if keys, _ := local.List(""); len(keys) != 0 {
+		t.Errorf("Expected source to be empty, got %v", keys)
+	}
+}
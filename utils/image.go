@@ -0,0 +1,192 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+)
+
+// MaxImagePixels limits the decoded size of uploaded images, so that a small
+// compressed file cannot expand into a huge bitmap in memory
+const MaxImagePixels = 4096 * 4096
+
+// AllowedImageTypes maps the sniffed MIME types accepted for image uploads to their format names
+var AllowedImageTypes = map[string]string{
+	"image/png":  "png",
+	"image/jpeg": "jpeg",
+	"image/gif":  "gif",
+}
+
+// DecodeImage sniffs, validates and decodes an uploaded PNG, JPEG or GIF image.
+// Only the pixels are kept, so any metadata in the file is dropped.
+func DecodeImage(data []byte) (image.Image, string, error) {
+	contentType := http.DetectContentType(data)
+	format, ok := AllowedImageTypes[contentType]
+	if !ok {
+		return nil, "", errors.New("only PNG, JPEG and GIF images are allowed")
+	}
+
+	config, configFormat, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", errors.New("image could not be read")
+	}
+	if configFormat != format {
+		return nil, "", errors.New("image content does not match its type")
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > MaxImagePixels {
+		return nil, "", errors.New("image dimensions are too large")
+	}
+
+	var img image.Image
+	switch format {
+	case "png":
+		img, err = png.Decode(bytes.NewReader(data))
+	case "jpeg":
+		img, err = jpeg.Decode(bytes.NewReader(data))
+	case "gif":
+		// Only the first frame of an animation is used
+		img, err = gif.Decode(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, "", errors.New("image could not be decoded")
+	}
+
+	return img, format, nil
+}
+
+// CropSquare returns the largest centered square of an image
+func CropSquare(img image.Image) image.Image {
+	b := img.Bounds()
+	size := b.Dx()
+	if b.Dy() < size {
+		size = b.Dy()
+	}
+	x := b.Min.X + (b.Dx()-size)/2
+	y := b.Min.Y + (b.Dy()-size)/2
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(dst, dst.Bounds(), img, image.Point{X: x, Y: y}, draw.Src)
+	return dst
+}
+
+// Resize scales an image to the given size. Downscaling averages every source
+// pixel that falls into a destination pixel, upscaling uses the nearest pixel.
+func Resize(img image.Image, width, height int) *image.RGBA {
+	src := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(src, src.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0 := y * sh / height
+		y1 := (y + 1) * sh / height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0 := x * sw / width
+			x1 := (x + 1) * sw / width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+
+			var r, g, b, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					i := src.PixOffset(sx, sy)
+					r += uint32(src.Pix[i])
+					g += uint32(src.Pix[i+1])
+					b += uint32(src.Pix[i+2])
+					a += uint32(src.Pix[i+3])
+					n++
+				}
+			}
+
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+
+	return dst
+}
+
+// Fit scales an image down so it fits within the given bounds, keeping its aspect ratio.
+// Images that already fit are returned unchanged.
+func Fit(img image.Image, maxWidth, maxHeight int) image.Image {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	if w <= maxWidth && h <= maxHeight {
+		return img
+	}
+
+	if w*maxHeight > h*maxWidth {
+		h = h * maxWidth / w
+		w = maxWidth
+	} else {
+		w = w * maxHeight / h
+		h = maxHeight
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	return Resize(img, w, h)
+}
+
+// EncodePNG writes an image as a PNG without any metadata chunks
+func EncodePNG(w io.Writer, img image.Image) error {
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	return encoder.Encode(w, img)
+}
+
+// Identicon draws a symmetric 5x5 pattern derived from a seed, used as a default avatar
+func Identicon(seed int64, size int) *image.RGBA {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(seed))
+	hash := sha256.Sum256(buf[:])
+
+	fg := color.RGBA{R: hash[0]/2 + 64, G: hash[1]/2 + 64, B: hash[2]/2 + 64, A: 255}
+	bg := color.RGBA{R: 240, G: 240, B: 240, A: 255}
+
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(img, img.Bounds(), &image.Uniform{C: bg}, image.Point{}, draw.Src)
+
+	// Leave a margin of half a cell around the 5x5 grid
+	const cells = 5
+	cell := size / (cells + 1)
+	if cell < 1 {
+		cell = 1
+	}
+	offset := (size - cell*cells) / 2
+
+	for row := 0; row < cells; row++ {
+		// The left three columns are random, the right two mirror them
+		for col := 0; col < 3; col++ {
+			if hash[3+row*3+col]%2 == 0 {
+				continue
+			}
+			for _, c := range []int{col, cells - 1 - col} {
+				rect := image.Rect(
+					offset+c*cell, offset+row*cell,
+					offset+(c+1)*cell, offset+(row+1)*cell,
+				)
+				draw.Draw(img, rect, &image.Uniform{C: fg}, image.Point{}, draw.Src)
+			}
+		}
+	}
+
+	return img
+}
@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// testImage creates a solid color image of the given size
+func testImage(width, height int, c color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestDecodeImage(t *testing.T) {
+	img := testImage(40, 20, color.RGBA{R: 255, A: 255})
+
+	// Test every allowed format
+	var pngBuf, jpegBuf, gifBuf bytes.Buffer
+	if err := png.Encode(&pngBuf, img); err != nil {
+		t.Fatalf("Failed to encode PNG: %v", err)
+	}
+	if err := jpeg.Encode(&jpegBuf, img, nil); err != nil {
+		t.Fatalf("Failed to encode JPEG: %v", err)
+	}
+	if err := gif.Encode(&gifBuf, img, nil); err != nil {
+		t.Fatalf("Failed to encode GIF: %v", err)
+	}
+
+	for format, data := range map[string][]byte{"png": pngBuf.Bytes(), "jpeg": jpegBuf.Bytes(), "gif": gifBuf.Bytes()} {
+		decoded, decodedFormat, err := DecodeImage(data)
+		if err != nil {
+			t.Errorf("Failed to decode %s: %v", format, err)
+			continue
+		}
+		if decodedFormat != format {
+			t.Errorf("Expected format %s, got %s", format, decodedFormat)
+		}
+		if decoded.Bounds().Dx() != 40 || decoded.Bounds().Dy() != 20 {
+			t.Errorf("Unexpected %s dimensions: %v", format, decoded.Bounds())
+		}
+	}
+
+	// Test a file that is not an image
+	_, _, err := DecodeImage([]byte("<svg xmlns=\"http://www.w3.org/2000/svg\"></svg>"))
+	if err == nil {
+		t.Fatal("Expected error for non-image data, got nil")
+	}
+
+	// Test a truncated image
+	_, _, err = DecodeImage(pngBuf.Bytes()[:30])
+	if err == nil {
+		t.Fatal("Expected error for truncated image, got nil")
+	}
+}
+
+func TestCropSquareAndResize(t *testing.T) {
+	img := testImage(300, 100, color.RGBA{B: 255, A: 255})
+
+	square := CropSquare(img)
+	if square.Bounds().Dx() != 100 || square.Bounds().Dy() != 100 {
+		t.Fatalf("Expected 100x100 crop, got %v", square.Bounds())
+	}
+
+	for _, size := range []int{32, 256} {
+		resized := Resize(square, size, size)
+		if resized.Bounds().Dx() != size || resized.Bounds().Dy() != size {
+			t.Errorf("Expected %dx%d image, got %v", size, size, resized.Bounds())
+		}
+		r, g, b, a := resized.At(size/2, size/2).RGBA()
+		if r != 0 || g != 0 || b>>8 != 255 || a>>8 != 255 {
+			t.Errorf("Unexpected color after resizing to %d: %v %v %v %v", size, r, g, b, a)
+		}
+	}
+}
+
+func TestIdenticon(t *testing.T) {
+	a := Identicon(1, 64)
+	b := Identicon(1, 64)
+	c := Identicon(2, 64)
+
+	if a.Bounds().Dx() != 64 || a.Bounds().Dy() != 64 {
+		t.Fatalf("Expected 64x64 identicon, got %v", a.Bounds())
+	}
+	if !bytes.Equal(a.Pix, b.Pix) {
+		t.Error("Identicons for the same seed differ")
+	}
+	if bytes.Equal(a.Pix, c.Pix) {
+		t.Error("Identicons for different seeds are identical")
+	}
+
+	// The pattern is mirrored horizontally
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 32; x++ {
+			if a.RGBAAt(x, y) != a.RGBAAt(63-x, y) {
+				t.Fatalf("Identicon is not symmetric at (%d, %d)", x, y)
+			}
+		}
+	}
+
+	// Encoded identicons are plain PNGs
+	var buf bytes.Buffer
+	if err := EncodePNG(&buf, a); err != nil {
+		t.Fatalf("Failed to encode identicon: %v", err)
+	}
+	if _, format, err := image.DecodeConfig(&buf); err != nil || format != "png" {
+		t.Errorf("Encoded identicon is not a valid PNG: %v %s", err, format)
+	}
+}
+
+func TestFit(t *testing.T) {
+	img := testImage(800, 200, color.RGBA{G: 255, A: 255})
+
+	fitted := Fit(img, 320, 320)
+	if fitted.Bounds().Dx() != 320 || fitted.Bounds().Dy() != 80 {
+		t.Errorf("Expected 320x80 image, got %v", fitted.Bounds())
+	}
+
+	// Small images are not enlarged
+	small := testImage(100, 50, color.RGBA{G: 255, A: 255})
+	if Fit(small, 320, 320) != image.Image(small) {
+		t.Error("Image that already fits should be returned unchanged")
+	}
+}
@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter allows a number of events per key, such as a user ID, within a sliding window
+type RateLimiter struct {
+	limit     int
+	window    time.Duration
+	mu        sync.Mutex
+	events    map[string][]time.Time
+	lastSweep time.Time
+}
+
+// NewRateLimiter creates a limiter that allows limit events per key in every window
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{limit: limit, window: window, events: make(map[string][]time.Time)}
+}
+
+// Allow records an event for the key and reports whether it is within the limit.
+// Events that are refused do not count.
+func (l *RateLimiter) Allow(key string) bool {
+	return l.AllowAt(key, time.Now())
+}
+
+// AllowAt is Allow at the given time
+func (l *RateLimiter) AllowAt(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	since := now.Add(-l.window)
+
+	// Forget keys that have been quiet for a whole window, so the map does not grow forever
+	if now.Sub(l.lastSweep) > l.window {
+		for k, times := range l.events {
+			if len(times) == 0 || !times[len(times)-1].After(since) {
+				delete(l.events, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	times := l.events[key]
+	recent := 0
+	for recent < len(times) && !times[recent].After(since) {
+		recent++
+	}
+	times = times[recent:]
+
+	if len(times) >= l.limit {
+		l.events[key] = times
+		return false
+	}
+	l.events[key] = append(times, now)
+	return true
+}
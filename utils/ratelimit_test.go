@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(2, time.Minute)
+	start := time.Now()
+
+	if !limiter.AllowAt("1", start) || !limiter.AllowAt("1", start.Add(time.Second)) {
+		t.Fatal("Expected the first two events to be allowed")
+	}
+	if limiter.AllowAt("1", start.Add(2*time.Second)) {
+		t.Error("Expected the third event within a minute to be refused")
+	}
+	if !limiter.AllowAt("2", start.Add(2*time.Second)) {
+		t.Error("Expected other keys to have their own limit")
+	}
+
+	// The window slides: the first event no longer counts after a minute
+	if !limiter.AllowAt("1", start.Add(time.Minute+time.Millisecond)) {
+		t.Error("Expected an event to be allowed once the first one left the window")
+	}
+	if limiter.AllowAt("1", start.Add(time.Minute+2*time.Millisecond)) {
+		t.Error("Expected the second event to still count")
+	}
+}
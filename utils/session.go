@@ -89,3 +89,15 @@ func CleanExpiredSessions(db *sql.DB) error {
 	_, err := db.Exec("DELETE FROM sessions WHERE expires_at < ?", time.Now())
 	return err
 }
+
+// DeleteOtherSessions removes every session of a user except the one being kept
+func DeleteOtherSessions(db *sql.DB, userID int64, keepSessionID string) (int64, error) {
+	result, err := db.Exec(
+		"DELETE FROM sessions WHERE user_id = ? AND id != ?",
+		userID, keepSessionID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
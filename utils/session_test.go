@@ -265,3 +265,44 @@ func TestCleanExpiredSessions(t *testing.T) {
 		t.Error("Valid session was deleted")
 	}
 }
+
+func TestDeleteOtherSessions(t *testing.T) {
+	db, cleanup, userID := setupSessionTestDB(t)
+	defer cleanup()
+
+	// Create several sessions for the same user, e.g. from different devices
+	expiresAt := time.Now().Add(1 * time.Hour)
+	for _, id := range []string{"current-session", "laptop-session", "phone-session"} {
+		_, err := db.Exec(
+			"INSERT INTO sessions (id, user_id, expires_at) VALUES (?, ?, ?)",
+			id, userID, expiresAt,
+		)
+		if err != nil {
+			t.Fatalf("Failed to create session %s: %v", id, err)
+		}
+	}
+
+	// Test revoking all sessions but the current one
+	revoked, err := DeleteOtherSessions(db, userID, "current-session")
+	if err != nil {
+		t.Fatalf("Failed to delete other sessions: %v", err)
+	}
+	if revoked != 2 {
+		t.Errorf("Expected 2 revoked sessions, got %d", revoked)
+	}
+
+	// Verify only the current session is left
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM sessions WHERE user_id = ?", userID).Scan(&count)
+	if err != nil {
+		t.Fatalf("Failed to count sessions: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 remaining session, got %d", count)
+	}
+
+	_, err = ValidateSession(db, "current-session")
+	if err != nil {
+		t.Errorf("Current session should still be valid: %v", err)
+	}
+}
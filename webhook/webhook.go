@@ -0,0 +1,107 @@
+// Package webhook sends signed webhook requests and checks the signature of received ones.
+//
+// Every request is a JSON POST. The X-Forum-Signature header holds "sha256=" followed by the
+// hex HMAC-SHA256 of the X-Forum-Timestamp value, a dot and the body, keyed with the
+// webhook's secret.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers sent with every request
+const (
+	EventHeader     = "X-Forum-Event"
+	DeliveryHeader  = "X-Forum-Delivery"
+	TimestampHeader = "X-Forum-Timestamp"
+	SignatureHeader = "X-Forum-Signature"
+)
+
+// MaxResponseBody is how much of a receiver's answer is kept
+const MaxResponseBody = 1024
+
+// maxRequestBody is the largest request Verify reads
+const maxRequestBody = 1 << 20
+
+// Delivery is a request to a webhook
+type Delivery struct {
+	ID     int64
+	URL    string
+	Secret string
+	Event  string
+	Body   []byte
+}
+
+// Result is how a receiver answered
+type Result struct {
+	StatusCode int
+	Body       string // at most MaxResponseBody bytes
+}
+
+// Sign computes the signature of a body sent at the given Unix time
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Send posts a signed delivery. Answers other than 2xx are returned with an error.
+func Send(client *http.Client, d *Delivery, now time.Time) (*Result, error) {
+	req, err := http.NewRequest("POST", d.URL, bytes.NewReader(d.Body))
+	if err != nil {
+		return nil, err
+	}
+	timestamp := now.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Forum-Webhooks/1.0")
+	req.Header.Set(EventHeader, d.Event)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(d.ID, 10))
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(d.Secret, timestamp, d.Body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, MaxResponseBody))
+	result := &Result{StatusCode: resp.StatusCode, Body: string(body)}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return result, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return result, nil
+}
+
+// Verify checks the signature of a received request and returns its body. Requests signed
+// more than tolerance away from now are refused, so captured requests cannot be replayed later.
+func Verify(r *http.Request, secret string, tolerance time.Duration, now time.Time) ([]byte, error) {
+	timestamp, err := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+	if err != nil {
+		return nil, errors.New("webhook: missing timestamp")
+	}
+	age := now.Sub(time.Unix(timestamp, 0))
+	if age > tolerance || age < -tolerance {
+		return nil, errors.New("webhook: timestamp too far from now")
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBody))
+	if err != nil {
+		return nil, err
+	}
+	expected := Sign(secret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(r.Header.Get(SignatureHeader))) {
+		return nil, errors.New("webhook: invalid signature")
+	}
+	return body, nil
+}
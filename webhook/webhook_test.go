@@ -0,0 +1,62 @@
+package webhook
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSendAndVerify(t *testing.T) {
+	received := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := Verify(r, "secret", 5*time.Minute, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		received <- r.Header.Get(EventHeader) + " " + r.Header.Get(DeliveryHeader) + " " + string(body)
+		w.Write([]byte("thanks"))
+	}))
+	defer server.Close()
+
+	d := &Delivery{ID: 7, URL: server.URL, Secret: "secret", Event: "ping", Body: []byte(`{"event":"ping"}`)}
+	result, err := Send(server.Client(), d, time.Now())
+	if err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	if result.StatusCode != http.StatusOK || result.Body != "thanks" {
+		t.Errorf("Unexpected result %+v", result)
+	}
+	if got := <-received; got != `ping 7 {"event":"ping"}` {
+		t.Errorf("Unexpected request %q", got)
+	}
+
+	// A wrong secret is refused, and the answer is kept
+	d.Secret = "other"
+	result, err = Send(server.Client(), d, time.Now())
+	if err == nil || result.StatusCode != http.StatusUnauthorized || !strings.Contains(result.Body, "invalid signature") {
+		t.Errorf("Expected an invalid signature answer, got %+v (%v)", result, err)
+	}
+
+	// Old requests are refused
+	d.Secret = "secret"
+	result, err = Send(server.Client(), d, time.Now().Add(-time.Hour))
+	if err == nil || !strings.Contains(result.Body, "timestamp") {
+		t.Errorf("Expected an old request to be refused, got %+v (%v)", result, err)
+	}
+}
+
+func TestSign(t *testing.T) {
+	a := Sign("secret", 1700000000, []byte("body"))
+	if !strings.HasPrefix(a, "sha256=") || len(a) != len("sha256=")+64 {
+		t.Fatalf("Unexpected signature %q", a)
+	}
+	if a == Sign("secret", 1700000001, []byte("body")) {
+		t.Error("Expected the timestamp to change the signature")
+	}
+	if a == Sign("secret", 1700000000, []byte("body!")) {
+		t.Error("Expected the body to change the signature")
+	}
+}
@@ -0,0 +1,158 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// dial performs the opening handshake against a test server
+func dial(t *testing.T, server *httptest.Server, origin string) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	request := "GET / HTTP/1.1\r\nHost: " + strings.TrimPrefix(server.URL, "http://") + "\r\n" +
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n"
+	if origin != "" {
+		request += "Origin: " + origin + "\r\n"
+	}
+	if _, err := conn.Write([]byte(request + "\r\n")); err != nil {
+		t.Fatalf("Failed to write handshake: %v", err)
+	}
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("Failed to read handshake: %v", err)
+	}
+	return conn, reader, response
+}
+
+// writeClientFrame sends a masked frame like a browser does
+func writeClientFrame(conn net.Conn, fin bool, opcode int, payload []byte) error {
+	first := byte(opcode)
+	if fin {
+		first |= 0x80
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{first, 0x80 | byte(len(payload))}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	_, err := conn.Write(frame)
+	return err
+}
+
+// readServerFrame reads a short unmasked frame
+func readServerFrame(reader *bufio.Reader) (int, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, header[1]&0x7f)
+	_, err := io.ReadFull(reader, payload)
+	return int(header[0] & 0x0f), payload, err
+}
+
+func TestEcho(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(messageType, data)
+		}
+	}))
+	defer server.Close()
+
+	conn, reader, response := dial(t, server, server.URL)
+	defer conn.Close()
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected 101, got %d", response.StatusCode)
+	}
+	// Example from RFC 6455, section 1.3
+	if accept := response.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Unexpected accept key %q", accept)
+	}
+
+	// A fragmented message with a ping in between comes back whole
+	writeClientFrame(conn, false, TextMessage, []byte("Hel"))
+	writeClientFrame(conn, true, PingMessage, []byte("p"))
+	writeClientFrame(conn, true, 0, []byte("lo"))
+
+	opcode, payload, err := readServerFrame(reader)
+	if err != nil || opcode != PongMessage || string(payload) != "p" {
+		t.Fatalf("Expected a pong, got %d %q %v", opcode, payload, err)
+	}
+	opcode, payload, err = readServerFrame(reader)
+	if err != nil || opcode != TextMessage || string(payload) != "Hello" {
+		t.Fatalf("Expected the echoed message, got %d %q %v", opcode, payload, err)
+	}
+
+	// Closing is answered with a close frame
+	closePayload := make([]byte, 2)
+	binary.BigEndian.PutUint16(closePayload, CloseNormal)
+	writeClientFrame(conn, true, CloseMessage, closePayload)
+	opcode, payload, err = readServerFrame(reader)
+	if err != nil || opcode != CloseMessage || binary.BigEndian.Uint16(payload) != CloseNormal {
+		t.Errorf("Expected a close frame, got %d %v %v", opcode, payload, err)
+	}
+}
+
+func TestProtocolErrors(t *testing.T) {
+	errs := make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.MaxMessageSize = 4
+		_, _, err = conn.ReadMessage()
+		errs <- err
+	}))
+	defer server.Close()
+
+	conn, reader, _ := dial(t, server, "")
+	defer conn.Close()
+	writeClientFrame(conn, true, TextMessage, []byte("too long"))
+
+	var closeErr *CloseError
+	if err := <-errs; !errors.As(err, &closeErr) || closeErr.Code != CloseMessageTooBig {
+		t.Fatalf("Expected a message too big error, got %v", err)
+	}
+	opcode, payload, _ := readServerFrame(reader)
+	if opcode != CloseMessage || binary.BigEndian.Uint16(payload) != CloseMessageTooBig {
+		t.Errorf("Expected a close frame with code %d, got %d %v", CloseMessageTooBig, opcode, payload)
+	}
+}
+
+func TestCrossOriginRefused(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if conn, err := Upgrade(w, r); err == nil {
+			conn.Close()
+		}
+	}))
+	defer server.Close()
+
+	conn, _, response := dial(t, server, "https://evil.example.com")
+	defer conn.Close()
+	if response.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected 403 for another origin, got %d", response.StatusCode)
+	}
+}